@@ -33,10 +33,45 @@ git:
   # Timeout used for network operations
   networkTimeout: 1m
 
+  # Requests that arrive at the same time are pushed together in one batch.
+  # If set, kuberpult waits up to this long for more requests before pushing.
+  # The actual wait is half of the average push duration, capped by this value, so batches grow when pushing is slow.
+  batchLinger: 0s
+  # The maximum number of requests that are pushed together. 0 means unlimited.
+  maxBatchSize: 0
+
+  # If another tool or another kuberpult pushes to the same branch, kuberpult's push is rejected.
+  # Kuberpult then fetches the branch, applies the requests again and retries the push up to this many times. Must be at least 1.
+  nonFastForwardRetries: 1
+  # Each of these retries waits a random time up to this duration, so that two writers don't collide again.
+  nonFastForwardJitter: 0s
+
   # If enabled, write data to the `/commit` directory in the manifest repo on every release.
   # Disabling this option does not delete the `/commit` directory.
   enableWritingCommitData: false
 
+  # If enabled, every batch action, including failed ones, is recorded in the `/audit` directory in the manifest repo.
+  # The entries are part of the commit of their actions, followed by a commit that stores the ids of these commits in the entries.
+  # Failed actions add an additional commit.
+  # The audit log can be exported with the frontend endpoint `/api/audit-log`. If Dex is enabled, this requires the `auth.dexAuth.adminRole`.
+  enableAuditLog: false
+  # Entries older than this are removed from the manifest repo. "0" keeps all entries.
+  auditLogRetention: 2160h
+
+  # Batch requests with an idempotency key are remembered in the `/idempotency` directory in the manifest repo for this long.
+  # A repeated request with the same key returns the first response instead of applying the actions again. "0" disables idempotency keys.
+  idempotencyWindow: 24h
+
+  # If enabled, the cd-service mirrors the source repositories of the applications (their `sourceRepoUrl`) to compute
+  # the changelog between the deployed release and another release. The ssh key and known hosts of the manifest repo are used.
+  sourceRepoMirror:
+    enabled: false
+    # Only source repositories whose url starts with one of these prefixes are mirrored, e.g. "git@github.com:freiheit-com/".
+    # Required if enabled. Local paths are never mirrored.
+    allowedUrls: []
+    # How often the mirrored repositories are fetched in the background.
+    fetchInterval: 5m
+
 hub: europe-west3-docker.pkg.dev/fdc-public-docker-registry/kuberpult
 
 log:
@@ -61,6 +96,9 @@ cd:
       cpu: 2
       memory: 3Gi
   enableSqlite: true
+  # The number of requests that may wait for the push to the manifest repository.
+  # Further requests fail immediately with "resource exhausted" instead of waiting. 0 means unlimited.
+  maxQueueLength: 0
   probes:
     liveness:
       initialDelaySeconds: 5
@@ -98,6 +136,11 @@ rollout:
       memory: 250Mi
   # annotations given here will take precedence over the defaults defined in _helpers.tpl
   podAnnotations: {}
+  dora:
+    # How long deployments are kept in memory to compute the DORA metrics. Must be positive.
+    # This is also the longest window that can be requested.
+    # After a restart of the rollout-service, the deployments of this window are read again from the manifest repository.
+    retention: 720h
 
 ingress:
   # The simplest setup involves an ingress, to make kuberpult available outside the cluster.
@@ -158,6 +201,13 @@ datadogTracing:
   debugging: false
   environment: "shared"
 
+# Sends traces to an OpenTelemetry collector instead of datadog.
+# Cannot be combined with datadogTracing.
+otlpTracing:
+  enabled: false
+  # grpc endpoint of the collector, e.g. "http://otel-collector:4317"
+  endpoint: ""
+
 dogstatsdMetrics:
   # send metrics:
   enabled: false
@@ -217,7 +267,9 @@ auth:
     # Indicates if dex is to be installed. If you want to use your own Dex instance do not enable this flag.
     installDex: false
     # Defines the rbac policy when using Dex.
-    # The permissions are added using the following format (<ROLE>, <ACTION>, <ENVIRONMENT_GROUP>:<ENVIRONMENT>, <APPLICATION>, allow).
+    # The permissions are added using the following format (<ROLE>, <ACTION>, <ENVIRONMENT_GROUP>:<ENVIRONMENT>, <APPLICATION>, <allow|deny>).
+    # Deny rules are evaluated before allow rules, e.g. "Developer, DeployRelease, *:*, *, allow" together with
+    # "Developer, DeployRelease, production:prod-de, *, deny" allows developers to deploy everywhere except prod-de.
     #
     # Available actions are: CreateLock, DeleteLock, CreateRelease, DeployRelease, CreateUndeploy, DeployUndeploy, CreateEnvironment, CreateEnvironmentApplication and DeployReleaseTrain.
     # The actions CreateUndeploy, DeployUndeploy and CreateEnvironmentApplication are environment independent meaning that the environment specified on the permission
@@ -227,11 +279,28 @@ auth:
     # If no group is configured for an environment, the environment group name is the same as the environment name, here "development".
     # The policy will be available on the kuberpult-rbac config map.
     policy_csv: ""
+    # If enabled, the rbac policy is read from "rbac/policy.csv" in the manifest repository on each permission check,
+    # so changes do not require a restart of the cd-service. As long as the repository does not contain a policy, policy_csv is used.
+    # In this case, policy_csv may be empty.
+    # The policy in the repository can be changed with the UpdateRbacPolicy batch action.
+    policyInRepository: false
+    # The role that is allowed to update the rbac policy in the manifest repository.
+    # The admin role is also allowed to perform every action that is not explicitly denied.
+    adminRole: ""
     clientId: ""
     clientSecret: ""
     baseURL: ""
     # List of scopes to validate the token. Please add them as comma separated values.
     scopes: ""
+  # Enables rbac without Dex, e.g. together with IAP or Azure authentication.
+  # The policy is configured with dexAuth.policy_csv, dexAuth.policyInRepository and dexAuth.adminRole.
+  rbac:
+    enabled: false
+    # The token claim that contains the role of the user. If the claim contains several groups, the first one is used.
+    roleClaim: "groups"
+    # Static mapping of users to roles, one "<EMAIL>,<ROLE>" per line. It takes precedence over the role claim.
+    # Example: alice@example.com,Developer
+    roleMapping_csv: ""
 
 # The Dex configuration values. For more information please check the Dex repository https://github.com/dexidp/dex
 dex:
@@ -258,6 +327,26 @@ dex:
   #       clientSecret: $GOOGLE_CLIENT_SECRET
   #       redirectURI: http://127.0.0.1:5556/callback
   config: {}
+# Outbound webhooks of the cd-service. Each receiver gets a json POST request for every matching event,
+# signed with HMAC-SHA256 in the header X-Hub-Signature-256.
+# Event types: release.created, deployment.deployed, deployment.queued, lock.created, lock.deleted, release_train.finished, environment.created
+webhooks:
+  receivers: []
+  # - url: https://example.com/kuberpult
+  #   secret: "my-secret"
+  #   # All filters are optional. Events without environment or team never match an environment or team filter.
+  #   environments: ["production"]
+  #   teams: ["team1"]
+  #   events: ["deployment.deployed", "lock.created"]
+  # Events that cannot be delivered are retried for this long.
+  retention: 24h
+  # The pending events are stored on a PersistentVolumeClaim, so that they survive restarts and the deletion of the pod.
+  # Because the volume can only be mounted by one pod, the cd-service is updated with the Recreate strategy if webhooks are enabled.
+  queue:
+    # Empty uses the default storage class of the cluster.
+    storageClassName: ""
+    size: 1Gi
+
 # Configuration for revolution dora metrics. If you are not using revolution you can safely ignore this.
 revolution:
   dora:
@@ -138,11 +138,11 @@ func (x *DexGrpcContextReader) ReadUserFromGrpcContext(ctx context.Context) (*Us
 	if u.Email == "" || u.Name == "" {
 		return nil, grpc.AuthError(ctx, errors.New("email and name in grpc context cannot both be empty"))
 	}
-	// RBAC Role of the user. only mandatory if DEX is enabled.
+	// RBAC Role of the user. only mandatory if RBAC is enabled.
 	if x.DexEnabled {
 		rolesInHeader := md.Get(HeaderUserRole)
 		if len(rolesInHeader) == 0 {
-			return nil, grpc.AuthError(ctx, fmt.Errorf("extract: role undefined but dex is enabled"))
+			return nil, grpc.AuthError(ctx, fmt.Errorf("extract: role undefined but rbac is enabled"))
 		}
 		userRole, err := Decode64(rolesInHeader[0])
 		if err != nil {
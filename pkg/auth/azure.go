@@ -126,7 +126,7 @@ func AllowBypassingAzureAuth(allowedPaths []string, requestUrlPath string, reque
 		}
 	}
 
-	// Skip azure authentication with ID for `/` (POST: createEnv), `/release`, `/releasetrain` and `/locks`  endpoints. The requests will be validated with pgp signature
+	// Skip azure authentication with ID for `/` (POST: createEnv), `/release`, `/releasetrain`, `/locks` and `/deploy` endpoints. The requests will be validated with pgp signature
 	// usage in requests from outside the cluster (e.g. by GitHub Actions and the publish.sh script).
 	group, tail := xpath.Shift(requestUrlPath)
 
@@ -141,6 +141,12 @@ func AllowBypassingAzureAuth(allowedPaths []string, requestUrlPath string, reque
 				return true
 			case "rollout-status":
 				return true
+			case "applications":
+				// deployments of a single application are validated with the pgp signature as well
+				_, tail := xpath.Shift(tail)
+				if function, _ := xpath.Shift(tail); function == "deploy" {
+					return true
+				}
 			case "": // create environment
 				if tail == "/" && requestMethod == http.MethodPost {
 					return true
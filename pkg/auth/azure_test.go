@@ -432,6 +432,22 @@ func TestAllowBypassingAzureAuth(t *testing.T) {
 			allowedPrefixes: nil,
 			expectedResult:  true,
 		},
+		{
+			Name:            "application deploy",
+			allowedPaths:    nil,
+			requestUrlPath:  "environments/dev/applications/app1/deploy",
+			requestMethod:   "PUT",
+			allowedPrefixes: nil,
+			expectedResult:  true,
+		},
+		{
+			Name:            "application locks are not bypassed",
+			allowedPaths:    nil,
+			requestUrlPath:  "environments/dev/applications/app1/locks/mylock123",
+			requestMethod:   "PUT",
+			allowedPrefixes: nil,
+			expectedResult:  false,
+		},
 		{
 			Name:            "env group rollout status",
 			allowedPaths:    nil,
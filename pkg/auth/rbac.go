@@ -18,9 +18,11 @@ package auth
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/freiheit-com/kuberpult/pkg/valid"
+	"io"
 	"os"
 	"strings"
 
@@ -40,14 +42,45 @@ const (
 	PermissionDeployReleaseTrain           = "DeployReleaseTrain"
 	// The default permission template.
 	PermissionTemplate = "%s,%s,%s:%s,%s,allow"
+	// The permission template of deny rules.
+	PermissionDenyTemplate = "%s,%s,%s:%s,%s,deny"
+	PermissionEffectAllow  = "allow"
+	PermissionEffectDeny   = "deny"
 )
 
 // All static rbac information that is required to check authentication of a given user.
 type RBACConfig struct {
-	// Indicates if Dex is enabled.
+	// Indicates if RBAC is enforced. This is the case if Dex is enabled,
+	// or if the roles are provided by another identity source like IAP or Azure.
 	DexEnabled bool
 	// The RBAC policy. A key is a permission, for example: "Developer, CreateLock, development:development, *, allow"
 	Policy map[string]*Permission
+	// Indicates if the RBAC policy is read from the manifest repository on each permission check.
+	// As long as the repository does not contain a policy, Policy is used instead.
+	PolicyFromRepository bool
+	// The role that is allowed to update the RBAC policy stored in the manifest repository.
+	// The admin role is allowed to perform every action that is not explicitly denied.
+	AdminRole string
+}
+
+// CheckAdmin returns a PermissionDenied error if RBAC is enforced and the user in the context does not have the admin role.
+// The action completes the error message, e.g. "read the audit log".
+func (c RBACConfig) CheckAdmin(ctx context.Context, action string) error {
+	if !c.DexEnabled {
+		return nil
+	}
+	user, err := ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	role := ""
+	if user.DexAuthContext != nil {
+		role = user.DexAuthContext.Role
+	}
+	if c.AdminRole == "" || role != c.AdminRole {
+		return status.Errorf(codes.PermissionDenied, "%s: The user '%s' with role '%s' is not allowed to %s", codes.PermissionDenied.String(), user.Name, role, action)
+	}
+	return nil
 }
 
 // Inits the RBAC Config struct
@@ -82,6 +115,12 @@ func (c *policyConfig) validateAction(action string) error {
 	return fmt.Errorf("invalid action %s", action)
 }
 
+// ValidateAction returns an error if the action is not a known RBAC action.
+func ValidateAction(action string) error {
+	cfg := initPolicyConfig()
+	return cfg.validateAction(action)
+}
+
 func (c *policyConfig) validateEnvs(envs, action string) error {
 	e := strings.Split(envs, ":")
 	if len(e) != 2 || envs == "" {
@@ -135,6 +174,8 @@ type Permission struct {
 	Application string
 	Environment string
 	Action      string
+	// Indicates a deny rule. Deny rules are evaluated before allow rules.
+	Deny bool
 }
 
 func ValidateRbacPermission(line string) (p *Permission, err error) {
@@ -164,16 +205,22 @@ func ValidateRbacPermission(line string) (p *Permission, err error) {
 	if err != nil {
 		return nil, err
 	}
+	// Validate the permission effect
+	effect := c[4]
+	if effect != PermissionEffectAllow && effect != PermissionEffectDeny {
+		return nil, fmt.Errorf("invalid effect %s, expected %s or %s", effect, PermissionEffectAllow, PermissionEffectDeny)
+	}
 	return &Permission{
 		Role:        role,
 		Action:      action,
 		Environment: environment,
 		Application: application,
+		Deny:        effect == PermissionEffectDeny,
 	}, nil
 }
 
-func ReadRbacPolicy(dexEnabled bool, DexRbacPolicyPath string) (policy map[string]*Permission, err error) {
-	if !dexEnabled {
+func ReadRbacPolicy(rbacEnabled bool, DexRbacPolicyPath string) (policy map[string]*Permission, err error) {
+	if !rbacEnabled {
 		return nil, nil
 	}
 
@@ -183,47 +230,98 @@ func ReadRbacPolicy(dexEnabled bool, DexRbacPolicyPath string) (policy map[strin
 	}
 	defer file.Close()
 
+	policy, err = ParseRbacPolicy(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(policy) == 0 {
+		return nil, errors.New("dex.policy.error: dexRbacPolicy is required when \"KUBERPULT_DEX_ENABLED\" or \"KUBERPULT_RBAC_ENABLED\" is true")
+	}
+	return policy, nil
+}
+
+// ParseRbacPolicy reads a policy in csv format and validates every line of it.
+func ParseRbacPolicy(r io.Reader) (policy map[string]*Permission, err error) {
 	policy = map[string]*Permission{}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
 		// Trim spaces from policy
 		line := strings.ReplaceAll(scanner.Text(), " ", "")
 		p, err := ValidateRbacPermission(line)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
 		}
 		policy[line] = p
 	}
-	if len(policy) == 0 {
-		return nil, errors.New("dex.policy.error: dexRbacPolicy is required when \"KUBERPULT_DEX_ENABLED\" is true")
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 	return policy, nil
 }
 
-// Checks user permissions on the RBAC policy.
-func CheckUserPermissions(rbacConfig RBACConfig, user *User, env, team, envGroup, application, action string) error {
+// Describes which rule of the RBAC policy decided a permission check.
+type PermissionExplanation struct {
+	Allowed bool
+	// The policy line that allowed or denied the action. Empty if no line matched.
+	Rule string
+	// Indicates that the action was allowed because the role is the admin role.
+	AdminRole bool
+}
+
+// Finds the rule of the RBAC policy that decides if the role is allowed to perform the action.
+// Deny rules are evaluated first, then the admin role, then allow rules.
+func ExplainPermission(rbacConfig RBACConfig, role, env, envGroup, application, action string) *PermissionExplanation {
 	// If the action is environment independent, the env format is <ENVIRONMENT_GROUP>:*
 	if isEnvironmentIndependent(action) {
 		env = "*"
 	}
+	if rule, found := findPermission(rbacConfig, PermissionDenyTemplate, role, env, envGroup, application, action); found {
+		return &PermissionExplanation{Allowed: false, Rule: rule}
+	}
+	if rbacConfig.AdminRole != "" && role == rbacConfig.AdminRole {
+		return &PermissionExplanation{Allowed: true, AdminRole: true}
+	}
+	if rule, found := findPermission(rbacConfig, PermissionTemplate, role, env, envGroup, application, action); found {
+		return &PermissionExplanation{Allowed: true, Rule: rule}
+	}
+	return &PermissionExplanation{Allowed: false}
+}
+
+func findPermission(rbacConfig RBACConfig, template, role, env, envGroup, application, action string) (string, bool) {
 	// Check for all possible Wildcard combinations. Maximum of 8 combinations (2^3).
 	for _, pEnvGroup := range []string{envGroup, "*"} {
 		for _, pEnv := range []string{env, "*"} {
 			for _, pApplication := range []string{application, "*"} {
 				// Check if the permission exists on the policy.
-				permissionsWanted := fmt.Sprintf(PermissionTemplate, user.DexAuthContext.Role, action, pEnvGroup, pEnv, pApplication)
+				permissionsWanted := fmt.Sprintf(template, role, action, pEnvGroup, pEnv, pApplication)
 				_, permissionsExist := rbacConfig.Policy[permissionsWanted]
 				if permissionsExist {
-					return nil
+					return permissionsWanted, true
 				}
 			}
 		}
 	}
-	// The permission is not found. Return an error.
+	return "", false
+}
+
+// Checks user permissions on the RBAC policy.
+func CheckUserPermissions(rbacConfig RBACConfig, user *User, env, team, envGroup, application, action string) error {
+	// If the action is environment independent, the env format is <ENVIRONMENT_GROUP>:*
+	if isEnvironmentIndependent(action) {
+		env = "*"
+	}
+	explanation := ExplainPermission(rbacConfig, user.DexAuthContext.Role, env, envGroup, application, action)
+	if explanation.Allowed {
+		return nil
+	}
+	// The permission is not found or explicitly denied. Return an error.
 	var errorMsg = fmt.Sprintf("%s: The user '%s' with role '%s' is not allowed to perform the action '%s' on environment '%s'", codes.PermissionDenied.String(), user.Name, user.DexAuthContext.Role, action, env)
 	if team != "" {
 		errorMsg = errorMsg + fmt.Sprintf(" for team '%s'", team)
 	}
+	if explanation.Rule != "" {
+		errorMsg = errorMsg + fmt.Sprintf(" (denied by '%s')", explanation.Rule)
+	}
 	return status.Errorf(codes.PermissionDenied, errorMsg)
 }
 
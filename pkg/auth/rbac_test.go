@@ -17,7 +17,9 @@ Copyright 2023 freiheit.com*/
 package auth
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -68,6 +70,22 @@ func TestValidateRbacPermission(t *testing.T) {
 			Permission: "Developer,CreateLock,,*,allow",
 			WantError:  "invalid environment ",
 		},
+		{
+			Name:       "Validating deny rules works as expected",
+			Permission: "Developer,DeployRelease,production:prod-de,*,deny",
+			WantPermission: &Permission{
+				Role:        "Developer",
+				Action:      "DeployRelease",
+				Application: "*",
+				Environment: "production:prod-de",
+				Deny:        true,
+			},
+		},
+		{
+			Name:       "Invalid permission effect",
+			Permission: "Developer,DeployRelease,production:prod-de,*,maybe",
+			WantError:  "invalid effect maybe, expected allow or deny",
+		},
 		{
 			Name:       "Invalid permission for Environment Independent action <ENVIRONMENT_GROUP:*>",
 			Permission: "Developer,DeployUndeploy,dev:development-1,*,allow",
@@ -145,6 +163,52 @@ func TestCheckUserPermissions(t *testing.T) {
 			rbacConfig:  RBACConfig{DexEnabled: true, Policy: map[string]*Permission{"Developer,CreateLock,production:production,app1,allow": {Role: "Developer"}}},
 			WantError:   status.Errorf(codes.PermissionDenied, fmt.Sprintf("PermissionDenied: The user '' with role 'Developer' is not allowed to perform the action 'CreateLock' on environment 'production' for team 'other-team'")),
 		},
+		{
+			Name:        "Deny rules are evaluated before allow rules",
+			user:        &User{DexAuthContext: &DexAuthContext{Role: "Developer"}},
+			env:         "prod-de",
+			envGroup:    "production",
+			application: "app1",
+			action:      PermissionDeployRelease,
+			rbacConfig: RBACConfig{DexEnabled: true, Policy: map[string]*Permission{
+				"Developer,DeployRelease,*:*,*,allow":               {Role: "Developer"},
+				"Developer,DeployRelease,production:prod-de,*,deny": {Role: "Developer", Deny: true},
+			}},
+			WantError: status.Errorf(codes.PermissionDenied, fmt.Sprintf("PermissionDenied: The user '' with role 'Developer' is not allowed to perform the action 'DeployRelease' on environment 'prod-de' (denied by 'Developer,DeployRelease,production:prod-de,*,deny')")),
+		},
+		{
+			Name:        "Deny rules only apply to the matching environment",
+			user:        &User{DexAuthContext: &DexAuthContext{Role: "Developer"}},
+			env:         "prod-fr",
+			envGroup:    "production",
+			application: "app1",
+			action:      PermissionDeployRelease,
+			rbacConfig: RBACConfig{DexEnabled: true, Policy: map[string]*Permission{
+				"Developer,DeployRelease,*:*,*,allow":               {Role: "Developer"},
+				"Developer,DeployRelease,production:prod-de,*,deny": {Role: "Developer", Deny: true},
+			}},
+		},
+		{
+			Name:        "Admin role is allowed without permission",
+			user:        &User{DexAuthContext: &DexAuthContext{Role: "Admin"}},
+			env:         "production",
+			envGroup:    "production",
+			application: "app1",
+			action:      PermissionCreateLock,
+			rbacConfig:  RBACConfig{DexEnabled: true, AdminRole: "Admin", Policy: map[string]*Permission{}},
+		},
+		{
+			Name:        "Admin role is denied by deny rules",
+			user:        &User{DexAuthContext: &DexAuthContext{Role: "Admin"}},
+			env:         "production",
+			envGroup:    "production",
+			application: "app1",
+			action:      PermissionCreateLock,
+			rbacConfig: RBACConfig{DexEnabled: true, AdminRole: "Admin", Policy: map[string]*Permission{
+				"Admin,CreateLock,*:*,*,deny": {Role: "Admin", Deny: true},
+			}},
+			WantError: status.Errorf(codes.PermissionDenied, fmt.Sprintf("PermissionDenied: The user '' with role 'Admin' is not allowed to perform the action 'CreateLock' on environment 'production' (denied by 'Admin,CreateLock,*:*,*,deny')")),
+		},
 	}
 
 	for _, tc := range tcs {
@@ -158,6 +222,49 @@ func TestCheckUserPermissions(t *testing.T) {
 	}
 }
 
+func TestCheckAdmin(t *testing.T) {
+	tcs := []struct {
+		Name       string
+		rbacConfig RBACConfig
+		user       User
+		WantError  error
+	}{
+		{
+			Name:       "Dex disabled allows everyone",
+			rbacConfig: RBACConfig{DexEnabled: false, AdminRole: "Admin"},
+			user:       User{Name: "user", DexAuthContext: &DexAuthContext{Role: "Developer"}},
+		},
+		{
+			Name:       "Admin role is allowed",
+			rbacConfig: RBACConfig{DexEnabled: true, AdminRole: "Admin"},
+			user:       User{Name: "user", DexAuthContext: &DexAuthContext{Role: "Admin"}},
+		},
+		{
+			Name:       "Other roles are denied",
+			rbacConfig: RBACConfig{DexEnabled: true, AdminRole: "Admin"},
+			user:       User{Name: "user", DexAuthContext: &DexAuthContext{Role: "Developer"}},
+			WantError:  status.Errorf(codes.PermissionDenied, "PermissionDenied: The user 'user' with role 'Developer' is not allowed to read the queue status"),
+		},
+		{
+			Name:       "Everyone is denied without an admin role",
+			rbacConfig: RBACConfig{DexEnabled: true},
+			user:       User{Name: "user", DexAuthContext: &DexAuthContext{Role: ""}},
+			WantError:  status.Errorf(codes.PermissionDenied, "PermissionDenied: The user 'user' with role '' is not allowed to read the queue status"),
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx := WriteUserToContext(context.Background(), tc.user)
+			err := tc.rbacConfig.CheckAdmin(ctx, "read the queue status")
+			if diff := cmp.Diff(tc.WantError, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Error mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestValidateRbacPermissionWildcards(t *testing.T) {
 	tcs := []struct {
 		Name        string
@@ -237,6 +344,131 @@ func TestCheckUserPermissionsWildcards(t *testing.T) {
 	}
 }
 
+func TestExplainPermission(t *testing.T) {
+	policy := map[string]*Permission{
+		"Developer,DeployRelease,*:*,*,allow":               {Role: "Developer"},
+		"Developer,DeployRelease,production:prod-de,*,deny": {Role: "Developer", Deny: true},
+		"Developer,CreateUndeploy,production:*,*,allow":     {Role: "Developer"},
+	}
+	tcs := []struct {
+		Name            string
+		role            string
+		env             string
+		envGroup        string
+		application     string
+		action          string
+		WantExplanation *PermissionExplanation
+	}{
+		{
+			Name:            "Explains the allowing rule",
+			role:            "Developer",
+			env:             "prod-fr",
+			envGroup:        "production",
+			application:     "app1",
+			action:          PermissionDeployRelease,
+			WantExplanation: &PermissionExplanation{Allowed: true, Rule: "Developer,DeployRelease,*:*,*,allow"},
+		},
+		{
+			Name:            "Explains the denying rule",
+			role:            "Developer",
+			env:             "prod-de",
+			envGroup:        "production",
+			application:     "app1",
+			action:          PermissionDeployRelease,
+			WantExplanation: &PermissionExplanation{Allowed: false, Rule: "Developer,DeployRelease,production:prod-de,*,deny"},
+		},
+		{
+			Name:            "Explains environment independent actions",
+			role:            "Developer",
+			env:             "prod-de",
+			envGroup:        "production",
+			application:     "app1",
+			action:          PermissionCreateUndeploy,
+			WantExplanation: &PermissionExplanation{Allowed: true, Rule: "Developer,CreateUndeploy,production:*,*,allow"},
+		},
+		{
+			Name:            "Explains missing rules",
+			role:            "Developer",
+			env:             "prod-de",
+			envGroup:        "production",
+			application:     "app1",
+			action:          PermissionCreateLock,
+			WantExplanation: &PermissionExplanation{Allowed: false},
+		},
+		{
+			Name:            "Explains the admin role",
+			role:            "Admin",
+			env:             "prod-de",
+			envGroup:        "production",
+			application:     "app1",
+			action:          PermissionCreateLock,
+			WantExplanation: &PermissionExplanation{Allowed: true, AdminRole: true},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			rbacConfig := RBACConfig{DexEnabled: true, AdminRole: "Admin", Policy: policy}
+			explanation := ExplainPermission(rbacConfig, tc.role, tc.env, tc.envGroup, tc.application, tc.action)
+			if diff := cmp.Diff(tc.WantExplanation, explanation); diff != "" {
+				t.Errorf("explanation mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseRbacPolicy(t *testing.T) {
+	tcs := []struct {
+		Name       string
+		Policy     string
+		WantPolicy map[string]*Permission
+		WantError  string
+	}{
+		{
+			Name:   "Parses every line of the policy",
+			Policy: "Developer, CreateLock, development:development, *, allow\nReleaseManager,DeployReleaseTrain,production:*,*,allow\n",
+			WantPolicy: map[string]*Permission{
+				"Developer,CreateLock,development:development,*,allow": {
+					Role:        "Developer",
+					Action:      "CreateLock",
+					Environment: "development:development",
+					Application: "*",
+				},
+				"ReleaseManager,DeployReleaseTrain,production:*,*,allow": {
+					Role:        "ReleaseManager",
+					Action:      "DeployReleaseTrain",
+					Environment: "production:*",
+					Application: "*",
+				},
+			},
+		},
+		{
+			Name:      "Reports the invalid line",
+			Policy:    "Developer,CreateLock,development:development,*,allow\nDeveloper,WRONG_ACTION,dev:development-d2,*,allow",
+			WantError: "line 2: invalid action WRONG_ACTION",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			policy, err := ParseRbacPolicy(strings.NewReader(tc.Policy))
+			if diff := cmp.Diff(tc.WantPolicy, policy, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("policy mismatch (-want +got):\n%s", diff)
+			}
+			if tc.WantError != "" {
+				if err == nil {
+					t.Fatalf("expected error %q but got none", tc.WantError)
+				}
+				if diff := cmp.Diff(tc.WantError, err.Error()); diff != "" {
+					t.Errorf("Error mismatch (-want +got):\n%s", diff)
+				}
+			} else if err != nil {
+				t.Errorf("expected no error but got %v", err)
+			}
+		})
+	}
+}
+
 func TestReadScopes(t *testing.T) {
 	tcs := []struct {
 		Name         string
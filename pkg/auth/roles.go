@@ -0,0 +1,115 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The claim that contains the groups of a user in IAP and Azure tokens.
+const DefaultRoleClaim = "groups"
+
+// Maps the email address of a user to its RBAC role.
+// Emails are stored in lower case.
+type RoleMapping map[string]string
+
+// ReadRoleMapping reads the static email to role mapping from a file.
+func ReadRoleMapping(path string) (RoleMapping, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseRoleMapping(file)
+}
+
+// ParseRoleMapping reads a mapping in csv format, one "<EMAIL>,<ROLE>" per line.
+// Empty lines and lines starting with "#" are ignored.
+func ParseRoleMapping(r io.Reader) (RoleMapping, error) {
+	mapping := RoleMapping{}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected 2 fields but got %d", lineNumber, len(fields))
+		}
+		email := strings.ToLower(strings.TrimSpace(fields[0]))
+		role := strings.TrimSpace(fields[1])
+		if email == "" || role == "" {
+			return nil, fmt.Errorf("line %d: email and role must not be empty", lineNumber)
+		}
+		if _, ok := mapping[email]; ok {
+			return nil, fmt.Errorf("line %d: duplicate email %s", lineNumber, email)
+		}
+		mapping[email] = role
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// RoleResolver determines the RBAC role of a user if the role is not provided by Dex.
+type RoleResolver struct {
+	// The token claim that contains the role. If empty, roles are not read from tokens.
+	Claim string
+	// Static roles by email. A mapping takes precedence over the token claim.
+	Mapping RoleMapping
+}
+
+// Resolve returns the role of the user with the given token claims.
+// Only validated token claims must be passed, so that users cannot choose their role with request headers.
+// The static mapping is looked up by the "email" claim. If the role claim contains several groups, the first one is used.
+// It returns an empty role if no role could be found, which means that the user is not allowed to do anything.
+func (r *RoleResolver) Resolve(claims map[string]interface{}) string {
+	if claims == nil {
+		return ""
+	}
+	if email, ok := claims["email"].(string); ok {
+		if role, ok := r.Mapping[strings.ToLower(email)]; ok {
+			return role
+		}
+	}
+	if r.Claim == "" {
+		return ""
+	}
+	switch value := claims[r.Claim].(type) {
+	case string:
+		return value
+	case []string:
+		for _, group := range value {
+			if group != "" {
+				return group
+			}
+		}
+	case []interface{}:
+		for _, group := range value {
+			if name, ok := group.(string); ok && name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParseRoleMapping(t *testing.T) {
+	tcs := []struct {
+		Name        string
+		Mapping     string
+		WantMapping RoleMapping
+		WantError   string
+	}{
+		{
+			Name:    "Parses every line and ignores comments",
+			Mapping: "# email,role\nAlice@Example.com, Developer\n\nbob@example.com,ReleaseManager\n",
+			WantMapping: RoleMapping{
+				"alice@example.com": "Developer",
+				"bob@example.com":   "ReleaseManager",
+			},
+		},
+		{
+			Name:      "Reports lines with a wrong number of fields",
+			Mapping:   "alice@example.com,Developer\nbob@example.com",
+			WantError: "line 2: expected 2 fields but got 1",
+		},
+		{
+			Name:      "Reports empty roles",
+			Mapping:   "alice@example.com,",
+			WantError: "line 1: email and role must not be empty",
+		},
+		{
+			Name:      "Reports duplicate emails",
+			Mapping:   "alice@example.com,Developer\nALICE@example.com,ReleaseManager",
+			WantError: "line 2: duplicate email alice@example.com",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			mapping, err := ParseRoleMapping(strings.NewReader(tc.Mapping))
+			if diff := cmp.Diff(tc.WantMapping, mapping, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("mapping mismatch (-want +got):\n%s", diff)
+			}
+			if tc.WantError != "" {
+				if err == nil {
+					t.Fatalf("expected error %q but got none", tc.WantError)
+				}
+				if diff := cmp.Diff(tc.WantError, err.Error()); diff != "" {
+					t.Errorf("Error mismatch (-want +got):\n%s", diff)
+				}
+			} else if err != nil {
+				t.Errorf("expected no error but got %v", err)
+			}
+		})
+	}
+}
+
+func TestRoleResolver(t *testing.T) {
+	tcs := []struct {
+		Name     string
+		Resolver RoleResolver
+		Claims   map[string]interface{}
+		WantRole string
+	}{
+		{
+			Name:     "Reads the role from a string claim",
+			Resolver: RoleResolver{Claim: "role"},
+			Claims:   map[string]interface{}{"role": "Developer"},
+			WantRole: "Developer",
+		},
+		{
+			Name:     "Reads the first group of a list claim",
+			Resolver: RoleResolver{Claim: DefaultRoleClaim},
+			Claims:   map[string]interface{}{"groups": []interface{}{"Developer", "ReleaseManager"}},
+			WantRole: "Developer",
+		},
+		{
+			Name: "The static mapping takes precedence over the claim",
+			Resolver: RoleResolver{
+				Claim:   DefaultRoleClaim,
+				Mapping: RoleMapping{"alice@example.com": "ReleaseManager"},
+			},
+			Claims:   map[string]interface{}{"email": "Alice@example.com", "groups": []interface{}{"Developer"}},
+			WantRole: "ReleaseManager",
+		},
+		{
+			Name:     "Returns no role if the claim is missing",
+			Resolver: RoleResolver{Claim: DefaultRoleClaim},
+			Claims:   map[string]interface{}{"email": "alice@example.com"},
+			WantRole: "",
+		},
+		{
+			Name: "Returns no role without validated claims",
+			Resolver: RoleResolver{
+				Claim:   DefaultRoleClaim,
+				Mapping: RoleMapping{"alice@example.com": "ReleaseManager"},
+			},
+			Claims:   nil,
+			WantRole: "",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			role := tc.Resolver.Resolve(tc.Claims)
+			if diff := cmp.Diff(tc.WantRole, role); diff != "" {
+				t.Errorf("role mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+// Package cli implements the kuberpult command line client for the rest api of the frontend-service.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/freiheit-com/kuberpult/pkg/client"
+)
+
+const usage = `Usage: kuberpult <command> [flags]
+
+Commands:
+  release create         Create a release from a directory of manifests
+  deploy                 Deploy a version of an application to an environment
+  lock env|app|group     Lock an environment, an application or an environment group
+  unlock env|app|group   Delete a lock
+  release-train          Run a release train, or show what it would deploy with --dry-run
+  rollout wait           Wait until all applications of an environment group are rolled out
+  overview               Show the deployed versions in all environments
+
+Run "kuberpult <command> --help" for the flags of a command.
+The common flags default to the environment variables KUBERPULT_URL, KUBERPULT_TOKEN,
+KUBERPULT_PGP_KEY, KUBERPULT_PGP_PASSPHRASE, KUBERPULT_AUTHOR_NAME and KUBERPULT_AUTHOR_EMAIL.
+`
+
+// Streams are the outputs of a command. Results are written to Out, so that they can be piped to other tools.
+type streams struct {
+	Out io.Writer
+	Err io.Writer
+}
+
+type commandFunc func(ctx context.Context, args []string, s streams) error
+
+var commands = map[string]commandFunc{
+	"release create": runReleaseCreate,
+	"deploy":         runDeploy,
+	"lock env":       runLockEnvironment,
+	"lock app":       runLockApplication,
+	"lock group":     runLockEnvironmentGroup,
+	"unlock env":     runUnlockEnvironment,
+	"unlock app":     runUnlockApplication,
+	"unlock group":   runUnlockEnvironmentGroup,
+	"release-train":  runReleaseTrain,
+	"rollout wait":   runRolloutWait,
+	"overview":       runOverview,
+}
+
+// commands with subcommands, e.g. "lock env"
+var commandGroups = map[string]bool{
+	"release": true,
+	"lock":    true,
+	"unlock":  true,
+	"rollout": true,
+}
+
+// Run executes the command in args and returns the exit code.
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	s := streams{Out: stdout, Err: stderr}
+	if len(args) == 0 || args[0] == "help" || args[0] == "--help" || args[0] == "-h" {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	name, rest := args[0], args[1:]
+	if commandGroups[name] && len(rest) > 0 {
+		name, rest = name+" "+rest[0], rest[1:]
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command '%s'\n\n%s", name, usage)
+		return 2
+	}
+	if err := run(ctx, rest, s); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 2
+		}
+		fmt.Fprintf(stderr, "error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+type options struct {
+	url           string
+	token         string
+	pgpKey        string
+	pgpPassphrase string
+	authorName    string
+	authorEmail   string
+	retries       uint64
+	output        string
+}
+
+func newFlagSet(name string, s streams) (*flag.FlagSet, *options) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(s.Err)
+	o := &options{}
+	fs.StringVar(&o.url, "url", os.Getenv("KUBERPULT_URL"), "url of the kuberpult frontend-service")
+	fs.StringVar(&o.token, "token", os.Getenv("KUBERPULT_TOKEN"), "token for the authorization header, e.g. an azure id token")
+	fs.StringVar(&o.pgpKey, "pgp-key", os.Getenv("KUBERPULT_PGP_KEY"), "file with the armored private pgp key that signs the requests")
+	fs.StringVar(&o.pgpPassphrase, "pgp-passphrase", os.Getenv("KUBERPULT_PGP_PASSPHRASE"), "passphrase of the pgp key")
+	fs.StringVar(&o.authorName, "author-name", os.Getenv("KUBERPULT_AUTHOR_NAME"), "git author of the changes in the manifest repository")
+	fs.StringVar(&o.authorEmail, "author-email", os.Getenv("KUBERPULT_AUTHOR_EMAIL"), "git author email of the changes in the manifest repository")
+	fs.Uint64Var(&o.retries, "retries", 3, "how often failed requests are retried")
+	fs.StringVar(&o.output, "output", "text", "output format, text or json")
+	return fs, o
+}
+
+// backOffProvider is replaced in tests to avoid waiting.
+var backOffProvider = client.DefaultBackOff
+
+func (o *options) newClient() (*client.Client, error) {
+	if o.url == "" {
+		return nil, fmt.Errorf("missing --url or KUBERPULT_URL")
+	}
+	if o.output != "text" && o.output != "json" {
+		return nil, fmt.Errorf("invalid --output '%s', must be text or json", o.output)
+	}
+	config := client.Config{
+		Url:         o.url,
+		Token:       o.token,
+		Signer:      nil,
+		AuthorName:  o.authorName,
+		AuthorEmail: o.authorEmail,
+		Retries:     o.retries,
+		BackOff:     backOffProvider,
+		HttpClient:  nil,
+		GrpcConn:    nil,
+	}
+	if o.pgpKey != "" {
+		signer, err := client.ReadSigner(o.pgpKey, o.pgpPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		config.Signer = signer
+	}
+	return client.New(config), nil
+}
+
+// stringList is a flag that can be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func requireFlags(fs *flag.FlagSet, names ...string) error {
+	for _, name := range names {
+		if fs.Lookup(name).Value.String() == "" {
+			return fmt.Errorf("missing required flag --%s", name)
+		}
+	}
+	return nil
+}
+
+func noArguments(fs *flag.FlagSet) error {
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
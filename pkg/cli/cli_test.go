@@ -0,0 +1,332 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/freiheit-com/kuberpult/pkg/client"
+	"github.com/google/go-cmp/cmp"
+)
+
+type recordedRequest struct {
+	Method      string
+	Path        string
+	Query       string
+	ContentType string
+	Body        string
+}
+
+type cannedResponse struct {
+	Status int
+	Body   string
+}
+
+// fakeFrontend records all requests and answers with canned responses by "<METHOD> <PATH>".
+// A list of responses is returned in order, the last one is repeated.
+type fakeFrontend struct {
+	mx        sync.Mutex
+	requests  []recordedRequest
+	responses map[string][]cannedResponse
+}
+
+func (f *fakeFrontend) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	f.requests = append(f.requests, recordedRequest{
+		Method:      req.Method,
+		Path:        req.URL.Path,
+		Query:       req.URL.RawQuery,
+		ContentType: req.Header.Get("Content-Type"),
+		Body:        string(body),
+	})
+	key := req.Method + " " + req.URL.Path
+	responses := f.responses[key]
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	response := responses[0]
+	if len(responses) > 1 {
+		f.responses[key] = responses[1:]
+	}
+	w.WriteHeader(response.Status)
+	w.Write([]byte(response.Body))
+}
+
+func writeTestKey(t *testing.T) (string, openpgp.EntityList) {
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	path := filepath.Join(t.TempDir(), "key.asc")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, openpgp.EntityList{entity}
+}
+
+func TestRun(t *testing.T) {
+	backOffProvider = func() backoff.BackOff { return &backoff.ZeroBackOff{} }
+	defer func() { backOffProvider = client.DefaultBackOff }()
+
+	tcs := []struct {
+		Name             string
+		Args             []string
+		Responses        map[string][]cannedResponse
+		ExpectedExitCode int
+		ExpectedOutput   string
+		ExpectedErr      string
+		ExpectedRequests []recordedRequest
+	}{
+		{
+			Name:             "deploys a version",
+			Args:             []string{"deploy", "--environment", "dev", "--application", "app1", "--version", "3", "--lock-behavior", "fail"},
+			ExpectedExitCode: 0,
+			ExpectedOutput:   "deployed app1 version 3 to dev\n",
+			ExpectedRequests: []recordedRequest{
+				{Method: "PUT", Path: "/environments/dev/applications/app1/deploy", ContentType: "application/json", Body: `{"version":3,"lockBehavior":"fail"}`},
+			},
+		},
+		{
+			Name: "reports the locks that block a deployment",
+			Args: []string{"deploy", "--environment", "dev", "--application", "app1", "--version", "3"},
+			Responses: map[string][]cannedResponse{
+				"PUT /environments/dev/applications/app1/deploy": {{Status: http.StatusConflict, Body: `{"environmentLocks":{"l1":{"message":"upgrade","lockId":"l1"}},"environmentApplicationLocks":{}}`}},
+			},
+			ExpectedExitCode: 1,
+			ExpectedErr:      "error: blocked by environment lock 'l1' (upgrade)\n",
+			ExpectedRequests: []recordedRequest{
+				{Method: "PUT", Path: "/environments/dev/applications/app1/deploy", ContentType: "application/json", Body: `{"version":3,"lockBehavior":"record"}`},
+			},
+		},
+		{
+			Name: "retries temporary failures",
+			Args: []string{"lock", "app", "--environment", "dev", "--application", "app1", "--lock-id", "l1", "--message", "wait", "--output", "json"},
+			Responses: map[string][]cannedResponse{
+				"PUT /environments/dev/applications/app1/locks/l1": {{Status: http.StatusServiceUnavailable}, {Status: http.StatusOK}},
+			},
+			ExpectedExitCode: 0,
+			ExpectedOutput:   "{\n  \"lock\": \"app\",\n  \"target\": {\n    \"application\": \"app1\",\n    \"environment\": \"dev\"\n  },\n  \"lockId\": \"l1\",\n  \"message\": \"wait\",\n  \"deleted\": false\n}\n",
+			ExpectedRequests: []recordedRequest{
+				{Method: "PUT", Path: "/environments/dev/applications/app1/locks/l1", ContentType: "application/json", Body: `{"message":"wait"}`},
+				{Method: "PUT", Path: "/environments/dev/applications/app1/locks/l1", ContentType: "application/json", Body: `{"message":"wait"}`},
+			},
+		},
+		{
+			Name:             "does not retry client errors",
+			Args:             []string{"unlock", "group", "--environment-group", "prod", "--lock-id", "l1", "--retries", "5"},
+			Responses:        map[string][]cannedResponse{"DELETE /environment-groups/prod/locks/l1": {{Status: http.StatusNotFound, Body: "not found\n"}}},
+			ExpectedExitCode: 1,
+			ExpectedErr:      "error: request failed with status 404: not found\n",
+			ExpectedRequests: []recordedRequest{
+				{Method: "DELETE", Path: "/environment-groups/prod/locks/l1", ContentType: "application/json"},
+			},
+		},
+		{
+			Name: "plans a release train without deploying",
+			Args: []string{"release-train", "--target", "prod", "--dry-run"},
+			Responses: map[string][]cannedResponse{
+				"GET /environments": {{Status: http.StatusOK, Body: `[{"name":"staging","environmentGroup":"staging"},{"name":"prod-de","environmentGroup":"prod"}]`}},
+				"GET /environments/prod-de": {{Status: http.StatusOK, Body: `{"name":"prod-de","upstream":{"environment":"staging"},"applications":{
+					"app1":{"name":"app1","version":1},
+					"app2":{"name":"app2","version":2,"locks":{"l1":{}}},
+					"app3":{"name":"app3","version":3}}}`}},
+				"GET /environments/staging": {{Status: http.StatusOK, Body: `{"name":"staging","applications":{
+					"app1":{"name":"app1","version":2},
+					"app2":{"name":"app2","version":3},
+					"app3":{"name":"app3","version":3}}}`}},
+			},
+			ExpectedExitCode: 0,
+			ExpectedOutput:   "prod-de/app1: 1 -> 2\nprod-de/app2: skip (application is locked)\nprod-de/app3: skip (already deployed)\n",
+			ExpectedRequests: []recordedRequest{
+				{Method: "GET", Path: "/environments"},
+				{Method: "GET", Path: "/environments/prod-de"},
+				{Method: "GET", Path: "/environments/staging"},
+			},
+		},
+		{
+			Name: "shows the changelog of a planned release train",
+			Args: []string{"release-train", "--target", "prod-de", "--dry-run", "--changelog"},
+			Responses: map[string][]cannedResponse{
+				"GET /environments":         {{Status: http.StatusOK, Body: `[{"name":"prod-de","environmentGroup":"prod"}]`}},
+				"GET /environments/prod-de": {{Status: http.StatusOK, Body: `{"name":"prod-de","upstream":{"environment":"staging"},"applications":{"app1":{"name":"app1","version":1}}}`}},
+				"GET /environments/staging": {{Status: http.StatusOK, Body: `{"name":"staging","applications":{"app1":{"name":"app1","version":2}}}`}},
+				"GET /environments/prod-de/applications/app1/changelog": {{Status: http.StatusOK, Body: `{"application":"app1","environment":"prod-de","deployedVersion":1,"version":2,"commits":[
+					{"commitId":"cccccccccccccccccccccccccccccccccccccccc","message":"fix the login\n\ndetails"},
+					{"commitId":"bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb","message":"add a button"}]}`}},
+			},
+			ExpectedExitCode: 0,
+			ExpectedOutput:   "prod-de/app1: 1 -> 2\n  ccccccc fix the login\n  bbbbbbb add a button\n",
+			ExpectedRequests: []recordedRequest{
+				{Method: "GET", Path: "/environments"},
+				{Method: "GET", Path: "/environments/prod-de"},
+				{Method: "GET", Path: "/environments/staging"},
+				{Method: "GET", Path: "/environments/prod-de/applications/app1/changelog", Query: "version=2"},
+			},
+		},
+		{
+			Name: "runs a release train",
+			Args: []string{"release-train", "--target", "prod", "--team", "sre"},
+			Responses: map[string][]cannedResponse{
+				"PUT /environments/prod/releasetrain": {{Status: http.StatusOK, Body: `{"target":"prod","team":"sre"}`}},
+			},
+			ExpectedExitCode: 0,
+			ExpectedOutput:   "release train to prod done\n",
+			ExpectedRequests: []recordedRequest{
+				{Method: "PUT", Path: "/environments/prod/releasetrain", Query: "team=sre"},
+			},
+		},
+		{
+			Name: "waits for the rollout",
+			Args: []string{"rollout", "wait", "--environment-group", "prod", "--timeout", "0s"},
+			Responses: map[string][]cannedResponse{
+				"POST /environment-groups/prod/rollout-status": {{Status: http.StatusOK, Body: `{"status":"progressing","applications":[{"application":"app1","environment":"prod-de","status":"progressing"}]}`}},
+			},
+			ExpectedExitCode: 1,
+			ExpectedOutput:   "prod-de/app1: progressing\n",
+			ExpectedErr:      "error: prod is not rolled out after 0s: progressing\n",
+			ExpectedRequests: []recordedRequest{
+				{Method: "POST", Path: "/environment-groups/prod/rollout-status", ContentType: "application/json", Body: `{}`},
+			},
+		},
+		{
+			Name:             "requires flags",
+			Args:             []string{"lock", "env", "--message", "hello"},
+			ExpectedExitCode: 1,
+			ExpectedErr:      "error: missing required flag --environment\n",
+		},
+		{
+			Name:             "rejects unknown commands",
+			Args:             []string{"lock", "cluster"},
+			ExpectedExitCode: 2,
+			ExpectedErr:      "unknown command 'lock cluster'\n\n" + usage,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			frontend := &fakeFrontend{responses: tc.Responses}
+			server := httptest.NewServer(frontend)
+			defer server.Close()
+			var stdout, stderr bytes.Buffer
+			args := append(tc.Args, "--url", server.URL)
+			if tc.ExpectedRequests == nil {
+				args = tc.Args
+			}
+			exitCode := Run(context.Background(), args, &stdout, &stderr)
+			if exitCode != tc.ExpectedExitCode {
+				t.Errorf("expected exit code %d but got %d, stderr: %s", tc.ExpectedExitCode, exitCode, stderr.String())
+			}
+			if d := cmp.Diff(tc.ExpectedOutput, stdout.String()); d != "" {
+				t.Errorf("output mismatch: %s", d)
+			}
+			if d := cmp.Diff(tc.ExpectedErr, stderr.String()); d != "" {
+				t.Errorf("error output mismatch: %s", d)
+			}
+			if d := cmp.Diff(tc.ExpectedRequests, frontend.requests); d != "" {
+				t.Errorf("requests mismatch: %s", d)
+			}
+		})
+	}
+}
+
+func TestReleaseCreate(t *testing.T) {
+	keyPath, keyRing := writeTestKey(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "dev.yaml"), []byte("dev manifest"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "prod"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "prod", "manifests.yaml"), []byte("prod manifest"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var form map[string][]string
+	manifests := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("invalid multipart form: %s", err)
+			return
+		}
+		form = req.MultipartForm.Value
+		for _, env := range []string{"dev", "prod"} {
+			manifest, err := req.MultipartForm.File["manifests["+env+"]"][0].Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(manifest)
+			manifests[env] = string(content)
+			signature, err := req.MultipartForm.File["signatures["+env+"]"][0].Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := openpgp.CheckArmoredDetachedSignature(keyRing, bytes.NewReader(content), signature, nil); err != nil {
+				t.Errorf("invalid signature for %s: %s", env, err)
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Success":{}}`))
+	}))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	exitCode := Run(context.Background(), []string{"release", "create", "--url", server.URL, "--pgp-key", keyPath, "--application", "app1", "--manifests", dir, "--version", "12", "--team", "sre", "--output", "json"}, &stdout, &stderr)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0 but got %d: %s", exitCode, stderr.String())
+	}
+	if d := cmp.Diff(map[string][]string{"application": {"app1"}, "team": {"sre"}, "version": {"12"}}, form); d != "" {
+		t.Errorf("form mismatch: %s", d)
+	}
+	if d := cmp.Diff(map[string]string{"dev": "dev manifest", "prod": "prod manifest"}, manifests); d != "" {
+		t.Errorf("manifests mismatch: %s", d)
+	}
+	var result releaseResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("invalid json output %s: %s", stdout.String(), err)
+	}
+	if d := cmp.Diff(releaseResult{Application: "app1", Version: 12, Status: "created", Environments: []string{"dev", "prod"}}, result); d != "" {
+		t.Errorf("result mismatch: %s", d)
+	}
+	if stderr.String() != "" {
+		t.Errorf("unexpected error output: %s", stderr.String())
+	}
+}
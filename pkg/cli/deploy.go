@@ -0,0 +1,65 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/freiheit-com/kuberpult/pkg/client"
+)
+
+type deployResult struct {
+	Environment  string `json:"environment"`
+	Application  string `json:"application"`
+	Version      uint64 `json:"version"`
+	LockBehavior string `json:"lockBehavior"`
+}
+
+func runDeploy(ctx context.Context, args []string, s streams) error {
+	fs, o := newFlagSet("deploy", s)
+	environment := fs.String("environment", "", "environment to deploy to")
+	application := fs.String("application", "", "application to deploy")
+	version := fs.Uint64("version", 0, "release to deploy")
+	lockBehavior := fs.String("lock-behavior", "record", "what to do if the application is locked: record, fail or ignore")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := noArguments(fs); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "environment", "application"); err != nil {
+		return err
+	}
+	if *version == 0 {
+		return fmt.Errorf("missing required flag --version")
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	if err := c.Deploy(ctx, *environment, *application, *version, client.LockBehavior(*lockBehavior)); err != nil {
+		return err
+	}
+	result := deployResult{
+		Environment:  *environment,
+		Application:  *application,
+		Version:      *version,
+		LockBehavior: *lockBehavior,
+	}
+	return writeResult(s, o, result, fmt.Sprintf("deployed %s version %d to %s", *application, *version, *environment))
+}
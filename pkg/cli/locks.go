@@ -0,0 +1,177 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/freiheit-com/kuberpult/pkg/client"
+)
+
+// A lockKind describes the differences between environment, application and environment group locks.
+type lockKind struct {
+	name string
+	// flags that identify the locked entity, e.g. "environment"
+	flags  []string
+	create func(ctx context.Context, c *client.Client, values []string, lockId, message string) error
+	delete func(ctx context.Context, c *client.Client, values []string, lockId string) error
+}
+
+var (
+	environmentLock = lockKind{
+		name:  "env",
+		flags: []string{"environment"},
+		create: func(ctx context.Context, c *client.Client, values []string, lockId, message string) error {
+			return c.CreateEnvironmentLock(ctx, values[0], lockId, message)
+		},
+		delete: func(ctx context.Context, c *client.Client, values []string, lockId string) error {
+			return c.DeleteEnvironmentLock(ctx, values[0], lockId)
+		},
+	}
+	applicationLock = lockKind{
+		name:  "app",
+		flags: []string{"environment", "application"},
+		create: func(ctx context.Context, c *client.Client, values []string, lockId, message string) error {
+			return c.CreateApplicationLock(ctx, values[0], values[1], lockId, message)
+		},
+		delete: func(ctx context.Context, c *client.Client, values []string, lockId string) error {
+			return c.DeleteApplicationLock(ctx, values[0], values[1], lockId)
+		},
+	}
+	environmentGroupLock = lockKind{
+		name:  "group",
+		flags: []string{"environment-group"},
+		create: func(ctx context.Context, c *client.Client, values []string, lockId, message string) error {
+			return c.CreateEnvironmentGroupLock(ctx, values[0], lockId, message)
+		},
+		delete: func(ctx context.Context, c *client.Client, values []string, lockId string) error {
+			return c.DeleteEnvironmentGroupLock(ctx, values[0], lockId)
+		},
+	}
+)
+
+type lockResult struct {
+	Lock    string            `json:"lock"`
+	Target  map[string]string `json:"target"`
+	LockId  string            `json:"lockId"`
+	Message string            `json:"message,omitempty"`
+	Deleted bool              `json:"deleted"`
+}
+
+func runLockEnvironment(ctx context.Context, args []string, s streams) error {
+	return runLock(ctx, args, s, environmentLock, true)
+}
+
+func runLockApplication(ctx context.Context, args []string, s streams) error {
+	return runLock(ctx, args, s, applicationLock, true)
+}
+
+func runLockEnvironmentGroup(ctx context.Context, args []string, s streams) error {
+	return runLock(ctx, args, s, environmentGroupLock, true)
+}
+
+func runUnlockEnvironment(ctx context.Context, args []string, s streams) error {
+	return runLock(ctx, args, s, environmentLock, false)
+}
+
+func runUnlockApplication(ctx context.Context, args []string, s streams) error {
+	return runLock(ctx, args, s, applicationLock, false)
+}
+
+func runUnlockEnvironmentGroup(ctx context.Context, args []string, s streams) error {
+	return runLock(ctx, args, s, environmentGroupLock, false)
+}
+
+func runLock(ctx context.Context, args []string, s streams, kind lockKind, create bool) error {
+	command := "unlock " + kind.name
+	if create {
+		command = "lock " + kind.name
+	}
+	fs, o := newFlagSet(command, s)
+	values := make([]*string, len(kind.flags))
+	for i, name := range kind.flags {
+		values[i] = fs.String(name, "", "name of the "+strings.ReplaceAll(name, "-", " "))
+	}
+	lockId := fs.String("lock-id", "", "id of the lock, generated when creating a lock without id")
+	message := fs.String("message", "", "why the lock is created")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := noArguments(fs); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, kind.flags...); err != nil {
+		return err
+	}
+	if create {
+		if err := requireFlags(fs, "message"); err != nil {
+			return err
+		}
+		if *lockId == "" {
+			id, err := newLockId()
+			if err != nil {
+				return err
+			}
+			*lockId = id
+		}
+	} else if err := requireFlags(fs, "lock-id"); err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	target := map[string]string{}
+	targetValues := make([]string, len(values))
+	for i, value := range values {
+		target[kind.flags[i]] = *value
+		targetValues[i] = *value
+	}
+	if create {
+		err = kind.create(ctx, c, targetValues, *lockId, *message)
+	} else {
+		err = kind.delete(ctx, c, targetValues, *lockId)
+	}
+	if err != nil {
+		return err
+	}
+	result := lockResult{
+		Lock:    kind.name,
+		Target:  target,
+		LockId:  *lockId,
+		Message: *message,
+		Deleted: !create,
+	}
+	text := fmt.Sprintf("created lock %s", *lockId)
+	if !create {
+		text = fmt.Sprintf("deleted lock %s", *lockId)
+	}
+	return writeResult(s, o, result, text)
+}
+
+// newLockId generates a lock id. The prefix shows where the lock comes from, like the "ui-" locks of the ui.
+func newLockId() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return "cli-" + hex.EncodeToString(random), nil
+}
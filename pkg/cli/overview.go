@@ -0,0 +1,77 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/freiheit-com/kuberpult/pkg/client"
+)
+
+func runOverview(ctx context.Context, args []string, s streams) error {
+	fs, o := newFlagSet("overview", s)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := noArguments(fs); err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	summaries, err := c.GetEnvironments(ctx)
+	if err != nil {
+		return err
+	}
+	environments := make([]client.Environment, 0, len(summaries))
+	for _, summary := range summaries {
+		env, err := c.GetEnvironment(ctx, summary.Name)
+		if err != nil {
+			return err
+		}
+		environments = append(environments, *env)
+	}
+	return writeResult(s, o, environments, formatOverview(environments))
+}
+
+func formatOverview(environments []client.Environment) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ENVIRONMENT\tAPPLICATION\tVERSION\tQUEUED\tLOCKS")
+	for _, env := range environments {
+		names := make([]string, 0, len(env.Applications))
+		for name := range env.Applications {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			app := env.Applications[name]
+			queued := "-"
+			if app.QueuedVersion != 0 {
+				queued = fmt.Sprint(app.QueuedVersion)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\n", env.Name, name, app.Version, queued, len(env.Locks)+len(app.Locks))
+		}
+	}
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
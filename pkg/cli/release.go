@@ -0,0 +1,134 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/freiheit-com/kuberpult/pkg/client"
+)
+
+type releaseResult struct {
+	Application  string   `json:"application"`
+	Version      uint64   `json:"version,omitempty"`
+	Status       string   `json:"status"`
+	Environments []string `json:"environments"`
+}
+
+func runReleaseCreate(ctx context.Context, args []string, s streams) error {
+	fs, o := newFlagSet("release create", s)
+	application := fs.String("application", "", "name of the application")
+	manifests := fs.String("manifests", "", "directory with one <environment>.yaml file or <environment>/manifests.yaml per environment")
+	team := fs.String("team", "", "team that owns the application")
+	sourceCommitId := fs.String("source-commit-id", "", "commit hash in the source repository")
+	sourceAuthor := fs.String("source-author", "", "author of the commit in the source repository")
+	sourceMessage := fs.String("source-message", "", "message of the commit in the source repository")
+	sourceRepoUrl := fs.String("source-repo-url", "", "url of the source repository, used for the changelog")
+	var sourcePaths stringList
+	fs.Var(&sourcePaths, "source-path", "path in the source repository that belongs to the application, used to filter the changelog; can be repeated")
+	version := fs.Uint64("version", 0, "version of the release, defaults to the last version + 1")
+	displayVersion := fs.String("display-version", "", "version shown in the ui")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := noArguments(fs); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "application", "manifests"); err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	files, err := readManifests(*manifests)
+	if err != nil {
+		return err
+	}
+
+	created, err := c.CreateRelease(ctx, client.ReleaseRequest{
+		Application:    *application,
+		Manifests:      files,
+		Team:           *team,
+		SourceCommitId: *sourceCommitId,
+		SourceAuthor:   *sourceAuthor,
+		SourceMessage:  *sourceMessage,
+		SourceRepoUrl:  *sourceRepoUrl,
+		SourcePaths:    sourcePaths,
+		Version:        *version,
+		DisplayVersion: *displayVersion,
+	})
+	if err != nil {
+		return err
+	}
+	environments := make([]string, 0, len(files))
+	for env := range files {
+		environments = append(environments, env)
+	}
+	sort.Strings(environments)
+	result := releaseResult{
+		Application:  *application,
+		Version:      *version,
+		Status:       "created",
+		Environments: environments,
+	}
+	if !created {
+		result.Status = "already exists"
+	}
+	return writeResult(s, o, result, fmt.Sprintf("release of %s %s for %s", *application, result.Status, strings.Join(environments, ", ")))
+}
+
+// readManifests reads the manifests by environment from a directory.
+// Each environment has either a file "<environment>.yaml" or a directory with a file "manifests.yaml".
+func readManifests(dir string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	manifests := map[string][]byte{}
+	for _, entry := range entries {
+		var env, path string
+		if entry.IsDir() {
+			env, path = entry.Name(), filepath.Join(dir, entry.Name(), "manifests.yaml")
+		} else if ext := filepath.Ext(entry.Name()); ext == ".yaml" || ext == ".yml" {
+			env, path = strings.TrimSuffix(entry.Name(), ext), filepath.Join(dir, entry.Name())
+		} else {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		if _, ok := manifests[env]; ok {
+			return nil, fmt.Errorf("multiple manifests for environment %s in %s", env, dir)
+		}
+		manifests[env] = content
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no manifests found in %s", dir)
+	}
+	return manifests, nil
+}
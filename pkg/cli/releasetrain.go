@@ -0,0 +1,198 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/freiheit-com/kuberpult/pkg/client"
+)
+
+// A plannedDeployment is what a release train would do for one application in one environment.
+type plannedDeployment struct {
+	Environment    string `json:"environment"`
+	Application    string `json:"application"`
+	CurrentVersion uint64 `json:"currentVersion"`
+	TargetVersion  uint64 `json:"targetVersion"`
+	// Why the application would not be deployed, empty if it would be deployed.
+	SkipReason string `json:"skipReason,omitempty"`
+	// The commits that would be deployed, only with --changelog.
+	Changelog []client.SourceCommit `json:"changelog,omitempty"`
+}
+
+func runReleaseTrain(ctx context.Context, args []string, s streams) error {
+	fs, o := newFlagSet("release-train", s)
+	target := fs.String("target", "", "environment or environment group to deploy to")
+	team := fs.String("team", "", "only deploy the applications of this team")
+	dryRun := fs.Bool("dry-run", false, "only show what would be deployed")
+	changelog := fs.Bool("changelog", false, "with --dry-run, also show the commits that would be deployed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := noArguments(fs); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "target"); err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		plan, err := planReleaseTrain(ctx, c, *target, *team)
+		if err != nil {
+			return err
+		}
+		if *changelog {
+			if err := addChangelogs(ctx, c, plan); err != nil {
+				return err
+			}
+		}
+		return writeResult(s, o, plan, formatPlan(plan))
+	}
+	result, err := c.ReleaseTrain(ctx, *target, *team)
+	if err != nil {
+		return err
+	}
+	return writeResult(s, o, result, fmt.Sprintf("release train to %s done", *target))
+}
+
+// planReleaseTrain computes the deployments of a release train from the current state.
+// This mirrors the release train of the cd-service, but it is only a preview: the state can change until the train runs.
+// For environments with the latest upstream, only applications that are already deployed there are considered.
+func planReleaseTrain(ctx context.Context, c *client.Client, target, team string) ([]plannedDeployment, error) {
+	environments, err := c.GetEnvironments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan := []plannedDeployment{}
+	found := false
+	for _, summary := range environments {
+		if summary.Name != target && summary.EnvironmentGroup != target {
+			continue
+		}
+		found = true
+		env, err := c.GetEnvironment(ctx, summary.Name)
+		if err != nil {
+			return nil, err
+		}
+		deployments, err := planEnvironment(ctx, c, env, team)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, deployments...)
+	}
+	if !found {
+		return nil, fmt.Errorf("no environment or environment group named %s", target)
+	}
+	return plan, nil
+}
+
+func planEnvironment(ctx context.Context, c *client.Client, env *client.Environment, team string) ([]plannedDeployment, error) {
+	if env.Upstream == nil || (env.Upstream.Environment == "" && !env.Upstream.Latest) {
+		return nil, fmt.Errorf("environment %s has no upstream", env.Name)
+	}
+	targetVersions := map[string]uint64{}
+	if env.Upstream.Latest {
+		for name := range env.Applications {
+			page, err := c.GetReleases(ctx, name, 0, 1)
+			if err != nil {
+				return nil, err
+			}
+			if len(page.Releases) > 0 {
+				targetVersions[name] = page.Releases[0].Version
+			}
+		}
+	} else {
+		upstreamEnv, err := c.GetEnvironment(ctx, env.Upstream.Environment)
+		if err != nil {
+			return nil, err
+		}
+		for name, app := range upstreamEnv.Applications {
+			targetVersions[name] = app.Version
+		}
+	}
+	names := make([]string, 0, len(targetVersions))
+	for name := range targetVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	plan := []plannedDeployment{}
+	for _, name := range names {
+		app := env.Applications[name]
+		if team != "" && app.Team != "" && app.Team != team {
+			continue
+		}
+		deployment := plannedDeployment{
+			Environment:    env.Name,
+			Application:    name,
+			CurrentVersion: app.Version,
+			TargetVersion:  targetVersions[name],
+			SkipReason:     "",
+		}
+		switch {
+		case deployment.TargetVersion == 0:
+			deployment.SkipReason = "not deployed upstream"
+		case deployment.TargetVersion == deployment.CurrentVersion:
+			deployment.SkipReason = "already deployed"
+		case len(env.Locks) > 0:
+			deployment.SkipReason = "environment is locked"
+		case len(app.Locks) > 0:
+			deployment.SkipReason = "application is locked"
+		}
+		plan = append(plan, deployment)
+	}
+	return plan, nil
+}
+
+// addChangelogs adds the commits of the source repository to the deployments that would happen.
+func addChangelogs(ctx context.Context, c *client.Client, plan []plannedDeployment) error {
+	for i := range plan {
+		if plan[i].SkipReason != "" {
+			continue
+		}
+		changelog, err := c.GetChangelog(ctx, plan[i].Environment, plan[i].Application, plan[i].TargetVersion)
+		if err != nil {
+			return err
+		}
+		plan[i].Changelog = changelog.Commits
+	}
+	return nil
+}
+
+func formatPlan(plan []plannedDeployment) string {
+	if len(plan) == 0 {
+		return "nothing to deploy"
+	}
+	lines := make([]string, 0, len(plan))
+	for _, deployment := range plan {
+		if deployment.SkipReason != "" {
+			lines = append(lines, fmt.Sprintf("%s/%s: skip (%s)", deployment.Environment, deployment.Application, deployment.SkipReason))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s/%s: %d -> %d", deployment.Environment, deployment.Application, deployment.CurrentVersion, deployment.TargetVersion))
+			for _, commit := range deployment.Changelog {
+				summary, _, _ := strings.Cut(commit.Message, "\n")
+				lines = append(lines, fmt.Sprintf("  %.7s %s", commit.CommitId, summary))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
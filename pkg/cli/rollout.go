@@ -0,0 +1,90 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/freiheit-com/kuberpult/pkg/client"
+)
+
+// The frontend-service limits how long a single request waits, see MaxWaitDuration.
+const maxRolloutWaitPerRequest = 5 * time.Minute
+
+var rolloutPollInterval = 5 * time.Second
+
+func runRolloutWait(ctx context.Context, args []string, s streams) error {
+	fs, o := newFlagSet("rollout wait", s)
+	environmentGroup := fs.String("environment-group", "", "environment group to wait for")
+	team := fs.String("team", "", "only wait for the applications of this team")
+	timeout := fs.Duration("timeout", 10*time.Minute, "how long to wait for the rollout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := noArguments(fs); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "environment-group"); err != nil {
+		return err
+	}
+	c, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(*timeout)
+	for {
+		wait := time.Until(deadline).Truncate(time.Second)
+		if wait > maxRolloutWaitPerRequest {
+			wait = maxRolloutWaitPerRequest
+		}
+		status, err := c.GetRolloutStatus(ctx, *environmentGroup, *team, wait)
+		if err != nil {
+			return err
+		}
+		if status.Status == client.RolloutStatusSuccessful {
+			return writeResult(s, o, status, fmt.Sprintf("%s is rolled out", *environmentGroup))
+		}
+		if !time.Now().Before(deadline) || wait < time.Second {
+			if err := writeResult(s, o, status, formatRolloutStatus(status)); err != nil {
+				return err
+			}
+			return fmt.Errorf("%s is not rolled out after %s: %s", *environmentGroup, *timeout, status.Status)
+		}
+		// the rollout service may answer before the wait duration, e.g. for errors that can still recover
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
+
+// formatRolloutStatus lists the applications that are not rolled out.
+func formatRolloutStatus(status *client.RolloutStatus) string {
+	lines := []string{}
+	for _, app := range status.Applications {
+		if app.Status != client.RolloutStatusSuccessful {
+			lines = append(lines, fmt.Sprintf("%s/%s: %s", app.Environment, app.Application, app.Status))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
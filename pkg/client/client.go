@@ -0,0 +1,248 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+// Package client is a Go client for the kuberpult frontend-service.
+// It wraps the http endpoints and the grpc services, signs requests and retries temporary failures.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/cenkalti/backoff/v4"
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"google.golang.org/grpc"
+)
+
+var defaultHttpClient = &http.Client{
+	// releases can be slow, because the cd-service has to push to the manifest repository
+	Timeout: 5 * time.Minute,
+}
+
+// DefaultBackOff waits exponentially longer between retries, up to 30 seconds.
+func DefaultBackOff() backoff.BackOff {
+	eb := backoff.NewExponentialBackOff()
+	eb.InitialInterval = time.Second
+	eb.MaxInterval = 30 * time.Second
+	return eb
+}
+
+type Config struct {
+	// Url of the frontend-service, e.g. "https://kuberpult.example.com".
+	Url string
+	// Sent in the authorization header, e.g. an azure id token.
+	Token string
+	// Signs the requests. Required if the frontend-service checks signatures.
+	Signer *openpgp.Entity
+	// The git author of the changes in the manifest repository.
+	AuthorName  string
+	AuthorEmail string
+	// How often failed requests are retried.
+	Retries uint64
+	// Defaults to DefaultBackOff.
+	BackOff func() backoff.BackOff
+	// Defaults to a client with a timeout of 5 minutes.
+	HttpClient *http.Client
+	// Connection to the grpc api of the frontend-service. Only needed for ProcessBatch and GetOverview.
+	GrpcConn grpc.ClientConnInterface
+}
+
+type Client struct {
+	config         Config
+	batchClient    api.BatchServiceClient
+	overviewClient api.OverviewServiceClient
+}
+
+func New(config Config) *Client {
+	config.Url = strings.TrimSuffix(config.Url, "/")
+	if config.BackOff == nil {
+		config.BackOff = DefaultBackOff
+	}
+	if config.HttpClient == nil {
+		config.HttpClient = defaultHttpClient
+	}
+	c := &Client{
+		config:         config,
+		batchClient:    nil,
+		overviewClient: nil,
+	}
+	if config.GrpcConn != nil {
+		c.batchClient = api.NewBatchServiceClient(config.GrpcConn)
+		c.overviewClient = api.NewOverviewServiceClient(config.GrpcConn)
+	}
+	return c
+}
+
+type response struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Responses with these status codes are temporary, e.g. while the frontend-service is restarted.
+// A request that is not idempotent may have been processed in case of a bad gateway or a gateway timeout,
+// so it is only retried if the status code shows that it was not processed.
+func isRetryable(statusCode int, idempotent bool) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// retry calls the operation until it succeeds, fails permanently or the retries are used up.
+func (c *Client) retry(ctx context.Context, operation func() error) error {
+	return backoff.Retry(operation, backoff.WithContext(backoff.WithMaxRetries(c.config.BackOff(), c.config.Retries), ctx))
+}
+
+// do sends a request and retries it on network errors and temporary failures.
+// Only POST requests are not idempotent, use send for idempotent POST requests.
+// Failed responses are returned as *HttpError.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, contentType string, body []byte) (*response, error) {
+	return c.send(ctx, method, path, query, contentType, body, method != http.MethodPost)
+}
+
+// send is like do. Requests that are not idempotent are only retried if they did not reach the frontend-service,
+// so that they are not processed twice.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, contentType string, body []byte, idempotent bool) (*response, error) {
+	target := c.config.Url + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var result *response
+	operation := func() error {
+		req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if c.config.Token != "" {
+			req.Header.Set("authorization", c.config.Token)
+		}
+		if c.config.AuthorName != "" {
+			req.Header.Set(auth.HeaderUserName, auth.Encode64(c.config.AuthorName))
+		}
+		if c.config.AuthorEmail != "" {
+			req.Header.Set(auth.HeaderUserEmail, auth.Encode64(c.config.AuthorEmail))
+		}
+		resp, err := c.config.HttpClient.Do(req)
+		if err != nil {
+			if !idempotent && !errors.Is(err, syscall.ECONNREFUSED) {
+				return backoff.Permanent(err)
+			}
+			return err
+		}
+		defer resp.Body.Close()
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if isRetryable(resp.StatusCode, idempotent) {
+			return &HttpError{StatusCode: resp.StatusCode, Body: string(responseBody)}
+		}
+		if resp.StatusCode >= 400 {
+			return backoff.Permanent(&HttpError{StatusCode: resp.StatusCode, Body: string(responseBody)})
+		}
+		result = &response{StatusCode: resp.StatusCode, Body: responseBody}
+		return nil
+	}
+	if err := c.retry(ctx, operation); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) getJson(ctx context.Context, path string, query url.Values, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, path, query, "", nil)
+	if err != nil {
+		return errorFromHttp(err)
+	}
+	return json.Unmarshal(resp.Body, v)
+}
+
+func (c *Client) sendJson(ctx context.Context, method, path string, body interface{}) (*response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, method, path, nil, "application/json", data)
+	if err != nil {
+		return nil, errorFromHttp(err)
+	}
+	return resp, nil
+}
+
+// Sign returns the armored detached signature of data, or an empty string if no signer is configured.
+func (c *Client) Sign(data []byte) (string, error) {
+	if c.config.Signer == nil {
+		return "", nil
+	}
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, c.config.Signer, bytes.NewReader(data), nil); err != nil {
+		return "", fmt.Errorf("could not sign request: %w", err)
+	}
+	return signature.String(), nil
+}
+
+// ReadSigner reads the first private key of an armored key ring file.
+func ReadSigner(path, passphrase string) (*openpgp.Entity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	keyRing, err := openpgp.ReadArmoredKeyRing(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read pgp key %s: %w", path, err)
+	}
+	for _, entity := range keyRing {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("could not decrypt pgp key %s: %w", path, err)
+			}
+		}
+		return entity, nil
+	}
+	return nil, fmt.Errorf("pgp key %s does not contain a private key", path)
+}
+
+// pathEscape escapes each segment, e.g. for lock ids with special characters.
+func pathEscape(segments ...string) string {
+	var b strings.Builder
+	for _, segment := range segments {
+		b.WriteString("/")
+		b.WriteString(url.PathEscape(segment))
+	}
+	return b.String()
+}
@@ -0,0 +1,606 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/cenkalti/backoff/v4"
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/ptr"
+	"github.com/freiheit-com/kuberpult/services/frontend-service/pkg/handler"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type fakeBatchClient struct {
+	// the methods that are not implemented here panic
+	api.BatchServiceClient
+	request  *api.BatchRequest
+	response *api.BatchResponse
+	err      error
+}
+
+func (f *fakeBatchClient) ProcessBatch(_ context.Context, in *api.BatchRequest, _ ...grpc.CallOption) (*api.BatchResponse, error) {
+	f.request = in
+	return f.response, f.err
+}
+
+func newSigner(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+func conflictStatus(t *testing.T) error {
+	st, err := status.New(codes.Aborted, "conflict").WithDetails(&api.ConcurrentModificationError{
+		Environment:     "dev",
+		Application:     "app1",
+		ExpectedVersion: 2,
+		CurrentVersion:  3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
+
+func lockedStatus(t *testing.T) error {
+	st, err := status.New(codes.FailedPrecondition, "locked").WithDetails(&api.LockedError{
+		EnvironmentLocks: map[string]*api.Lock{
+			"l1": {
+				Message:   "upgrade",
+				LockId:    "l1",
+				CreatedAt: timestamppb.New(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)),
+				CreatedBy: &api.Actor{Name: "alice", Email: "alice@example.com"},
+			},
+		},
+		EnvironmentApplicationLocks: nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
+
+var expectedLockedError = &LockedError{
+	EnvironmentLocks: map[string]Lock{
+		"l1": {
+			Message:   "upgrade",
+			LockId:    "l1",
+			CreatedAt: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+			CreatedBy: Actor{Name: "alice", Email: "alice@example.com"},
+		},
+	},
+	EnvironmentApplicationLocks: map[string]Lock{},
+}
+
+func releaseResult(response *api.CreateReleaseResponse) *api.BatchResponse {
+	return &api.BatchResponse{Results: []*api.BatchResult{
+		{Result: &api.BatchResult_CreateReleaseResponse{CreateReleaseResponse: response}},
+	}}
+}
+
+func TestClient(t *testing.T) {
+	signer := newSigner(t)
+	tcs := []struct {
+		Name            string
+		Call            func(ctx context.Context, c *Client) (interface{}, error)
+		BatchResponse   *api.BatchResponse
+		BatchError      error
+		ExpectedResult  interface{}
+		ExpectedError   error
+		ExpectedRequest *api.BatchRequest
+	}{
+		{
+			Name: "creates a release with signed manifests",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return c.CreateRelease(ctx, ReleaseRequest{
+					Application:    "app1",
+					Manifests:      map[string][]byte{"dev": []byte("manifest")},
+					Team:           "sre",
+					SourceCommitId: "",
+					SourceAuthor:   "",
+					SourceMessage:  "",
+					SourceRepoUrl:  "https://github.com/example/source.git",
+					SourcePaths:    []string{"services/app1", "pkg"},
+					Version:        12,
+					DisplayVersion: "",
+				})
+			},
+			BatchResponse: releaseResult(&api.CreateReleaseResponse{
+				Response: &api.CreateReleaseResponse_Success{Success: &api.CreateReleaseResponseSuccess{}},
+			}),
+			ExpectedResult: true,
+			ExpectedRequest: &api.BatchRequest{Actions: []*api.BatchAction{
+				{Action: &api.BatchAction_CreateRelease{CreateRelease: &api.CreateReleaseRequest{
+					Application:   "app1",
+					Manifests:     map[string]string{"dev": "manifest"},
+					Team:          "sre",
+					Version:       12,
+					SourceRepoUrl: "https://github.com/example/source.git",
+					SourcePaths:   []string{"services/app1", "pkg"},
+				}}},
+			}},
+		},
+		{
+			Name: "reports an existing release",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return c.CreateRelease(ctx, ReleaseRequest{Application: "app1", Manifests: map[string][]byte{"dev": []byte("manifest")}})
+			},
+			BatchResponse: releaseResult(&api.CreateReleaseResponse{
+				Response: &api.CreateReleaseResponse_AlreadyExistsSame{AlreadyExistsSame: &api.CreateReleaseResponseAlreadyExistsSame{}},
+			}),
+			ExpectedResult: false,
+		},
+		{
+			Name: "returns a conflict for a different release with the same version",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return c.CreateRelease(ctx, ReleaseRequest{Application: "app1", Manifests: map[string][]byte{"dev": []byte("manifest")}})
+			},
+			BatchResponse: releaseResult(&api.CreateReleaseResponse{
+				Response: &api.CreateReleaseResponse_AlreadyExistsDifferent{AlreadyExistsDifferent: &api.CreateReleaseResponseAlreadyExistsDifferent{
+					FirstDifferingField: api.DifferingField_MANIFESTS,
+					Diff:                "-old\n+new",
+				}},
+			}),
+			ExpectedResult: false,
+			ExpectedError:  &ConflictError{FirstDifferingField: api.DifferingField_MANIFESTS, Diff: "-old\n+new"},
+		},
+		{
+			Name: "returns an error for old releases",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return c.CreateRelease(ctx, ReleaseRequest{Application: "app1", Manifests: map[string][]byte{"dev": []byte("manifest")}})
+			},
+			BatchResponse: releaseResult(&api.CreateReleaseResponse{
+				Response: &api.CreateReleaseResponse_TooOld{TooOld: &api.CreateReleaseResponseTooOld{}},
+			}),
+			ExpectedResult: false,
+			ExpectedError:  &TooOldError{},
+		},
+		{
+			Name: "returns the locks that block a deployment",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return nil, c.Deploy(ctx, "dev", "app1", 3, LockBehaviorFail)
+			},
+			BatchError:    lockedStatus(t),
+			ExpectedError: expectedLockedError,
+			ExpectedRequest: &api.BatchRequest{Actions: []*api.BatchAction{
+				{Action: &api.BatchAction_Deploy{Deploy: &api.DeployRequest{
+					Environment:  "dev",
+					Application:  "app1",
+					Version:      3,
+					LockBehavior: api.LockBehavior_FAIL,
+				}}},
+			}},
+		},
+		{
+			Name: "returns a conflict if someone else deployed",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return nil, c.DeployWithOptions(ctx, "dev", "app1", 4, LockBehaviorFail, DeployOptions{ExpectedCurrentVersion: ptr.Uint64(2), ExpectedGitRevision: "abc"})
+			},
+			BatchError: conflictStatus(t),
+			ExpectedError: &ConcurrentModificationError{
+				Message:         "conflict",
+				Environment:     "dev",
+				Application:     "app1",
+				ExpectedVersion: 2,
+				CurrentVersion:  3,
+			},
+			ExpectedRequest: &api.BatchRequest{
+				Actions: []*api.BatchAction{
+					{Action: &api.BatchAction_Deploy{Deploy: &api.DeployRequest{
+						Environment:            "dev",
+						Application:            "app1",
+						Version:                4,
+						LockBehavior:           api.LockBehavior_FAIL,
+						ExpectedCurrentVersion: ptr.Uint64(2),
+					}}},
+				},
+				ExpectedGitRevision: "abc",
+			},
+		},
+		{
+			Name: "returns permission errors",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return nil, c.CreateEnvironmentLock(ctx, "prod", "l1", "freeze")
+			},
+			BatchError:    status.Error(codes.PermissionDenied, "not allowed"),
+			ExpectedError: &PermissionDeniedError{Message: "not allowed"},
+			ExpectedRequest: &api.BatchRequest{Actions: []*api.BatchAction{
+				{Action: &api.BatchAction_CreateEnvironmentLock{CreateEnvironmentLock: &api.CreateEnvironmentLockRequest{
+					Environment: "prod",
+					LockId:      "l1",
+					Message:     "freeze",
+				}}},
+			}},
+		},
+		{
+			Name: "deletes an environment lock with a signature",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return nil, c.DeleteEnvironmentLock(ctx, "prod", "l1")
+			},
+			BatchResponse: &api.BatchResponse{},
+			ExpectedRequest: &api.BatchRequest{Actions: []*api.BatchAction{
+				{Action: &api.BatchAction_DeleteEnvironmentLock{DeleteEnvironmentLock: &api.DeleteEnvironmentLockRequest{
+					Environment: "prod",
+					LockId:      "l1",
+				}}},
+			}},
+		},
+		{
+			Name: "deletes an environment group lock with a signature",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return nil, c.DeleteEnvironmentGroupLock(ctx, "production", "l1")
+			},
+			BatchResponse: &api.BatchResponse{Results: []*api.BatchResult{{}}},
+			ExpectedRequest: &api.BatchRequest{Actions: []*api.BatchAction{
+				{Action: &api.BatchAction_DeleteEnvironmentGroupLock{DeleteEnvironmentGroupLock: &api.DeleteEnvironmentGroupLockRequest{
+					EnvironmentGroup: "production",
+					LockId:           "l1",
+				}}},
+			}},
+		},
+		{
+			Name: "runs a release train",
+			Call: func(ctx context.Context, c *Client) (interface{}, error) {
+				return c.ReleaseTrain(ctx, "prod", "sre")
+			},
+			BatchResponse: &api.BatchResponse{Results: []*api.BatchResult{
+				{Result: &api.BatchResult_ReleaseTrain{ReleaseTrain: &api.ReleaseTrainResponse{Target: "prod", Team: "sre"}}},
+			}},
+			ExpectedResult: &ReleaseTrainResult{Target: "prod", Team: "sre"},
+			ExpectedRequest: &api.BatchRequest{Actions: []*api.BatchAction{
+				{Action: &api.BatchAction_ReleaseTrain{ReleaseTrain: &api.ReleaseTrainRequest{Target: "prod", Team: "sre"}}},
+			}},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			batchClient := &fakeBatchClient{response: tc.BatchResponse, err: tc.BatchError}
+			frontend := handler.Server{
+				BatchClient: batchClient,
+				KeyRing:     openpgp.EntityList{signer},
+				AzureAuth:   true,
+			}
+			server := httptest.NewServer(http.HandlerFunc(frontend.Handle))
+			defer server.Close()
+			c := New(Config{
+				Url:    server.URL,
+				Signer: signer,
+			})
+			result, err := tc.Call(context.Background(), c)
+			if d := cmp.Diff(tc.ExpectedError, err); d != "" {
+				t.Errorf("error mismatch: %s", d)
+			}
+			if tc.ExpectedResult != nil {
+				if d := cmp.Diff(tc.ExpectedResult, result); d != "" {
+					t.Errorf("result mismatch: %s", d)
+				}
+			}
+			if tc.ExpectedRequest != nil {
+				if d := cmp.Diff(tc.ExpectedRequest, batchClient.request, protocmp.Transform()); d != "" {
+					t.Errorf("batch request mismatch: %s", d)
+				}
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tcs := []struct {
+		Name      string
+		Responses []int
+		Retries   uint64
+		// defaults to deleting an application lock
+		Request          func(c *Client) error
+		ExpectedRequests int
+		ExpectedError    error
+	}{
+		{
+			Name:             "retries until the request succeeds",
+			Responses:        []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			Retries:          3,
+			ExpectedRequests: 3,
+		},
+		{
+			Name:             "gives up after the retries",
+			Responses:        []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			Retries:          1,
+			ExpectedRequests: 2,
+			ExpectedError:    &HttpError{StatusCode: http.StatusServiceUnavailable, Body: ""},
+		},
+		{
+			Name:             "does not retry client errors",
+			Responses:        []int{http.StatusNotFound, http.StatusOK},
+			Retries:          3,
+			ExpectedRequests: 1,
+			ExpectedError:    &HttpError{StatusCode: http.StatusNotFound, Body: ""},
+		},
+		{
+			Name:      "does not retry a release without version after a bad gateway",
+			Responses: []int{http.StatusBadGateway, http.StatusCreated},
+			Retries:   3,
+			Request: func(c *Client) error {
+				_, err := c.CreateRelease(context.Background(), ReleaseRequest{Application: "app1", Manifests: map[string][]byte{"dev": []byte("manifest")}})
+				return err
+			},
+			ExpectedRequests: 1,
+			ExpectedError:    &HttpError{StatusCode: http.StatusBadGateway, Body: ""},
+		},
+		{
+			Name:      "retries a release without version that was not processed",
+			Responses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusCreated},
+			Retries:   3,
+			Request: func(c *Client) error {
+				_, err := c.CreateRelease(context.Background(), ReleaseRequest{Application: "app1", Manifests: map[string][]byte{"dev": []byte("manifest")}})
+				return err
+			},
+			ExpectedRequests: 3,
+		},
+		{
+			Name:      "retries a release with version after a bad gateway",
+			Responses: []int{http.StatusBadGateway, http.StatusGatewayTimeout, http.StatusCreated},
+			Retries:   3,
+			Request: func(c *Client) error {
+				_, err := c.CreateRelease(context.Background(), ReleaseRequest{Application: "app1", Version: 1, Manifests: map[string][]byte{"dev": []byte("manifest")}})
+				return err
+			},
+			ExpectedRequests: 3,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			var mx sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mx.Lock()
+				defer mx.Unlock()
+				w.WriteHeader(tc.Responses[requests])
+				requests++
+			}))
+			defer server.Close()
+			c := New(Config{
+				Url:     server.URL,
+				Retries: tc.Retries,
+				BackOff: func() backoff.BackOff { return &backoff.ZeroBackOff{} },
+			})
+			request := tc.Request
+			if request == nil {
+				request = func(c *Client) error {
+					return c.DeleteApplicationLock(context.Background(), "dev", "app1", "l1")
+				}
+			}
+			err := request(c)
+			if d := cmp.Diff(tc.ExpectedError, err); d != "" {
+				t.Errorf("error mismatch: %s", d)
+			}
+			if requests != tc.ExpectedRequests {
+				t.Errorf("expected %d requests but got %d", tc.ExpectedRequests, requests)
+			}
+		})
+	}
+}
+
+// batchServer fails with the given errors before it succeeds.
+type batchServer struct {
+	api.UnimplementedBatchServiceServer
+	errors   []error
+	requests int
+}
+
+func (b *batchServer) ProcessBatch(ctx context.Context, in *api.BatchRequest) (*api.BatchResponse, error) {
+	b.requests++
+	if len(b.errors) > 0 {
+		err := b.errors[0]
+		b.errors = b.errors[1:]
+		return nil, err
+	}
+	return &api.BatchResponse{}, nil
+}
+
+func TestClientGrpc(t *testing.T) {
+	tcs := []struct {
+		Name             string
+		Errors           []error
+		ExpectedRequests int
+		ExpectedError    error
+	}{
+		{
+			Name:             "retries unavailable servers",
+			Errors:           []error{status.Error(codes.Unavailable, "restarting"), status.Error(codes.ResourceExhausted, "busy")},
+			ExpectedRequests: 3,
+		},
+		{
+			Name:             "returns the locks that block a deployment",
+			Errors:           []error{lockedStatus(t)},
+			ExpectedRequests: 1,
+			ExpectedError:    expectedLockedError,
+		},
+		{
+			Name:             "returns permission errors",
+			Errors:           []error{status.Error(codes.PermissionDenied, "not allowed")},
+			ExpectedRequests: 1,
+			ExpectedError:    &PermissionDeniedError{Message: "not allowed"},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			listener := bufconn.Listen(1 << 20)
+			grpcServer := grpc.NewServer()
+			server := &batchServer{errors: tc.Errors}
+			api.RegisterBatchServiceServer(grpcServer, server)
+			go grpcServer.Serve(listener)
+			defer grpcServer.Stop()
+			conn, err := grpc.Dial("bufnet",
+				grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+				grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			c := New(Config{
+				GrpcConn: conn,
+				Retries:  3,
+				BackOff:  func() backoff.BackOff { return &backoff.ZeroBackOff{} },
+			})
+			_, err = c.ProcessBatch(context.Background(), &api.BatchAction{Action: &api.BatchAction_Deploy{Deploy: &api.DeployRequest{Environment: "dev", Application: "app1", Version: 1}}})
+			if d := cmp.Diff(tc.ExpectedError, err); d != "" {
+				t.Errorf("error mismatch: %s", d)
+			}
+			if server.requests != tc.ExpectedRequests {
+				t.Errorf("expected %d requests but got %d", tc.ExpectedRequests, server.requests)
+			}
+		})
+	}
+}
+
+// jobServer sends one list of job states for each watch, and ends the watch with Unavailable if the last job is not finished.
+type jobServer struct {
+	api.UnimplementedBatchServiceServer
+	watches [][]*api.BatchJob
+	request *api.BatchRequest
+}
+
+func (j *jobServer) SubmitBatch(ctx context.Context, in *api.BatchRequest) (*api.SubmitBatchResponse, error) {
+	j.request = in
+	return &api.SubmitBatchResponse{JobId: "job1"}, nil
+}
+
+func (j *jobServer) WatchBatchJob(in *api.GetBatchJobRequest, stream api.BatchService_WatchBatchJobServer) error {
+	if len(j.watches) == 0 {
+		return status.Error(codes.NotFound, "not found")
+	}
+	jobs := j.watches[0]
+	j.watches = j.watches[1:]
+	for _, job := range jobs {
+		if err := stream.Send(job); err != nil {
+			return err
+		}
+	}
+	if last := jobs[len(jobs)-1]; last.State != api.BatchJobState_BATCH_JOB_SUCCEEDED && last.State != api.BatchJobState_BATCH_JOB_FAILED {
+		return status.Error(codes.Unavailable, "restarting")
+	}
+	return nil
+}
+
+func TestClientBatchJob(t *testing.T) {
+	lockedDetails := status.Convert(lockedStatus(t)).Details()[0].(*api.LockedError)
+	tcs := []struct {
+		Name             string
+		Watches          [][]*api.BatchJob
+		ExpectedResponse *api.BatchResponse
+		ExpectedError    error
+	}{
+		{
+			Name: "returns the response of a successful job",
+			Watches: [][]*api.BatchJob{{
+				{JobId: "job1", State: api.BatchJobState_BATCH_JOB_QUEUED},
+				{JobId: "job1", State: api.BatchJobState_BATCH_JOB_PUSHING},
+				{JobId: "job1", State: api.BatchJobState_BATCH_JOB_SUCCEEDED, Response: &api.BatchResponse{Results: []*api.BatchResult{{}}}},
+			}},
+			ExpectedResponse: &api.BatchResponse{Results: []*api.BatchResult{{}}},
+		},
+		{
+			Name: "watches again if the stream breaks",
+			Watches: [][]*api.BatchJob{
+				{{JobId: "job1", State: api.BatchJobState_BATCH_JOB_APPLYING}},
+				{{JobId: "job1", State: api.BatchJobState_BATCH_JOB_SUCCEEDED, Response: &api.BatchResponse{}}},
+			},
+			ExpectedResponse: &api.BatchResponse{},
+		},
+		{
+			Name: "returns the error of a failed job",
+			Watches: [][]*api.BatchJob{{
+				{JobId: "job1", State: api.BatchJobState_BATCH_JOB_FAILED, Error: &api.BatchJobError{Code: int32(codes.FailedPrecondition), Message: "locked", Locked: lockedDetails}},
+			}},
+			ExpectedError: expectedLockedError,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			listener := bufconn.Listen(1 << 20)
+			grpcServer := grpc.NewServer()
+			server := &jobServer{watches: tc.Watches}
+			api.RegisterBatchServiceServer(grpcServer, server)
+			go grpcServer.Serve(listener)
+			defer grpcServer.Stop()
+			conn, err := grpc.Dial("bufnet",
+				grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+				grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			c := New(Config{
+				GrpcConn: conn,
+				Retries:  3,
+				BackOff:  func() backoff.BackOff { return &backoff.ZeroBackOff{} },
+			})
+			jobId, err := c.SubmitBatch(context.Background(), &api.BatchRequest{IdempotencyKey: "key"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jobId != "job1" || server.request.IdempotencyKey != "key" {
+				t.Errorf("unexpected job id %q for request %v", jobId, server.request)
+			}
+			response, err := c.WaitForBatchJob(context.Background(), jobId)
+			if d := cmp.Diff(tc.ExpectedError, err); d != "" {
+				t.Errorf("error mismatch: %s", d)
+			}
+			if d := cmp.Diff(tc.ExpectedResponse, response, protocmp.Transform()); d != "" {
+				t.Errorf("response mismatch: %s", d)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	signer := newSigner(t)
+	c := New(Config{Signer: signer})
+	signature, err := c.Sign([]byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{signer}, bytes.NewReader([]byte("data")), bytes.NewReader([]byte(signature)), nil); err != nil {
+		t.Errorf("invalid signature: %s", err)
+	}
+	unsigned, err := New(Config{}).Sign([]byte("data"))
+	if err != nil || unsigned != "" {
+		t.Errorf("expected no signature without signer, got %q, %v", unsigned, err)
+	}
+}
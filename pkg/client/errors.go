@@ -0,0 +1,206 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HttpError is returned for failed http requests that have no more specific error.
+type HttpError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HttpError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// LockedError is returned if locks prevent a deployment. It mirrors api.LockedError.
+type LockedError struct {
+	EnvironmentLocks            map[string]Lock `json:"environmentLocks"`
+	EnvironmentApplicationLocks map[string]Lock `json:"environmentApplicationLocks"`
+}
+
+func (e *LockedError) Error() string {
+	locks := []string{}
+	for id, lock := range e.EnvironmentLocks {
+		locks = append(locks, fmt.Sprintf("environment lock '%s' (%s)", id, lock.Message))
+	}
+	for id, lock := range e.EnvironmentApplicationLocks {
+		locks = append(locks, fmt.Sprintf("application lock '%s' (%s)", id, lock.Message))
+	}
+	sort.Strings(locks)
+	return "blocked by " + strings.Join(locks, ", ")
+}
+
+// ConflictError is returned if a release with the same version but different content exists.
+// It mirrors api.CreateReleaseResponseAlreadyExistsDifferent.
+type ConflictError struct {
+	// There might be more differences, but only the first one is reported.
+	FirstDifferingField api.DifferingField
+	Diff                string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("a release with the same version but a different %s already exists", strings.ToLower(e.FirstDifferingField.String()))
+}
+
+// TooOldError is returned if the version of a release is older than the releases that are kept.
+// It mirrors api.CreateReleaseResponseTooOld.
+type TooOldError struct{}
+
+func (e *TooOldError) Error() string {
+	return "the release is too old"
+}
+
+// TooLongError is returned if the application name is too long.
+// It mirrors api.CreateReleaseResponseAppNameTooLong.
+type TooLongError struct {
+	AppName string
+	RegExp  string
+	MaxLen  uint32
+}
+
+func (e *TooLongError) Error() string {
+	return fmt.Sprintf("the application name '%s' must match %s and be at most %d characters long", e.AppName, e.RegExp, e.MaxLen)
+}
+
+// PermissionDeniedError is returned if the rbac policy does not allow the request.
+type PermissionDeniedError struct {
+	Message string
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return e.Message
+}
+
+// ConcurrentModificationError is returned if the environment or the manifest repository changed since the expected version or revision.
+// Read the state again before retrying.
+type ConcurrentModificationError struct {
+	Message             string `json:"message"`
+	Environment         string `json:"environment,omitempty"`
+	Application         string `json:"application,omitempty"`
+	ExpectedVersion     uint64 `json:"expectedVersion,omitempty"`
+	CurrentVersion      uint64 `json:"currentVersion,omitempty"`
+	ExpectedGitRevision string `json:"expectedGitRevision,omitempty"`
+	CurrentGitRevision  string `json:"currentGitRevision,omitempty"`
+}
+
+func (e *ConcurrentModificationError) Error() string {
+	return e.Message
+}
+
+// ReleaseError returns the error described by the response to a release creation,
+// or nil if the release was created or already exists with the same content.
+func ReleaseError(response *api.CreateReleaseResponse) error {
+	switch r := response.GetResponse().(type) {
+	case *api.CreateReleaseResponse_Success, *api.CreateReleaseResponse_AlreadyExistsSame:
+		return nil
+	case *api.CreateReleaseResponse_AlreadyExistsDifferent:
+		return &ConflictError{
+			FirstDifferingField: r.AlreadyExistsDifferent.FirstDifferingField,
+			Diff:                r.AlreadyExistsDifferent.Diff,
+		}
+	case *api.CreateReleaseResponse_TooOld:
+		return &TooOldError{}
+	case *api.CreateReleaseResponse_TooLong:
+		return &TooLongError{
+			AppName: r.TooLong.AppName,
+			RegExp:  r.TooLong.RegExp,
+			MaxLen:  r.TooLong.MaxLen,
+		}
+	case *api.CreateReleaseResponse_GeneralFailure:
+		return fmt.Errorf("could not create release: %s", r.GeneralFailure.Message)
+	default:
+		return fmt.Errorf("unknown response to release creation: %v", response)
+	}
+}
+
+// errorFromHttp converts the responses of the frontend-service to typed errors.
+func errorFromHttp(err error) error {
+	var httpErr *HttpError
+	if !errors.As(err, &httpErr) {
+		return err
+	}
+	switch httpErr.StatusCode {
+	case http.StatusForbidden:
+		return &PermissionDeniedError{Message: strings.TrimSpace(httpErr.Body)}
+	case http.StatusConflict:
+		var locked LockedError
+		if json.Unmarshal([]byte(httpErr.Body), &locked) == nil && (locked.EnvironmentLocks != nil || locked.EnvironmentApplicationLocks != nil) {
+			return &locked
+		}
+		var conflict ConcurrentModificationError
+		if json.Unmarshal([]byte(httpErr.Body), &conflict) == nil && conflict.Message != "" {
+			return &conflict
+		}
+	}
+	return err
+}
+
+// errorFromGrpc converts the status of grpc errors to typed errors.
+func errorFromGrpc(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, detail := range s.Details() {
+		if lockedError, ok := detail.(*api.LockedError); ok {
+			locked := &LockedError{
+				EnvironmentLocks:            map[string]Lock{},
+				EnvironmentApplicationLocks: map[string]Lock{},
+			}
+			for lockId, lock := range lockedError.EnvironmentLocks {
+				locked.EnvironmentLocks[lockId] = lockFromApi(lock)
+			}
+			for lockId, lock := range lockedError.EnvironmentApplicationLocks {
+				locked.EnvironmentApplicationLocks[lockId] = lockFromApi(lock)
+			}
+			return locked
+		}
+		if conflict, ok := detail.(*api.ConcurrentModificationError); ok {
+			return &ConcurrentModificationError{
+				Message:             s.Message(),
+				Environment:         conflict.Environment,
+				Application:         conflict.Application,
+				ExpectedVersion:     conflict.ExpectedVersion,
+				CurrentVersion:      conflict.CurrentVersion,
+				ExpectedGitRevision: conflict.ExpectedGitRevision,
+				CurrentGitRevision:  conflict.CurrentGitRevision,
+			}
+		}
+	}
+	if s.Code() == codes.PermissionDenied {
+		return &PermissionDeniedError{Message: s.Message()}
+	}
+	return err
+}
+
+// Only these codes are retried, other errors would fail again.
+func isRetryableCode(code codes.Code) bool {
+	return code == codes.Unavailable || code == codes.ResourceExhausted
+}
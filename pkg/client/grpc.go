@@ -0,0 +1,164 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/cenkalti/backoff/v4"
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var errNoGrpcConn = errors.New("the grpc api requires Config.GrpcConn")
+
+// grpcContext adds the credentials and the author to the grpc metadata.
+func (c *Client) grpcContext(ctx context.Context) context.Context {
+	if c.config.Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", c.config.Token)
+	}
+	if c.config.AuthorName != "" || c.config.AuthorEmail != "" {
+		ctx = auth.WriteUserToGrpcContext(ctx, auth.User{
+			Email:          c.config.AuthorEmail,
+			Name:           c.config.AuthorName,
+			DexAuthContext: nil,
+		})
+	}
+	return ctx
+}
+
+// retryGrpc retries calls that failed with a temporary error and converts the final error to a typed error.
+func (c *Client) retryGrpc(ctx context.Context, call func(ctx context.Context) error) error {
+	ctx = c.grpcContext(ctx)
+	err := c.retry(ctx, func() error {
+		err := call(ctx)
+		if err != nil && !isRetryableCode(status.Code(err)) {
+			return backoff.Permanent(err)
+		}
+		return err
+	})
+	if err != nil {
+		return errorFromGrpc(err)
+	}
+	return nil
+}
+
+// ProcessBatch applies the actions in one transaction.
+// Release creations that fail are not returned as error, use ReleaseError on their results.
+func (c *Client) ProcessBatch(ctx context.Context, actions ...*api.BatchAction) (*api.BatchResponse, error) {
+	return c.ProcessBatchRequest(ctx, &api.BatchRequest{Actions: actions, Mode: api.BatchMode_ATOMIC})
+}
+
+// ProcessBatchIndependently applies each action on its own, so failed actions don't prevent the others.
+// The response has one result for each action, failed actions have an error result.
+func (c *Client) ProcessBatchIndependently(ctx context.Context, actions ...*api.BatchAction) (*api.BatchResponse, error) {
+	return c.ProcessBatchRequest(ctx, &api.BatchRequest{Actions: actions, Mode: api.BatchMode_INDEPENDENT})
+}
+
+// ProcessBatchRequest sends a batch request as is, e.g. with an idempotency key.
+// Requests with an idempotency key are safe to retry, because the cd-service applies them only once.
+func (c *Client) ProcessBatchRequest(ctx context.Context, request *api.BatchRequest) (*api.BatchResponse, error) {
+	if c.batchClient == nil {
+		return nil, errNoGrpcConn
+	}
+	var response *api.BatchResponse
+	err := c.retryGrpc(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = c.batchClient.ProcessBatch(ctx, request)
+		return err
+	})
+	return response, err
+}
+
+// SubmitBatch queues a batch request in the cd-service and returns the id of its job without waiting for the push.
+// Use WaitForBatchJob to get the response.
+func (c *Client) SubmitBatch(ctx context.Context, request *api.BatchRequest) (string, error) {
+	if c.batchClient == nil {
+		return "", errNoGrpcConn
+	}
+	var response *api.SubmitBatchResponse
+	err := c.retryGrpc(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = c.batchClient.SubmitBatch(ctx, request)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return response.JobId, nil
+}
+
+// WaitForBatchJob waits until a submitted job is finished and returns its response.
+// A failed job returns the same error as ProcessBatch.
+func (c *Client) WaitForBatchJob(ctx context.Context, jobId string) (*api.BatchResponse, error) {
+	if c.batchClient == nil {
+		return nil, errNoGrpcConn
+	}
+	var response *api.BatchResponse
+	err := c.retryGrpc(ctx, func(ctx context.Context) error {
+		stream, err := c.batchClient.WatchBatchJob(ctx, &api.GetBatchJobRequest{JobId: jobId})
+		if err != nil {
+			return err
+		}
+		for {
+			job, err := stream.Recv()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return status.Errorf(codes.Unavailable, "batch job %s: stream ended before the job finished", jobId)
+				}
+				return err
+			}
+			switch job.State {
+			case api.BatchJobState_BATCH_JOB_SUCCEEDED:
+				response = job.Response
+				return nil
+			case api.BatchJobState_BATCH_JOB_FAILED:
+				// the job is already removed, so it must not be watched again
+				return backoff.Permanent(batchJobError(job.Error))
+			}
+		}
+	})
+	return response, err
+}
+
+func batchJobError(jobError *api.BatchJobError) error {
+	st := status.New(codes.Code(jobError.GetCode()), jobError.GetMessage())
+	if jobError.GetLocked() != nil {
+		if withDetails, err := st.WithDetails(jobError.Locked); err == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+func (c *Client) GetOverview(ctx context.Context) (*api.GetOverviewResponse, error) {
+	if c.overviewClient == nil {
+		return nil, errNoGrpcConn
+	}
+	var response *api.GetOverviewResponse
+	err := c.retryGrpc(ctx, func(ctx context.Context) error {
+		var err error
+		response, err = c.overviewClient.GetOverview(ctx, &api.GetOverviewRequest{})
+		return err
+	})
+	return response, err
+}
@@ -0,0 +1,376 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+)
+
+type ReleaseRequest struct {
+	Application string
+	// The manifests by environment.
+	Manifests      map[string][]byte
+	Team           string
+	SourceCommitId string
+	SourceAuthor   string
+	SourceMessage  string
+	SourceRepoUrl  string
+	// The paths in the source repository that belong to the application. Used to filter the changelog.
+	SourcePaths []string
+	// Defaults to the last version + 1.
+	Version        uint64
+	DisplayVersion string
+}
+
+// The response body of /release is one variant of api.CreateReleaseResponse.
+type releaseResponse struct {
+	Success                *api.CreateReleaseResponseSuccess
+	AlreadyExistsSame      *api.CreateReleaseResponseAlreadyExistsSame
+	AlreadyExistsDifferent *api.CreateReleaseResponseAlreadyExistsDifferent
+	GeneralFailure         *api.CreateReleaseResponseGeneralFailure
+	TooOld                 *api.CreateReleaseResponseTooOld
+	TooLong                *api.CreateReleaseResponseAppNameTooLong
+}
+
+func (r *releaseResponse) toApi() *api.CreateReleaseResponse {
+	switch {
+	case r.Success != nil:
+		return &api.CreateReleaseResponse{Response: &api.CreateReleaseResponse_Success{Success: r.Success}}
+	case r.AlreadyExistsSame != nil:
+		return &api.CreateReleaseResponse{Response: &api.CreateReleaseResponse_AlreadyExistsSame{AlreadyExistsSame: r.AlreadyExistsSame}}
+	case r.AlreadyExistsDifferent != nil:
+		return &api.CreateReleaseResponse{Response: &api.CreateReleaseResponse_AlreadyExistsDifferent{AlreadyExistsDifferent: r.AlreadyExistsDifferent}}
+	case r.GeneralFailure != nil:
+		return &api.CreateReleaseResponse{Response: &api.CreateReleaseResponse_GeneralFailure{GeneralFailure: r.GeneralFailure}}
+	case r.TooOld != nil:
+		return &api.CreateReleaseResponse{Response: &api.CreateReleaseResponse_TooOld{TooOld: r.TooOld}}
+	case r.TooLong != nil:
+		return &api.CreateReleaseResponse{Response: &api.CreateReleaseResponse_TooLong{TooLong: r.TooLong}}
+	}
+	return nil
+}
+
+// CreateRelease uploads the manifests of a release.
+// It returns false if the same release already exists, and a *ConflictError if the existing release is different.
+func (c *Client) CreateRelease(ctx context.Context, request ReleaseRequest) (bool, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := map[string]string{
+		"application":      request.Application,
+		"team":             request.Team,
+		"source_commit_id": request.SourceCommitId,
+		"source_author":    request.SourceAuthor,
+		"source_message":   request.SourceMessage,
+		"source_repo_url":  request.SourceRepoUrl,
+		"display_version":  request.DisplayVersion,
+	}
+	if request.Version != 0 {
+		fields["version"] = strconv.FormatUint(request.Version, 10)
+	}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return false, err
+		}
+	}
+	for _, path := range request.SourcePaths {
+		if err := form.WriteField("source_paths", path); err != nil {
+			return false, err
+		}
+	}
+	environments := make([]string, 0, len(request.Manifests))
+	for env := range request.Manifests {
+		environments = append(environments, env)
+	}
+	sort.Strings(environments)
+	for _, env := range environments {
+		if err := writeFormFile(form, fmt.Sprintf("manifests[%s]", env), env+".yaml", request.Manifests[env]); err != nil {
+			return false, err
+		}
+		signature, err := c.Sign(request.Manifests[env])
+		if err != nil {
+			return false, err
+		}
+		if signature != "" {
+			if err := writeFormFile(form, fmt.Sprintf("signatures[%s]", env), env+".asc", []byte(signature)); err != nil {
+				return false, err
+			}
+		}
+	}
+	if err := form.Close(); err != nil {
+		return false, err
+	}
+
+	// without a version, a repeated request would create another release
+	resp, err := c.send(ctx, http.MethodPost, "/release", nil, form.FormDataContentType(), body.Bytes(), request.Version != 0)
+	if err != nil {
+		var httpErr *HttpError
+		if errors.As(err, &httpErr) {
+			var parsed releaseResponse
+			if json.Unmarshal([]byte(httpErr.Body), &parsed) == nil && parsed.toApi() != nil {
+				if releaseErr := ReleaseError(parsed.toApi()); releaseErr != nil {
+					return false, releaseErr
+				}
+			}
+		}
+		return false, errorFromHttp(err)
+	}
+	return resp.StatusCode != http.StatusOK, nil
+}
+
+func writeFormFile(form *multipart.Writer, field, fileName string, content []byte) error {
+	file, err := form.CreateFormFile(field, fileName)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	return err
+}
+
+// Deploy deploys a version of an application. Depending on the lock behavior, a locked application returns a *LockedError.
+func (c *Client) Deploy(ctx context.Context, environment, application string, version uint64, lockBehavior LockBehavior) error {
+	return c.DeployWithOptions(ctx, environment, application, version, lockBehavior, DeployOptions{})
+}
+
+// DeployOptions make a deployment fail with a *ConcurrentModificationError if someone else deployed in the meantime.
+type DeployOptions struct {
+	// Only deploy if this version is deployed. 0 means that no version is deployed.
+	ExpectedCurrentVersion *uint64
+	// Only deploy if the manifest repository is at this commit.
+	ExpectedGitRevision string
+}
+
+func (c *Client) DeployWithOptions(ctx context.Context, environment, application string, version uint64, lockBehavior LockBehavior, options DeployOptions) error {
+	if lockBehavior == "" {
+		lockBehavior = LockBehaviorRecord
+	}
+	// the lock behavior and the expected version are signed, too, so that the signature cannot be used to deploy past locks
+	signedData := environment + application + strconv.FormatUint(version, 10) + string(lockBehavior)
+	if options.ExpectedCurrentVersion != nil {
+		signedData += strconv.FormatUint(*options.ExpectedCurrentVersion, 10)
+	}
+	signature, err := c.Sign([]byte(signedData))
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Version                uint64       `json:"version"`
+		LockBehavior           LockBehavior `json:"lockBehavior"`
+		Signature              string       `json:"signature,omitempty"`
+		ExpectedCurrentVersion *uint64      `json:"expectedCurrentVersion,omitempty"`
+		ExpectedGitRevision    string       `json:"expectedGitRevision,omitempty"`
+	}{
+		Version:                version,
+		LockBehavior:           lockBehavior,
+		Signature:              signature,
+		ExpectedCurrentVersion: options.ExpectedCurrentVersion,
+		ExpectedGitRevision:    options.ExpectedGitRevision,
+	}
+	_, err = c.sendJson(ctx, http.MethodPut, pathEscape("environments", environment, "applications", application, "deploy"), body)
+	return err
+}
+
+type lockRequest struct {
+	Message   string `json:"message"`
+	Signature string `json:"signature,omitempty"`
+}
+
+func (c *Client) CreateEnvironmentLock(ctx context.Context, environment, lockId, message string) error {
+	signature, err := c.Sign([]byte(environment + lockId))
+	if err != nil {
+		return err
+	}
+	_, err = c.sendJson(ctx, http.MethodPut, pathEscape("environments", environment, "locks", lockId), lockRequest{Message: message, Signature: signature})
+	return err
+}
+
+func (c *Client) DeleteEnvironmentLock(ctx context.Context, environment, lockId string) error {
+	signature, err := c.Sign([]byte(environment + lockId))
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, http.MethodDelete, pathEscape("environments", environment, "locks", lockId), nil, "text/plain", []byte(signature))
+	return errorFromHttp(err)
+}
+
+func (c *Client) CreateApplicationLock(ctx context.Context, environment, application, lockId, message string) error {
+	_, err := c.sendJson(ctx, http.MethodPut, pathEscape("environments", environment, "applications", application, "locks", lockId), lockRequest{Message: message, Signature: ""})
+	return err
+}
+
+func (c *Client) DeleteApplicationLock(ctx context.Context, environment, application, lockId string) error {
+	_, err := c.do(ctx, http.MethodDelete, pathEscape("environments", environment, "applications", application, "locks", lockId), nil, "", nil)
+	return errorFromHttp(err)
+}
+
+func (c *Client) CreateEnvironmentGroupLock(ctx context.Context, environmentGroup, lockId, message string) error {
+	signature, err := c.Sign([]byte(environmentGroup + lockId))
+	if err != nil {
+		return err
+	}
+	_, err = c.sendJson(ctx, http.MethodPut, pathEscape("environment-groups", environmentGroup, "locks", lockId), lockRequest{Message: message, Signature: signature})
+	return err
+}
+
+func (c *Client) DeleteEnvironmentGroupLock(ctx context.Context, environmentGroup, lockId string) error {
+	signature, err := c.Sign([]byte(environmentGroup + lockId))
+	if err != nil {
+		return err
+	}
+	// unlike for environment locks, the server requires application/json for the plain signature
+	_, err = c.do(ctx, http.MethodDelete, pathEscape("environment-groups", environmentGroup, "locks", lockId), nil, "application/json", []byte(signature))
+	return errorFromHttp(err)
+}
+
+// ReleaseTrain deploys the versions of the upstream environments to an environment or environment group.
+// The team is optional and restricts the release train to the applications of that team.
+func (c *Client) ReleaseTrain(ctx context.Context, target, team string) (*ReleaseTrainResult, error) {
+	signature, err := c.Sign([]byte(target))
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	if team != "" {
+		query.Set("team", team)
+	}
+	resp, err := c.do(ctx, http.MethodPut, pathEscape("environments", target, "releasetrain"), query, "", []byte(signature))
+	if err != nil {
+		return nil, errorFromHttp(err)
+	}
+	var result ReleaseTrainResult
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetRolloutStatus returns the rollout status of an environment group.
+// If wait is at least a second, the frontend-service waits up to that long for the rollout to succeed.
+func (c *Client) GetRolloutStatus(ctx context.Context, environmentGroup, team string, wait time.Duration) (*RolloutStatus, error) {
+	signature, err := c.Sign([]byte(environmentGroup))
+	if err != nil {
+		return nil, err
+	}
+	body := struct {
+		Signature    string `json:"signature,omitempty"`
+		Team         string `json:"team,omitempty"`
+		WaitDuration string `json:"waitDuration,omitempty"`
+	}{
+		Signature:    signature,
+		Team:         team,
+		WaitDuration: "",
+	}
+	if wait >= time.Second {
+		body.WaitDuration = wait.Truncate(time.Second).String()
+	}
+	resp, err := c.sendJson(ctx, http.MethodPost, pathEscape("environment-groups", environmentGroup, "rollout-status"), body)
+	if err != nil {
+		return nil, err
+	}
+	var result RolloutStatus
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetEnvironments returns all environments without their applications.
+func (c *Client) GetEnvironments(ctx context.Context) ([]Environment, error) {
+	var result []Environment
+	if err := c.getJson(ctx, "/environments", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) GetEnvironment(ctx context.Context, environment string) (*Environment, error) {
+	var result Environment
+	if err := c.getJson(ctx, pathEscape("environments", environment), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) GetEnvironmentApplication(ctx context.Context, environment, application string) (*EnvironmentApplication, error) {
+	var result EnvironmentApplication
+	if err := c.getJson(ctx, pathEscape("environments", environment, "applications", application), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetReleases returns a page of the releases of an application, newest first.
+// Page and page size are optional, the frontend-service defaults to the first page of 20 releases.
+func (c *Client) GetReleases(ctx context.Context, application string, page, pageSize int) (*ReleasesPage, error) {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if pageSize > 0 {
+		query.Set("pageSize", strconv.Itoa(pageSize))
+	}
+	var result ReleasesPage
+	if err := c.getJson(ctx, pathEscape("applications", application, "releases"), query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetProductSummary returns the versions of all applications at a commit of the manifest repository.
+// Exactly one of environment and environmentGroup must be set.
+func (c *Client) GetProductSummary(ctx context.Context, commit, environment, environmentGroup string) ([]ProductSummary, error) {
+	query := url.Values{"commit": {commit}}
+	if environment != "" {
+		query.Set("env", environment)
+	}
+	if environmentGroup != "" {
+		query.Set("group", environmentGroup)
+	}
+	var result []ProductSummary
+	if err := c.getJson(ctx, "/product-summary", query, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetChangelog returns the commits of the source repository between the deployed release of an application and another release, newest first.
+// If version is 0, the other release is the one that a release train would deploy. This requires the source repository mirror of the cd-service.
+func (c *Client) GetChangelog(ctx context.Context, environment, application string, version uint64) (*Changelog, error) {
+	query := url.Values{}
+	if version > 0 {
+		query.Set("version", strconv.FormatUint(version, 10))
+	}
+	var result Changelog
+	if err := c.getJson(ctx, pathEscape("environments", environment, "applications", application, "changelog"), query, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
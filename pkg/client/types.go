@@ -0,0 +1,152 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package client
+
+import (
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+)
+
+// LockBehavior decides what happens if a deployment is blocked by a lock.
+type LockBehavior string
+
+const (
+	// The deployment is recorded as queued version and done when the lock is deleted.
+	LockBehaviorRecord LockBehavior = "record"
+	// The deployment fails with a *LockedError.
+	LockBehaviorFail LockBehavior = "fail"
+	// The locks are ignored.
+	LockBehaviorIgnore LockBehavior = "ignore"
+)
+
+// The rollout service spells it this way.
+const RolloutStatusSuccessful = "succesful"
+
+type Actor struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type Lock struct {
+	Message   string    `json:"message"`
+	LockId    string    `json:"lockId"`
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy Actor     `json:"createdBy"`
+}
+
+type Upstream struct {
+	Environment string `json:"environment,omitempty"`
+	Latest      bool   `json:"latest,omitempty"`
+}
+
+type Environment struct {
+	Name               string          `json:"name"`
+	EnvironmentGroup   string          `json:"environmentGroup"`
+	Priority           string          `json:"priority"`
+	DistanceToUpstream uint32          `json:"distanceToUpstream"`
+	Upstream           *Upstream       `json:"upstream,omitempty"`
+	Locks              map[string]Lock `json:"locks"`
+	// Only set by GetEnvironment.
+	Applications map[string]EnvironmentApplication `json:"applications,omitempty"`
+}
+
+type EnvironmentApplication struct {
+	Name            string          `json:"name"`
+	Team            string          `json:"team,omitempty"`
+	Version         uint64          `json:"version"`
+	QueuedVersion   uint64          `json:"queuedVersion"`
+	UndeployVersion bool            `json:"undeployVersion"`
+	Locks           map[string]Lock `json:"locks"`
+	DeployAuthor    string          `json:"deployAuthor,omitempty"`
+	DeployTime      *time.Time      `json:"deployTime,omitempty"`
+}
+
+type Release struct {
+	Version         uint64    `json:"version"`
+	DisplayVersion  string    `json:"displayVersion,omitempty"`
+	SourceCommitId  string    `json:"sourceCommitId"`
+	SourceAuthor    string    `json:"sourceAuthor"`
+	SourceMessage   string    `json:"sourceMessage"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UndeployVersion bool      `json:"undeployVersion"`
+	PrNumber        string    `json:"prNumber,omitempty"`
+}
+
+type ReleasesPage struct {
+	Application   string    `json:"application"`
+	Releases      []Release `json:"releases"`
+	Page          int       `json:"page"`
+	PageSize      int       `json:"pageSize"`
+	TotalReleases int       `json:"totalReleases"`
+}
+
+type RolloutApplication struct {
+	Application string `json:"application"`
+	Environment string `json:"environment"`
+	Status      string `json:"status"`
+}
+
+type RolloutStatus struct {
+	Status       string               `json:"status"`
+	Applications []RolloutApplication `json:"applications"`
+}
+
+type ProductSummary struct {
+	App            string `json:"app"`
+	Version        string `json:"version"`
+	CommitId       string `json:"commitId"`
+	DisplayVersion string `json:"displayVersion"`
+	Environment    string `json:"environment"`
+	Team           string `json:"team"`
+}
+
+type SourceCommit struct {
+	CommitId    string    `json:"commitId"`
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	Message     string    `json:"message"`
+	AuthoredAt  time.Time `json:"authoredAt"`
+}
+
+type Changelog struct {
+	Application     string         `json:"application"`
+	Environment     string         `json:"environment"`
+	DeployedVersion *uint64        `json:"deployedVersion,omitempty"`
+	Version         uint64         `json:"version"`
+	FromCommitId    string         `json:"fromCommitId,omitempty"`
+	ToCommitId      string         `json:"toCommitId"`
+	Commits         []SourceCommit `json:"commits"`
+	Truncated       bool           `json:"truncated"`
+}
+
+type ReleaseTrainResult struct {
+	Target string `json:"target"`
+	Team   string `json:"team"`
+}
+
+func lockFromApi(lock *api.Lock) Lock {
+	return Lock{
+		Message:   lock.Message,
+		LockId:    lock.LockId,
+		CreatedAt: lock.CreatedAt.AsTime(),
+		CreatedBy: Actor{
+			Name:  lock.CreatedBy.GetName(),
+			Email: lock.CreatedBy.GetEmail(),
+		},
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"net/http"
 
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -47,8 +48,13 @@ type ctxKeyType struct{}
 
 var ctxKey ctxKeyType = ctxKeyType{}
 
+// FromContext returns the meter provider of the context or a no-op provider,
+// so that code running outside of setup.Run (e.g. in tests) doesn't need one.
 func FromContext(ctx context.Context) metric.MeterProvider {
-	return ctx.Value(ctxKey).(metric.MeterProvider)
+	if pv, ok := ctx.Value(ctxKey).(metric.MeterProvider); ok {
+		return pv
+	}
+	return noop.NewMeterProvider()
 }
 
 func WithProvider(ctx context.Context, pv metric.MeterProvider) context.Context {
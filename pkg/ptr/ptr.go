@@ -31,6 +31,10 @@ func Bool(b bool) *bool {
 	return &b
 }
 
+func Uint64(u uint64) *uint64 {
+	return &u
+}
+
 func ToUint64(u *uint64) uint64 {
 	if u == nil {
 		return 0
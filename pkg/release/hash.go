@@ -0,0 +1,68 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+// Package release defines the hash that is signed in json release requests.
+package release
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Release contains the fields of a release that are covered by the signature.
+// The json names are sorted, so that the canonical json has sorted keys.
+type Release struct {
+	Application    string `json:"application"`
+	DisplayVersion string `json:"displayVersion"`
+	// The plain manifests by environment, also if they were sent compressed.
+	Manifests      map[string]string `json:"manifests"`
+	SourceAuthor   string            `json:"sourceAuthor"`
+	SourceCommitId string            `json:"sourceCommitId"`
+	SourceMessage  string            `json:"sourceMessage"`
+	// The source repository fields were added later. They are omitted if empty, so that older signatures stay valid.
+	SourcePaths   []string `json:"sourcePaths,omitempty"`
+	SourceRepoUrl string   `json:"sourceRepoUrl,omitempty"`
+	Team          string   `json:"team"`
+	Version       uint64   `json:"version"`
+}
+
+// CanonicalJson returns the json of the release with sorted keys, without whitespace and without escaping html characters.
+// Empty fields are included, e.g. `"team":""`, except for the source repository fields.
+func CanonicalJson(r Release) ([]byte, error) {
+	if r.Manifests == nil {
+		r.Manifests = map[string]string{}
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(r); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Hash returns the hex encoded sha256 of the canonical json.
+// The signature of a json release request is an armored detached pgp signature of this hash.
+func Hash(r Release) (string, error) {
+	canonical, err := CanonicalJson(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,83 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCanonicalJson(t *testing.T) {
+	tcs := []struct {
+		Name         string
+		Release      Release
+		ExpectedJson string
+	}{
+		{
+			Name:         "empty release",
+			Release:      Release{},
+			ExpectedJson: `{"application":"","displayVersion":"","manifests":{},"sourceAuthor":"","sourceCommitId":"","sourceMessage":"","team":"","version":0}`,
+		},
+		{
+			Name: "sorts environments and does not escape html",
+			Release: Release{
+				Application:    "app1",
+				DisplayVersion: "1.2",
+				Manifests:      map[string]string{"prod": "b: 2\n", "dev": "a: 1\n"},
+				SourceAuthor:   "Alice <alice@example.com>",
+				SourceCommitId: "0123456789abcdef",
+				SourceMessage:  "fix & release",
+				Team:           "sre",
+				Version:        12,
+			},
+			ExpectedJson: `{"application":"app1","displayVersion":"1.2","manifests":{"dev":"a: 1\n","prod":"b: 2\n"},"sourceAuthor":"Alice <alice@example.com>","sourceCommitId":"0123456789abcdef","sourceMessage":"fix & release","team":"sre","version":12}`,
+		},
+		{
+			Name: "includes the source repository fields if set",
+			Release: Release{
+				Application:   "app1",
+				Manifests:     map[string]string{"dev": "a: 1\n"},
+				SourcePaths:   []string{"services/app1", "pkg"},
+				SourceRepoUrl: "https://github.com/example/source.git",
+			},
+			ExpectedJson: `{"application":"app1","displayVersion":"","manifests":{"dev":"a: 1\n"},"sourceAuthor":"","sourceCommitId":"","sourceMessage":"","sourcePaths":["services/app1","pkg"],"sourceRepoUrl":"https://github.com/example/source.git","team":"","version":0}`,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			actual, err := CanonicalJson(tc.Release)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tc.ExpectedJson, string(actual)); d != "" {
+				t.Errorf("json mismatch: %s", d)
+			}
+			hash, err := Hash(tc.Release)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256([]byte(tc.ExpectedJson))
+			if d := cmp.Diff(hex.EncodeToString(sum[:]), hash); d != "" {
+				t.Errorf("hash mismatch: %s", d)
+			}
+		})
+	}
+}
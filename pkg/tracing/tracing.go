@@ -0,0 +1,114 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	ddotel "gopkg.in/DataDog/dd-trace-go.v1/ddtrace/opentelemetry"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// ExporterDatadog sends the spans to the datadog agent, configured with the usual DD_* variables.
+	ExporterDatadog = "datadog"
+	// ExporterOtlp sends the spans to an OpenTelemetry collector, configured with the usual OTEL_EXPORTER_OTLP_* variables.
+	ExporterOtlp = "otlp"
+)
+
+const tracerName = "github.com/freiheit-com/kuberpult"
+
+// Init installs the global tracer provider for the given exporter and
+// propagates the trace context in the W3C format. The returned function
+// flushes the remaining spans and must be called on shutdown.
+func Init(ctx context.Context, exporter string, serviceName string) (func(context.Context) error, error) {
+	var provider trace.TracerProvider
+	var shutdown func(context.Context) error
+	switch exporter {
+	case ExporterDatadog:
+		tp := ddotel.NewTracerProvider(tracer.WithService(serviceName))
+		provider = tp
+		shutdown = func(context.Context) error {
+			return tp.Shutdown()
+		}
+	case ExporterOtlp:
+		exp, err := otlptracegrpc.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating otlp exporter: %w", err)
+		}
+		res, err := resource.New(ctx,
+			resource.WithAttributes(attribute.String("service.name", serviceName)),
+			resource.WithFromEnv(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("creating otlp resource: %w", err)
+		}
+		tp := sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exp),
+			sdktrace.WithResource(res),
+		)
+		provider = tp
+		shutdown = tp.Shutdown
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, must be %q or %q", exporter, ExporterDatadog, ExporterOtlp)
+	}
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return shutdown, nil
+}
+
+// StartSpan starts a span with the global tracer provider. Without Init this is a no-op.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends the span and marks it as failed if err is set.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ServerOption traces incoming grpc calls and continues the traces of the caller.
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler())
+}
+
+// DialOption traces outgoing grpc calls and propagates the trace context to the callee.
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+}
+
+// WrapHandler traces incoming http requests.
+func WrapHandler(handler http.Handler, operation string) http.Handler {
+	return otelhttp.NewHandler(handler, operation)
+}
@@ -33,41 +33,61 @@ import (
 	"github.com/freiheit-com/kuberpult/pkg/tracing"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/service"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/webhook"
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	"github.com/kelseyhightower/envconfig"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
-	grpctrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/google.golang.org/grpc"
-	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
+// The frontend-service accepts releases up to 64Mi, which is larger than the default of 4Mi.
+const maxReceiveMessageSize = 64 * 1024 * 1024
+
 type Config struct {
 	// these will be mapped to "KUBERPULT_GIT_URL", etc.
-	GitUrl             string        `required:"true" split_words:"true"`
-	GitBranch          string        `default:"master" split_words:"true"`
-	BootstrapMode      bool          `default:"false" split_words:"true"`
-	GitCommitterEmail  string        `default:"kuberpult@freiheit.com" split_words:"true"`
-	GitCommitterName   string        `default:"kuberpult" split_words:"true"`
-	GitSshKey          string        `default:"/etc/ssh/identity" split_words:"true"`
-	GitSshKnownHosts   string        `default:"/etc/ssh/ssh_known_hosts" split_words:"true"`
-	GitNetworkTimeout  time.Duration `default:"1m" split_words:"true"`
-	GitWriteCommitData bool          `default:"false" split_words:"true"`
-	PgpKeyRingPath     string        `split_words:"true"`
-	AzureEnableAuth    bool          `default:"false" split_words:"true"`
-	DexEnabled         bool          `default:"false" split_words:"true"`
-	DexRbacPolicyPath  string        `split_words:"true"`
-	EnableTracing      bool          `default:"false" split_words:"true"`
-	EnableMetrics      bool          `default:"false" split_words:"true"`
-	EnableEvents       bool          `default:"false" split_words:"true"`
-	DogstatsdAddr      string        `default:"127.0.0.1:8125" split_words:"true"`
-	EnableSqlite       bool          `default:"true" split_words:"true"`
-	DexMock            bool          `default:"false" split_words:"true"`
-	DexMockRole        string        `default:"Developer" split_words:"true"`
-	ArgoCdServer       string        `default:"" split_words:"true"`
-	ArgoCdInsecure     bool          `default:"false" split_words:"true"`
-	GitWebUrl          string        `default:"" split_words:"true"`
+	GitUrl                    string        `required:"true" split_words:"true"`
+	GitBranch                 string        `default:"master" split_words:"true"`
+	BootstrapMode             bool          `default:"false" split_words:"true"`
+	GitCommitterEmail         string        `default:"kuberpult@freiheit.com" split_words:"true"`
+	GitCommitterName          string        `default:"kuberpult" split_words:"true"`
+	GitSshKey                 string        `default:"/etc/ssh/identity" split_words:"true"`
+	GitSshKnownHosts          string        `default:"/etc/ssh/ssh_known_hosts" split_words:"true"`
+	GitNetworkTimeout         time.Duration `default:"1m" split_words:"true"`
+	GitBatchLinger            time.Duration `default:"0s" split_words:"true"`
+	GitMaxBatchSize           int           `default:"0" split_words:"true"`
+	GitNonFastForwardRetries  int           `default:"1" split_words:"true"`
+	GitNonFastForwardJitter   time.Duration `default:"0s" split_words:"true"`
+	GitWriteCommitData        bool          `default:"false" split_words:"true"`
+	EnableAuditLog            bool          `default:"false" split_words:"true"`
+	AuditLogRetention         time.Duration `default:"2160h" split_words:"true"`
+	IdempotencyWindow         time.Duration `default:"24h" split_words:"true"`
+	BatchJobRetention         time.Duration `default:"1h" split_words:"true"`
+	PgpKeyRingPath            string        `split_words:"true"`
+	AzureEnableAuth           bool          `default:"false" split_words:"true"`
+	DexEnabled                bool          `default:"false" split_words:"true"`
+	RbacEnabled               bool          `default:"false" split_words:"true"`
+	DexRbacPolicyPath         string        `split_words:"true"`
+	DexRbacPolicyInRepository bool          `default:"false" split_words:"true"`
+	DexRbacAdminRole          string        `default:"" split_words:"true"`
+	EnableTracing             bool          `default:"false" split_words:"true"`
+	TracingExporter           string        `default:"datadog" split_words:"true"`
+	EnableMetrics             bool          `default:"false" split_words:"true"`
+	EnableEvents              bool          `default:"false" split_words:"true"`
+	DogstatsdAddr             string        `default:"127.0.0.1:8125" split_words:"true"`
+	EnableSqlite              bool          `default:"true" split_words:"true"`
+	DexMock                   bool          `default:"false" split_words:"true"`
+	DexMockRole               string        `default:"Developer" split_words:"true"`
+	ArgoCdServer              string        `default:"" split_words:"true"`
+	ArgoCdInsecure            bool          `default:"false" split_words:"true"`
+	GitWebUrl                 string        `default:"" split_words:"true"`
+	WebhookConfigPath         string        `default:"" split_words:"true"`
+	WebhookQueuePath          string        `default:"./webhooks" split_words:"true"`
+	WebhookRetention          time.Duration `default:"24h" split_words:"true"`
+	SourceRepoMirrorPath      string        `default:"" split_words:"true"`
+	SourceRepoAllowedUrls     []string      `default:"" split_words:"true"`
+	SourceRepoFetchInterval   time.Duration `default:"5m" split_words:"true"`
+	MaxQueueLength            int           `default:"0" split_words:"true"`
 }
 
 func (c *Config) storageBackend() repository.StorageBackend {
@@ -87,7 +107,12 @@ func RunServer() {
 		if err != nil {
 			logger.FromContext(ctx).Fatal("config.parse.error", zap.Error(err))
 		}
+		if c.GitNonFastForwardRetries < 1 {
+			logger.FromContext(ctx).Fatal("gitNonFastForwardRetries must be at least 1", zap.Int("gitNonFastForwardRetries", c.GitNonFastForwardRetries))
+		}
 
+		// RBAC is enforced if the roles are provided by Dex or by another identity source of the frontend-service.
+		rbacEnabled := c.DexEnabled || c.RbacEnabled
 		var reader auth.GrpcContextReader
 		if c.DexMock {
 			if !c.DexEnabled {
@@ -98,11 +123,16 @@ func RunServer() {
 			//}
 			reader = &auth.DummyGrpcContextReader{Role: c.DexMockRole}
 		} else {
-			reader = &auth.DexGrpcContextReader{DexEnabled: c.DexEnabled}
+			reader = &auth.DexGrpcContextReader{DexEnabled: rbacEnabled}
 		}
-		dexRbacPolicy, err := auth.ReadRbacPolicy(c.DexEnabled, c.DexRbacPolicyPath)
-		if err != nil {
-			logger.FromContext(ctx).Fatal("dex.read.error", zap.Error(err))
+		var dexRbacPolicy map[string]*auth.Permission
+		// The policy file is optional if the policy is stored in the manifest repository.
+		// In that case it is only used until the repository contains a policy.
+		if !c.DexRbacPolicyInRepository || c.DexRbacPolicyPath != "" {
+			dexRbacPolicy, err = auth.ReadRbacPolicy(rbacEnabled, c.DexRbacPolicyPath)
+			if err != nil {
+				logger.FromContext(ctx).Fatal("dex.read.error", zap.Error(err))
+			}
 		}
 
 		grpcServerLogger := logger.FromContext(ctx).Named("grpc_server")
@@ -124,16 +154,20 @@ func RunServer() {
 			unaryUserContextInterceptor,
 		}
 
+		grpcServerOpts := []grpc.ServerOption{
+			grpc.ChainStreamInterceptor(grpcStreamInterceptors...),
+			grpc.ChainUnaryInterceptor(grpcUnaryInterceptors...),
+			grpc.MaxRecvMsgSize(maxReceiveMessageSize),
+		}
+
 		if c.EnableTracing {
-			tracer.Start()
-			defer tracer.Stop()
-
-			grpcStreamInterceptors = append(grpcStreamInterceptors,
-				grpctrace.StreamServerInterceptor(grpctrace.WithServiceName(tracing.ServiceName("kuberpult-cd-service"))),
-			)
-			grpcUnaryInterceptors = append(grpcUnaryInterceptors,
-				grpctrace.UnaryServerInterceptor(grpctrace.WithServiceName(tracing.ServiceName("kuberpult-cd-service"))),
-			)
+			shutdownTracing, err := tracing.Init(ctx, c.TracingExporter, tracing.ServiceName("kuberpult-cd-service"))
+			if err != nil {
+				logger.FromContext(ctx).Fatal("tracing.init.error", zap.Error(err))
+			}
+			defer shutdownTracing(context.Background()) //nolint:errcheck
+
+			grpcServerOpts = append(grpcServerOpts, tracing.ServerOption())
 		}
 
 		if c.EnableMetrics {
@@ -145,7 +179,7 @@ func RunServer() {
 		}
 
 		// If the tracer is not started, calling this function is a no-op.
-		span, ctx := tracer.StartSpanFromContext(ctx, "Start server")
+		ctx, span := tracing.StartSpan(ctx, "Start server")
 
 		if strings.HasPrefix(c.GitUrl, "https") {
 			logger.FromContext(ctx).Fatal("git.url.protocol.unsupported",
@@ -153,6 +187,18 @@ func RunServer() {
 				zap.String("details", "https is not supported for git communication, only ssh is supported"))
 		}
 
+		var webhooks *webhook.Queue
+		if c.WebhookConfigPath != "" {
+			webhookConfigs, err := webhook.ReadConfig(c.WebhookConfigPath)
+			if err != nil {
+				logger.FromContext(ctx).Fatal("webhook.config.error", zap.Error(err))
+			}
+			webhooks, err = webhook.NewQueue(c.WebhookQueuePath, webhookConfigs, c.WebhookRetention)
+			if err != nil {
+				logger.FromContext(ctx).Fatal("webhook.queue.error", zap.Error(err))
+			}
+		}
+
 		cfg := repository.RepositoryConfig{
 			URL:            c.GitUrl,
 			Path:           "./repository",
@@ -175,17 +221,37 @@ func RunServer() {
 			NetworkTimeout:         c.GitNetworkTimeout,
 			DogstatsdEvents:        c.EnableMetrics,
 			WriteCommitData:        c.GitWriteCommitData,
+			Webhooks:               webhooks,
+			MaxQueueLength:         c.MaxQueueLength,
+			BatchLinger:            c.GitBatchLinger,
+			MaxBatchSize:           c.GitMaxBatchSize,
+			NonFastForwardRetries:  c.GitNonFastForwardRetries,
+			NonFastForwardJitter:   c.GitNonFastForwardJitter,
 		}
 		repo, repoQueue, err := repository.New2(ctx, cfg)
 		if err != nil {
 			logger.FromContext(ctx).Fatal("repository.new.error", zap.Error(err), zap.String("git.url", c.GitUrl), zap.String("git.branch", c.GitBranch))
 		}
 
+		var sourceMirror *repository.SourceMirror
+		if c.SourceRepoMirrorPath != "" {
+			sourceMirror, err = repository.NewSourceMirror(repository.SourceMirrorConfig{
+				Path:          c.SourceRepoMirrorPath,
+				AllowedUrls:   c.SourceRepoAllowedUrls,
+				FetchInterval: c.SourceRepoFetchInterval,
+				Credentials:   cfg.Credentials,
+				Certificates:  cfg.Certificates,
+			})
+			if err != nil {
+				logger.FromContext(ctx).Fatal("source.mirror.error", zap.Error(err))
+			}
+		}
+
 		repositoryService := &service.Service{
 			Repository: repo,
 		}
 
-		span.Finish()
+		span.End()
 
 		// Shutdown channel is used to terminate server side streams.
 		shutdownCh := make(chan struct{})
@@ -196,7 +262,7 @@ func RunServer() {
 					Register: func(mux *http.ServeMux) {
 						handler := logger.WithHttpLogger(httpServerLogger, repositoryService)
 						if c.EnableTracing {
-							handler = httptrace.WrapHandler(handler, "kuberpult-cd-service", "/")
+							handler = tracing.WrapHandler(handler, "kuberpult-cd-service")
 						}
 						mux.Handle("/", handler)
 					},
@@ -204,21 +270,38 @@ func RunServer() {
 			},
 			GRPC: &setup.GRPCConfig{
 				Port: "8443",
-				Opts: []grpc.ServerOption{
-					grpc.ChainStreamInterceptor(grpcStreamInterceptors...),
-					grpc.ChainUnaryInterceptor(grpcUnaryInterceptors...),
-				},
+				Opts: grpcServerOpts,
 				Register: func(srv *grpc.Server) {
+					rbacConfig := auth.RBACConfig{
+						DexEnabled:           rbacEnabled,
+						Policy:               dexRbacPolicy,
+						PolicyFromRepository: c.DexRbacPolicyInRepository,
+						AdminRole:            c.DexRbacAdminRole,
+					}
 					api.RegisterBatchServiceServer(srv, &service.BatchServer{
 						Repository: repo,
-						RBACConfig: auth.RBACConfig{
-							DexEnabled: c.DexEnabled,
-							Policy:     dexRbacPolicy,
-						},
+						RBACConfig: rbacConfig,
 						Config: service.BatchServerConfig{
-							WriteCommitData: c.GitWriteCommitData,
+							WriteCommitData:   c.GitWriteCommitData,
+							WriteAuditLog:     c.EnableAuditLog,
+							AuditLogRetention: c.AuditLogRetention,
+							IdempotencyWindow: c.IdempotencyWindow,
+							BatchJobRetention: c.BatchJobRetention,
 						},
 					})
+					api.RegisterAuditServiceServer(srv, &service.AuditServiceServer{
+						Repository: repo,
+						RBACConfig: rbacConfig,
+					})
+					api.RegisterRbacServiceServer(srv, &service.RbacServiceServer{
+						Repository: repo,
+						RBACConfig: rbacConfig,
+					})
+					api.RegisterQueueServiceServer(srv, &service.QueueServiceServer{
+						Repository:     repo,
+						RBACConfig:     rbacConfig,
+						MaxQueueLength: c.MaxQueueLength,
+					})
 
 					overviewSrv := &service.OverviewServiceServer{
 						Repository:       repo,
@@ -226,14 +309,14 @@ func RunServer() {
 						Shutdown:         shutdownCh,
 					}
 					api.RegisterOverviewServiceServer(srv, overviewSrv)
-					api.RegisterGitServiceServer(srv, &service.GitServer{Config: cfg, OverviewService: overviewSrv})
+					api.RegisterGitServiceServer(srv, &service.GitServer{Config: cfg, OverviewService: overviewSrv, SourceMirror: sourceMirror})
 					api.RegisterVersionServiceServer(srv, &service.VersionServiceServer{Repository: repo})
 					reflection.Register(srv)
 					reposerver.Register(srv, repo, cfg)
 
 				},
 			},
-			Background: []setup.BackgroundTaskConfig{
+			Background: append([]setup.BackgroundTaskConfig{
 				{
 					Name: "ddmetrics",
 					Run: func(ctx context.Context, reporter *setup.HealthReporter) error {
@@ -246,7 +329,7 @@ func RunServer() {
 					Name: "push queue",
 					Run:  repoQueue,
 				},
-			},
+			}, append(webhookTasks(webhooks), sourceMirrorTasks(sourceMirror)...)...),
 			Shutdown: func(ctx context.Context) error {
 				close(shutdownCh)
 				return nil
@@ -256,3 +339,29 @@ func RunServer() {
 		return nil
 	})
 }
+
+// webhookTasks delivers the webhooks in the background, so that slow receivers don't delay pushes to the manifest repository.
+func webhookTasks(webhooks *webhook.Queue) []setup.BackgroundTaskConfig {
+	if webhooks == nil {
+		return nil
+	}
+	return []setup.BackgroundTaskConfig{
+		{
+			Name: "webhooks",
+			Run:  webhooks.Run,
+		},
+	}
+}
+
+// sourceMirrorTasks fetches the source repositories in the background, so that changelog requests only read the mirrors.
+func sourceMirrorTasks(sourceMirror *repository.SourceMirror) []setup.BackgroundTaskConfig {
+	if sourceMirror == nil {
+		return nil
+	}
+	return []setup.BackgroundTaskConfig{
+		{
+			Name: "source mirror",
+			Run:  sourceMirror.Run,
+		},
+	}
+}
@@ -16,5 +16,8 @@ Copyright 2023 freiheit.com*/
 package event
 
 const (
-	NewReleaseEventName = "new-release"
+	NewReleaseEventName              = "new-release"
+	DeploymentEventName              = "deployment"
+	LockPreventedDeploymentEventName = "lock-prevented-deployment"
+	ReplacedByEventName              = "replaced-by"
 )
@@ -130,3 +130,31 @@ func (l *LockedError) Error() string {
 }
 
 var _ error = (*LockedError)(nil)
+
+// ConcurrentModificationError means that the manifest repository changed since the client read it.
+type ConcurrentModificationError struct {
+	// Set if a different version is deployed than expected. nil versions mean that no version is deployed.
+	Environment     string
+	Application     string
+	ExpectedVersion *uint64
+	CurrentVersion  *uint64
+	// Set if the repository is not at the expected revision.
+	ExpectedGitRevision string
+	CurrentGitRevision  string
+}
+
+func (c *ConcurrentModificationError) Error() string {
+	if c.ExpectedGitRevision != "" {
+		return fmt.Sprintf("conflict: expected the manifest repository at revision %s, but it is at %s", c.ExpectedGitRevision, c.CurrentGitRevision)
+	}
+	return fmt.Sprintf("conflict: expected version %s of %s on %s, but version %s is deployed", versionOrNone(c.ExpectedVersion), c.Application, c.Environment, versionOrNone(c.CurrentVersion))
+}
+
+func versionOrNone(version *uint64) string {
+	if version == nil {
+		return "none"
+	}
+	return fmt.Sprint(*version)
+}
+
+var _ error = (*ConcurrentModificationError)(nil)
@@ -0,0 +1,204 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// repositoryMetrics are the OpenTelemetry instruments of the repository.
+// They are served on the /metrics endpoint, statsd is only used when the
+// dogstatsd metrics are enabled.
+type repositoryMetrics struct {
+	batchSize          metric.Int64Histogram
+	transformerLatency metric.Float64Histogram
+	pushLatency        metric.Float64Histogram
+	pushFailures       metric.Int64Counter
+	fetchAndResets     metric.Int64Counter
+	queueWait          metric.Float64Histogram
+	batchLinger        metric.Float64Histogram
+	reappliedElements  metric.Int64Counter
+}
+
+func newRepositoryMetrics(meterProvider metric.MeterProvider) (*repositoryMetrics, error) {
+	meter := meterProvider.Meter("kuberpult")
+	batchSize, err := meter.Int64Histogram("push_batch_elements", metric.WithDescription("Number of queue elements that are pushed together"))
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	transformerLatency, err := meter.Float64Histogram("transformer_apply_seconds", metric.WithUnit("s"))
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	pushLatency, err := meter.Float64Histogram("push_seconds", metric.WithUnit("s"))
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	pushFailures, err := meter.Int64Counter("push_failures")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	fetchAndResets, err := meter.Int64Counter("fetch_and_resets")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	queueWait, err := meter.Float64Histogram("repository_queue_wait_seconds", metric.WithUnit("s"), metric.WithDescription("Time between adding an element to the queue and processing it"))
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	batchLinger, err := meter.Float64Histogram("push_batch_linger_seconds", metric.WithUnit("s"), metric.WithDescription("Time spent waiting for more elements before applying and pushing a batch"))
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	reappliedElements, err := meter.Int64Counter("push_reapplied_elements", metric.WithDescription("Number of queue elements that were applied again because the push was rejected"))
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	return &repositoryMetrics{
+		batchSize:          batchSize,
+		transformerLatency: transformerLatency,
+		pushLatency:        pushLatency,
+		pushFailures:       pushFailures,
+		fetchAndResets:     fetchAndResets,
+		queueWait:          queueWait,
+		batchLinger:        batchLinger,
+		reappliedElements:  reappliedElements,
+	}, nil
+}
+
+var noopRepositoryMetrics, _ = newRepositoryMetrics(noop.NewMeterProvider())
+
+func (r *repository) metrics() *repositoryMetrics {
+	if m := r.repositoryMetrics.Load(); m != nil {
+		return m
+	}
+	return noopRepositoryMetrics
+}
+
+// registerMetrics creates the instruments of the repository and the observers
+// for the state of the repository, like the lock counts.
+func (r *repository) registerMetrics(meterProvider metric.MeterProvider) (metric.Registration, error) {
+	m, err := newRepositoryMetrics(meterProvider)
+	if err != nil {
+		return nil, err
+	}
+	meter := meterProvider.Meter("kuberpult")
+	queueLength, err := meter.Int64ObservableGauge("repository_queue_length")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	envLocks, err := meter.Int64ObservableGauge("env_lock_count")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	appLocks, err := meter.Int64ObservableGauge("app_lock_count")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	gitObjects, err := meter.Int64ObservableGauge("git_objects")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	gitPacks, err := meter.Int64ObservableGauge("git_packs")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	reg, err := meter.RegisterCallback(
+		func(ctx context.Context, o metric.Observer) error {
+			o.ObserveInt64(queueLength, int64(r.queue.waiting()))
+			if err := r.observeLocks(o, envLocks, appLocks); err != nil {
+				return err
+			}
+			// The objects are only stored in git with the git backend.
+			if r.config.StorageBackend == GitBackend {
+				stats, err := r.countObjects(ctx)
+				if err != nil {
+					return err
+				}
+				o.ObserveInt64(gitObjects, int64(stats.Count), metric.WithAttributes(attribute.String("kuberpult_git_objects", "loose")))
+				o.ObserveInt64(gitObjects, int64(stats.InPack), metric.WithAttributes(attribute.String("kuberpult_git_objects", "packed")))
+				o.ObserveInt64(gitObjects, int64(stats.Garbage), metric.WithAttributes(attribute.String("kuberpult_git_objects", "garbage")))
+				o.ObserveInt64(gitPacks, int64(stats.Packs))
+			}
+			return nil
+		},
+		queueLength, envLocks, appLocks, gitObjects, gitPacks,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("registering callback: %w", err)
+	}
+	r.repositoryMetrics.Store(m)
+	return reg, nil
+}
+
+func (r *repository) observeLocks(o metric.Observer, envLocks, appLocks metric.Int64Observable) error {
+	state := r.State()
+	configs, err := state.GetEnvironmentConfigs()
+	if err != nil {
+		return err
+	}
+	for env := range configs {
+		o.ObserveInt64(envLocks, int64(GetEnvironmentLocksCount(state.Filesystem, env)), metric.WithAttributes(
+			attribute.String("kuberpult_environment", env),
+		))
+		apps, err := state.GetEnvironmentApplications(env)
+		if err != nil {
+			return err
+		}
+		for _, app := range apps {
+			o.ObserveInt64(appLocks, int64(GetEnvironmentApplicationLocksCount(state.Filesystem, env, app)), metric.WithAttributes(
+				attribute.String("kuberpult_environment", env),
+				attribute.String("kuberpult_application", app),
+			))
+		}
+	}
+	return nil
+}
+
+func (r *repository) recordQueueWait(ctx context.Context, elements []element) {
+	for _, e := range elements {
+		if e.enqueuedAt.IsZero() {
+			continue
+		}
+		r.metrics().queueWait.Record(ctx, time.Since(e.enqueuedAt).Seconds())
+	}
+}
+
+func (r *repository) recordTransformer(ctx context.Context, t Transformer, start time.Time) {
+	r.metrics().transformerLatency.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("kuberpult_transformer", transformerType(t)),
+	))
+}
+
+func transformerType(t Transformer) string {
+	// the audit log entry does not change what the transformer does
+	if audited, ok := t.(*WithAuditLogEntry); ok {
+		t = audited.Transformer
+	}
+	typ := reflect.TypeOf(t)
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return typ.Name()
+}
@@ -0,0 +1,92 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package repository
+
+import (
+	"os/exec"
+	"path"
+	"testing"
+
+	pkgmetrics "github.com/freiheit-com/kuberpult/pkg/metrics"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestRepositoryMetrics(t *testing.T) {
+	dir := t.TempDir()
+	remoteDir := path.Join(dir, "remote")
+	localDir := path.Join(dir, "local")
+	cmd := exec.Command("git", "init", "--bare", remoteDir)
+	cmd.Start()
+	cmd.Wait()
+	reader := sdkmetric.NewManualReader()
+	ctx := pkgmetrics.WithProvider(testutil.MakeTestContext(), sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	repo, err := New(
+		ctx,
+		RepositoryConfig{
+			URL:            "file://" + remoteDir,
+			Path:           localDir,
+			StorageBackend: GitBackend,
+		},
+	)
+	if err != nil {
+		t.Fatalf("new: expected no error, got '%e'", err)
+	}
+	err = repo.Apply(ctx, &CreateEnvironment{Environment: "production"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Apply(ctx, &CreateEnvironmentLock{Environment: "production", LockId: "l1", Message: "stop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	for _, name := range []string{"push_batch_elements", "transformer_apply_seconds", "push_seconds", "repository_queue_length", "env_lock_count", "app_lock_count", "git_objects", "git_packs"} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("expected metric %q to be recorded", name)
+		}
+	}
+	if _, ok := metrics["push_failures"]; ok {
+		t.Errorf("expected no push failures to be recorded")
+	}
+
+	locks, _ := metrics["env_lock_count"].(metricdata.Gauge[int64])
+	if len(locks.DataPoints) != 1 || locks.DataPoints[0].Value != 1 {
+		t.Errorf("expected one lock in production, got %#v", locks.DataPoints)
+	}
+	transformers, _ := metrics["transformer_apply_seconds"].(metricdata.Histogram[float64])
+	counts := map[string]uint64{}
+	for _, dp := range transformers.DataPoints {
+		tr, _ := dp.Attributes.Value(attribute.Key("kuberpult_transformer"))
+		counts[tr.AsString()] = dp.Count
+	}
+	if counts["CreateEnvironment"] != 1 || counts["CreateEnvironmentLock"] != 1 {
+		t.Errorf("expected one apply per transformer type, got %v", counts)
+	}
+}
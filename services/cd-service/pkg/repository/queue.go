@@ -27,36 +27,253 @@ This queue does not improve the latency, because each request still waits for th
 
 import (
 	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type queue struct {
 	elements chan element
+	// the number of elements that may wait in the queue, new elements are rejected above it. 0 means unlimited.
+	maxLength int
+	pending   *pendingElements
 }
 
 type element struct {
 	ctx          context.Context
 	transformers []Transformer
 	result       chan error
+	// transformerErrors is only set for elements whose transformers are applied independently of each other.
+	// It receives the error of each transformer, nil for the transformers that were applied.
+	transformerErrors []error
+	// id identifies the element in the pending elements. 0 for elements that were not added to a queue.
+	id         uint64
+	enqueuedAt time.Time
+	// done is only set for elements that nobody waits for. It receives the result instead of the result channel.
+	done func(error)
+}
+
+// QueuedElement describes an element that was added to the queue and is not finished yet.
+type QueuedElement struct {
+	UserName  string
+	UserEmail string
+	// The types of the transformers of the element, e.g. "CreateApplicationVersion".
+	Transformers []string
+	EnqueuedAt   time.Time
+	// Processing is set once the element is taken from the queue, i.e. its transformers are applied and pushed.
+	Processing bool
+}
+
+// pendingElements keeps track of the elements from the time they are added until their result is sent.
+// This includes the callers that are blocked because the queue channel is full.
+type pendingElements struct {
+	mx       sync.Mutex
+	lastId   uint64
+	elements map[uint64]*QueuedElement
 }
 
 func (q *queue) add(ctx context.Context, transformers []Transformer) <-chan error {
-	resultChannel := make(chan error, 1)
+	return q.addElement(element{
+		ctx:               ctx,
+		transformers:      transformers,
+		result:            make(chan error, 1),
+		transformerErrors: nil,
+	})
+}
+
+func (q *queue) addIndependent(ctx context.Context, transformers []Transformer, transformerErrors []error) <-chan error {
+	return q.addElement(element{
+		ctx:               ctx,
+		transformers:      transformers,
+		result:            make(chan error, 1),
+		transformerErrors: transformerErrors,
+	})
+}
+
+// addAsync adds an element whose result is passed to done.
+// Unlike add, it returns the error if the queue is full instead of sending it as the result.
+func (q *queue) addAsync(ctx context.Context, transformers []Transformer, transformerErrors []error, done func(error)) error {
 	e := element{
-		ctx:          ctx,
-		transformers: transformers,
-		result:       resultChannel,
+		ctx:               ctx,
+		transformers:      transformers,
+		result:            nil,
+		transformerErrors: transformerErrors,
+		done:              done,
+	}
+	if err := q.register(&e); err != nil {
+		return err
 	}
 	select {
 	case q.elements <- e:
-		return resultChannel
 	case <-ctx.Done():
-		resultChannel <- ctx.Err()
+		q.finish(e, ctx.Err())
+	}
+	return nil
+}
+
+func (q *queue) addElement(e element) <-chan error {
+	resultChannel := e.result
+	if err := q.register(&e); err != nil {
+		resultChannel <- err
 		return resultChannel
 	}
+	select {
+	case q.elements <- e:
+		return resultChannel
+	case <-e.ctx.Done():
+		q.finish(e, e.ctx.Err())
+		return resultChannel
+	}
+}
+
+// register adds the element to the pending elements or rejects it if too many elements are waiting.
+func (q *queue) register(e *element) error {
+	if q.pending == nil {
+		return nil
+	}
+	q.pending.mx.Lock()
+	defer q.pending.mx.Unlock()
+	if q.maxLength > 0 {
+		if waiting := q.pending.waiting(); waiting >= q.maxLength {
+			return status.Errorf(codes.ResourceExhausted, "the repository queue is full: %d elements are waiting, the maximum is %d. Please try again later", waiting, q.maxLength)
+		}
+	}
+	queued := describeElement(*e)
+	queued.EnqueuedAt = time.Now()
+	q.pending.lastId++
+	e.id = q.pending.lastId
+	e.enqueuedAt = queued.EnqueuedAt
+	q.pending.elements[e.id] = queued
+	return nil
+}
+
+// describeElement returns the user and the transformer types of the element.
+func describeElement(e element) *QueuedElement {
+	described := &QueuedElement{
+		UserName:     "",
+		UserEmail:    "",
+		Transformers: make([]string, 0, len(e.transformers)),
+		EnqueuedAt:   e.enqueuedAt,
+		Processing:   false,
+	}
+	if user, err := auth.ReadUserFromContext(e.ctx); err == nil {
+		described.UserName = user.Name
+		described.UserEmail = user.Email
+	}
+	for _, t := range e.transformers {
+		described.Transformers = append(described.Transformers, transformerType(t))
+	}
+	return described
+}
+
+// waiting returns the number of elements that are not processed yet. The mutex must be held.
+func (p *pendingElements) waiting() int {
+	result := 0
+	for _, queued := range p.elements {
+		if !queued.Processing {
+			result++
+		}
+	}
+	return result
+}
+
+// waiting returns the number of elements that are not processed yet, including the callers that are blocked.
+func (q *queue) waiting() int {
+	if q.pending == nil {
+		return len(q.elements)
+	}
+	q.pending.mx.Lock()
+	defer q.pending.mx.Unlock()
+	return q.pending.waiting()
+}
+
+// startProcessing marks the elements as taken from the queue.
+func (q *queue) startProcessing(elements []element) {
+	if q.pending == nil {
+		return
+	}
+	q.pending.mx.Lock()
+	defer q.pending.mx.Unlock()
+	for _, e := range elements {
+		if queued, ok := q.pending.elements[e.id]; ok {
+			queued.Processing = true
+		}
+	}
+}
+
+// finish sends the result of the element and removes it from the pending elements.
+func (q *queue) finish(e element, err error) {
+	if q.pending != nil {
+		q.pending.mx.Lock()
+		delete(q.pending.elements, e.id)
+		q.pending.mx.Unlock()
+	}
+	if e.done != nil {
+		// done may apply transformers itself, so it must not block the queue
+		go e.done(err)
+		return
+	}
+	e.result <- err
+}
+
+// status returns the pending elements in the order they were added.
+func (q *queue) status() []QueuedElement {
+	if q.pending == nil {
+		return nil
+	}
+	q.pending.mx.Lock()
+	defer q.pending.mx.Unlock()
+	ids := make([]uint64, 0, len(q.pending.elements))
+	for id := range q.pending.elements {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	result := make([]QueuedElement, 0, len(ids))
+	for _, id := range ids {
+		queued := *q.pending.elements[id]
+		queued.Transformers = append([]string(nil), queued.Transformers...)
+		result = append(result, queued)
+	}
+	return result
+}
+
+// A Stage is a step of processing the elements of the queue.
+type Stage int
+
+const (
+	// StageApplying means that the transformers are applied to the local repository.
+	StageApplying Stage = iota
+	// StagePushing means that the transformers are applied and the commits are pushed.
+	StagePushing
+)
+
+type progressCtxMarker struct{}
+
+// WithProgress returns a context that reports the stages of the transformers that are applied with it.
+func WithProgress(ctx context.Context, report func(Stage)) context.Context {
+	return context.WithValue(ctx, progressCtxMarker{}, report)
+}
+
+func reportProgress(elements []element, stage Stage) {
+	for _, e := range elements {
+		if report, ok := e.ctx.Value(progressCtxMarker{}).(func(Stage)); ok {
+			report(stage)
+		}
+	}
 }
 
-func makeQueue() queue {
+func makeQueue(maxLength int) queue {
 	return queue{
-		elements: make(chan element, 5),
+		elements:  make(chan element, 5),
+		maxLength: maxLength,
+		pending: &pendingElements{
+			mx:       sync.Mutex{},
+			lastId:   0,
+			elements: map[uint64]*QueuedElement{},
+		},
 	}
 }
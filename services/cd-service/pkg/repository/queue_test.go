@@ -0,0 +1,111 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository/testutil"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQueueStatus(t *testing.T) {
+	q := makeQueue(2)
+	ctx := testutil.MakeTestContext()
+	first := q.add(ctx, []Transformer{&CreateEnvironmentLock{}})
+	second := q.add(context.Background(), []Transformer{&CreateApplicationVersion{}, &DeployApplicationVersion{}})
+
+	expected := []QueuedElement{
+		{
+			UserName:     "test tester",
+			UserEmail:    "testmail@example.com",
+			Transformers: []string{"CreateEnvironmentLock"},
+		},
+		{
+			Transformers: []string{"CreateApplicationVersion", "DeployApplicationVersion"},
+		},
+	}
+	ignoreTime := cmpopts.IgnoreFields(QueuedElement{}, "EnqueuedAt")
+	if diff := cmp.Diff(expected, q.status(), ignoreTime); diff != "" {
+		t.Errorf("status mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The queue is full, so the next element is rejected.
+	rejected := <-q.add(ctx, []Transformer{&CreateEnvironmentLock{}})
+	if status.Code(rejected) != codes.ResourceExhausted {
+		t.Errorf("expected the element to be rejected with ResourceExhausted, got %v", rejected)
+	}
+
+	// Elements that are processed don't count towards the maximum.
+	e := <-q.elements
+	q.startProcessing([]element{e})
+	expected[0].Processing = true
+	if diff := cmp.Diff(expected, q.status(), ignoreTime); diff != "" {
+		t.Errorf("status mismatch (-want, +got):\n%s", diff)
+	}
+	if waiting := q.waiting(); waiting != 1 {
+		t.Errorf("expected 1 waiting element, got %d", waiting)
+	}
+	third := q.add(ctx, []Transformer{&CreateEnvironmentLock{}})
+
+	// Finished elements are removed.
+	q.finish(e, nil)
+	if err := <-first; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	for _, ch := range []<-chan error{second, third} {
+		e := <-q.elements
+		q.finish(e, nil)
+		if err := <-ch; err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	}
+	if diff := cmp.Diff([]QueuedElement{}, q.status()); diff != "" {
+		t.Errorf("status mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestQueueAddAsync(t *testing.T) {
+	q := makeQueue(1)
+	ctx := testutil.MakeTestContext()
+	results := make(chan error, 2)
+	done := func(err error) { results <- err }
+	if err := q.addAsync(ctx, []Transformer{&CreateEnvironmentLock{}}, nil, done); err != nil {
+		t.Fatalf("expected the element to be added, got %v", err)
+	}
+
+	// The queue is full, so the error is returned right away and done is not called.
+	err := q.addAsync(ctx, []Transformer{&CreateEnvironmentLock{}}, nil, done)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the element to be rejected with ResourceExhausted, got %v", err)
+	}
+
+	e := <-q.elements
+	q.finish(e, nil)
+	if err := <-results; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	select {
+	case err := <-results:
+		t.Errorf("expected done to be called once, got a second result %v", err)
+	default:
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/exec"
@@ -34,6 +35,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/freiheit-com/kuberpult/pkg/grpc"
@@ -45,29 +47,45 @@ import (
 	backoff "github.com/cenkalti/backoff/v4"
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 	"github.com/freiheit-com/kuberpult/pkg/auth"
+	pkgmetrics "github.com/freiheit-com/kuberpult/pkg/metrics"
 	"github.com/freiheit-com/kuberpult/pkg/setup"
+	"github.com/freiheit-com/kuberpult/pkg/tracing"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/argocd"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/config"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/fs"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/notify"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/sqlitestore"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/webhook"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"go.uber.org/zap"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 
 	"github.com/freiheit-com/kuberpult/pkg/logger"
 	billy "github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/util"
 	git "github.com/libgit2/git2go/v34"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 // A Repository provides a multiple reader / single writer access to a git repository.
 type Repository interface {
 	Apply(ctx context.Context, transformers ...Transformer) error
+	// ApplyIndependently applies each transformer on its own, so that failing transformers don't prevent the others.
+	// It returns the error of each transformer and an error if nothing could be applied or pushed.
+	ApplyIndependently(ctx context.Context, transformers ...Transformer) ([]error, error)
+	// ApplyAsync adds the transformers to the queue without waiting for them to be applied and pushed.
+	// It returns an error if MaxQueueLength elements are waiting, otherwise done is called with the result.
+	// The context must not be canceled before the transformers are applied.
+	ApplyAsync(ctx context.Context, done func(error), transformers ...Transformer) error
+	// ApplyIndependentlyAsync is ApplyIndependently without waiting, like ApplyAsync.
+	ApplyIndependentlyAsync(ctx context.Context, done func([]error, error), transformers ...Transformer) error
 	Push(ctx context.Context, pushAction func() error) error
 	ApplyTransformersInternal(ctx context.Context, transformers ...Transformer) ([]string, *State, []*TransformerResult, error)
 	State() *State
 	StateAt(oid *git.Oid) (*State, error)
 	Notify() *notify.Notify
+	// QueueStatus returns the elements that wait in the queue or are being processed, oldest first.
+	QueueStatus() []QueuedElement
 }
 
 func defaultBackOffProvider() backoff.BackOff {
@@ -109,6 +127,11 @@ type repository struct {
 	notify notify.Notify
 
 	backOffProvider func() backoff.BackOff
+
+	repositoryMetrics atomic.Pointer[repositoryMetrics]
+
+	// moving average of the push latency in nanoseconds, it determines the linger window
+	pushLatency atomic.Int64
 }
 
 type RepositoryConfig struct {
@@ -140,6 +163,20 @@ type RepositoryConfig struct {
 	WebURL          string
 	DogstatsdEvents bool
 	WriteCommitData bool
+	// if set, the events of pushed changes are enqueued for the webhooks
+	Webhooks *webhook.Queue
+	// The number of elements that may wait in the queue. Above it, Apply fails with ResourceExhausted. 0 means unlimited.
+	MaxQueueLength int
+	// The longest time to wait for more elements before applying and pushing them together.
+	// The actual wait grows with the push latency. 0 pushes whatever is in the queue immediately.
+	BatchLinger time.Duration
+	// The maximum number of elements that are pushed together. 0 means unlimited.
+	MaxBatchSize int
+	// How often the elements are applied and pushed again when the push is rejected because the branch diverged.
+	// Defaults to 1.
+	NonFastForwardRetries int
+	// The maximum random time to wait before each of these retries.
+	NonFastForwardJitter time.Duration
 }
 
 func openOrCreate(path string, storageBackend StorageBackend) (*git.Repository, error) {
@@ -281,6 +318,12 @@ func New2(ctx context.Context, cfg RepositoryConfig) (Repository, setup.Backgrou
 	if cfg.NetworkTimeout == 0 {
 		cfg.NetworkTimeout = time.Minute
 	}
+	if cfg.NonFastForwardRetries == 0 {
+		cfg.NonFastForwardRetries = 1
+	}
+	if cfg.NonFastForwardRetries < 0 {
+		return nil, nil, fmt.Errorf("NonFastForwardRetries must be at least 1, got %d", cfg.NonFastForwardRetries)
+	}
 	var credentials *credentialsStore
 	var certificates *certificateStore
 	var err error
@@ -309,7 +352,7 @@ func New2(ctx context.Context, cfg RepositoryConfig) (Repository, setup.Backgrou
 				credentials:     credentials,
 				certificates:    certificates,
 				repository:      repo2,
-				queue:           makeQueue(),
+				queue:           makeQueue(cfg.MaxQueueLength),
 				backOffProvider: defaultBackOffProvider,
 			}
 			result.headLock.Lock()
@@ -350,6 +393,13 @@ func New2(ctx context.Context, cfg RepositoryConfig) (Repository, setup.Backgrou
 				}
 			}
 
+			// the events of a commit that was pushed right before a restart are still staged
+			if err := cfg.Webhooks.Recover(func(commitId string) (bool, error) {
+				return containsCommit(repo2, rev, commitId)
+			}); err != nil {
+				return nil, nil, fmt.Errorf("recovering webhook events: %w", err)
+			}
+
 			// check that we can build the current state
 			state, err := result.StateAt(nil)
 			if err != nil {
@@ -371,9 +421,14 @@ func (r *repository) ProcessQueue(ctx context.Context, health *setup.HealthRepor
 	defer func() {
 		close(r.queue.elements)
 		for e := range r.queue.elements {
-			e.result <- ctx.Err()
+			r.queue.finish(e, ctx.Err())
 		}
 	}()
+	reg, err := r.registerMetrics(pkgmetrics.FromContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer reg.Unregister() //nolint:errcheck
 	tick := time.Tick(r.config.NetworkTimeout)
 	ttl := r.config.NetworkTimeout * 3
 	for {
@@ -403,18 +458,25 @@ func (r *repository) applyElements(elements []element, allowFetchAndReset bool)
 	var changes = &TransformerResult{}
 	for i := 0; i < len(elements); {
 		e := elements[i]
-		subChanges, applyErr := r.ApplyTransformers(e.ctx, e.transformers...)
-		changes.Combine(subChanges)
+		var applyErr error
+		if e.transformerErrors != nil {
+			applyErr = r.applyIndependentElement(e, changes)
+		} else {
+			var subChanges *TransformerResult
+			subChanges, applyErr = r.ApplyTransformers(e.ctx, e.transformers...)
+			changes.Combine(subChanges)
+		}
 		if applyErr != nil {
 			if errors.Is(applyErr, InvalidJson) && allowFetchAndReset {
 				// Invalid state. fetch and reset and redo
+				r.metrics().fetchAndResets.Add(e.ctx, 1, metric.WithAttributes(attribute.String("reason", "invalid_json")))
 				err := r.FetchAndReset(e.ctx)
 				if err != nil {
 					return elements, err, nil
 				}
 				return r.applyElements(elements, false)
 			} else {
-				e.result <- applyErr
+				r.queue.finish(e, applyErr)
 				// here, we keep all elements "behind i".
 				// these are the elements that have not been applied yet
 				elements = append(elements[:i], elements[i+1:]...)
@@ -423,9 +485,40 @@ func (r *repository) applyElements(elements []element, allowFetchAndReset bool)
 			i++
 		}
 	}
+	if len(changes.AuditLogEntries) > 0 {
+		// the last element was applied, so its context has a user
+		if _, err := r.ApplyTransformers(elements[len(elements)-1].ctx, &IndexAuditLog{Entries: changes.AuditLogEntries}); err != nil {
+			// the entries stay without commit id
+			logger.FromContext(elements[len(elements)-1].ctx).Warn("audit.index", zap.Error(err))
+		}
+	}
 	return elements, nil, changes
 }
 
+// errNothingApplied is the result of an independent element when none of its transformers could be applied.
+var errNothingApplied = errors.New("none of the transformers could be applied")
+
+// applyIndependentElement applies the transformers of an element one by one and records their errors in the element.
+// Each transformer that succeeds creates its own commit.
+func (r *repository) applyIndependentElement(e element, changes *TransformerResult) error {
+	applied := 0
+	for i, transformer := range e.transformers {
+		subChanges, err := r.ApplyTransformers(e.ctx, transformer)
+		if errors.Is(err, InvalidJson) {
+			return err
+		}
+		e.transformerErrors[i] = err
+		if err == nil {
+			changes.Combine(subChanges)
+			applied++
+		}
+	}
+	if applied == 0 {
+		return errNothingApplied
+	}
+	return nil
+}
+
 var panicError = errors.New("Panic")
 
 func (r *repository) useRemote(ctx context.Context, callback func(*git.Remote) error) error {
@@ -451,22 +544,143 @@ func (r *repository) useRemote(ctx context.Context, callback func(*git.Remote) e
 	}
 }
 
-func (r *repository) drainQueue() []element {
+// drainQueue takes the elements from the queue that are pushed together with the first element.
+// It waits up to the linger window for more elements and stops once the batch has MaxBatchSize elements.
+func (r *repository) drainQueue(ctx context.Context) []element {
 	elements := []element{}
-	for {
+	var timeout <-chan time.Time
+	if linger := r.lingerWindow(); linger > 0 {
+		start := time.Now()
+		timer := time.NewTimer(linger)
+		defer func() {
+			timer.Stop()
+			r.metrics().batchLinger.Record(ctx, time.Since(start).Seconds())
+		}()
+		timeout = timer.C
+	}
+	take := func(f element) {
+		// Check that the item is not already cancelled
+		select {
+		case <-f.ctx.Done():
+			r.queue.finish(f, f.ctx.Err())
+		default:
+			elements = append(elements, f)
+		}
+	}
+	// the first element is already taken
+	for r.config.MaxBatchSize <= 0 || len(elements)+1 < r.config.MaxBatchSize {
 		select {
 		case f := <-r.queue.elements:
-			// Check that the item is not already cancelled
-			select {
-			case <-f.ctx.Done():
-				f.result <- f.ctx.Err()
-			default:
-				elements = append(elements, f)
-			}
+			take(f)
+			continue
 		default:
+		}
+		if timeout == nil {
+			return elements
+		}
+		select {
+		case f := <-r.queue.elements:
+			take(f)
+		case <-timeout:
+			// take what is left in the queue, but don't wait any longer
+			timeout = nil
+		case <-ctx.Done():
 			return elements
 		}
 	}
+	return elements
+}
+
+// The linger window is half of the average push latency.
+// Waiting for more elements is cheap compared to a slow push, so the batches grow when pushing is slow.
+const lingerPushLatencyDivisor = 2
+
+// lingerWindow returns how long to wait for more elements before applying and pushing them.
+// It is at most BatchLinger.
+func (r *repository) lingerWindow() time.Duration {
+	if r.config.BatchLinger <= 0 {
+		return 0
+	}
+	linger := time.Duration(r.pushLatency.Load() / lingerPushLatencyDivisor)
+	if linger > r.config.BatchLinger {
+		return r.config.BatchLinger
+	}
+	return linger
+}
+
+// The latest push counts one fifth in the average push latency.
+const pushLatencySmoothing = 5
+
+func (r *repository) updatePushLatency(latency time.Duration) {
+	for {
+		old := r.pushLatency.Load()
+		updated := int64(latency)
+		if old != 0 {
+			updated = old + (int64(latency)-old)/pushLatencySmoothing
+		}
+		if r.pushLatency.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+// retryNonFastForward handles a push that was rejected because the branch diverged.
+// It fetches and resets the branch, applies the elements again and pushes them,
+// up to NonFastForwardRetries times. The retries after the first one wait according to the backOffProvider.
+// All attempts wait a random time up to NonFastForwardJitter, so that two writers don't collide again.
+// The returned changes are nil if fetching or applying failed.
+func (r *repository) retryNonFastForward(ctx context.Context, elements []element, changes *TransformerResult, pushErr error, push func(changes *TransformerResult) error) ([]element, error, *TransformerResult) {
+	logger := logger.FromContext(ctx)
+	eb := r.backOffProvider()
+	for attempt := 1; attempt <= r.config.NonFastForwardRetries; attempt++ {
+		wait := time.Duration(0)
+		if attempt > 1 {
+			next := eb.NextBackOff()
+			if next == backoff.Stop {
+				break
+			}
+			wait = next
+		}
+		if r.config.NonFastForwardJitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(r.config.NonFastForwardJitter)))
+		}
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return elements, ctx.Err(), nil
+			}
+		}
+		r.metrics().fetchAndResets.Add(ctx, 1, metric.WithAttributes(attribute.String("reason", "non_fast_forward")))
+		if err := r.FetchAndReset(ctx); err != nil {
+			return elements, err, nil
+		}
+		// Apply the items
+		var err error
+		elements, err, changes = r.applyElements(elements, false)
+		if err != nil || len(elements) == 0 {
+			return elements, err, changes
+		}
+		r.metrics().reappliedElements.Add(ctx, int64(len(elements)))
+		for _, e := range elements {
+			described := describeElement(e)
+			logger.Info("push.reapplied",
+				zap.Int("attempt", attempt),
+				zap.String("user.email", described.UserEmail),
+				zap.Strings("transformers", described.Transformers),
+			)
+		}
+		pushErr = push(changes)
+		if pushErr == nil {
+			return elements, nil, changes
+		}
+		gerr, ok := pushErr.(*git.GitError)
+		if !ok || gerr.Code != git.ErrorCodeNonFastForward {
+			break
+		}
+		logger.Warn("push.conflict", zap.Int("attempt", attempt), zap.Error(pushErr))
+	}
+	return elements, &InternalError{inner: pushErr}, changes
 }
 
 // It returns always nil
@@ -500,19 +714,23 @@ func (r *repository) ProcessQueueOnce(ctx context.Context, e element, callback P
 	elements := []element{e}
 	defer func() {
 		for _, el := range elements {
-			el.result <- err
+			r.queue.finish(el, err)
 		}
 	}()
 	// Check that the first element is not already canceled
 	select {
 	case <-e.ctx.Done():
-		e.result <- e.ctx.Err()
+		r.queue.finish(e, e.ctx.Err())
 		return
 	default:
 	}
 
 	// Try to fetch more items from the queue in order to push more things together
-	elements = append(elements, r.drainQueue()...)
+	elements = append(elements, r.drainQueue(ctx)...)
+	r.queue.startProcessing(elements)
+	r.recordQueueWait(ctx, elements)
+	reportProgress(elements, StageApplying)
+	r.metrics().batchSize.Record(ctx, int64(len(elements)))
 
 	var pushSuccess = true
 	pushOptions := git.PushOptions{
@@ -533,24 +751,27 @@ func (r *repository) ProcessQueueOnce(ctx context.Context, e element, callback P
 		return
 	}
 
+	// The webhook events are staged before the push, so that they are not lost if the cd-service stops right after it.
+	stagedCommit := ""
+	push := func(changes *TransformerResult) error {
+		r.discardWebhookEvents(logger, stagedCommit)
+		stagedCommit = r.stageWebhookEvents(logger, changes)
+		return r.timedPush(e.ctx, pushAction(pushOptions, r))
+	}
+
 	// Try pushing once
-	err = r.Push(e.ctx, pushAction(pushOptions, r))
+	reportProgress(elements, StagePushing)
+	err = push(changes)
 	if err != nil {
 		gerr, ok := err.(*git.GitError)
 		// If it doesn't work because the branch diverged, try reset and apply again.
 		if ok && gerr.Code == git.ErrorCodeNonFastForward {
-			err = r.FetchAndReset(e.ctx)
-			if err != nil {
+			elements, err, changes = r.retryNonFastForward(e.ctx, elements, changes, err, push)
+			// nothing was pushed because fetching or applying failed
+			if changes == nil || len(elements) == 0 {
+				r.discardWebhookEvents(logger, stagedCommit)
 				return
 			}
-			// Apply the items
-			elements, err, changes = r.applyElements(elements, false)
-			if err != nil || len(elements) == 0 {
-				return
-			}
-			if pushErr := r.Push(e.ctx, pushAction(pushOptions, r)); pushErr != nil {
-				err = &InternalError{inner: pushErr}
-			}
 		} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
 			err = grpc.CanceledError(ctx, err)
 		} else {
@@ -562,25 +783,83 @@ func (r *repository) ProcessQueueOnce(ctx context.Context, e element, callback P
 			err = fmt.Errorf("failed to push - this indicates that branch protection is enabled in '%s' on branch '%s'", r.config.URL, r.config.Branch)
 		}
 	}
-	span, ctx := tracer.StartSpanFromContext(e.ctx, "PostPush")
-	defer span.Finish()
+	if err != nil {
+		r.metrics().pushFailures.Add(ctx, 1)
+	}
+	ctx, span := tracing.StartSpan(e.ctx, "PostPush")
+	defer span.End()
+
+	// the changes were not pushed, so nobody must be told about them
+	if err == nil && changes != nil {
+		_, ddSpan := tracing.StartSpan(ctx, "SendMetrics")
+		if r.config.DogstatsdEvents {
+			ddError := UpdateDatadogMetrics(r.State(), changes)
+			if ddError != nil {
+				logger.Warn(fmt.Sprintf("Could not send datadog metrics/events %v", ddError))
+			}
+		}
+		ddSpan.End()
 
-	ddSpan, ctx := tracer.StartSpanFromContext(ctx, "SendMetrics")
-	if r.config.DogstatsdEvents {
-		ddError := UpdateDatadogMetrics(r.State(), changes)
-		if ddError != nil {
-			logger.Warn(fmt.Sprintf("Could not send datadog metrics/events %v", ddError))
+		if r.config.ArgoWebhookUrl != "" {
+			r.sendWebhookToArgoCd(ctx, logger, changes)
 		}
-	}
-	ddSpan.Finish()
 
-	if r.config.ArgoWebhookUrl != "" {
-		r.sendWebhookToArgoCd(ctx, logger, changes)
+		// Releasing only moves the events on disk, the webhooks are called in the background.
+		if whErr := r.config.Webhooks.Release(stagedCommit); whErr != nil {
+			logger.Error(fmt.Sprintf("could not enqueue webhook events: %v", whErr))
+		}
+	} else {
+		r.discardWebhookEvents(logger, stagedCommit)
 	}
 
 	r.notify.Notify()
 }
 
+// stageWebhookEvents stores the events of the changes for the commit that is pushed next and returns its id.
+func (r *repository) stageWebhookEvents(logger *zap.Logger, changes *TransformerResult) string {
+	if r.config.Webhooks == nil || changes == nil || len(changes.Events) == 0 {
+		return ""
+	}
+	head, err := r.repository.References.Lookup(fmt.Sprintf("refs/heads/%s", r.config.Branch))
+	if err != nil {
+		logger.Error(fmt.Sprintf("could not stage webhook events: %v", err))
+		return ""
+	}
+	commitId := head.Target().String()
+	if err := r.config.Webhooks.Stage(commitId, changes.Events); err != nil {
+		logger.Error(fmt.Sprintf("could not stage webhook events: %v", err))
+		return ""
+	}
+	return commitId
+}
+
+func (r *repository) discardWebhookEvents(logger *zap.Logger, commitId string) {
+	if commitId == "" {
+		return
+	}
+	if err := r.config.Webhooks.Discard(commitId); err != nil {
+		logger.Error(fmt.Sprintf("could not discard webhook events: %v", err))
+	}
+}
+
+// containsCommit is true if the commit is tip or one of its ancestors.
+func containsCommit(repo *git.Repository, tip *git.Oid, commitId string) (bool, error) {
+	oid, err := git.NewOid(commitId)
+	if err != nil || tip.IsZero() {
+		return false, nil
+	}
+	if tip.Equal(oid) {
+		return true, nil
+	}
+	commit, err := repo.LookupCommit(oid)
+	if err != nil {
+		// the commit was neither pushed nor fetched
+		return false, nil
+	}
+	commit.Free()
+	return repo.DescendantOf(tip, oid)
+}
+
 func (r *repository) sendWebhookToArgoCd(ctx context.Context, logger *zap.Logger, changes *TransformerResult) {
 	var modified = []string{}
 	for i := range changes.ChangedApps {
@@ -618,8 +897,8 @@ func (r *repository) sendWebhookToArgoCd(ctx context.Context, logger *zap.Logger
 		argoResult.change.payloadBefore = changes.Commits.Previous.String()
 	}
 
-	span, ctx := tracer.StartSpanFromContext(ctx, "Webhook-Retries")
-	defer span.Finish()
+	ctx, span := tracing.StartSpan(ctx, "Webhook-Retries")
+	defer span.End()
 	success := false
 	var err error = nil
 	for i := 1; i <= maxArgoRequests; i++ {
@@ -638,7 +917,7 @@ func (r *repository) sendWebhookToArgoCd(ctx context.Context, logger *zap.Logger
 			break
 		}
 	}
-	span.SetTag("success", success)
+	span.SetAttributes(attribute.Bool("success", success))
 	if !success {
 		logger.Error(fmt.Sprintf("ProcessQueueOnce: error sending webhook after all %d tries: %v", maxArgoRequests, err))
 	}
@@ -654,11 +933,12 @@ func contains(s []int, e int) bool {
 }
 
 func doWebhookPostRequest(ctx context.Context, data ArgoWebhookData, repoConfig *RepositoryConfig, retryCounter int) (error, bool) {
-	span, ctx := tracer.StartSpanFromContext(ctx, "Webhook")
-	span.SetTag("changeAfter", data.change.payloadAfter)
-	span.SetTag("changeBefore", data.change.payloadBefore)
-	span.SetTag("try", retryCounter)
-	defer span.Finish()
+	ctx, span := tracing.StartSpan(ctx, "Webhook",
+		attribute.String("changeAfter", data.change.payloadAfter),
+		attribute.String("changeBefore", data.change.payloadBefore),
+		attribute.Int("try", retryCounter),
+	)
+	defer span.End()
 	url := repoConfig.ArgoWebhookUrl + "/api/webhook"
 	l := logger.FromContext(ctx)
 	l.Info(fmt.Sprintf("doWebhookPostRequest: URL: %s", url))
@@ -778,7 +1058,12 @@ func (r *repository) ApplyTransformersInternal(ctx context.Context, transformers
 		commitMsg := []string{}
 		ctxWithTime := WithTimeNow(ctx, time.Now())
 		for _, t := range transformers {
-			if msg, subChanges, err := t.Transform(ctxWithTime, state); err != nil {
+			start := time.Now()
+			spanCtx, span := tracing.StartSpan(ctxWithTime, "Transform", attribute.String("kuberpult.transformer", transformerType(t)))
+			msg, subChanges, err := t.Transform(spanCtx, state)
+			tracing.EndSpan(span, err)
+			r.recordTransformer(ctx, t, start)
+			if err != nil {
 				return nil, nil, nil, err
 			} else {
 				commitMsg = append(commitMsg, msg)
@@ -804,6 +1089,10 @@ type TransformerResult struct {
 	ChangedApps     []AppEnv
 	DeletedRootApps []RootApp
 	Commits         *CommitIds
+	// Events are sent to the webhooks after the changes are pushed
+	Events []webhook.Event
+	// The successful audit log entries. Their commit ids are stored before the push, see IndexAuditLog.
+	AuditLogEntries []*api.AuditLogEntry
 }
 
 type CommitIds struct {
@@ -825,6 +1114,10 @@ func (r *TransformerResult) AddRootApp(env string) {
 	})
 }
 
+func (r *TransformerResult) AddEvent(event webhook.Event) {
+	r.Events = append(r.Events, event)
+}
+
 func (r *TransformerResult) Combine(other *TransformerResult) {
 	if other == nil {
 		return
@@ -837,6 +1130,8 @@ func (r *TransformerResult) Combine(other *TransformerResult) {
 		a := other.DeletedRootApps[i]
 		r.AddRootApp(a.Env)
 	}
+	r.Events = append(r.Events, other.Events...)
+	r.AuditLogEntries = append(r.AuditLogEntries, other.AuditLogEntries...)
 	if r.Commits == nil {
 		r.Commits = other.Commits
 	}
@@ -904,6 +1199,9 @@ func (r *repository) ApplyTransformers(ctx context.Context, transformers ...Tran
 		Current:  newCommitId,
 		Previous: nil,
 	}
+	for _, entry := range result.AuditLogEntries {
+		entry.CommitId = newCommitId.String()
+	}
 	if oldCommitId != nil {
 		result.Commits.Previous = oldCommitId
 	}
@@ -977,22 +1275,72 @@ func (r *repository) Apply(ctx context.Context, transformers ...Transformer) err
 	}
 }
 
+func (r *repository) ApplyIndependently(ctx context.Context, transformers ...Transformer) ([]error, error) {
+	defer func() {
+		r.writesDone = r.writesDone + uint(len(transformers))
+		r.maybeGc(ctx)
+	}()
+	transformerErrors := make([]error, len(transformers))
+	eCh := r.queue.addIndependent(ctx, transformers, transformerErrors)
+	select {
+	case err := <-eCh:
+		if err != nil && !errors.Is(err, errNothingApplied) {
+			return nil, err
+		}
+		return transformerErrors, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *repository) ApplyAsync(ctx context.Context, done func(error), transformers ...Transformer) error {
+	return r.queue.addAsync(ctx, transformers, nil, func(err error) {
+		r.writesDone = r.writesDone + uint(len(transformers))
+		r.maybeGc(ctx)
+		done(err)
+	})
+}
+
+func (r *repository) ApplyIndependentlyAsync(ctx context.Context, done func([]error, error), transformers ...Transformer) error {
+	transformerErrors := make([]error, len(transformers))
+	return r.queue.addAsync(ctx, transformers, transformerErrors, func(err error) {
+		r.writesDone = r.writesDone + uint(len(transformers))
+		r.maybeGc(ctx)
+		if err != nil && !errors.Is(err, errNothingApplied) {
+			done(nil, err)
+			return
+		}
+		done(transformerErrors, nil)
+	})
+}
+
 func (r *repository) applyDeferred(ctx context.Context, transformers ...Transformer) <-chan error {
 	return r.queue.add(ctx, transformers)
 }
 
+// timedPush records the latency of the push including its retries.
+func (r *repository) timedPush(ctx context.Context, pushAction func() error) error {
+	start := time.Now()
+	defer func() {
+		latency := time.Since(start)
+		r.updatePushLatency(latency)
+		r.metrics().pushLatency.Record(ctx, latency.Seconds())
+	}()
+	return r.Push(ctx, pushAction)
+}
+
 // Push returns an 'error' for typing reasons, really it is always a git.GitError
 func (r *repository) Push(ctx context.Context, pushAction func() error) error {
 
-	span, ctx := tracer.StartSpanFromContext(ctx, "Apply")
-	defer span.Finish()
+	ctx, span := tracing.StartSpan(ctx, "Apply")
+	defer span.End()
 
 	eb := r.backOffProvider()
 	return backoff.Retry(
 		func() error {
-			span, _ := tracer.StartSpanFromContext(ctx, "Push")
-			defer span.Finish()
+			_, span := tracing.StartSpan(ctx, "Push")
 			err := pushAction()
+			tracing.EndSpan(span, err)
 			if err != nil {
 				gerr, ok := err.(*git.GitError)
 				if ok && gerr.Code == git.ErrorCodeNonFastForward {
@@ -1006,8 +1354,8 @@ func (r *repository) Push(ctx context.Context, pushAction func() error) error {
 }
 
 func (r *repository) afterTransform(ctx context.Context, state State) error {
-	span, ctx := tracer.StartSpanFromContext(ctx, "afterTransform")
-	defer span.Finish()
+	ctx, span := tracing.StartSpan(ctx, "afterTransform")
+	defer span.End()
 
 	configs, err := state.GetEnvironmentConfigs()
 	if err != nil {
@@ -1118,6 +1466,10 @@ func (r *repository) StateAt(oid *git.Oid) (*State, error) {
 	}, nil
 }
 
+func (r *repository) QueueStatus() []QueuedElement {
+	return r.queue.status()
+}
+
 func (r *repository) Notify() *notify.Notify {
 	return &r.notify
 }
@@ -1165,7 +1517,7 @@ func (r *repository) countObjects(ctx context.Context) (ObjectCount, error) {
 			stats.Count = value
 		case "size:":
 			stats.Size = value
-		case "in-packs:":
+		case "in-pack:":
 			stats.InPack = value
 		case "packs:":
 			stats.Packs = value
@@ -1173,7 +1525,7 @@ func (r *repository) countObjects(ctx context.Context) (ObjectCount, error) {
 			stats.SizePack = value
 		case "garbage:":
 			stats.Garbage = value
-		case "size-garbage":
+		case "size-garbage:":
 			stats.SizeGarbage = value
 		}
 	}
@@ -1698,6 +2050,207 @@ func (s *State) GetApplicationSourceRepoUrl(application string) (string, error)
 	}
 }
 
+// GetApplicationSourcePaths returns the paths in the source repository that belong to the application.
+// If none are set, the whole repository belongs to the application and nil is returned.
+func (s *State) GetApplicationSourcePaths(application string) ([]string, error) {
+	appDir := applicationDirectory(s.Filesystem, application)
+	content, err := readFile(s.Filesystem, s.Filesystem.Join(appDir, fieldSourcePaths))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error while reading sourcePaths file for application %v: %w", application, err)
+	}
+	var paths []string
+	for _, path := range strings.Split(string(content), "\n") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// GetRbacPolicy returns the RBAC policy stored in the manifest repository.
+// If the repository does not contain a policy, nil is returned.
+func (s *State) GetRbacPolicy() (map[string]*auth.Permission, error) {
+	content, err := readFile(s.Filesystem, rbacPolicyFile(s.Filesystem))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error while reading rbac policy file: %w", err)
+	}
+	policy, err := auth.ParseRbacPolicy(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid rbac policy in manifest repository: %w", err)
+	}
+	return policy, nil
+}
+
+// Filters the audit log. Empty fields match everything.
+type AuditLogFilter struct {
+	// Matches the email or the name of the user.
+	User        string
+	Environment string
+	Application string
+	From        time.Time
+	To          time.Time
+	// The maximum number of entries. 0 means no limit.
+	Limit int
+}
+
+func (f *AuditLogFilter) matches(entry *api.AuditLogEntry) bool {
+	if f.User != "" && entry.UserEmail != f.User && entry.UserName != f.User {
+		return false
+	}
+	if f.Environment != "" && entry.Environment != f.Environment {
+		return false
+	}
+	if f.Application != "" && entry.Application != f.Application {
+		return false
+	}
+	timestamp := entry.Timestamp.AsTime()
+	if !f.From.IsZero() && timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && timestamp.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// GetAuditLog returns the audit log entries that match the filter, newest entries first.
+func (s *State) GetAuditLog(filter AuditLogFilter) ([]*api.AuditLogEntry, error) {
+	days, err := names(s.Filesystem, auditLogDirectory(s.Filesystem))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// The day directories sort chronologically, so the newest day comes first.
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	result := []*api.AuditLogEntry{}
+	for _, day := range days {
+		// Skip whole days outside of the time range. The day format has no time zone, so it is always UTC.
+		if !filter.From.IsZero() && day < filter.From.UTC().Format(auditLogDayFormat) {
+			break
+		}
+		if !filter.To.IsZero() && day > filter.To.UTC().Format(auditLogDayFormat) {
+			continue
+		}
+		dayDir := s.Filesystem.Join(auditLogDirectory(s.Filesystem), day)
+		files, err := names(s.Filesystem, dayDir)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]*api.AuditLogEntry, 0, len(files))
+		for _, file := range files {
+			content, err := readFile(s.Filesystem, s.Filesystem.Join(dayDir, file))
+			if err != nil {
+				return nil, err
+			}
+			var entry api.AuditLogEntry
+			if err := protojson.Unmarshal(content, &entry); err != nil {
+				return nil, fmt.Errorf("invalid audit log entry %s: %w", file, err)
+			}
+			if filter.matches(&entry) {
+				entries = append(entries, &entry)
+			}
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Timestamp.AsTime().After(entries[j].Timestamp.AsTime())
+		})
+		result = append(result, entries...)
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			result = result[:filter.Limit]
+			break
+		}
+	}
+	return result, nil
+}
+
+// An IdempotencyRecord is the response to a batch request with an idempotency key.
+type IdempotencyRecord struct {
+	// The email of the user who sent the request. Keys are only unique per user.
+	User string
+	Key  string
+	// The hash of the request, to detect keys that are reused for a different request.
+	RequestHash string
+	CreatedAt   time.Time
+	Response    *api.BatchResponse
+}
+
+type idempotencyRecordJson struct {
+	User        string          `json:"user"`
+	Key         string          `json:"key"`
+	RequestHash string          `json:"requestHash"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	Response    json.RawMessage `json:"response"`
+}
+
+func (r *IdempotencyRecord) marshal() ([]byte, error) {
+	response, err := protojson.Marshal(r.Response)
+	if err != nil {
+		return nil, fmt.Errorf("error writing idempotency record: %w", err)
+	}
+	return json.Marshal(idempotencyRecordJson{
+		User:        r.User,
+		Key:         r.Key,
+		RequestHash: r.RequestHash,
+		CreatedAt:   r.CreatedAt,
+		Response:    response,
+	})
+}
+
+// GetIdempotencyRecord returns the record of the user's key if it was created after since, nil otherwise.
+func (s *State) GetIdempotencyRecord(user, key string, since time.Time) (*IdempotencyRecord, error) {
+	days, err := names(s.Filesystem, idempotencyDirectory(s.Filesystem))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	for _, day := range days {
+		if day < since.UTC().Format(auditLogDayFormat) {
+			break
+		}
+		dayTime, err := time.Parse(auditLogDayFormat, day)
+		if err != nil {
+			return nil, fmt.Errorf("invalid idempotency directory %s: %w", day, err)
+		}
+		file := idempotencyRecordFile(s.Filesystem, dayTime, user, key)
+		content, err := readFile(s.Filesystem, file)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		var stored idempotencyRecordJson
+		if err := json.Unmarshal(content, &stored); err != nil {
+			return nil, fmt.Errorf("invalid idempotency record %s: %w", file, err)
+		}
+		if stored.User != user || stored.Key != key || stored.CreatedAt.Before(since) {
+			return nil, nil
+		}
+		var response api.BatchResponse
+		if err := protojson.Unmarshal(stored.Response, &response); err != nil {
+			return nil, fmt.Errorf("invalid idempotency record %s: %w", file, err)
+		}
+		return &IdempotencyRecord{
+			User:        stored.User,
+			Key:         stored.Key,
+			RequestHash: stored.RequestHash,
+			CreatedAt:   stored.CreatedAt,
+			Response:    &response,
+		}, nil
+	}
+	return nil, nil
+}
+
 func names(fs billy.Filesystem, path string) ([]string, error) {
 	files, err := fs.ReadDir(path)
 	if err != nil {
@@ -22,11 +22,14 @@ import (
 	"fmt"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository/testutil"
 	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -1820,6 +1823,264 @@ func TestProcessQueueOnce(t *testing.T) {
 	}
 }
 
+func TestProcessQueueOnceNonFastForward(t *testing.T) {
+	tcs := []struct {
+		Name              string
+		Retries           int
+		MaxBackOffs       uint64
+		Conflicts         int
+		ExpectedPushCalls int
+		// the argo webhook is only sent for pushed changes
+		ExpectedWebhooks int
+		ExpectedError    string
+	}{
+		{
+			Name:              "succeeds after one conflict",
+			Retries:           1,
+			MaxBackOffs:       5,
+			Conflicts:         1,
+			ExpectedPushCalls: 2,
+			ExpectedWebhooks:  1,
+		},
+		{
+			Name:              "fails after the configured retries",
+			Retries:           1,
+			MaxBackOffs:       5,
+			Conflicts:         2,
+			ExpectedPushCalls: 2,
+			ExpectedError:     "repository internal: conflict 2",
+		},
+		{
+			Name:              "succeeds after several conflicts",
+			Retries:           3,
+			MaxBackOffs:       5,
+			Conflicts:         3,
+			ExpectedPushCalls: 4,
+			ExpectedWebhooks:  1,
+		},
+		{
+			Name:              "stops when the backoff is exhausted",
+			Retries:           5,
+			MaxBackOffs:       1,
+			Conflicts:         5,
+			ExpectedPushCalls: 3,
+			ExpectedError:     "repository internal: conflict 3",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+
+			// create a remote
+			dir := t.TempDir()
+			remoteDir := path.Join(dir, "remote")
+			localDir := path.Join(dir, "local")
+			cmd := exec.Command("git", "init", "--bare", remoteDir)
+			cmd.Start()
+			cmd.Wait()
+			var webhooksMx sync.Mutex
+			webhooks := 0
+			argoCd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				webhooksMx.Lock()
+				defer webhooksMx.Unlock()
+				webhooks++
+			}))
+			defer argoCd.Close()
+			repo, err := New(
+				testutil.MakeTestContext(),
+				RepositoryConfig{
+					URL:                   "file://" + remoteDir,
+					Path:                  localDir,
+					NonFastForwardRetries: tc.Retries,
+					ArgoWebhookUrl:        argoCd.URL,
+				},
+			)
+			if err != nil {
+				t.Fatalf("new: expected no error, got '%e'", err)
+			}
+			repoInternal := repo.(*repository)
+			repoInternal.backOffProvider = func() backoff.BackOff {
+				return backoff.WithMaxRetries(&backoff.ZeroBackOff{}, tc.MaxBackOffs)
+			}
+			// the remote branch must exist to fetch and reset it
+			initial := element{
+				ctx: testutil.MakeTestContext(),
+				transformers: []Transformer{
+					&EmptyTransformer{},
+				},
+				result: make(chan error, 1),
+			}
+			repoInternal.ProcessQueueOnce(testutil.MakeTestContext(), initial, defaultPushUpdate, DefaultPushActionCallback)
+			if err := <-initial.result; err != nil {
+				t.Fatalf("initial push: expected no error, got %q", err.Error())
+			}
+			webhooksMx.Lock()
+			webhooks = 0
+			webhooksMx.Unlock()
+			pushCalls := 0
+			pushAction := func(options git.PushOptions, r *repository) PushActionFunc {
+				return func() error {
+					pushCalls++
+					if pushCalls <= tc.Conflicts {
+						return &git.GitError{Message: fmt.Sprintf("conflict %d", pushCalls), Code: git.ErrorCodeNonFastForward}
+					}
+					return DefaultPushActionCallback(options, r)()
+				}
+			}
+			e := element{
+				ctx: testutil.MakeTestContext(),
+				transformers: []Transformer{
+					&EmptyTransformer{},
+				},
+				result: make(chan error, 1),
+			}
+			repoInternal.ProcessQueueOnce(testutil.MakeTestContext(), e, defaultPushUpdate, pushAction)
+
+			actualError := <-e.result
+			if tc.ExpectedError == "" && actualError != nil {
+				t.Fatalf("expected no error, got %q", actualError.Error())
+			}
+			if tc.ExpectedError != "" {
+				if actualError == nil {
+					t.Fatalf("expected error %q, got none", tc.ExpectedError)
+				}
+				if actualError.Error() != tc.ExpectedError {
+					t.Errorf("expected error %q, got %q", tc.ExpectedError, actualError.Error())
+				}
+			}
+			if pushCalls != tc.ExpectedPushCalls {
+				t.Errorf("expected %d push calls, got %d", tc.ExpectedPushCalls, pushCalls)
+			}
+			webhooksMx.Lock()
+			defer webhooksMx.Unlock()
+			if webhooks != tc.ExpectedWebhooks {
+				t.Errorf("expected %d argo webhooks, got %d", tc.ExpectedWebhooks, webhooks)
+			}
+		})
+	}
+}
+
+func TestDrainQueue(t *testing.T) {
+	tcs := []struct {
+		Name             string
+		Config           RepositoryConfig
+		PushLatency      time.Duration
+		Queued           int
+		Late             int
+		ExpectedElements int
+	}{
+		{
+			Name:             "takes everything without linger window",
+			Queued:           4,
+			Late:             1,
+			ExpectedElements: 4,
+		},
+		{
+			Name:             "stops at the max batch size",
+			Config:           RepositoryConfig{MaxBatchSize: 3},
+			Queued:           4,
+			ExpectedElements: 2,
+		},
+		{
+			Name:             "waits for late elements",
+			Config:           RepositoryConfig{BatchLinger: time.Minute},
+			PushLatency:      2 * time.Second,
+			Queued:           1,
+			Late:             2,
+			ExpectedElements: 3,
+		},
+		{
+			Name:             "stops waiting once the batch is full",
+			Config:           RepositoryConfig{BatchLinger: time.Hour, MaxBatchSize: 3},
+			PushLatency:      time.Hour,
+			Queued:           1,
+			Late:             1,
+			ExpectedElements: 2,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			cfg := tc.Config
+			repo := &repository{
+				config: &cfg,
+				queue:  makeQueue(0),
+			}
+			repo.pushLatency.Store(int64(tc.PushLatency))
+			ctx := testutil.MakeTestContext()
+			for i := 0; i < tc.Queued; i++ {
+				repo.queue.add(ctx, []Transformer{&EmptyTransformer{}})
+			}
+			go func() {
+				for i := 0; i < tc.Late; i++ {
+					time.Sleep(10 * time.Millisecond)
+					repo.queue.add(ctx, []Transformer{&EmptyTransformer{}})
+				}
+			}()
+			elements := repo.drainQueue(ctx)
+			if len(elements) != tc.ExpectedElements {
+				t.Errorf("expected %d elements, got %d", tc.ExpectedElements, len(elements))
+			}
+		})
+	}
+}
+
+func TestLingerWindow(t *testing.T) {
+	tcs := []struct {
+		Name           string
+		BatchLinger    time.Duration
+		PushLatencies  []time.Duration
+		ExpectedLinger time.Duration
+	}{
+		{
+			Name:           "disabled",
+			BatchLinger:    0,
+			PushLatencies:  []time.Duration{10 * time.Second},
+			ExpectedLinger: 0,
+		},
+		{
+			Name:           "no push yet",
+			BatchLinger:    time.Second,
+			ExpectedLinger: 0,
+		},
+		{
+			Name:           "half of the push latency",
+			BatchLinger:    time.Second,
+			PushLatencies:  []time.Duration{400 * time.Millisecond},
+			ExpectedLinger: 200 * time.Millisecond,
+		},
+		{
+			Name:           "grows with slow pushes",
+			BatchLinger:    time.Second,
+			PushLatencies:  []time.Duration{400 * time.Millisecond, 1400 * time.Millisecond},
+			ExpectedLinger: 300 * time.Millisecond,
+		},
+		{
+			Name:           "capped by the batch linger",
+			BatchLinger:    time.Second,
+			PushLatencies:  []time.Duration{10 * time.Second},
+			ExpectedLinger: time.Second,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			repo := &repository{
+				config: &RepositoryConfig{BatchLinger: tc.BatchLinger},
+			}
+			for _, latency := range tc.PushLatencies {
+				repo.updatePushLatency(latency)
+			}
+			if linger := repo.lingerWindow(); linger != tc.ExpectedLinger {
+				t.Errorf("expected linger window %s, got %s", tc.ExpectedLinger, linger)
+			}
+		})
+	}
+}
+
 func TestGitPushDoesntGetStuck(t *testing.T) {
 	tcs := []struct {
 		Name string
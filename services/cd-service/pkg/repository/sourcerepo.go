@@ -0,0 +1,387 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freiheit-com/kuberpult/pkg/logger"
+	"github.com/freiheit-com/kuberpult/pkg/setup"
+	git "github.com/libgit2/git2go/v34"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxChangelogCommits limits the number of commits in one changelog.
+	maxChangelogCommits = 500
+	// maxChangelogWalk limits the number of commits that are read for one changelog,
+	// because with paths most of the commits may not be part of the changelog.
+	maxChangelogWalk = 10000
+)
+
+// ErrSourceNotMirrored is returned if the commits of a changelog are not mirrored yet. The mirror is updated in the background.
+var ErrSourceNotMirrored = errors.New("the commits are not mirrored yet, try again later")
+
+// SourceMirrorConfig configures the local mirrors of the source repositories of the applications.
+type SourceMirrorConfig struct {
+	// the directory that contains one bare clone per source repository
+	Path string
+	// Only source repositories whose url starts with one of these prefixes are mirrored, e.g. "git@github.com:freiheit-com/".
+	// Local paths are never mirrored.
+	AllowedUrls []string
+	// how often the mirrored repositories are fetched, defaults to 5 minutes
+	FetchInterval time.Duration
+	Credentials   Credentials
+	Certificates  Certificates
+}
+
+// SourceCommit is a commit in the source repository of an application.
+type SourceCommit struct {
+	Id          string
+	AuthorName  string
+	AuthorEmail string
+	Message     string
+	AuthoredAt  time.Time
+}
+
+// Changelog contains the commits that are in one source commit but not in another one.
+type Changelog struct {
+	// Newest commits first.
+	Commits []SourceCommit
+	// Set if there were more than maxChangelogCommits commits, or if more than maxChangelogWalk commits were read.
+	Truncated bool
+}
+
+// SourceMirror mirrors the source repositories into local bare clones, so that the changelog
+// between two releases can be computed. The repositories are fetched by Run in the background,
+// the changelog only reads the mirrors.
+type SourceMirror struct {
+	path          string
+	allowedUrls   []string
+	fetchInterval time.Duration
+	credentials   *credentialsStore
+	certificates  *certificateStore
+	// only used in tests, which mirror local repositories
+	allowLocal bool
+
+	// protects urls
+	mx sync.Mutex
+	// The mirrored repositories. Each one has its own lock, so that only one fetch per repository runs at a time.
+	urls map[string]*sync.Mutex
+	// repositories that should be fetched before the next interval
+	fetches chan string
+}
+
+func NewSourceMirror(cfg SourceMirrorConfig) (*SourceMirror, error) {
+	credentials, err := cfg.Credentials.load()
+	if err != nil {
+		return nil, fmt.Errorf("failure to load credentials: %w", err)
+	}
+	certificates, err := cfg.Certificates.load()
+	if err != nil {
+		return nil, fmt.Errorf("failure to load certificates: %w", err)
+	}
+	// an empty prefix would allow every url
+	allowedUrls := []string{}
+	for _, prefix := range cfg.AllowedUrls {
+		if prefix != "" {
+			allowedUrls = append(allowedUrls, prefix)
+		}
+	}
+	if len(allowedUrls) == 0 {
+		return nil, fmt.Errorf("no allowed source repository urls given")
+	}
+	if err := os.MkdirAll(cfg.Path, 0777); err != nil {
+		return nil, fmt.Errorf("could not create the source mirror directory %s: %w", cfg.Path, err)
+	}
+	if cfg.FetchInterval == 0 {
+		cfg.FetchInterval = 5 * time.Minute
+	}
+	return &SourceMirror{
+		path:          cfg.Path,
+		allowedUrls:   allowedUrls,
+		fetchInterval: cfg.FetchInterval,
+		credentials:   credentials,
+		certificates:  certificates,
+		allowLocal:    false,
+		mx:            sync.Mutex{},
+		urls:          map[string]*sync.Mutex{},
+		fetches:       make(chan string, 100),
+	}, nil
+}
+
+// CheckUrl returns an error if the source repository at url must not be mirrored.
+func (m *SourceMirror) CheckUrl(url string) error {
+	if url == "" {
+		return fmt.Errorf("no source repository url given")
+	}
+	if !m.allowLocal && isLocalUrl(url) {
+		return fmt.Errorf("the source repository url %s is not a remote repository", url)
+	}
+	for _, prefix := range m.allowedUrls {
+		if strings.HasPrefix(url, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the source repository url %s is not allowed", url)
+}
+
+// isLocalUrl is true unless the url uses https, ssh or the scp-like syntax "user@host:path".
+// The relative path ".." is not allowed either, so that the url cannot leave the allowed prefix.
+func isLocalUrl(url string) bool {
+	if strings.Contains(url, "..") {
+		return true
+	}
+	if strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "ssh://") {
+		return false
+	}
+	user, rest, found := strings.Cut(url, "@")
+	if !found || user == "" || strings.Contains(user, "/") {
+		return true
+	}
+	host, _, found := strings.Cut(rest, ":")
+	return !found || host == "" || strings.Contains(host, "/")
+}
+
+// Changelog returns the commits of the source repository at url that are reachable from toCommit but not from fromCommit.
+// If fromCommit is empty, all commits up to toCommit are returned. If paths are given, only commits that change
+// one of the paths are returned.
+// If one of the commits is not mirrored, a fetch is requested and ErrSourceNotMirrored is returned.
+func (m *SourceMirror) Changelog(ctx context.Context, url string, fromCommit string, toCommit string, paths []string) (*Changelog, error) {
+	if err := m.CheckUrl(url); err != nil {
+		return nil, err
+	}
+	to, err := git.NewOid(toCommit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit id %q: %w", toCommit, err)
+	}
+	var from *git.Oid
+	if fromCommit != "" {
+		from, err = git.NewOid(fromCommit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit id %q: %w", fromCommit, err)
+		}
+	}
+	m.add(url)
+	// a separate repository object is used for reading, so that a running fetch does not block the changelog
+	repo, err := git.OpenRepository(m.dir(url))
+	if err != nil {
+		m.requestFetch(url)
+		return nil, fmt.Errorf("%w: the source repository %s is not mirrored yet", ErrSourceNotMirrored, url)
+	}
+	defer repo.Free()
+	for _, commit := range []*git.Oid{to, from} {
+		if commit != nil && !hasCommit(repo, commit) {
+			m.requestFetch(url)
+			return nil, fmt.Errorf("%w: commit %s was not found in the mirror of the source repository %s", ErrSourceNotMirrored, commit, url)
+		}
+	}
+
+	walk, err := repo.Walk()
+	if err != nil {
+		return nil, err
+	}
+	defer walk.Free()
+	walk.Sorting(git.SortTopological | git.SortTime)
+	if err := walk.Push(to); err != nil {
+		return nil, err
+	}
+	if from != nil {
+		if err := walk.Hide(from); err != nil {
+			return nil, err
+		}
+	}
+	result := &Changelog{
+		Commits:   []SourceCommit{},
+		Truncated: false,
+	}
+	var walkErr error
+	walked := 0
+	err = walk.Iterate(func(commit *git.Commit) bool {
+		defer commit.Free()
+		if len(result.Commits) == maxChangelogCommits || walked == maxChangelogWalk {
+			result.Truncated = true
+			return false
+		}
+		walked++
+		if len(paths) > 0 {
+			touches, err := touchesPaths(repo, commit, paths)
+			if err != nil {
+				walkErr = err
+				return false
+			}
+			if !touches {
+				return true
+			}
+		}
+		author := commit.Author()
+		result.Commits = append(result.Commits, SourceCommit{
+			Id:          commit.Id().String(),
+			AuthorName:  author.Name,
+			AuthorEmail: author.Email,
+			Message:     commit.Message(),
+			AuthoredAt:  author.When,
+		})
+		return true
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Run fetches all mirrored repositories regularly, and the ones requested by Changelog immediately.
+func (m *SourceMirror) Run(ctx context.Context, reporter *setup.HealthReporter) error {
+	reporter.ReportReady("mirroring")
+	ticker := time.NewTicker(m.fetchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case url := <-m.fetches:
+			go m.fetchUrl(ctx, url)
+		case <-ticker.C:
+			m.mx.Lock()
+			for url := range m.urls {
+				go m.fetchUrl(ctx, url)
+			}
+			m.mx.Unlock()
+		}
+	}
+}
+
+// add registers url to be fetched regularly and returns its lock.
+func (m *SourceMirror) add(url string) *sync.Mutex {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	lock, ok := m.urls[url]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.urls[url] = lock
+	}
+	return lock
+}
+
+func (m *SourceMirror) requestFetch(url string) {
+	select {
+	case m.fetches <- url:
+	default:
+		// the repository will be fetched in the next interval
+	}
+}
+
+// fetchUrl updates the mirror of the repository at url. It does nothing if the repository is already being fetched.
+func (m *SourceMirror) fetchUrl(ctx context.Context, url string) {
+	lock := m.add(url)
+	if !lock.TryLock() {
+		return
+	}
+	defer lock.Unlock()
+	dir := m.dir(url)
+	repo, err := openOrCreate(dir, GitBackend)
+	if err != nil {
+		logger.FromContext(ctx).Warn("source.mirror.open", zap.String("dir", dir), zap.Error(err))
+		return
+	}
+	defer repo.Free()
+	if err := m.fetch(ctx, repo, url); err != nil {
+		logger.FromContext(ctx).Warn("source.mirror.fetch", zap.Error(err))
+	}
+}
+
+// dir returns the directory of the mirror of the repository at url.
+func (m *SourceMirror) dir(url string) string {
+	// the directory name must not contain the url, because it may contain credentials
+	return filepath.Join(m.path, fmt.Sprintf("%x", sha256.Sum256([]byte(url))))
+}
+
+func (m *SourceMirror) fetch(ctx context.Context, repo *git.Repository, url string) error {
+	remote, err := repo.Remotes.CreateAnonymous(url)
+	if err != nil {
+		return fmt.Errorf("failure to create anonymous remote: %w", err)
+	}
+	defer remote.Free()
+	fetchOptions := git.FetchOptions{}
+	if !strings.HasPrefix(url, "https://") && !isLocalUrl(url) {
+		// https certificates are checked by libgit2 itself
+		fetchOptions.RemoteCallbacks = git.RemoteCallbacks{
+			CredentialsCallback:      m.credentials.CredentialsCallback(ctx),
+			CertificateCheckCallback: m.certificates.CertificateCheckCallback(ctx),
+		}
+	}
+	if err := remote.Fetch([]string{"+refs/heads/*:refs/heads/*"}, &fetchOptions, "fetching"); err != nil {
+		return fmt.Errorf("failure to fetch the source repository: %w", err)
+	}
+	return nil
+}
+
+func hasCommit(repo *git.Repository, id *git.Oid) bool {
+	commit, err := repo.LookupCommit(id)
+	if err != nil {
+		return false
+	}
+	commit.Free()
+	return true
+}
+
+// touchesPaths checks whether the commit changes one of the paths compared to its first parent.
+func touchesPaths(repo *git.Repository, commit *git.Commit, paths []string) (bool, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return false, err
+	}
+	defer tree.Free()
+	var parentTree *git.Tree
+	if commit.ParentCount() > 0 {
+		parent := commit.Parent(0)
+		defer parent.Free()
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return false, err
+		}
+		defer parentTree.Free()
+	}
+	opts, err := git.DefaultDiffOptions()
+	if err != nil {
+		return false, err
+	}
+	opts.Pathspec = paths
+	diff, err := repo.DiffTreeToTree(parentTree, tree, &opts)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = diff.Free()
+	}()
+	deltas, err := diff.NumDeltas()
+	if err != nil {
+		return false, err
+	}
+	return deltas > 0, nil
+}
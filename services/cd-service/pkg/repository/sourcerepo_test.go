@@ -0,0 +1,217 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=kuberpult",
+		"GIT_COMMITTER_NAME=kuberpult",
+		"EMAIL=test@kuberpult.com",
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			t.Logf("stderr: %s\n", exitErr.Stderr)
+		}
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestSourceMirrorChangelog(t *testing.T) {
+	// The source repository contains three commits, which each change one file.
+	remoteDir := filepath.Join(t.TempDir(), "source.git")
+	runGit(t, ".", "init", "--bare", remoteDir)
+	workdir := t.TempDir()
+	runGit(t, ".", "clone", remoteDir, workdir)
+	commitIds := map[string]string{}
+	for _, file := range []string{"app1/a.txt", "app2/b.txt", "app1/c.txt"} {
+		if err := os.MkdirAll(filepath.Join(workdir, filepath.Dir(file)), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(workdir, file), []byte(file), 0666); err != nil {
+			t.Fatal(err)
+		}
+		runGit(t, workdir, "add", file)
+		runGit(t, workdir, "commit", "-m", "add "+file)
+		commitIds[file] = runGit(t, workdir, "rev-parse", "HEAD")
+	}
+	runGit(t, workdir, "push", "origin", "HEAD")
+
+	tcs := []struct {
+		Name            string
+		From            string
+		To              string
+		Paths           []string
+		ExpectedCommits []string
+		ExpectedError   string
+	}{
+		{
+			Name:            "commits between two commits",
+			From:            commitIds["app1/a.txt"],
+			To:              commitIds["app1/c.txt"],
+			ExpectedCommits: []string{"add app1/c.txt", "add app2/b.txt"},
+		},
+		{
+			Name:            "all commits without a previous commit",
+			To:              commitIds["app1/c.txt"],
+			ExpectedCommits: []string{"add app1/c.txt", "add app2/b.txt", "add app1/a.txt"},
+		},
+		{
+			Name:            "only commits that change the paths",
+			To:              commitIds["app1/c.txt"],
+			Paths:           []string{"app1"},
+			ExpectedCommits: []string{"add app1/c.txt", "add app1/a.txt"},
+		},
+		{
+			Name:            "no commits for the same commit",
+			From:            commitIds["app2/b.txt"],
+			To:              commitIds["app2/b.txt"],
+			ExpectedCommits: []string{},
+		},
+		{
+			Name:          "unknown commit",
+			To:            "0000000000000000000000000000000000000001",
+			ExpectedError: "the commits are not mirrored yet, try again later: commit 0000000000000000000000000000000000000001 was not found in the mirror of the source repository " + remoteDir,
+		},
+		{
+			Name:          "unknown previous commit",
+			From:          "0000000000000000000000000000000000000002",
+			To:            commitIds["app1/c.txt"],
+			ExpectedError: "the commits are not mirrored yet, try again later: commit 0000000000000000000000000000000000000002 was not found in the mirror of the source repository " + remoteDir,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx := context.Background()
+			mirror, err := NewSourceMirror(SourceMirrorConfig{Path: t.TempDir(), AllowedUrls: []string{remoteDir}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mirror.allowLocal = true
+			// the changelog only reads the mirror and requests a fetch
+			_, err = mirror.Changelog(ctx, remoteDir, tc.From, tc.To, tc.Paths)
+			if !errors.Is(err, ErrSourceNotMirrored) {
+				t.Fatalf("expected ErrSourceNotMirrored before the first fetch, got %v", err)
+			}
+			if url := <-mirror.fetches; url != remoteDir {
+				t.Fatalf("expected a fetch of %s, got %s", remoteDir, url)
+			}
+			mirror.fetchUrl(ctx, remoteDir)
+			changelog, err := mirror.Changelog(ctx, remoteDir, tc.From, tc.To, tc.Paths)
+			if tc.ExpectedError != "" {
+				if err == nil || err.Error() != tc.ExpectedError {
+					t.Fatalf("expected error %q, got %v", tc.ExpectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			actualCommits := []string{}
+			for _, commit := range changelog.Commits {
+				actualCommits = append(actualCommits, strings.TrimSpace(commit.Message))
+				if commit.AuthorName != "kuberpult" || commit.AuthorEmail != "test@kuberpult.com" {
+					t.Errorf("unexpected author of commit %s: %s <%s>", commit.Id, commit.AuthorName, commit.AuthorEmail)
+				}
+			}
+			if diff := cmp.Diff(tc.ExpectedCommits, actualCommits); diff != "" {
+				t.Errorf("unexpected commits (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSourceMirrorCheckUrl(t *testing.T) {
+	tcs := []struct {
+		Name          string
+		Url           string
+		ExpectedError string
+	}{
+		{
+			Name: "allowed ssh url",
+			Url:  "git@github.com:freiheit-com/kuberpult.git",
+		},
+		{
+			Name: "allowed https url",
+			Url:  "https://github.com/freiheit-com/kuberpult.git",
+		},
+		{
+			Name:          "other organization",
+			Url:           "git@github.com:example/kuberpult.git",
+			ExpectedError: "the source repository url git@github.com:example/kuberpult.git is not allowed",
+		},
+		{
+			Name:          "absolute path",
+			Url:           "/repository",
+			ExpectedError: "the source repository url /repository is not a remote repository",
+		},
+		{
+			Name:          "file url",
+			Url:           "file:///repository",
+			ExpectedError: "the source repository url file:///repository is not a remote repository",
+		},
+		{
+			Name:          "relative path",
+			Url:           "git@github.com:freiheit-com/../example/kuberpult.git",
+			ExpectedError: "the source repository url git@github.com:freiheit-com/../example/kuberpult.git is not a remote repository",
+		},
+		{
+			Name:          "empty url",
+			Url:           "",
+			ExpectedError: "no source repository url given",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			mirror, err := NewSourceMirror(SourceMirrorConfig{
+				Path:        t.TempDir(),
+				AllowedUrls: []string{"git@github.com:freiheit-com/", "https://github.com/freiheit-com/", ""},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = mirror.CheckUrl(tc.Url)
+			if tc.ExpectedError == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.ExpectedError {
+				t.Fatalf("expected error %q, got %v", tc.ExpectedError, err)
+			}
+		})
+	}
+}
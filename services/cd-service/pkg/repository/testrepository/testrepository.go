@@ -37,6 +37,18 @@ func (fr *failingRepository) Apply(ctx context.Context, transformers ...reposito
 	return fr.err
 }
 
+func (fr *failingRepository) ApplyIndependently(ctx context.Context, transformers ...repository.Transformer) ([]error, error) {
+	return nil, fr.err
+}
+
+func (fr *failingRepository) ApplyAsync(ctx context.Context, done func(error), transformers ...repository.Transformer) error {
+	return fr.err
+}
+
+func (fr *failingRepository) ApplyIndependentlyAsync(ctx context.Context, done func([]error, error), transformers ...repository.Transformer) error {
+	return fr.err
+}
+
 func (fr *failingRepository) Push(ctx context.Context, pushAction func() error) error {
 	return fr.err
 }
@@ -56,3 +68,7 @@ func (fr *failingRepository) StateAt(oid *git.Oid) (*repository.State, error) {
 func (fr *failingRepository) Notify() *notify.Notify {
 	return &fr.notify
 }
+
+func (fr *failingRepository) QueueStatus() []repository.QueuedElement {
+	return nil
+}
@@ -18,6 +18,8 @@ package repository
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -32,6 +34,7 @@ import (
 
 	"github.com/freiheit-com/kuberpult/pkg/uuid"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/event"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/webhook"
 
 	"github.com/freiheit-com/kuberpult/pkg/grpc"
 	"github.com/freiheit-com/kuberpult/pkg/valid"
@@ -51,6 +54,8 @@ import (
 	"github.com/hexops/gotextdiff"
 	"github.com/hexops/gotextdiff/myers"
 	"github.com/hexops/gotextdiff/span"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -61,10 +66,12 @@ const (
 	fieldSourceCommitId = "source_commit_id"
 	fieldDisplayVersion = "display_version"
 	fieldSourceRepoUrl  = "sourceRepoUrl" // urgh, inconsistent
+	fieldSourcePaths    = "sourcePaths"
 	fieldCreatedAt      = "created_at"
 	fieldTeam           = "team"
 	// number of old releases that will ALWAYS be kept in addition to the ones that are deployed:
 	keptVersionsOnCleanup = 20
+	auditLogDayFormat     = "2006-01-02"
 )
 
 func versionToString(Version uint64) string {
@@ -91,6 +98,34 @@ func releasesDirectoryWithVersion(fs billy.Filesystem, application string, versi
 	return fs.Join(releasesDirectory(fs, application), versionToString(version))
 }
 
+func rbacDirectory(fs billy.Filesystem) string {
+	return fs.Join("rbac")
+}
+
+func rbacPolicyFile(fs billy.Filesystem) string {
+	return fs.Join(rbacDirectory(fs), "policy.csv")
+}
+
+func auditLogDirectory(fs billy.Filesystem) string {
+	return fs.Join("audit")
+}
+
+// Audit log entries are stored in one directory per day, so that queries for a time range only read the relevant days.
+func auditLogDayDirectory(fs billy.Filesystem, day time.Time) string {
+	return fs.Join(auditLogDirectory(fs), day.UTC().Format(auditLogDayFormat))
+}
+
+func idempotencyDirectory(fs billy.Filesystem) string {
+	return fs.Join("idempotency")
+}
+
+// Idempotency records are stored in one directory per day like the audit log, so that expired records can be removed by day.
+// The file name is the hash of the user and the key, because keys can contain any character.
+func idempotencyRecordFile(fs billy.Filesystem, day time.Time, user, key string) string {
+	hash := sha256.Sum256([]byte(user + "\x00" + key))
+	return fs.Join(idempotencyDirectory(fs), day.UTC().Format(auditLogDayFormat), hex.EncodeToString(hash[:])+".json")
+}
+
 func commitDirectory(fs billy.Filesystem, commit string) string {
 	return fs.Join("commits", commit[:2], commit[2:])
 }
@@ -210,15 +245,40 @@ func (t TransformerFunc) Transform(ctx context.Context, state *State) (string, *
 	return (t)(ctx, state)
 }
 
+// newEvent returns a webhook event with the time and the author of the change.
+func newEvent(ctx context.Context, eventType webhook.EventType) webhook.Event {
+	event := webhook.Event{
+		Id:   "",
+		Type: eventType,
+		Time: getTimeNow(ctx).UTC(),
+	}
+	if user, err := auth.ReadUserFromContext(ctx); err == nil {
+		event.Author = user.Email
+	}
+	return event
+}
+
+func lockEvent(ctx context.Context, eventType webhook.EventType, environment, application, team, lockId, message string) webhook.Event {
+	event := newEvent(ctx, eventType)
+	event.Environment = environment
+	event.Application = application
+	event.Team = team
+	event.LockId = lockId
+	event.Message = message
+	return event
+}
+
 type CreateApplicationVersion struct {
 	Authentication
-	Version         uint64
-	Application     string
-	Manifests       map[string]string
-	SourceCommitId  string
-	SourceAuthor    string
-	SourceMessage   string
-	SourceRepoUrl   string
+	Version        uint64
+	Application    string
+	Manifests      map[string]string
+	SourceCommitId string
+	SourceAuthor   string
+	SourceMessage  string
+	SourceRepoUrl  string
+	// The paths in the source repository that belong to the application. Used to filter the changelog.
+	SourcePaths     []string
 	Team            string
 	DisplayVersion  string
 	WriteCommitData bool
@@ -321,6 +381,14 @@ func (c *CreateApplicationVersion) Transform(ctx context.Context, state *State)
 			return "", nil, GetCreateReleaseGeneralFailure(err)
 		}
 	}
+	// the paths of the latest release apply, also if it has none
+	if len(c.SourcePaths) > 0 {
+		if err := util.WriteFile(fs, fs.Join(appDir, fieldSourcePaths), []byte(strings.Join(c.SourcePaths, "\n")), 0666); err != nil {
+			return "", nil, GetCreateReleaseGeneralFailure(err)
+		}
+	} else if err := fs.Remove(fs.Join(appDir, fieldSourcePaths)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", nil, GetCreateReleaseGeneralFailure(err)
+	}
 	result := ""
 	isLatest, err := isLatestsVersion(state, c.Application, version)
 	if err != nil {
@@ -339,10 +407,34 @@ func (c *CreateApplicationVersion) Transform(ctx context.Context, state *State)
 		}
 	}
 
-	changes := &TransformerResult{}
 	var allEnvsOfThisApp []string = nil
-	for env, man := range c.Manifests {
+	for env := range c.Manifests {
 		allEnvsOfThisApp = append(allEnvsOfThisApp, env)
+	}
+	sort.Strings(allEnvsOfThisApp)
+	if c.WriteCommitData {
+		// The commit data is written before the deployments, so that they can add their events to it.
+		eventUuid := eventUuidGenerator(ctx).Generate()
+		err = writeCommitData(ctx, c.SourceCommitId, c.SourceMessage, c.Application, eventUuid, allEnvsOfThisApp, fs)
+		if err != nil {
+			return "", nil, GetCreateReleaseGeneralFailure(err)
+		}
+	}
+
+	changes := &TransformerResult{}
+	releaseTeam, err := state.GetApplicationTeamOwner(c.Application)
+	if err != nil {
+		return "", nil, GetCreateReleaseGeneralFailure(err)
+	}
+	releaseCreated := newEvent(ctx, webhook.EventReleaseCreated)
+	releaseCreated.Application = c.Application
+	releaseCreated.Team = releaseTeam
+	releaseCreated.Version = version
+	releaseCreated.SourceCommitId = c.SourceCommitId
+	releaseCreated.Environments = allEnvsOfThisApp
+	// the release is created before it is deployed
+	changes.AddEvent(releaseCreated)
+	for env, man := range c.Manifests {
 		err := state.checkUserPermissions(ctx, env, c.Application, auth.PermissionCreateRelease, c.Team, c.RBACConfig)
 		if err != nil {
 			return "", nil, GetCreateReleaseGeneralFailure(err)
@@ -368,11 +460,13 @@ func (c *CreateApplicationVersion) Transform(ctx context.Context, state *State)
 		changes.AddAppEnv(c.Application, env, teamOwner)
 		if hasUpstream && config.Upstream.Latest && isLatest {
 			d := &DeployApplicationVersion{
-				Environment:    env,
-				Application:    c.Application,
-				Version:        version, // the train should queue deployments, instead of giving up:
-				LockBehaviour:  api.LockBehavior_RECORD,
-				Authentication: c.Authentication,
+				Environment:     env,
+				Application:     c.Application,
+				Version:         version, // the train should queue deployments, instead of giving up:
+				LockBehaviour:   api.LockBehavior_RECORD,
+				Authentication:  c.Authentication,
+				WriteCommitData: c.WriteCommitData,
+				Trigger:         api.DeploymentEvent_NEW_RELEASE,
 			}
 			deployResult, subChanges, err := d.Transform(ctx, state)
 			changes.Combine(subChanges)
@@ -387,21 +481,18 @@ func (c *CreateApplicationVersion) Transform(ctx context.Context, state *State)
 			result = result + deployResult + "\n"
 		}
 	}
+	return fmt.Sprintf("created version %d of %q\n%s", version, c.Application, result), changes, nil
+}
+
+// eventUuidGenerator returns the generator for the ids of commit events, which tests can replace.
+func eventUuidGenerator(ctx context.Context) uuid.GenerateUUIDs {
 	gen, ok := getGeneratorFromContext(ctx)
 	if !ok || gen == nil {
 		logger.FromContext(ctx).Info("using real UUID generator.")
-		gen = uuid.RealUUIDGenerator{}
-	} else {
-		logger.FromContext(ctx).Info("using  UUID generator from context.")
+		return uuid.RealUUIDGenerator{}
 	}
-	eventUuid := gen.Generate()
-	if c.WriteCommitData {
-		err = writeCommitData(ctx, c.SourceCommitId, c.SourceMessage, c.Application, eventUuid, allEnvsOfThisApp, fs)
-		if err != nil {
-			return "", nil, GetCreateReleaseGeneralFailure(err)
-		}
-	}
-	return fmt.Sprintf("created version %d of %q\n%s", version, c.Application, result), changes, nil
+	logger.FromContext(ctx).Info("using  UUID generator from context.")
+	return gen
 }
 
 func getGeneratorFromContext(ctx context.Context) (uuid.GenerateUUIDs, bool) {
@@ -473,6 +564,44 @@ func writeEvent(ctx context.Context, eventId string, sourceCommitId string, file
 	return nil
 }
 
+// writeCommitEvent adds an event with one file per field to the commit data of a source commit.
+// Nothing is written for commits without commit data, e.g. for releases that were created before writing commit data was enabled.
+func writeCommitEvent(ctx context.Context, filesystem billy.Filesystem, sourceCommitId string, eventType string, fields map[string]string) error {
+	if !valid.SHA1CommitID(sourceCommitId) {
+		return nil
+	}
+	if _, err := filesystem.Stat(commitDirectory(filesystem, sourceCommitId)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	eventDir := commitEventDir(filesystem, sourceCommitId, eventUuidGenerator(ctx).Generate())
+	if err := filesystem.MkdirAll(eventDir, 0777); err != nil {
+		return fmt.Errorf("could not create directory %s: %v", eventDir, err)
+	}
+	fields["eventType"] = eventType
+	for name, value := range fields {
+		path := filesystem.Join(eventDir, name)
+		if err := util.WriteFile(filesystem, path, []byte(value), 0666); err != nil {
+			return fmt.Errorf("could not write file %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// releaseSourceCommitId returns the source commit of a release or "" if the release has none.
+func releaseSourceCommitId(fs billy.Filesystem, application string, version uint64) (string, error) {
+	content, err := readFile(fs, fs.Join(releasesDirectoryWithVersion(fs, application, version), fieldSourceCommitId))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(content), nil
+}
+
 func (c *CreateApplicationVersion) calculateVersion(state *State) (uint64, error) {
 	bfs := state.Filesystem
 	if c.Version == 0 {
@@ -1020,10 +1149,22 @@ func (s *State) checkUserPermissions(ctx context.Context, env, application, acti
 		return fmt.Errorf(fmt.Sprintf("checkUserPermissions: user not found: %v", err))
 	}
 
-	envs, err := s.GetEnvironmentConfigs()
+	group, err := s.environmentGroup(env)
 	if err != nil {
 		return err
 	}
+	RBACConfig, err = s.rbacConfigWithRepositoryPolicy(RBACConfig)
+	if err != nil {
+		return err
+	}
+	return auth.CheckUserPermissions(RBACConfig, user, env, team, group, application, action)
+}
+
+func (s *State) environmentGroup(env string) (string, error) {
+	envs, err := s.GetEnvironmentConfigs()
+	if err != nil {
+		return "", err
+	}
 	var group string
 	for envName, config := range envs {
 		if envName == env {
@@ -1032,9 +1173,42 @@ func (s *State) checkUserPermissions(ctx context.Context, env, application, acti
 		}
 	}
 	if group == "" {
-		return fmt.Errorf("group not found for environment: %s", env)
+		return "", fmt.Errorf("group not found for environment: %s", env)
 	}
-	return auth.CheckUserPermissions(RBACConfig, user, env, team, group, application, action)
+	return group, nil
+}
+
+// ExplainPermission finds the rule of the RBAC policy that decides if the role may perform the action.
+// If no environment group is given, it is derived from the environment.
+func (s *State) ExplainPermission(RBACConfig auth.RBACConfig, role, envGroup, env, application, action string) (*auth.PermissionExplanation, error) {
+	if envGroup == "" {
+		group, err := s.environmentGroup(env)
+		if err != nil {
+			return nil, err
+		}
+		envGroup = group
+	}
+	RBACConfig, err := s.rbacConfigWithRepositoryPolicy(RBACConfig)
+	if err != nil {
+		return nil, err
+	}
+	return auth.ExplainPermission(RBACConfig, role, env, envGroup, application, action), nil
+}
+
+// rbacConfigWithRepositoryPolicy replaces the policy of the config with the one stored in the manifest repository.
+// If the repository does not contain a policy (yet), the config is returned unchanged.
+func (s *State) rbacConfigWithRepositoryPolicy(RBACConfig auth.RBACConfig) (auth.RBACConfig, error) {
+	if !RBACConfig.PolicyFromRepository {
+		return RBACConfig, nil
+	}
+	policy, err := s.GetRbacPolicy()
+	if err != nil {
+		return RBACConfig, err
+	}
+	if policy != nil {
+		RBACConfig.Policy = policy
+	}
+	return RBACConfig, nil
 }
 
 func (s *State) checkUserPermissionsEnvGroup(ctx context.Context, envGroup, application, action, team string, RBACConfig auth.RBACConfig) error {
@@ -1045,6 +1219,10 @@ func (s *State) checkUserPermissionsEnvGroup(ctx context.Context, envGroup, appl
 	if err != nil {
 		return fmt.Errorf(fmt.Sprintf("checkUserPermissions: user not found: %v", err))
 	}
+	RBACConfig, err = s.rbacConfigWithRepositoryPolicy(RBACConfig)
+	if err != nil {
+		return err
+	}
 	return auth.CheckUserPermissions(RBACConfig, user, "*", team, envGroup, application, action)
 }
 
@@ -1063,6 +1241,10 @@ func (s *State) checkUserPermissionsCreateEnvironment(ctx context.Context, RBACC
 	if envConfig.EnvironmentGroup != nil {
 		envGroup = *(envConfig.EnvironmentGroup)
 	}
+	RBACConfig, err = s.rbacConfigWithRepositoryPolicy(RBACConfig)
+	if err != nil {
+		return err
+	}
 	return auth.CheckUserPermissions(RBACConfig, user, "*", "", envGroup, "*", auth.PermissionCreateEnvironment)
 }
 
@@ -1084,6 +1266,7 @@ func (c *CreateEnvironmentLock) Transform(ctx context.Context, state *State) (st
 			} else {
 				GaugeEnvLockMetric(fs, c.Environment)
 				changes := &TransformerResult{}
+				changes.AddEvent(lockEvent(ctx, webhook.EventLockCreated, c.Environment, "", "", c.LockId, c.Message))
 				return fmt.Sprintf("Created lock %q on environment %q", c.LockId, c.Environment), changes, nil
 			}
 		}
@@ -1178,6 +1361,7 @@ func (c *DeleteEnvironmentLock) Transform(ctx context.Context, state *State) (st
 		}
 		GaugeEnvLockMetric(fs, c.Environment)
 		changes := &TransformerResult{}
+		changes.AddEvent(lockEvent(ctx, webhook.EventLockDeleted, c.Environment, "", "", c.LockId, ""))
 		return fmt.Sprintf("Deleted lock %q on environment %q%s", c.LockId, c.Environment, additionalMessageFromDeployment), changes, nil
 	}
 }
@@ -1283,7 +1467,12 @@ func (c *CreateEnvironmentApplicationLock) Transform(ctx context.Context, state
 				return "", nil, err
 			} else {
 				GaugeEnvAppLockMetric(fs, c.Environment, c.Application)
+				teamOwner, err := state.GetApplicationTeamOwner(c.Application)
+				if err != nil {
+					return "", nil, err
+				}
 				changes := &TransformerResult{} // locks are invisible to argoCd, so no changes here
+				changes.AddEvent(lockEvent(ctx, webhook.EventLockCreated, c.Environment, c.Application, teamOwner, c.LockId, c.Message))
 				return fmt.Sprintf("Created lock %q on environment %q for application %q", c.LockId, c.Environment, c.Application), changes, nil
 			}
 		}
@@ -1326,7 +1515,12 @@ func (c *DeleteEnvironmentApplicationLock) Transform(ctx context.Context, state
 			return "", nil, err
 		}
 		GaugeEnvAppLockMetric(fs, c.Environment, c.Application)
+		teamOwner, err := state.GetApplicationTeamOwner(c.Application)
+		if err != nil {
+			return "", nil, err
+		}
 		changes := &TransformerResult{}
+		changes.AddEvent(lockEvent(ctx, webhook.EventLockDeleted, c.Environment, c.Application, teamOwner, c.LockId, ""))
 		return fmt.Sprintf("Deleted lock %q on environment %q for application %q%s", c.LockId, c.Environment, c.Application, queueMessage), changes, nil
 	}
 }
@@ -1363,10 +1557,214 @@ func (c *CreateEnvironment) Transform(ctx context.Context, state *State) (string
 			return "", nil, fmt.Errorf("error writing json: %w", err)
 		}
 		changes := &TransformerResult{} // we do not need to inform argoCd when creating an environment, as there are no apps yet
+		environmentCreated := newEvent(ctx, webhook.EventEnvironmentCreated)
+		environmentCreated.Environment = c.Environment
+		changes.AddEvent(environmentCreated)
 		return fmt.Sprintf("create environment %q", c.Environment), changes, file.Close()
 	}
 }
 
+type UpdateRbacPolicy struct {
+	Authentication
+	Policy string
+}
+
+func (c *UpdateRbacPolicy) Transform(ctx context.Context, state *State) (string, *TransformerResult, error) {
+	if !c.RBACConfig.DexEnabled || !c.RBACConfig.PolicyFromRepository {
+		return "", nil, grpc.FailedPrecondition(ctx, errors.New("the rbac policy is not stored in the manifest repository"))
+	}
+	if err := c.RBACConfig.CheckAdmin(ctx, "update the rbac policy"); err != nil {
+		return "", nil, err
+	}
+	policy, err := auth.ParseRbacPolicy(strings.NewReader(c.Policy))
+	if err != nil {
+		return "", nil, grpc.PublicError(ctx, fmt.Errorf("invalid rbac policy: %w", err))
+	}
+	if len(policy) == 0 {
+		return "", nil, grpc.PublicError(ctx, errors.New("invalid rbac policy: the policy must not be empty"))
+	}
+	fs := state.Filesystem
+	if err := fs.MkdirAll(rbacDirectory(fs), 0777); err != nil {
+		return "", nil, err
+	}
+	if err := util.WriteFile(fs, rbacPolicyFile(fs), []byte(c.Policy), 0666); err != nil {
+		return "", nil, err
+	}
+	changes := &TransformerResult{} // the rbac policy is invisible to argoCd
+	return fmt.Sprintf("Updated rbac policy (%d permissions)", len(policy)), changes, nil
+}
+
+// WriteAuditLog stores the audit log entries of a batch in the manifest repository.
+// It is applied by the cd-service itself together with the actions of a batch, or on its own for failed actions,
+// so it does not check any permissions.
+type WriteAuditLog struct {
+	Entries []*api.AuditLogEntry
+	// The days that are older than the retention are removed. 0 keeps all entries.
+	Retention time.Duration
+}
+
+func (c *WriteAuditLog) Transform(ctx context.Context, state *State) (string, *TransformerResult, error) {
+	fs := state.Filesystem
+	changes := &TransformerResult{} // the audit log is invisible to argoCd
+	var newest time.Time
+	for _, entry := range c.Entries {
+		if entry.Id == "" || entry.Timestamp == nil {
+			return "", nil, fmt.Errorf("audit log entry requires an id and a timestamp: %v", entry)
+		}
+		if err := writeAuditLogEntry(fs, entry); err != nil {
+			return "", nil, err
+		}
+		if entry.Outcome == api.AuditLogOutcome_AUDIT_LOG_OUTCOME_SUCCESS {
+			// the commit id is set once the commit exists, see IndexAuditLog
+			changes.AuditLogEntries = append(changes.AuditLogEntries, proto.Clone(entry).(*api.AuditLogEntry))
+		}
+		if timestamp := entry.Timestamp.AsTime(); timestamp.After(newest) {
+			newest = timestamp
+		}
+	}
+	if c.Retention > 0 && !newest.IsZero() {
+		days, err := names(fs, auditLogDirectory(fs))
+		if err != nil {
+			return "", nil, err
+		}
+		oldestDay := newest.Add(-c.Retention).UTC().Format(auditLogDayFormat)
+		for _, day := range days {
+			if day < oldestDay {
+				if err := util.RemoveAll(fs, fs.Join(auditLogDirectory(fs), day)); err != nil {
+					return "", nil, err
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("Added %d audit log entries", len(c.Entries)), changes, nil
+}
+
+func writeAuditLogEntry(fs billy.Filesystem, entry *api.AuditLogEntry) error {
+	dayDir := auditLogDayDirectory(fs, entry.Timestamp.AsTime())
+	if err := fs.MkdirAll(dayDir, 0777); err != nil {
+		return err
+	}
+	content, err := protojson.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error writing audit log entry: %w", err)
+	}
+	return util.WriteFile(fs, fs.Join(dayDir, entry.Id+".json"), content, 0666)
+}
+
+// WithAuditLogEntry applies a transformer and stores the audit log entry of its action in the same commit.
+// It is used in independent batches, where the entry must only be written if its own action was applied.
+type WithAuditLogEntry struct {
+	Transformer Transformer
+	Entry       *api.AuditLogEntry
+	Retention   time.Duration
+}
+
+func (c *WithAuditLogEntry) Transform(ctx context.Context, state *State) (string, *TransformerResult, error) {
+	msg, changes, err := c.Transformer.Transform(ctx, state)
+	if err != nil {
+		return "", nil, err
+	}
+	_, auditChanges, err := (&WriteAuditLog{Entries: []*api.AuditLogEntry{c.Entry}, Retention: c.Retention}).Transform(ctx, state)
+	if err != nil {
+		return "", nil, err
+	}
+	if changes == nil {
+		changes = &TransformerResult{}
+	}
+	changes.Combine(auditChanges)
+	return msg, changes, nil
+}
+
+// IndexAuditLog stores the commit ids of audit log entries. The entries are written in the commit of their actions,
+// so the id of that commit is only known afterwards. It is applied by the repository before the push.
+type IndexAuditLog struct {
+	// Entries with their commit ids.
+	Entries []*api.AuditLogEntry
+}
+
+func (c *IndexAuditLog) Transform(ctx context.Context, state *State) (string, *TransformerResult, error) {
+	fs := state.Filesystem
+	for _, entry := range c.Entries {
+		file := fs.Join(auditLogDayDirectory(fs, entry.Timestamp.AsTime()), entry.Id+".json")
+		if _, err := fs.Stat(file); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// removed by the retention in the meantime
+				continue
+			}
+			return "", nil, err
+		}
+		if err := writeAuditLogEntry(fs, entry); err != nil {
+			return "", nil, err
+		}
+	}
+	changes := &TransformerResult{} // the audit log is invisible to argoCd
+	return fmt.Sprintf("Indexed %d audit log entries", len(c.Entries)), changes, nil
+}
+
+// ExpectGitRevision fails with a ConcurrentModificationError unless the manifest repository is at the given commit.
+// It is applied before the other transformers of a batch, so that they are only applied if nobody else changed the repository in the meantime.
+type ExpectGitRevision struct {
+	Revision string
+}
+
+func (c *ExpectGitRevision) Transform(ctx context.Context, state *State) (string, *TransformerResult, error) {
+	current := ""
+	if state.Commit != nil {
+		current = state.Commit.Id().String()
+	}
+	if current != c.Revision {
+		return "", nil, &ConcurrentModificationError{
+			Environment:         "",
+			Application:         "",
+			ExpectedVersion:     nil,
+			CurrentVersion:      nil,
+			ExpectedGitRevision: c.Revision,
+			CurrentGitRevision:  current,
+		}
+	}
+	changes := &TransformerResult{}
+	return fmt.Sprintf("Based on revision %s", c.Revision), changes, nil
+}
+
+// RecordIdempotencyKey stores the response of a batch request with an idempotency key and removes the records that are older than the window.
+// It is applied by the cd-service itself, so it does not check any permissions.
+type RecordIdempotencyKey struct {
+	Record IdempotencyRecord
+	Window time.Duration
+}
+
+func (c *RecordIdempotencyKey) Transform(ctx context.Context, state *State) (string, *TransformerResult, error) {
+	fs := state.Filesystem
+	if c.Record.Key == "" || c.Record.CreatedAt.IsZero() {
+		return "", nil, fmt.Errorf("idempotency record requires a key and a creation time")
+	}
+	content, err := c.Record.marshal()
+	if err != nil {
+		return "", nil, err
+	}
+	file := idempotencyRecordFile(fs, c.Record.CreatedAt, c.Record.User, c.Record.Key)
+	if err := fs.MkdirAll(path.Dir(file), 0777); err != nil {
+		return "", nil, err
+	}
+	if err := util.WriteFile(fs, file, content, 0666); err != nil {
+		return "", nil, err
+	}
+	days, err := names(fs, idempotencyDirectory(fs))
+	if err != nil {
+		return "", nil, err
+	}
+	oldestDay := c.Record.CreatedAt.Add(-c.Window).UTC().Format(auditLogDayFormat)
+	for _, day := range days {
+		if day < oldestDay {
+			if err := util.RemoveAll(fs, fs.Join(idempotencyDirectory(fs), day)); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	changes := &TransformerResult{} // idempotency records are invisible to argoCd
+	return fmt.Sprintf("Recorded idempotency key %q", c.Record.Key), changes, nil
+}
+
 type QueueApplicationVersion struct {
 	Environment string
 	Application string
@@ -1390,7 +1788,17 @@ func (c *QueueApplicationVersion) Transform(ctx context.Context, state *State) (
 	}
 
 	// TODO SU: maybe check here if that version is already deployed? or somewhere else ... or not at all...
+	teamOwner, err := state.GetApplicationTeamOwner(c.Application)
+	if err != nil {
+		return "", nil, err
+	}
 	changes := &TransformerResult{}
+	queued := newEvent(ctx, webhook.EventDeploymentQueued)
+	queued.Environment = c.Environment
+	queued.Application = c.Application
+	queued.Team = teamOwner
+	queued.Version = c.Version
+	changes.AddEvent(queued)
 	return fmt.Sprintf("Queued version %d of app %q in env %q", c.Version, c.Application, c.Environment), changes, nil
 }
 
@@ -1400,6 +1808,13 @@ type DeployApplicationVersion struct {
 	Application   string
 	Version       uint64
 	LockBehaviour api.LockBehavior
+	// If set, the deployment fails with a ConcurrentModificationError unless this version is deployed. 0 means that no version is deployed.
+	ExpectedCurrentVersion *uint64
+	// If set, the deployment is recorded in the commit data of the release's source commit.
+	WriteCommitData bool
+	Trigger         api.DeploymentEvent_Trigger
+	// Only set for deployments of release trains.
+	ReleaseTrainSource *api.DeploymentEvent_ReleaseTrainSource
 }
 
 func (c *DeployApplicationVersion) Transform(ctx context.Context, state *State) (string, *TransformerResult, error) {
@@ -1407,6 +1822,30 @@ func (c *DeployApplicationVersion) Transform(ctx context.Context, state *State)
 	if err != nil {
 		return "", nil, err
 	}
+	if c.ExpectedCurrentVersion != nil {
+		currentVersion, err := state.GetEnvironmentApplicationVersion(c.Environment, c.Application)
+		if err != nil {
+			return "", nil, err
+		}
+		current := uint64(0)
+		if currentVersion != nil {
+			current = *currentVersion
+		}
+		if current != *c.ExpectedCurrentVersion {
+			var expectedVersion *uint64
+			if *c.ExpectedCurrentVersion != 0 {
+				expectedVersion = c.ExpectedCurrentVersion
+			}
+			return "", nil, &ConcurrentModificationError{
+				Environment:         c.Environment,
+				Application:         c.Application,
+				ExpectedVersion:     expectedVersion,
+				CurrentVersion:      currentVersion,
+				ExpectedGitRevision: "",
+				CurrentGitRevision:  "",
+			}
+		}
+	}
 	fs := state.Filesystem
 	// Check that the release exist and fetch manifest
 	releaseDir := releasesDirectoryWithVersion(fs, c.Application, c.Version)
@@ -1440,6 +1879,11 @@ func (c *DeployApplicationVersion) Transform(ctx context.Context, state *State)
 		if len(envLocks) > 0 || len(appLocks) > 0 {
 			switch c.LockBehaviour {
 			case api.LockBehavior_RECORD:
+				if c.WriteCommitData {
+					if err := c.writeLockPreventedEvent(ctx, fs, envLocks, appLocks); err != nil {
+						return "", nil, err
+					}
+				}
 				q := QueueApplicationVersion{
 					Environment: c.Environment,
 					Application: c.Application,
@@ -1456,6 +1900,10 @@ func (c *DeployApplicationVersion) Transform(ctx context.Context, state *State)
 			}
 		}
 	}
+	previousVersion, err := state.GetEnvironmentApplicationVersion(c.Environment, c.Application)
+	if err != nil {
+		return "", nil, err
+	}
 	// Create a symlink to the release
 	applicationDir := fs.Join("environments", c.Environment, "applications", c.Application)
 	if err := fs.MkdirAll(applicationDir, 0777); err != nil {
@@ -1490,6 +1938,12 @@ func (c *DeployApplicationVersion) Transform(ctx context.Context, state *State)
 		return "", nil, err
 	}
 	changes.AddAppEnv(c.Application, c.Environment, teamOwner)
+	deployed := newEvent(ctx, webhook.EventDeployed)
+	deployed.Environment = c.Environment
+	deployed.Application = c.Application
+	deployed.Team = teamOwner
+	deployed.Version = c.Version
+	changes.AddEvent(deployed)
 
 	user, err := auth.ReadUserFromContext(ctx)
 	if err != nil {
@@ -1506,6 +1960,11 @@ func (c *DeployApplicationVersion) Transform(ctx context.Context, state *State)
 	if err := util.WriteFile(fs, fs.Join(applicationDir, "deployed_at_utc"), []byte(getTimeNow(ctx).UTC().String()), 0666); err != nil {
 		return "", nil, err
 	}
+	if c.WriteCommitData {
+		if err := c.writeDeploymentEvents(ctx, fs, user, previousVersion); err != nil {
+			return "", nil, err
+		}
+	}
 
 	s := State{
 		Filesystem: fs,
@@ -1528,10 +1987,77 @@ func (c *DeployApplicationVersion) Transform(ctx context.Context, state *State)
 	return fmt.Sprintf("deployed version %d of %q to %q\n%s", c.Version, c.Application, c.Environment, transform), changes, nil
 }
 
+// writeDeploymentEvents records the deployment for the source commit of the release,
+// and the replacement for the source commit of the previously deployed release.
+func (c *DeployApplicationVersion) writeDeploymentEvents(ctx context.Context, fs billy.Filesystem, user *auth.User, previousVersion *uint64) error {
+	commitId, err := releaseSourceCommitId(fs, c.Application, c.Version)
+	if err != nil {
+		return err
+	}
+	fields := map[string]string{
+		"application":  c.Application,
+		"environment":  c.Environment,
+		"version":      strconv.FormatUint(c.Version, 10),
+		"author_name":  user.Name,
+		"author_email": user.Email,
+		"trigger":      c.Trigger.String(),
+	}
+	if c.ReleaseTrainSource != nil {
+		fields["release_train_upstream_environment"] = c.ReleaseTrainSource.UpstreamEnvironment
+		fields["release_train_target"] = c.ReleaseTrainSource.Target
+	}
+	if err := writeCommitEvent(ctx, fs, commitId, event.DeploymentEventName, fields); err != nil {
+		return err
+	}
+	if previousVersion == nil || *previousVersion == c.Version {
+		return nil
+	}
+	previousCommitId, err := releaseSourceCommitId(fs, c.Application, *previousVersion)
+	if err != nil {
+		return err
+	}
+	if previousCommitId == "" || previousCommitId == commitId {
+		return nil
+	}
+	return writeCommitEvent(ctx, fs, previousCommitId, event.ReplacedByEventName, map[string]string{
+		"application":           c.Application,
+		"environment":           c.Environment,
+		"replaced_by_commit_id": commitId,
+	})
+}
+
+// writeLockPreventedEvent records for the source commit of the release that the deployment was queued because of the locks.
+func (c *DeployApplicationVersion) writeLockPreventedEvent(ctx context.Context, fs billy.Filesystem, envLocks, appLocks map[string]Lock) error {
+	commitId, err := releaseSourceCommitId(fs, c.Application, c.Version)
+	if err != nil {
+		return err
+	}
+	lockType := api.LockPreventedDeploymentEvent_ENVIRONMENT
+	locks := envLocks
+	if len(envLocks) == 0 {
+		lockType = api.LockPreventedDeploymentEvent_APPLICATION
+		locks = appLocks
+	}
+	lockIds := make([]string, 0, len(locks))
+	for id := range locks {
+		lockIds = append(lockIds, id)
+	}
+	sort.Strings(lockIds)
+	return writeCommitEvent(ctx, fs, commitId, event.LockPreventedDeploymentEventName, map[string]string{
+		"application":  c.Application,
+		"environment":  c.Environment,
+		"version":      strconv.FormatUint(c.Version, 10),
+		"lock_type":    lockType.String(),
+		"lock_message": locks[lockIds[0]].Message,
+	})
+}
+
 type ReleaseTrain struct {
 	Authentication
 	Target string
 	Team   string
+	// If set, the deployments are recorded in the commit data of the releases' source commits.
+	WriteCommitData bool
 }
 
 func getEnvironmentGroupsEnvironmentsOrEnvironment(configs map[string]config.EnvironmentConfig, targetGroupName string) map[string]config.EnvironmentConfig {
@@ -1603,6 +2129,9 @@ func (c *ReleaseTrain) Transform(ctx context.Context, state *State) (string, *Tr
 	envDeployedMsg := make(map[string]string)
 	envSkippedMsg := make(map[string]string)
 	changes := &TransformerResult{}
+	trainFinished := newEvent(ctx, webhook.EventReleaseTrainFinished)
+	trainFinished.Target = targetGroupName
+	trainFinished.Team = c.Team
 	for _, envName := range envGroups {
 		envConfig := envGroupConfigs[envName]
 		if envConfig.Upstream == nil {
@@ -1698,11 +2227,17 @@ func (c *ReleaseTrain) Transform(ctx context.Context, state *State) (string, *Tr
 			}
 
 			d := &DeployApplicationVersion{
-				Environment:    envName, // here we deploy to the next env
-				Application:    appName,
-				Version:        versionToDeploy,
-				LockBehaviour:  api.LockBehavior_RECORD,
-				Authentication: c.Authentication,
+				Environment:     envName, // here we deploy to the next env
+				Application:     appName,
+				Version:         versionToDeploy,
+				LockBehaviour:   api.LockBehavior_RECORD,
+				Authentication:  c.Authentication,
+				WriteCommitData: c.WriteCommitData,
+				Trigger:         api.DeploymentEvent_RELEASE_TRAIN,
+				ReleaseTrainSource: &api.DeploymentEvent_ReleaseTrainSource{
+					UpstreamEnvironment: source,
+					Target:              targetGroupName,
+				},
 			}
 			transform, subChanges, err := d.Transform(ctx, state)
 			if err != nil {
@@ -1724,7 +2259,9 @@ func (c *ReleaseTrain) Transform(ctx context.Context, state *State) (string, *Tr
 			teamInfo = " for team '" + c.Team + "'"
 		}
 		envDeployedMsg[envName] = fmt.Sprintf("The release train deployed %d services from '%s' to '%s'%s\n%s\n", numServices, source, envName, teamInfo, completeMessage)
+		trainFinished.Environments = append(trainFinished.Environments, envName)
 	}
+	changes.AddEvent(trainFinished)
 
 	return generateReleaseTrainResponse(envDeployedMsg, envSkippedMsg, targetGroupName), changes, nil
 }
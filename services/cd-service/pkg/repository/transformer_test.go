@@ -39,6 +39,8 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 	"github.com/freiheit-com/kuberpult/pkg/auth"
@@ -46,6 +48,7 @@ import (
 	"github.com/freiheit-com/kuberpult/pkg/testfs"
 	"github.com/freiheit-com/kuberpult/pkg/valid"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/config"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/webhook"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/google/go-cmp/cmp"
@@ -496,6 +499,65 @@ func TestCreateApplicationVersionEvents(t *testing.T) {
 	}
 }
 
+func TestCreateApplicationVersionSourcePaths(t *testing.T) {
+	tcs := []struct {
+		Name          string
+		Transformers  []Transformer
+		ExpectedPaths []string
+	}{
+		{
+			Name: "the paths of the release are stored",
+			Transformers: []Transformer{
+				&CreateApplicationVersion{
+					Application: "app1",
+					Manifests: map[string]string{
+						envAcceptance: envAcceptance,
+					},
+					SourcePaths: []string{"services/app1", "pkg"},
+				},
+			},
+			ExpectedPaths: []string{"services/app1", "pkg"},
+		},
+		{
+			Name: "a release without paths removes the paths of an older release",
+			Transformers: []Transformer{
+				&CreateApplicationVersion{
+					Application: "app1",
+					Manifests: map[string]string{
+						envAcceptance: envAcceptance,
+					},
+					SourcePaths: []string{"services/app1"},
+				},
+				&CreateApplicationVersion{
+					Application: "app1",
+					Manifests: map[string]string{
+						envAcceptance: envAcceptance,
+					},
+				},
+			},
+			ExpectedPaths: nil,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			repo := setupRepositoryTest(t)
+			_, updatedState, _, err := repo.ApplyTransformersInternal(testutil.MakeTestContext(), tc.Transformers...)
+			if err != nil {
+				t.Fatalf("expected no error but transformer failed with %v", err)
+			}
+			paths, err := updatedState.GetApplicationSourcePaths("app1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.ExpectedPaths, paths); diff != "" {
+				t.Errorf("unexpected paths (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestDeployOnSelectedEnvs(t *testing.T) {
 	type Expected struct {
 		Path     string
@@ -1651,6 +1713,98 @@ func TestDeployApplicationVersion(t *testing.T) {
 	}
 }
 
+func TestDeployApplicationVersionConflict(t *testing.T) {
+	setup := []Transformer{
+		&CreateEnvironment{
+			Environment: envAcceptance,
+			Config:      config.EnvironmentConfig{Upstream: &config.EnvironmentConfigUpstream{Environment: envAcceptance, Latest: false}},
+		},
+		&CreateApplicationVersion{
+			Application: "app1",
+			Manifests: map[string]string{
+				envAcceptance: "v1",
+			},
+		},
+		&CreateApplicationVersion{
+			Application: "app1",
+			Manifests: map[string]string{
+				envAcceptance: "v2",
+			},
+		},
+	}
+	deploy := func(version uint64, expected *uint64) Transformer {
+		return &DeployApplicationVersion{
+			Environment:            envAcceptance,
+			Application:            "app1",
+			Version:                version,
+			LockBehaviour:          api.LockBehavior_FAIL,
+			ExpectedCurrentVersion: expected,
+		}
+	}
+	tcs := []struct {
+		Name          string
+		Transformers  []Transformer
+		ExpectedError error
+	}{
+		{
+			Name:          "deploys if nothing is deployed as expected",
+			Transformers:  []Transformer{deploy(1, ptr.Uint64(0))},
+			ExpectedError: nil,
+		},
+		{
+			Name:          "deploys if the expected version is deployed",
+			Transformers:  []Transformer{deploy(1, nil), deploy(2, ptr.Uint64(1))},
+			ExpectedError: nil,
+		},
+		{
+			Name:         "fails if another version is deployed",
+			Transformers: []Transformer{deploy(2, nil), deploy(1, ptr.Uint64(1))},
+			ExpectedError: &ConcurrentModificationError{
+				Environment:     envAcceptance,
+				Application:     "app1",
+				ExpectedVersion: ptr.Uint64(1),
+				CurrentVersion:  ptr.Uint64(2),
+			},
+		},
+		{
+			Name:         "fails if a version is deployed although none was expected",
+			Transformers: []Transformer{deploy(1, nil), deploy(2, ptr.Uint64(0))},
+			ExpectedError: &ConcurrentModificationError{
+				Environment:     envAcceptance,
+				Application:     "app1",
+				ExpectedVersion: nil,
+				CurrentVersion:  ptr.Uint64(1),
+			},
+		},
+		{
+			Name:         "fails if the repository is not at the expected revision",
+			Transformers: []Transformer{&ExpectGitRevision{Revision: "0000000000000000000000000000000000000000"}},
+			ExpectedError: &ConcurrentModificationError{
+				ExpectedGitRevision: "0000000000000000000000000000000000000000",
+			},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			repo := setupRepositoryTest(t)
+			if err := repo.Apply(testutil.MakeTestContext(), setup...); err != nil {
+				t.Fatalf("Expected no error: %v", err)
+			}
+			var err error
+			for _, tr := range tc.Transformers {
+				if err = repo.Apply(testutil.MakeTestContext(), tr); err != nil {
+					break
+				}
+			}
+			if d := cmp.Diff(tc.ExpectedError, err, cmpopts.IgnoreFields(ConcurrentModificationError{}, "CurrentGitRevision")); d != "" {
+				t.Errorf("error mismatch: %s", d)
+			}
+		})
+	}
+}
+
 func TestCreateApplicationVersionWithVersion(t *testing.T) {
 	tcs := []struct {
 		Name             string
@@ -2303,13 +2457,210 @@ func TestTransformerChanges(t *testing.T) {
 				t.Fatalf("Expected no error: %v", err)
 			}
 
-			if diff := cmp.Diff(lastChanges, tc.expectedChanges); diff != "" {
+			// the events are tested in TestTransformerEvents
+			if diff := cmp.Diff(lastChanges, tc.expectedChanges, cmpopts.IgnoreFields(TransformerResult{}, "Events")); diff != "" {
 				t.Errorf("got %v, want %v, diff (-want +got) %s", lastChanges, tc.expectedChanges, diff)
 			}
 		})
 	}
 }
 
+func TestTransformerEvents(t *testing.T) {
+	setup := []Transformer{
+		&CreateEnvironment{
+			Environment: envAcceptance,
+			Config:      testutil.MakeEnvConfigLatest(nil),
+		},
+		&CreateEnvironment{
+			Environment: envProduction,
+			Config:      testutil.MakeEnvConfigUpstream(envAcceptance, nil),
+		},
+	}
+	tcs := []struct {
+		Name           string
+		Transformers   []Transformer
+		ExpectedEvents []webhook.Event
+	}{
+		{
+			Name: "creating an environment",
+			Transformers: []Transformer{
+				&CreateEnvironment{
+					Environment: "staging",
+				},
+			},
+			ExpectedEvents: []webhook.Event{
+				{
+					Type:        webhook.EventEnvironmentCreated,
+					Author:      "testmail@example.com",
+					Environment: "staging",
+				},
+			},
+		},
+		{
+			Name: "creating a release deploys it to the latest environment",
+			Transformers: []Transformer{
+				&CreateApplicationVersion{
+					Application:    "foo",
+					Team:           "team1",
+					SourceCommitId: "cafe1cafe2cafe1cafe2cafe1cafe2cafe1cafe2",
+					Manifests: map[string]string{
+						envProduction: envProduction,
+						envAcceptance: envAcceptance,
+					},
+				},
+			},
+			ExpectedEvents: []webhook.Event{
+				{
+					Type:           webhook.EventReleaseCreated,
+					Author:         "testmail@example.com",
+					Environments:   []string{envAcceptance, envProduction},
+					Application:    "foo",
+					Team:           "team1",
+					Version:        1,
+					SourceCommitId: "cafe1cafe2cafe1cafe2cafe1cafe2cafe1cafe2",
+				},
+				{
+					Type:        webhook.EventDeployed,
+					Author:      "testmail@example.com",
+					Environment: envAcceptance,
+					Application: "foo",
+					Team:        "team1",
+					Version:     1,
+				},
+			},
+		},
+		{
+			Name: "creating a release queues it if the environment is locked",
+			Transformers: []Transformer{
+				&CreateEnvironmentLock{
+					Environment: envAcceptance,
+					LockId:      "l1",
+					Message:     "no deployments",
+				},
+				&CreateApplicationVersion{
+					Application: "foo",
+					Manifests: map[string]string{
+						envAcceptance: envAcceptance,
+					},
+				},
+			},
+			ExpectedEvents: []webhook.Event{
+				{
+					Type:         webhook.EventReleaseCreated,
+					Author:       "testmail@example.com",
+					Environments: []string{envAcceptance},
+					Application:  "foo",
+					Version:      1,
+				},
+				{
+					Type:        webhook.EventDeploymentQueued,
+					Author:      "testmail@example.com",
+					Environment: envAcceptance,
+					Application: "foo",
+					Version:     1,
+				},
+			},
+		},
+		{
+			Name: "creating an environment lock",
+			Transformers: []Transformer{
+				&CreateEnvironmentLock{
+					Environment: envAcceptance,
+					LockId:      "l1",
+					Message:     "no deployments",
+				},
+			},
+			ExpectedEvents: []webhook.Event{
+				{
+					Type:        webhook.EventLockCreated,
+					Author:      "testmail@example.com",
+					Environment: envAcceptance,
+					LockId:      "l1",
+					Message:     "no deployments",
+				},
+			},
+		},
+		{
+			Name: "deleting an application lock",
+			Transformers: []Transformer{
+				&CreateApplicationVersion{
+					Application: "foo",
+					Team:        "team1",
+					Manifests: map[string]string{
+						envAcceptance: envAcceptance,
+					},
+				},
+				&CreateEnvironmentApplicationLock{
+					Environment: envAcceptance,
+					Application: "foo",
+					LockId:      "l1",
+					Message:     "no deployments",
+				},
+				&DeleteEnvironmentApplicationLock{
+					Environment: envAcceptance,
+					Application: "foo",
+					LockId:      "l1",
+				},
+			},
+			ExpectedEvents: []webhook.Event{
+				{
+					Type:        webhook.EventLockDeleted,
+					Author:      "testmail@example.com",
+					Environment: envAcceptance,
+					Application: "foo",
+					Team:        "team1",
+					LockId:      "l1",
+				},
+			},
+		},
+		{
+			Name: "running a release train",
+			Transformers: []Transformer{
+				&CreateApplicationVersion{
+					Application: "foo",
+					Manifests: map[string]string{
+						envProduction: envProduction,
+						envAcceptance: envAcceptance,
+					},
+				},
+				&ReleaseTrain{
+					Target: envProduction,
+				},
+			},
+			ExpectedEvents: []webhook.Event{
+				{
+					Type:        webhook.EventDeployed,
+					Author:      "testmail@example.com",
+					Environment: envProduction,
+					Application: "foo",
+					Version:     1,
+				},
+				{
+					Type:         webhook.EventReleaseTrainFinished,
+					Author:       "testmail@example.com",
+					Environments: []string{envProduction},
+					Target:       envProduction,
+				},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			repo := setupRepositoryTest(t)
+			_, _, actualChanges, err := repo.ApplyTransformersInternal(testutil.MakeTestContext(), append(setup, tc.Transformers...)...)
+			if err != nil {
+				t.Fatalf("Expected no error: %v", err)
+			}
+			lastChanges := actualChanges[len(actualChanges)-1]
+			if diff := cmp.Diff(tc.ExpectedEvents, lastChanges.Events, cmpopts.IgnoreFields(webhook.Event{}, "Time")); diff != "" {
+				t.Errorf("events mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestRbacTransformerTest(t *testing.T) {
 	envGroupProduction := "production"
 	tcs := []struct {
@@ -2928,6 +3279,83 @@ func TestRbacTransformerTest(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "able to create environment lock with the policy from the repository",
+			ctx:  testutil.MakeTestContextDexEnabledUser("admin"),
+			Transformers: []Transformer{
+				&CreateEnvironment{
+					Environment:    envProduction,
+					Config:         config.EnvironmentConfig{Upstream: &config.EnvironmentConfigUpstream{Latest: true}},
+					Authentication: Authentication{RBACConfig: auth.RBACConfig{DexEnabled: false}},
+				},
+				&UpdateRbacPolicy{
+					Policy:         "admin,CreateLock,production:production,*,allow\n",
+					Authentication: Authentication{RBACConfig: auth.RBACConfig{DexEnabled: true, PolicyFromRepository: true, AdminRole: "admin"}},
+				},
+				&CreateEnvironmentLock{
+					Environment:    envProduction,
+					LockId:         "l123",
+					Message:        "my lock",
+					Authentication: Authentication{RBACConfig: auth.RBACConfig{DexEnabled: true, PolicyFromRepository: true, Policy: map[string]*auth.Permission{}}},
+				},
+			},
+		},
+		{
+			Name: "unable to create environment lock when the policy from the repository does not allow it",
+			ctx:  testutil.MakeTestContextDexEnabledUser("admin"),
+			Transformers: []Transformer{
+				&CreateEnvironment{
+					Environment:    envProduction,
+					Config:         config.EnvironmentConfig{Upstream: &config.EnvironmentConfigUpstream{Latest: true}},
+					Authentication: Authentication{RBACConfig: auth.RBACConfig{DexEnabled: false}},
+				},
+				&UpdateRbacPolicy{
+					Policy:         "admin,DeleteLock,production:production,*,allow\n",
+					Authentication: Authentication{RBACConfig: auth.RBACConfig{DexEnabled: true, PolicyFromRepository: true, AdminRole: "admin"}},
+				},
+				&CreateEnvironmentLock{
+					Environment: envProduction,
+					LockId:      "l123",
+					Message:     "my lock",
+					Authentication: Authentication{RBACConfig: auth.RBACConfig{DexEnabled: true, PolicyFromRepository: true, Policy: map[string]*auth.Permission{
+						"admin,CreateLock,production:production,*,allow": {Role: "admin"},
+					}}},
+				},
+			},
+			ExpectedError: "PermissionDenied: The user 'test tester' with role 'admin' is not allowed to perform the action 'CreateLock' on environment 'production'",
+		},
+		{
+			Name: "unable to update the rbac policy without the admin role",
+			Transformers: []Transformer{
+				&UpdateRbacPolicy{
+					Policy:         "developer,CreateLock,production:production,*,allow\n",
+					Authentication: Authentication{RBACConfig: auth.RBACConfig{DexEnabled: true, PolicyFromRepository: true, AdminRole: "admin"}},
+				},
+			},
+			ExpectedError: "PermissionDenied: The user 'test tester' with role 'developer' is not allowed to update the rbac policy",
+		},
+		{
+			Name: "unable to update the rbac policy with an invalid line",
+			ctx:  testutil.MakeTestContextDexEnabledUser("admin"),
+			Transformers: []Transformer{
+				&UpdateRbacPolicy{
+					Policy:         "admin,CreateLock,production:production,*,allow\nadmin,WRONG_ACTION,production:production,*,allow\n",
+					Authentication: Authentication{RBACConfig: auth.RBACConfig{DexEnabled: true, PolicyFromRepository: true, AdminRole: "admin"}},
+				},
+			},
+			ExpectedError: "invalid rbac policy: line 2: invalid action WRONG_ACTION",
+		},
+		{
+			Name: "unable to update the rbac policy if it is not stored in the repository",
+			ctx:  testutil.MakeTestContextDexEnabledUser("admin"),
+			Transformers: []Transformer{
+				&UpdateRbacPolicy{
+					Policy:         "admin,CreateLock,production:production,*,allow\n",
+					Authentication: Authentication{RBACConfig: auth.RBACConfig{DexEnabled: true, AdminRole: "admin"}},
+				},
+			},
+			ExpectedError: "the rbac policy is not stored in the manifest repository",
+		},
 	}
 
 	for _, tc := range tcs {
@@ -5707,3 +6135,218 @@ func TestEnvironmentGroupLocks(t *testing.T) {
 		})
 	}
 }
+
+func TestAuditLog(t *testing.T) {
+	day1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	entries := []*api.AuditLogEntry{
+		{
+			Id:          "1",
+			Timestamp:   timestamppb.New(day1),
+			UserName:    "alice",
+			UserEmail:   "alice@example.com",
+			Action:      "create_environment_lock",
+			Environment: "production",
+			Outcome:     api.AuditLogOutcome_AUDIT_LOG_OUTCOME_SUCCESS,
+		},
+		{
+			Id:          "2",
+			Timestamp:   timestamppb.New(day1.Add(time.Hour)),
+			UserName:    "bob",
+			UserEmail:   "bob@example.com",
+			Action:      "deploy",
+			Environment: "production",
+			Application: "app1",
+			Outcome:     api.AuditLogOutcome_AUDIT_LOG_OUTCOME_FAILURE,
+			Error:       "locked",
+		},
+		{
+			Id:          "3",
+			Timestamp:   timestamppb.New(day2),
+			UserName:    "alice",
+			UserEmail:   "alice@example.com",
+			Action:      "deploy",
+			Environment: "staging",
+			Application: "app1",
+			Outcome:     api.AuditLogOutcome_AUDIT_LOG_OUTCOME_SUCCESS,
+		},
+	}
+	tcs := []struct {
+		Name        string
+		Filter      AuditLogFilter
+		ExpectedIds []string
+	}{
+		{
+			Name:        "Returns all entries, newest first",
+			Filter:      AuditLogFilter{},
+			ExpectedIds: []string{"3", "2", "1"},
+		},
+		{
+			Name:        "Filters by user email",
+			Filter:      AuditLogFilter{User: "alice@example.com"},
+			ExpectedIds: []string{"3", "1"},
+		},
+		{
+			Name:        "Filters by user name, environment and application",
+			Filter:      AuditLogFilter{User: "bob", Environment: "production", Application: "app1"},
+			ExpectedIds: []string{"2"},
+		},
+		{
+			Name:        "Filters by time range",
+			Filter:      AuditLogFilter{From: day1.Add(30 * time.Minute), To: day2.Add(-time.Minute)},
+			ExpectedIds: []string{"2"},
+		},
+		{
+			Name:        "Limits the number of entries",
+			Filter:      AuditLogFilter{Limit: 2},
+			ExpectedIds: []string{"3", "2"},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			repo := setupRepositoryTest(t)
+			err := repo.Apply(testutil.MakeTestContext(), &WriteAuditLog{Entries: entries})
+			if err != nil {
+				t.Fatalf("Expected no error: %v", err)
+			}
+			actual, err := repo.State().GetAuditLog(tc.Filter)
+			if err != nil {
+				t.Fatalf("Expected no error: %v", err)
+			}
+			actualIds := []string{}
+			for _, entry := range actual {
+				actualIds = append(actualIds, entry.Id)
+			}
+			if diff := cmp.Diff(tc.ExpectedIds, actualIds); diff != "" {
+				t.Errorf("audit log mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAuditLogRetentionAndCommitIds(t *testing.T) {
+	day1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	day3 := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	entry := func(id string, timestamp time.Time) *api.AuditLogEntry {
+		return &api.AuditLogEntry{
+			Id:        id,
+			Timestamp: timestamppb.New(timestamp),
+			Action:    "deploy",
+			Outcome:   api.AuditLogOutcome_AUDIT_LOG_OUTCOME_SUCCESS,
+		}
+	}
+	repo := setupRepositoryTest(t)
+	ctx := testutil.MakeTestContext()
+	if err := repo.Apply(ctx, &WriteAuditLog{Entries: []*api.AuditLogEntry{entry("1", day1)}, Retention: 24 * time.Hour}); err != nil {
+		t.Fatalf("Expected no error: %v", err)
+	}
+	if err := repo.Apply(ctx, &WriteAuditLog{Entries: []*api.AuditLogEntry{entry("2", day3)}, Retention: 24 * time.Hour}); err != nil {
+		t.Fatalf("Expected no error: %v", err)
+	}
+	actual, err := repo.State().GetAuditLog(AuditLogFilter{})
+	if err != nil {
+		t.Fatalf("Expected no error: %v", err)
+	}
+	if len(actual) != 1 || actual[0].Id != "2" {
+		t.Fatalf("expected only the entry within the retention, got %v", actual)
+	}
+	// the entry is stored in the commit before the index commit
+	head := repo.State().Commit
+	if head.ParentCount() != 1 {
+		t.Fatalf("expected the index commit on top of the entry")
+	}
+	parent := head.Parent(0)
+	defer parent.Free()
+	if actual[0].CommitId != parent.Id().String() {
+		t.Errorf("expected commit id %s, got %s", parent.Id(), actual[0].CommitId)
+	}
+}
+
+func TestIdempotencyRecords(t *testing.T) {
+	day1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	day3 := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	record := func(key string, createdAt time.Time) *RecordIdempotencyKey {
+		return &RecordIdempotencyKey{
+			Record: IdempotencyRecord{
+				User:        "ci@example.com",
+				Key:         key,
+				RequestHash: "hash-" + key,
+				CreatedAt:   createdAt,
+				Response: &api.BatchResponse{Results: []*api.BatchResult{
+					{Result: &api.BatchResult_ReleaseTrain{ReleaseTrain: &api.ReleaseTrainResponse{Target: key}}},
+				}},
+			},
+			Window: 24 * time.Hour,
+		}
+	}
+	tcs := []struct {
+		Name           string
+		Transformers   []Transformer
+		User           string
+		Key            string
+		Since          time.Time
+		ExpectedRecord *IdempotencyRecord
+	}{
+		{
+			Name:           "Returns the record of the key",
+			Transformers:   []Transformer{record("a", day1), record("b", day1)},
+			User:           "ci@example.com",
+			Key:            "b",
+			Since:          day1.Add(-time.Hour),
+			ExpectedRecord: &record("b", day1).Record,
+		},
+		{
+			Name:           "Ignores the same key of another user",
+			Transformers:   []Transformer{record("a", day1)},
+			User:           "other@example.com",
+			Key:            "a",
+			Since:          day1.Add(-time.Hour),
+			ExpectedRecord: nil,
+		},
+		{
+			Name:           "Ignores records that are older than since",
+			Transformers:   []Transformer{record("a", day1)},
+			User:           "ci@example.com",
+			Key:            "a",
+			Since:          day1.Add(time.Minute),
+			ExpectedRecord: nil,
+		},
+		{
+			Name:           "Removes records outside of the window",
+			Transformers:   []Transformer{record("a", day1), record("b", day3)},
+			User:           "ci@example.com",
+			Key:            "a",
+			Since:          time.Time{},
+			ExpectedRecord: nil,
+		},
+		{
+			Name:           "Returns nil without records",
+			Transformers:   []Transformer{},
+			User:           "ci@example.com",
+			Key:            "a",
+			Since:          time.Time{},
+			ExpectedRecord: nil,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			repo := setupRepositoryTest(t)
+			for _, tr := range tc.Transformers {
+				if err := repo.Apply(testutil.MakeTestContext(), tr); err != nil {
+					t.Fatalf("Expected no error: %v", err)
+				}
+			}
+			actual, err := repo.State().GetIdempotencyRecord(tc.User, tc.Key, tc.Since)
+			if err != nil {
+				t.Fatalf("Expected no error: %v", err)
+			}
+			if diff := cmp.Diff(tc.ExpectedRecord, actual, protocmp.Transform()); diff != "" {
+				t.Errorf("idempotency record mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/pkg/grpc"
+	"github.com/freiheit-com/kuberpult/pkg/logger"
+	"github.com/freiheit-com/kuberpult/pkg/uuid"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The number of audit log entries that are returned if the request has no limit.
+const defaultAuditLogLimit = 1000
+
+type AuditServiceServer struct {
+	Repository repository.Repository
+	RBACConfig auth.RBACConfig
+}
+
+func (a *AuditServiceServer) QueryAuditLog(
+	ctx context.Context,
+	in *api.QueryAuditLogRequest) (*api.QueryAuditLogResponse, error) {
+	if err := a.RBACConfig.CheckAdmin(ctx, "read the audit log"); err != nil {
+		return nil, err
+	}
+	filter := repository.AuditLogFilter{
+		User:        in.User,
+		Environment: in.Environment,
+		Application: in.Application,
+		Limit:       int(in.Limit),
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAuditLogLimit
+	}
+	if in.From != nil {
+		filter.From = in.From.AsTime()
+	}
+	if in.To != nil {
+		filter.To = in.To.AsTime()
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		return nil, status.Error(codes.InvalidArgument, "cannot query audit log: 'to' must not be before 'from'")
+	}
+	entries, err := a.Repository.State().GetAuditLog(filter)
+	if err != nil {
+		return nil, grpc.InternalError(ctx, err)
+	}
+	return &api.QueryAuditLogResponse{Entries: entries}, nil
+}
+
+var _ api.AuditServiceServer = (*AuditServiceServer)(nil)
+
+// newAuditLogEntries describes the actions of a batch before it is applied, so all outcomes are successful.
+// The entries are written in the same commit as the actions. It returns nil if the audit log is disabled.
+func (d *BatchServer) newAuditLogEntries(user *auth.User, in *api.BatchRequest) []*api.AuditLogEntry {
+	if !d.Config.WriteAuditLog {
+		return nil
+	}
+	return auditLogEntries(user, in, uuid.RealUUIDGenerator{}, time.Now())
+}
+
+// writeFailedAuditLog records the failed actions of the batch. They are not part of any commit, so they are written on their own.
+// Errors are only logged, because the outcome of the batch must not depend on the audit log.
+// actionErrors contains the errors of the single actions of an independent batch, it is nil for atomic batches.
+func (d *BatchServer) writeFailedAuditLog(ctx context.Context, entries []*api.AuditLogEntry, batchErr error, actionErrors []error) {
+	failed := failedAuditLogEntries(entries, batchErr, actionErrors)
+	if len(failed) == 0 {
+		return
+	}
+	if err := d.Repository.Apply(ctx, &repository.WriteAuditLog{Entries: failed, Retention: d.Config.AuditLogRetention}); err != nil {
+		logger.FromContext(ctx).Error("audit.write", zap.Error(err))
+	}
+}
+
+// failedAuditLogEntries sets the outcome of the entries whose action failed and returns them.
+func failedAuditLogEntries(entries []*api.AuditLogEntry, batchErr error, actionErrors []error) []*api.AuditLogEntry {
+	failed := []*api.AuditLogEntry{}
+	for i, entry := range entries {
+		err := batchErr
+		if err == nil && i < len(actionErrors) {
+			err = actionErrors[i]
+		}
+		if err == nil {
+			continue
+		}
+		entry.Outcome = api.AuditLogOutcome_AUDIT_LOG_OUTCOME_FAILURE
+		entry.Error = err.Error()
+		failed = append(failed, entry)
+	}
+	return failed
+}
+
+// auditLogEntries creates one audit log entry for each action of the batch.
+func auditLogEntries(user *auth.User, in *api.BatchRequest, gen uuid.GenerateUUIDs, now time.Time) []*api.AuditLogEntry {
+	role := ""
+	if user.DexAuthContext != nil {
+		role = user.DexAuthContext.Role
+	}
+	entries := make([]*api.AuditLogEntry, 0, len(in.GetActions()))
+	for _, batchAction := range in.GetActions() {
+		action, parameters, environment, application := describeBatchAction(batchAction)
+		entries = append(entries, &api.AuditLogEntry{
+			Id:          gen.Generate(),
+			Timestamp:   timestamppb.New(now),
+			UserName:    user.Name,
+			UserEmail:   user.Email,
+			Role:        role,
+			Action:      action,
+			Parameters:  parameters,
+			Environment: environment,
+			Application: application,
+			Outcome:     api.AuditLogOutcome_AUDIT_LOG_OUTCOME_SUCCESS,
+			Error:       "",
+			// the commit that contains the entry is stored after it was created, see repository.IndexAuditLog
+			CommitId: "",
+		})
+	}
+	return entries
+}
+
+// describeBatchAction returns the name of the action, its parameters in json format and the environment and application it refers to.
+func describeBatchAction(batchAction *api.BatchAction) (action string, parameters string, environment string, application string) {
+	msg := batchAction.ProtoReflect()
+	field := msg.WhichOneof(msg.Descriptor().Oneofs().ByName("action"))
+	if field == nil {
+		return "unknown", "", "", ""
+	}
+	request := msg.Get(field).Message().Interface()
+	if createRelease, ok := request.(*api.CreateReleaseRequest); ok {
+		// Manifests are large and they are stored in the manifest repository anyway.
+		createRelease = proto.Clone(createRelease).(*api.CreateReleaseRequest)
+		createRelease.Manifests = nil
+		request = createRelease
+	}
+	parameters = ""
+	if content, err := protojson.Marshal(request); err != nil {
+		parameters = fmt.Sprintf("%q", err.Error())
+	} else {
+		// protojson does not guarantee stable whitespace, so the output is compacted.
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, content); err != nil {
+			parameters = string(content)
+		} else {
+			parameters = compact.String()
+		}
+	}
+	environment = stringField(request, "environment")
+	if environment == "" {
+		// release trains refer to their target environment
+		environment = stringField(request, "target")
+	}
+	return string(field.Name()), parameters, environment, stringField(request, "application")
+}
+
+func stringField(msg proto.Message, name protoreflect.Name) string {
+	field := msg.ProtoReflect().Descriptor().Fields().ByName(name)
+	if field == nil || field.Kind() != protoreflect.StringKind {
+		return ""
+	}
+	return msg.ProtoReflect().Get(field).String()
+}
@@ -0,0 +1,270 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/config"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository/testutil"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	git "github.com/libgit2/git2go/v34"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestAuditLog(t *testing.T) {
+	tcs := []struct {
+		Name            string
+		Context         context.Context
+		RBACConfig      auth.RBACConfig
+		Batch           []*api.BatchAction
+		Mode            api.BatchMode
+		ExpectedEntries []*api.AuditLogEntry
+	}{
+		{
+			Name:    "Records successful actions",
+			Context: testutil.MakeTestContext(),
+			Batch: []*api.BatchAction{
+				{
+					Action: &api.BatchAction_CreateEnvironmentLock{
+						CreateEnvironmentLock: &api.CreateEnvironmentLockRequest{
+							Environment: "production",
+							LockId:      "1234",
+							Message:     "EnvLock",
+						},
+					},
+				},
+			},
+			ExpectedEntries: []*api.AuditLogEntry{
+				{
+					UserName:    "test tester",
+					UserEmail:   "testmail@example.com",
+					Action:      "create_environment_lock",
+					Parameters:  `{"environment":"production","lockId":"1234","message":"EnvLock"}`,
+					Environment: "production",
+					Outcome:     api.AuditLogOutcome_AUDIT_LOG_OUTCOME_SUCCESS,
+				},
+			},
+		},
+		{
+			Name:       "Records denied actions",
+			Context:    testutil.MakeTestContextDexEnabled(),
+			RBACConfig: auth.RBACConfig{DexEnabled: true, Policy: map[string]*auth.Permission{}},
+			Batch: []*api.BatchAction{
+				{
+					Action: &api.BatchAction_DeleteEnvironmentApplicationLock{
+						DeleteEnvironmentApplicationLock: &api.DeleteEnvironmentApplicationLockRequest{
+							Environment: "production",
+							Application: "test",
+							LockId:      "1234",
+						},
+					},
+				},
+			},
+			ExpectedEntries: []*api.AuditLogEntry{
+				{
+					UserName:    "test tester",
+					UserEmail:   "testmail@example.com",
+					Role:        "developer",
+					Action:      "delete_environment_application_lock",
+					Parameters:  `{"environment":"production","application":"test","lockId":"1234"}`,
+					Environment: "production",
+					Application: "test",
+					Outcome:     api.AuditLogOutcome_AUDIT_LOG_OUTCOME_FAILURE,
+					Error:       "rpc error: code = PermissionDenied desc = PermissionDenied: The user 'test tester' with role 'developer' is not allowed to perform the action 'DeleteLock' on environment 'production'",
+				},
+			},
+		},
+		{
+			Name:    "Records the applied and the failed actions of an independent batch",
+			Context: testutil.MakeTestContextDexEnabled(),
+			RBACConfig: auth.RBACConfig{DexEnabled: true, Policy: map[string]*auth.Permission{
+				"developer,CreateLock,production:production,*,allow": {Role: "developer"},
+			}},
+			Mode: api.BatchMode_INDEPENDENT,
+			Batch: []*api.BatchAction{
+				{
+					Action: &api.BatchAction_CreateEnvironmentLock{
+						CreateEnvironmentLock: &api.CreateEnvironmentLockRequest{
+							Environment: "production",
+							LockId:      "1234",
+							Message:     "EnvLock",
+						},
+					},
+				},
+				{
+					Action: &api.BatchAction_DeleteEnvironmentApplicationLock{
+						DeleteEnvironmentApplicationLock: &api.DeleteEnvironmentApplicationLockRequest{
+							Environment: "production",
+							Application: "test",
+							LockId:      "1234",
+						},
+					},
+				},
+			},
+			ExpectedEntries: []*api.AuditLogEntry{
+				{
+					UserName:    "test tester",
+					UserEmail:   "testmail@example.com",
+					Role:        "developer",
+					Action:      "create_environment_lock",
+					Parameters:  `{"environment":"production","lockId":"1234","message":"EnvLock"}`,
+					Environment: "production",
+					Outcome:     api.AuditLogOutcome_AUDIT_LOG_OUTCOME_SUCCESS,
+				},
+				{
+					UserName:    "test tester",
+					UserEmail:   "testmail@example.com",
+					Role:        "developer",
+					Action:      "delete_environment_application_lock",
+					Parameters:  `{"environment":"production","application":"test","lockId":"1234"}`,
+					Environment: "production",
+					Application: "test",
+					Outcome:     api.AuditLogOutcome_AUDIT_LOG_OUTCOME_FAILURE,
+					Error:       "rpc error: code = PermissionDenied desc = PermissionDenied: The user 'test tester' with role 'developer' is not allowed to perform the action 'DeleteLock' on environment 'production'",
+				},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			repo, err := setupRepositoryTest(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = repo.Apply(testutil.MakeTestContext(), &repository.CreateEnvironment{
+				Environment: "production",
+				Config:      config.EnvironmentConfig{Upstream: &config.EnvironmentConfigUpstream{Latest: true}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc := &BatchServer{
+				Repository: repo,
+				RBACConfig: tc.RBACConfig,
+				Config:     BatchServerConfig{WriteAuditLog: true},
+			}
+			_, _ = svc.ProcessBatch(tc.Context, &api.BatchRequest{Actions: tc.Batch, Mode: tc.Mode})
+
+			auditSvc := &AuditServiceServer{Repository: repo}
+			response, err := auditSvc.QueryAuditLog(tc.Context, &api.QueryAuditLogRequest{})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for _, entry := range response.Entries {
+				if entry.Id == "" || entry.Timestamp == nil {
+					t.Errorf("expected id and timestamp, got %v", entry)
+				}
+				if entry.Outcome != api.AuditLogOutcome_AUDIT_LOG_OUTCOME_SUCCESS {
+					if entry.CommitId != "" {
+						t.Errorf("expected no commit id, got %q", entry.CommitId)
+					}
+					continue
+				}
+				// the entries of applied actions are in the commit of the action
+				commitId, err := git.NewOid(entry.CommitId)
+				if err != nil {
+					t.Fatalf("expected a commit id, got %q", entry.CommitId)
+				}
+				state, err := repo.StateAt(commitId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				locks, err := state.GetEnvironmentLocks("production")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := locks["1234"]; !ok {
+					t.Errorf("expected the lock in commit %s", entry.CommitId)
+				}
+			}
+			// the entries of one batch have the same timestamp
+			sortEntries := cmpopts.SortSlices(func(a, b *api.AuditLogEntry) bool { return a.Action < b.Action })
+			if diff := cmp.Diff(tc.ExpectedEntries, response.Entries, protocmp.Transform(), protocmp.IgnoreFields(&api.AuditLogEntry{}, "id", "timestamp", "commit_id"), sortEntries); diff != "" {
+				t.Errorf("audit log mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQueryAuditLogPermissions(t *testing.T) {
+	rbacConfig := auth.RBACConfig{
+		DexEnabled: true,
+		AdminRole:  "admin",
+	}
+	tcs := []struct {
+		Name          string
+		RBACConfig    auth.RBACConfig
+		Context       context.Context
+		ExpectedError string
+	}{
+		{
+			Name:       "rbac disabled",
+			RBACConfig: auth.RBACConfig{DexEnabled: false},
+			Context:    testutil.MakeTestContext(),
+		},
+		{
+			Name:       "admin",
+			RBACConfig: rbacConfig,
+			Context:    testutil.MakeTestContextDexEnabledUser("admin"),
+		},
+		{
+			Name:          "not admin",
+			RBACConfig:    rbacConfig,
+			Context:       testutil.MakeTestContextDexEnabled(),
+			ExpectedError: "rpc error: code = PermissionDenied desc = PermissionDenied: The user 'test tester' with role 'developer' is not allowed to read the audit log",
+		},
+		{
+			Name:          "no admin role configured",
+			RBACConfig:    auth.RBACConfig{DexEnabled: true},
+			Context:       testutil.MakeTestContextDexEnabledUser(""),
+			ExpectedError: "rpc error: code = PermissionDenied desc = PermissionDenied: The user 'test tester' with role '' is not allowed to read the audit log",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			repo, err := setupRepositoryTest(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc := &AuditServiceServer{
+				Repository: repo,
+				RBACConfig: tc.RBACConfig,
+			}
+			_, err = svc.QueryAuditLog(tc.Context, &api.QueryAuditLogRequest{})
+			if tc.ExpectedError != "" {
+				if err == nil {
+					t.Fatalf("expected error %q but got none", tc.ExpectedError)
+				}
+				if diff := cmp.Diff(tc.ExpectedError, err.Error()); diff != "" {
+					t.Errorf("error mismatch (-want, +got):\n%s", diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -18,28 +18,47 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/freiheit-com/kuberpult/pkg/grpc"
+	"github.com/freiheit-com/kuberpult/pkg/logger"
 	"github.com/freiheit-com/kuberpult/pkg/valid"
 
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 	"github.com/freiheit-com/kuberpult/pkg/auth"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/config"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type BatchServerConfig struct {
 	WriteCommitData bool
+	// Records every batch action, including failed ones, in the audit log of the manifest repository.
+	WriteAuditLog bool
+	// How long audit log entries are kept. 0 keeps all entries.
+	AuditLogRetention time.Duration
+	// How long the responses of requests with an idempotency key are kept. 0 disables idempotency keys.
+	IdempotencyWindow time.Duration
+	// How long finished jobs of SubmitBatch are kept. Defaults to one hour.
+	BatchJobRetention time.Duration
 }
 
 type BatchServer struct {
 	Repository repository.Repository
 	RBACConfig auth.RBACConfig
 	Config     BatchServerConfig
+	// The idempotency keys of the requests that are currently processed, see idempotencyScope.
+	idempotencyKeys sync.Map
+	jobs            batchJobs
 }
 
 // see maxBatchActions in store.tsx
@@ -177,11 +196,14 @@ func (d *BatchServer) processAction(
 			b = api.LockBehavior_IGNORE
 		}
 		return &repository.DeployApplicationVersion{
-			Environment:    act.Environment,
-			Application:    act.Application,
-			Version:        act.Version,
-			LockBehaviour:  b,
-			Authentication: repository.Authentication{RBACConfig: d.RBACConfig},
+			Environment:            act.Environment,
+			Application:            act.Application,
+			Version:                act.Version,
+			LockBehaviour:          b,
+			ExpectedCurrentVersion: act.ExpectedCurrentVersion,
+			Authentication:         repository.Authentication{RBACConfig: d.RBACConfig},
+			WriteCommitData:        d.Config.WriteCommitData,
+			Trigger:                api.DeploymentEvent_MANUAL,
 		}, nil, nil
 	case *api.BatchAction_DeleteEnvFromApp:
 		act := action.DeleteEnvFromApp
@@ -199,9 +221,10 @@ func (d *BatchServer) processAction(
 			return nil, nil, status.Error(codes.InvalidArgument, "invalid Team name")
 		}
 		return &repository.ReleaseTrain{
-				Target:         in.Target,
-				Team:           in.Team,
-				Authentication: repository.Authentication{RBACConfig: d.RBACConfig},
+				Target:          in.Target,
+				Team:            in.Team,
+				Authentication:  repository.Authentication{RBACConfig: d.RBACConfig},
+				WriteCommitData: d.Config.WriteCommitData,
 			}, &api.BatchResult{
 				Result: &api.BatchResult_ReleaseTrain{
 					ReleaseTrain: &api.ReleaseTrainResponse{Target: in.Target, Team: in.Team},
@@ -218,6 +241,7 @@ func (d *BatchServer) processAction(
 				SourceAuthor:    in.SourceAuthor,
 				SourceMessage:   in.SourceMessage,
 				SourceRepoUrl:   in.SourceRepoUrl,
+				SourcePaths:     in.SourcePaths,
 				Team:            in.Team,
 				DisplayVersion:  in.DisplayVersion,
 				Authentication:  repository.Authentication{RBACConfig: d.RBACConfig},
@@ -277,6 +301,12 @@ func (d *BatchServer) processAction(
 			LockId:           act.LockId,
 			Authentication:   repository.Authentication{RBACConfig: d.RBACConfig},
 		}, nil, nil
+	case *api.BatchAction_UpdateRbacPolicy:
+		act := action.UpdateRbacPolicy
+		return &repository.UpdateRbacPolicy{
+			Policy:         act.Policy,
+			Authentication: repository.Authentication{RBACConfig: d.RBACConfig},
+		}, nil, nil
 	}
 	return nil, nil, status.Error(codes.InvalidArgument, "processAction: cannot process action: invalid action type")
 }
@@ -290,44 +320,406 @@ func (d *BatchServer) ProcessBatch(
 		return nil, grpc.AuthError(ctx, errors.New(fmt.Sprintf("batch requires user to be provided %v", err)))
 	}
 	ctx = auth.WriteUserToContext(ctx, *user)
+	var response *api.BatchResponse
+	var batchErr error
+	if err := d.processIdempotentBatch(ctx, user, in, d.waitingApplier(), func(r *api.BatchResponse, err error) {
+		response, batchErr = r, err
+	}); err != nil {
+		return nil, err
+	}
+	return response, batchErr
+}
+
+// batchApplier applies the transformers of a batch and passes the result to done.
+// ProcessBatch waits for the result, SubmitBatch only adds the transformers to the repository queue.
+// The error is only returned if the transformers could not be added to the queue, done is not called then.
+type batchApplier struct {
+	apply              func(ctx context.Context, done func(error), transformers ...repository.Transformer) error
+	applyIndependently func(ctx context.Context, done func([]error, error), transformers ...repository.Transformer) error
+}
+
+func (d *BatchServer) waitingApplier() batchApplier {
+	return batchApplier{
+		apply: func(ctx context.Context, done func(error), transformers ...repository.Transformer) error {
+			done(d.Repository.Apply(ctx, transformers...))
+			return nil
+		},
+		applyIndependently: func(ctx context.Context, done func([]error, error), transformers ...repository.Transformer) error {
+			done(d.Repository.ApplyIndependently(ctx, transformers...))
+			return nil
+		},
+	}
+}
+
+func (d *BatchServer) queueingApplier() batchApplier {
+	return batchApplier{
+		apply:              d.Repository.ApplyAsync,
+		applyIndependently: d.Repository.ApplyIndependentlyAsync,
+	}
+}
+
+// processIdempotentBatch returns the stored response if the idempotency key of the request was already processed.
+// Like the batchApplier, it only returns the error if the batch could not be added to the queue.
+func (d *BatchServer) processIdempotentBatch(
+	ctx context.Context,
+	user *auth.User,
+	in *api.BatchRequest,
+	applier batchApplier,
+	done func(*api.BatchResponse, error),
+) error {
+	if in.IdempotencyKey == "" || d.Config.IdempotencyWindow == 0 {
+		return d.processBatch(ctx, user, in, applier, done)
+	}
+	scope := idempotencyScope(user, in.IdempotencyKey)
+	if _, inProgress := d.idempotencyKeys.LoadOrStore(scope, true); inProgress {
+		done(nil, status.Error(codes.Aborted, "a request with the same idempotency key is in progress"))
+		return nil
+	}
+	finish := func(response *api.BatchResponse, err error) {
+		d.idempotencyKeys.Delete(scope)
+		done(response, err)
+	}
+	requestHash, err := hashBatchRequest(in)
+	if err != nil {
+		finish(nil, grpc.InternalError(ctx, err))
+		return nil
+	}
+	record, err := d.Repository.State().GetIdempotencyRecord(user.Email, in.IdempotencyKey, time.Now().Add(-d.Config.IdempotencyWindow))
+	if err != nil {
+		finish(nil, grpc.InternalError(ctx, err))
+		return nil
+	}
+	if record != nil {
+		if record.RequestHash != requestHash {
+			finish(nil, status.Error(codes.InvalidArgument, "the idempotency key was already used for a different request"))
+			return nil
+		}
+		finish(record.Response, nil)
+		return nil
+	}
+	if err := d.processBatch(ctx, user, in, applier, finish); err != nil {
+		d.idempotencyKeys.Delete(scope)
+		return err
+	}
+	return nil
+}
+
+func (d *BatchServer) processBatch(
+	ctx context.Context,
+	user *auth.User,
+	in *api.BatchRequest,
+	applier batchApplier,
+	done func(*api.BatchResponse, error),
+) error {
+	auditLogEntries := d.newAuditLogEntries(user, in)
+	if in.Mode == api.BatchMode_INDEPENDENT {
+		return d.applyIndependentBatch(ctx, in, auditLogEntries, applier, func(results []*api.BatchResult, actionErrors []error, err error) {
+			d.writeFailedAuditLog(ctx, auditLogEntries, err, actionErrors)
+			if err != nil {
+				done(nil, err)
+				return
+			}
+			response := &api.BatchResponse{Results: results}
+			if d.keepsIdempotencyKey(in) {
+				// the actions are already pushed, so the record can only be written afterwards
+				record, err := d.idempotencyRecord(user, in, response)
+				if err == nil {
+					err = d.Repository.Apply(ctx, record)
+				}
+				if err != nil {
+					logger.FromContext(ctx).Error("idempotency.record", zap.Error(err))
+				}
+			}
+			done(response, nil)
+		})
+	}
+	return d.applyBatch(ctx, user, in, auditLogEntries, applier, func(results []*api.BatchResult, err error) {
+		d.writeFailedAuditLog(ctx, auditLogEntries, err, nil)
+		if err != nil {
+			switch createReleaseError := err.(type) {
+			case *repository.CreateReleaseError:
+				{
+					// in the atomic mode, nothing was applied, so the failed release is the only result.
+					// use the independent mode to get a result for each release creation.
+					errorResults := make([]*api.BatchResult, 1)
+					errorResults[0] = &api.BatchResult{
+						Result: &api.BatchResult_CreateReleaseResponse{
+							CreateReleaseResponse: createReleaseError.Response(),
+						},
+					}
+					done(&api.BatchResponse{Results: errorResults}, nil)
+				}
+			case *repository.LockedError:
+				done(nil, lockedErrorStatus(createReleaseError))
+			case *repository.ConcurrentModificationError:
+				done(nil, concurrentModificationStatus(createReleaseError))
+			default:
+				done(nil, err)
+			}
+			return
+		}
+		done(&api.BatchResponse{Results: results}, nil)
+	})
+}
+
+// lockedErrorStatus returns a FailedPrecondition error that contains the blocking locks as details.
+func lockedErrorStatus(lockedError *repository.LockedError) error {
+	st, err := status.New(codes.FailedPrecondition, lockedError.Error()).WithDetails(lockedErrorDetails(lockedError))
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, lockedError.Error())
+	}
+	return st.Err()
+}
+
+func lockedErrorDetails(lockedError *repository.LockedError) *api.LockedError {
+	details := &api.LockedError{
+		EnvironmentLocks:            map[string]*api.Lock{},
+		EnvironmentApplicationLocks: map[string]*api.Lock{},
+	}
+	for lockId, lock := range lockedError.EnvironmentLocks {
+		details.EnvironmentLocks[lockId] = lockToApi(lockId, lock)
+	}
+	for lockId, lock := range lockedError.EnvironmentApplicationLocks {
+		details.EnvironmentApplicationLocks[lockId] = lockToApi(lockId, lock)
+	}
+	return details
+}
+
+// concurrentModificationStatus returns an Aborted error that contains what changed as details.
+func concurrentModificationStatus(conflict *repository.ConcurrentModificationError) error {
+	st, err := status.New(codes.Aborted, conflict.Error()).WithDetails(concurrentModificationDetails(conflict))
+	if err != nil {
+		return status.Error(codes.Aborted, conflict.Error())
+	}
+	return st.Err()
+}
+
+func concurrentModificationDetails(conflict *repository.ConcurrentModificationError) *api.ConcurrentModificationError {
+	details := &api.ConcurrentModificationError{
+		Environment:         conflict.Environment,
+		Application:         conflict.Application,
+		ExpectedVersion:     0,
+		CurrentVersion:      0,
+		ExpectedGitRevision: conflict.ExpectedGitRevision,
+		CurrentGitRevision:  conflict.CurrentGitRevision,
+	}
+	if conflict.ExpectedVersion != nil {
+		details.ExpectedVersion = *conflict.ExpectedVersion
+	}
+	if conflict.CurrentVersion != nil {
+		details.CurrentVersion = *conflict.CurrentVersion
+	}
+	return details
+}
+
+func lockToApi(lockId string, lock repository.Lock) *api.Lock {
+	return &api.Lock{
+		Message:   lock.Message,
+		LockId:    lockId,
+		CreatedAt: timestamppb.New(lock.CreatedAt),
+		CreatedBy: &api.Actor{
+			Name:  lock.CreatedBy.Name,
+			Email: lock.CreatedBy.Email,
+		},
+	}
+}
+
+// applyBatch applies all actions in one commit, together with their audit log entries.
+func (d *BatchServer) applyBatch(
+	ctx context.Context,
+	user *auth.User,
+	in *api.BatchRequest,
+	auditLogEntries []*api.AuditLogEntry,
+	applier batchApplier,
+	done func([]*api.BatchResult, error),
+) error {
 	if len(in.GetActions()) > maxBatchActions {
-		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("cannot process batch: too many actions. limit is %d", maxBatchActions))
+		done(nil, status.Error(codes.InvalidArgument, fmt.Sprintf("cannot process batch: too many actions. limit is %d", maxBatchActions)))
+		return nil
 	}
 
 	results := make([]*api.BatchResult, len(in.GetActions()))
 	transformers := make([]repository.Transformer, 0, maxBatchActions)
+	if in.ExpectedGitRevision != "" {
+		transformers = append(transformers, &repository.ExpectGitRevision{Revision: in.ExpectedGitRevision})
+	}
 	for i, batchAction := range in.GetActions() {
 		transformer, result, err := d.processAction(batchAction)
 		if err != nil {
 			// Validation error
-			return nil, err
+			done(nil, err)
+			return nil
 		}
 		transformers = append(transformers, transformer)
 		results[i] = result
 	}
+	if d.keepsIdempotencyKey(in) {
+		// the record is part of the same commit, so it exists if and only if the actions were applied
+		record, err := d.idempotencyRecord(user, in, &api.BatchResponse{Results: results})
+		if err != nil {
+			done(nil, err)
+			return nil
+		}
+		transformers = append(transformers, record)
+	}
+	if len(auditLogEntries) > 0 {
+		transformers = append(transformers, &repository.WriteAuditLog{Entries: auditLogEntries, Retention: d.Config.AuditLogRetention})
+	}
+
+	return applier.apply(ctx, func(err error) {
+		if err != nil {
+			done(nil, err)
+			return
+		}
+		done(results, nil)
+	}, transformers...)
+}
+
+func (d *BatchServer) keepsIdempotencyKey(in *api.BatchRequest) bool {
+	return in.IdempotencyKey != "" && d.Config.IdempotencyWindow > 0
+}
+
+// idempotencyScope identifies an idempotency key, keys of different users are independent.
+func idempotencyScope(user *auth.User, key string) string {
+	return user.Email + "\x00" + key
+}
+
+func (d *BatchServer) idempotencyRecord(user *auth.User, in *api.BatchRequest, response *api.BatchResponse) (*repository.RecordIdempotencyKey, error) {
+	requestHash, err := hashBatchRequest(in)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.RecordIdempotencyKey{
+		Record: repository.IdempotencyRecord{
+			User:        user.Email,
+			Key:         in.IdempotencyKey,
+			RequestHash: requestHash,
+			CreatedAt:   time.Now(),
+			Response:    response,
+		},
+		Window: d.Config.IdempotencyWindow,
+	}, nil
+}
 
-	err = d.Repository.Apply(ctx, transformers...)
+// hashBatchRequest returns the hash of the actions and the mode of a request.
+func hashBatchRequest(in *api.BatchRequest) (string, error) {
+	content, err := proto.MarshalOptions{Deterministic: true}.Marshal(&api.BatchRequest{
+		Actions:        in.Actions,
+		Mode:           in.Mode,
+		IdempotencyKey: "",
+	})
 	if err != nil {
-		switch createReleaseError := err.(type) {
-		case *repository.CreateReleaseError:
-			{
-				// very hackerish way to handle create release errors for now
-				// if you really e.g. batch three release creations you wont know
-				// which failed and which succeeded.
-				// see SRX-OS3BVE to resolve this
-				errorResults := make([]*api.BatchResult, 1)
-				errorResults[0] = &api.BatchResult{
-					Result: &api.BatchResult_CreateReleaseResponse{
-						CreateReleaseResponse: createReleaseError.Response(),
-					},
-				}
-				return &api.BatchResponse{Results: errorResults}, nil
+		return "", fmt.Errorf("hashing batch request: %w", err)
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// applyIndependentBatch applies each action on its own, all in one push.
+// It returns one result for each action in the order of the actions, failed actions get an error result.
+// The error passed to done is only set if no action could be applied at all, e.g. because the push failed.
+func (d *BatchServer) applyIndependentBatch(
+	ctx context.Context,
+	in *api.BatchRequest,
+	auditLogEntries []*api.AuditLogEntry,
+	applier batchApplier,
+	done func([]*api.BatchResult, []error, error),
+) error {
+	if len(in.GetActions()) > maxBatchActions {
+		done(nil, nil, status.Error(codes.InvalidArgument, fmt.Sprintf("cannot process batch: too many actions. limit is %d", maxBatchActions)))
+		return nil
+	}
+	if in.ExpectedGitRevision != "" {
+		// every action is applied on top of the previous one, so only the first one could expect the revision
+		done(nil, nil, status.Error(codes.InvalidArgument, "cannot process batch: expected_git_revision is only supported in the atomic mode"))
+		return nil
+	}
+
+	results := make([]*api.BatchResult, len(in.GetActions()))
+	actionErrors := make([]error, len(in.GetActions()))
+	transformers := make([]repository.Transformer, 0, maxBatchActions)
+	// the index of the action of each transformer
+	actionIndexes := make([]int, 0, maxBatchActions)
+	for i, batchAction := range in.GetActions() {
+		transformer, result, err := d.processAction(batchAction)
+		if err != nil {
+			actionErrors[i] = err
+			continue
+		}
+		if result == nil {
+			result = &api.BatchResult{}
+		}
+		results[i] = result
+		if i < len(auditLogEntries) {
+			// the entry is only written if the action is applied
+			transformer = &repository.WithAuditLogEntry{Transformer: transformer, Entry: auditLogEntries[i], Retention: d.Config.AuditLogRetention}
+		}
+		transformers = append(transformers, transformer)
+		actionIndexes = append(actionIndexes, i)
+	}
+
+	finish := func() {
+		for i, err := range actionErrors {
+			if err != nil {
+				results[i] = actionErrorResult(err)
 			}
-		default:
-			return nil, err
 		}
+		done(results, actionErrors, nil)
+	}
+	if len(transformers) == 0 {
+		finish()
+		return nil
+	}
+	return applier.applyIndependently(ctx, func(transformerErrors []error, err error) {
+		if err != nil {
+			done(nil, nil, err)
+			return
+		}
+		for j, err := range transformerErrors {
+			actionErrors[actionIndexes[j]] = err
+		}
+		finish()
+	}, transformers...)
+}
+
+// actionErrorResult describes why an action of an independent batch failed.
+func actionErrorResult(err error) *api.BatchResult {
+	var createReleaseError *repository.CreateReleaseError
+	if errors.As(err, &createReleaseError) {
+		return &api.BatchResult{
+			Result: &api.BatchResult_CreateReleaseResponse{
+				CreateReleaseResponse: createReleaseError.Response(),
+			},
+		}
+	}
+	actionError := &api.BatchActionError{
+		Kind:     api.BatchActionError_FAILED,
+		Message:  err.Error(),
+		Locked:   nil,
+		Conflict: nil,
+	}
+	var lockedError *repository.LockedError
+	var conflict *repository.ConcurrentModificationError
+	if errors.As(err, &lockedError) {
+		actionError.Kind = api.BatchActionError_LOCKED
+		actionError.Locked = lockedErrorDetails(lockedError)
+	} else if errors.As(err, &conflict) {
+		actionError.Kind = api.BatchActionError_CONFLICT
+		actionError.Conflict = concurrentModificationDetails(conflict)
+	} else if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.InvalidArgument:
+			actionError.Kind = api.BatchActionError_VALIDATION
+		case codes.PermissionDenied:
+			actionError.Kind = api.BatchActionError_PERMISSION_DENIED
+		}
+		actionError.Message = st.Message()
+	}
+	return &api.BatchResult{
+		Result: &api.BatchResult_Error{
+			Error: actionError,
+		},
 	}
-	return &api.BatchResponse{Results: results}, nil
 }
 
 var _ api.BatchServiceServer = (*BatchServer)(nil)
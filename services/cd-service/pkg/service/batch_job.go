@@ -0,0 +1,290 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/pkg/grpc"
+	"github.com/freiheit-com/kuberpult/pkg/uuid"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/notify"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defaultBatchJobRetention = time.Hour
+
+// batchJobs keeps the state of the batches that were submitted with SubmitBatch.
+// Finished jobs are removed when they expire.
+type batchJobs struct {
+	mx   sync.Mutex
+	jobs map[string]*batchJob
+}
+
+type batchJob struct {
+	// Only the user that submitted the job can read it.
+	userEmail string
+	// The current state, it is replaced on every update and never modified.
+	state      *api.BatchJob
+	finishedAt time.Time
+	notify     notify.Notify
+}
+
+func (j *batchJob) finished() bool {
+	return !j.finishedAt.IsZero()
+}
+
+func (b *batchJobs) add(userEmail string, now time.Time, retention time.Duration) string {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.jobs == nil {
+		b.jobs = map[string]*batchJob{}
+	}
+	b.removeExpired(now, retention)
+	id := uuid.RealUUIDGenerator{}.Generate()
+	b.jobs[id] = &batchJob{
+		userEmail: userEmail,
+		state: &api.BatchJob{
+			JobId:     id,
+			State:     api.BatchJobState_BATCH_JOB_QUEUED,
+			CreatedAt: timestamppb.New(now),
+			UpdatedAt: timestamppb.New(now),
+			Response:  nil,
+			Error:     nil,
+		},
+		finishedAt: time.Time{},
+		notify:     notify.Notify{},
+	}
+	return id
+}
+
+// update changes the state of a job. The state only moves forward, e.g. a job that is pushing is not applying again.
+func (b *batchJobs) update(id string, now time.Time, update func(state *api.BatchJob)) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	job, ok := b.jobs[id]
+	if !ok || job.finished() {
+		return
+	}
+	state := proto.Clone(job.state).(*api.BatchJob)
+	update(state)
+	if state.State < job.state.State {
+		return
+	}
+	state.UpdatedAt = timestamppb.New(now)
+	job.state = state
+	if state.State == api.BatchJobState_BATCH_JOB_SUCCEEDED || state.State == api.BatchJobState_BATCH_JOB_FAILED {
+		job.finishedAt = now
+	}
+	job.notify.Notify()
+}
+
+// get returns the job of the user, nil if it does not exist.
+func (b *batchJobs) get(id string, userEmail string, now time.Time, retention time.Duration) *batchJob {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.removeExpired(now, retention)
+	job, ok := b.jobs[id]
+	if !ok || job.userEmail != userEmail {
+		return nil
+	}
+	return job
+}
+
+// read returns the current state of a job.
+func (b *batchJobs) read(job *batchJob) *api.BatchJob {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return job.state
+}
+
+// remove removes a job that could not be added to the repository queue.
+func (b *batchJobs) remove(id string) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	delete(b.jobs, id)
+}
+
+func (b *batchJobs) removeExpired(now time.Time, retention time.Duration) {
+	for id, job := range b.jobs {
+		if job.finished() && now.Sub(job.finishedAt) > retention {
+			delete(b.jobs, id)
+		}
+	}
+}
+
+func (d *BatchServer) batchJobRetention() time.Duration {
+	if d.Config.BatchJobRetention == 0 {
+		return defaultBatchJobRetention
+	}
+	return d.Config.BatchJobRetention
+}
+
+func (d *BatchServer) SubmitBatch(
+	ctx context.Context,
+	in *api.BatchRequest,
+) (*api.SubmitBatchResponse, error) {
+	user, err := auth.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, grpc.AuthError(ctx, errors.New(fmt.Sprintf("batch requires user to be provided %v", err)))
+	}
+	if len(in.GetActions()) > maxBatchActions {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("cannot process batch: too many actions. limit is %d", maxBatchActions))
+	}
+	id := d.jobs.add(user.Email, time.Now(), d.batchJobRetention())
+	// the job outlives the request, so it must not be canceled with it
+	jobCtx := auth.WriteUserToContext(detachedContext{parent: ctx}, *user)
+	jobCtx = repository.WithProgress(jobCtx, func(stage repository.Stage) {
+		d.jobs.update(id, time.Now(), func(state *api.BatchJob) {
+			switch stage {
+			case repository.StageApplying:
+				state.State = api.BatchJobState_BATCH_JOB_APPLYING
+			case repository.StagePushing:
+				state.State = api.BatchJobState_BATCH_JOB_PUSHING
+			}
+		})
+	})
+	// the batch is added to the repository queue right away, so a full queue rejects the job
+	err = d.processIdempotentBatch(jobCtx, user, in, d.queueingApplier(), func(response *api.BatchResponse, err error) {
+		d.jobs.update(id, time.Now(), func(state *api.BatchJob) {
+			if err != nil {
+				state.State = api.BatchJobState_BATCH_JOB_FAILED
+				state.Error = batchJobError(err)
+			} else {
+				state.State = api.BatchJobState_BATCH_JOB_SUCCEEDED
+				state.Response = response
+			}
+		})
+	})
+	if err != nil {
+		d.jobs.remove(id)
+		return nil, err
+	}
+	return &api.SubmitBatchResponse{JobId: id}, nil
+}
+
+// detachedContext keeps the values of its parent, e.g. for tracing, but is neither canceled nor has a deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// batchJobError converts the error that ProcessBatch would return.
+func batchJobError(err error) *api.BatchJobError {
+	var lockedError *repository.LockedError
+	var conflict *repository.ConcurrentModificationError
+	if errors.As(err, &lockedError) {
+		err = lockedErrorStatus(lockedError)
+	} else if errors.As(err, &conflict) {
+		err = concurrentModificationStatus(conflict)
+	}
+	st := status.Convert(err)
+	jobError := &api.BatchJobError{
+		Code:     int32(st.Code()),
+		Message:  st.Message(),
+		Locked:   nil,
+		Conflict: nil,
+	}
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *api.LockedError:
+			jobError.Locked = detail
+		case *api.ConcurrentModificationError:
+			jobError.Conflict = detail
+		}
+	}
+	return jobError
+}
+
+func (d *BatchServer) GetBatchJob(
+	ctx context.Context,
+	in *api.GetBatchJobRequest,
+) (*api.BatchJob, error) {
+	job, err := d.getBatchJob(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return d.jobs.read(job), nil
+}
+
+func (d *BatchServer) WatchBatchJob(
+	in *api.GetBatchJobRequest,
+	stream api.BatchService_WatchBatchJobServer,
+) error {
+	ctx := stream.Context()
+	job, err := d.getBatchJob(ctx, in)
+	if err != nil {
+		return err
+	}
+	ch, unsubscribe := job.notify.Subscribe()
+	defer unsubscribe()
+	var last *api.BatchJob
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ch:
+		}
+		state := d.jobs.read(job)
+		if state == last {
+			continue
+		}
+		if err := stream.Send(state); err != nil {
+			return err
+		}
+		if state.State == api.BatchJobState_BATCH_JOB_SUCCEEDED || state.State == api.BatchJobState_BATCH_JOB_FAILED {
+			return nil
+		}
+		last = state
+	}
+}
+
+func (d *BatchServer) getBatchJob(ctx context.Context, in *api.GetBatchJobRequest) (*batchJob, error) {
+	user, err := auth.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, grpc.AuthError(ctx, errors.New(fmt.Sprintf("batch job requires user to be provided %v", err)))
+	}
+	job := d.jobs.get(in.JobId, user.Email, time.Now(), d.batchJobRetention())
+	if job == nil {
+		return nil, status.Errorf(codes.NotFound, "batch job %s not found, finished jobs are kept for %s", in.JobId, d.batchJobRetention())
+	}
+	return job, nil
+}
@@ -0,0 +1,252 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/config"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository/testrepository"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository/testutil"
+)
+
+type watchBatchJobStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*api.BatchJob
+}
+
+func (w *watchBatchJobStream) Context() context.Context {
+	return w.ctx
+}
+
+func (w *watchBatchJobStream) Send(job *api.BatchJob) error {
+	w.sent = append(w.sent, job)
+	return nil
+}
+
+func TestSubmitBatch(t *testing.T) {
+	tcs := []struct {
+		Name     string
+		Batch    []*api.BatchAction
+		Expected string
+	}{
+		{
+			Name: "job succeeds",
+			Batch: []*api.BatchAction{
+				{
+					Action: &api.BatchAction_ReleaseTrain{
+						ReleaseTrain: &api.ReleaseTrainRequest{Target: "production"},
+					},
+				},
+			},
+			Expected: `state:BATCH_JOB_SUCCEEDED response:{results:{release_train:{target:"production"}}}`,
+		},
+		{
+			Name: "job fails because of a lock",
+			Batch: []*api.BatchAction{
+				{
+					Action: &api.BatchAction_Deploy{
+						Deploy: &api.DeployRequest{
+							Environment:  "production",
+							Application:  "myapp",
+							Version:      1,
+							LockBehavior: api.LockBehavior_FAIL,
+						},
+					},
+				},
+			},
+			Expected: `state:BATCH_JOB_FAILED error:{code:9 message:"locked" locked:{environment_locks:{key:"maintenance" value:{message:"database upgrade" lock_id:"maintenance"}}}}`,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			var expected api.BatchJob
+			if err := prototext.Unmarshal([]byte(tc.Expected), &expected); err != nil {
+				t.Fatalf("failed to unmarshal the expected job: %v", err)
+			}
+			repo, err := setupRepositoryTest(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setup := []repository.Transformer{
+				&repository.CreateEnvironment{
+					Environment: "production",
+					Config:      config.EnvironmentConfig{Upstream: &config.EnvironmentConfigUpstream{Latest: true}},
+				},
+				&repository.CreateApplicationVersion{
+					Application: "myapp",
+					Manifests: map[string]string{
+						"production": "manifest",
+					},
+				},
+				&repository.CreateEnvironmentLock{
+					Environment: "production",
+					LockId:      "maintenance",
+					Message:     "database upgrade",
+				},
+			}
+			for _, tr := range setup {
+				if err := repo.Apply(testutil.MakeTestContext(), tr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			svc := &BatchServer{
+				Repository: repo,
+			}
+			// the job must not be canceled with the request that submitted it
+			ctx, cancel := context.WithCancel(testutil.MakeTestContext())
+			submitted, err := svc.SubmitBatch(ctx, &api.BatchRequest{Actions: tc.Batch})
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream := &watchBatchJobStream{ctx: testutil.MakeTestContext()}
+			if err := svc.WatchBatchJob(&api.GetBatchJobRequest{JobId: submitted.JobId}, stream); err != nil {
+				t.Fatal(err)
+			}
+			last := stream.sent[len(stream.sent)-1]
+			if d := cmp.Diff(&expected, last, protocmp.Transform(),
+				protocmp.IgnoreFields(&api.BatchJob{}, "job_id", "created_at", "updated_at"),
+				protocmp.IgnoreFields(&api.Lock{}, "created_at", "created_by")); d != "" {
+				t.Errorf("job mismatch: %s", d)
+			}
+			for i := 1; i < len(stream.sent); i++ {
+				if stream.sent[i].State < stream.sent[i-1].State {
+					t.Errorf("job state went back from %s to %s", stream.sent[i-1].State, stream.sent[i].State)
+				}
+			}
+			// finished jobs are kept until they expire
+			again, err := svc.GetBatchJob(testutil.MakeTestContext(), &api.GetBatchJobRequest{JobId: submitted.JobId})
+			if err != nil {
+				t.Fatalf("expected the job to be read again, got %v", err)
+			}
+			if d := cmp.Diff(last, again, protocmp.Transform()); d != "" {
+				t.Errorf("job mismatch when read again: %s", d)
+			}
+		})
+	}
+}
+
+func TestSubmitBatchQueueFull(t *testing.T) {
+	svc := &BatchServer{
+		Repository: testrepository.Failing(status.Error(codes.ResourceExhausted, "the repository queue is full")),
+	}
+	_, err := svc.SubmitBatch(testutil.MakeTestContext(), &api.BatchRequest{
+		Actions: []*api.BatchAction{
+			{
+				Action: &api.BatchAction_ReleaseTrain{
+					ReleaseTrain: &api.ReleaseTrainRequest{Target: "production"},
+				},
+			},
+		},
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected the job to be rejected with ResourceExhausted, got %v", err)
+	}
+	if len(svc.jobs.jobs) != 0 {
+		t.Errorf("expected the rejected job to be removed, got %d jobs", len(svc.jobs.jobs))
+	}
+}
+
+func TestBatchJobs(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tcs := []struct {
+		Name          string
+		Finish        bool
+		ReadBy        string
+		ReadAt        time.Time
+		ExpectedFound bool
+	}{
+		{
+			Name:          "unfinished jobs do not expire",
+			Finish:        false,
+			ReadBy:        "alice@example.com",
+			ReadAt:        start.Add(24 * time.Hour),
+			ExpectedFound: true,
+		},
+		{
+			Name:          "finished jobs are kept for the retention",
+			Finish:        true,
+			ReadBy:        "alice@example.com",
+			ReadAt:        start.Add(time.Hour),
+			ExpectedFound: true,
+		},
+		{
+			Name:          "finished jobs expire after the retention",
+			Finish:        true,
+			ReadBy:        "alice@example.com",
+			ReadAt:        start.Add(time.Hour + time.Second),
+			ExpectedFound: false,
+		},
+		{
+			Name:          "other users cannot read the job",
+			Finish:        false,
+			ReadBy:        "bob@example.com",
+			ReadAt:        start,
+			ExpectedFound: false,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			var jobs batchJobs
+			id := jobs.add("alice@example.com", start, time.Hour)
+			if tc.Finish {
+				jobs.update(id, start, func(state *api.BatchJob) {
+					state.State = api.BatchJobState_BATCH_JOB_SUCCEEDED
+				})
+			}
+			job := jobs.get(id, tc.ReadBy, tc.ReadAt, time.Hour)
+			if found := job != nil; found != tc.ExpectedFound {
+				t.Errorf("expected found to be %t, got %t", tc.ExpectedFound, found)
+			}
+		})
+	}
+}
+
+func TestBatchJobsStateOnlyMovesForward(t *testing.T) {
+	ctx := auth.WriteUserToContext(context.Background(), auth.User{Email: "alice@example.com"})
+	svc := &BatchServer{}
+	id := svc.jobs.add("alice@example.com", time.Now(), time.Hour)
+	svc.jobs.update(id, time.Now(), func(state *api.BatchJob) {
+		state.State = api.BatchJobState_BATCH_JOB_PUSHING
+	})
+	svc.jobs.update(id, time.Now(), func(state *api.BatchJob) {
+		state.State = api.BatchJobState_BATCH_JOB_APPLYING
+	})
+	job, err := svc.GetBatchJob(ctx, &api.GetBatchJobRequest{JobId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != api.BatchJobState_BATCH_JOB_PUSHING {
+		t.Errorf("expected state %s, got %s", api.BatchJobState_BATCH_JOB_PUSHING, job.State)
+	}
+}
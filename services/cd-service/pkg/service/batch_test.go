@@ -23,10 +23,13 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
@@ -345,6 +348,8 @@ func TestBatchServiceErrors(t *testing.T) {
 		Setup            []repository.Transformer
 		ExpectedResponse string
 		ExpectedError    string
+		// The LockedError in the error details, ignoring who created the locks and when
+		ExpectedLockDetails string
 	}{
 		{
 			// tests that in ProcessBatch, transformer errors are returned without wrapping them in a
@@ -386,6 +391,69 @@ func TestBatchServiceErrors(t *testing.T) {
 				}},
 			ExpectedResponse: `results:{create_release_response:{too_long:{app_name:"myappIsWayTooLongDontYouThink" reg_exp:"\\A[a-z0-9]+(?:-[a-z0-9]+)*\\z" max_len:39}}}`,
 		},
+		{
+			Name: "deployment fails because of an environment lock",
+			Setup: []repository.Transformer{
+				&repository.CreateEnvironment{
+					Environment: "production",
+					Config:      config.EnvironmentConfig{Upstream: &config.EnvironmentConfigUpstream{Latest: true}},
+				},
+				&repository.CreateApplicationVersion{
+					Application: "myapp",
+					Manifests: map[string]string{
+						"production": "manifest",
+					},
+				},
+				&repository.CreateEnvironmentLock{
+					Environment: "production",
+					LockId:      "maintenance",
+					Message:     "database upgrade",
+				},
+			},
+			Batch: []*api.BatchAction{
+				{
+					Action: &api.BatchAction_Deploy{
+						Deploy: &api.DeployRequest{
+							Environment:  "production",
+							Application:  "myapp",
+							Version:      1,
+							LockBehavior: api.LockBehavior_FAIL,
+						},
+					},
+				}},
+			ExpectedResponse:    "",
+			ExpectedError:       "rpc error: code = FailedPrecondition desc = locked",
+			ExpectedLockDetails: `environment_locks:{key:"maintenance" value:{message:"database upgrade" lock_id:"maintenance"}}`,
+		},
+		{
+			Name: "deployment fails because another version was deployed in the meantime",
+			Setup: []repository.Transformer{
+				&repository.CreateEnvironment{
+					Environment: "production",
+					Config:      config.EnvironmentConfig{Upstream: &config.EnvironmentConfigUpstream{Latest: true}},
+				},
+				&repository.CreateApplicationVersion{
+					Application: "myapp",
+					Manifests: map[string]string{
+						"production": "manifest",
+					},
+				},
+			},
+			Batch: []*api.BatchAction{
+				{
+					Action: &api.BatchAction_Deploy{
+						Deploy: &api.DeployRequest{
+							Environment:            "production",
+							Application:            "myapp",
+							Version:                1,
+							LockBehavior:           api.LockBehavior_FAIL,
+							ExpectedCurrentVersion: ptr.Uint64(0),
+						},
+					},
+				}},
+			ExpectedResponse: "",
+			ExpectedError:    "rpc error: code = Aborted desc = conflict: expected version none of myapp on production, but version 1 is deployed",
+		},
 	}
 	for _, tc := range tcs {
 		tc := tc
@@ -418,6 +486,276 @@ func TestBatchServiceErrors(t *testing.T) {
 			if tc.ExpectedResponse == "" && tc.ExpectedError != processErr.Error() {
 				t.Fatalf("expected error\n%s\ngot:\n%s\n%s", tc.ExpectedError, response.String(), processErr)
 			}
+			if tc.ExpectedLockDetails != "" {
+				var expectedDetails api.LockedError
+				if err := prototext.Unmarshal([]byte(tc.ExpectedLockDetails), &expectedDetails); err != nil {
+					t.Fatalf("failed to unmarshal the expected lock details: %v", err)
+				}
+				details := status.Convert(processErr).Details()
+				if len(details) != 1 {
+					t.Fatalf("expected exactly one error detail, got %v", details)
+				}
+				if d := cmp.Diff(&expectedDetails, details[0], protocmp.Transform(), protocmp.IgnoreFields(&api.Lock{}, "created_at", "created_by")); d != "" {
+					t.Errorf("lock details mismatch: %s", d)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchServiceIndependent(t *testing.T) {
+	setup := []repository.Transformer{
+		&repository.CreateEnvironment{
+			Environment: "production",
+			Config:      config.EnvironmentConfig{Upstream: &config.EnvironmentConfigUpstream{Latest: true}},
+		},
+		&repository.CreateApplicationVersion{
+			Application: "myapp",
+			Manifests: map[string]string{
+				"production": "manifest",
+			},
+		},
+		&repository.CreateEnvironmentLock{
+			Environment: "production",
+			LockId:      "maintenance",
+			Message:     "database upgrade",
+		},
+	}
+	tcs := []struct {
+		Name             string
+		Batch            []*api.BatchAction
+		ExpectedResponse string
+		ExpectedLocks    []string
+	}{
+		{
+			Name: "failed actions do not prevent the others",
+			Batch: []*api.BatchAction{
+				{
+					Action: &api.BatchAction_CreateEnvironmentApplicationLock{
+						CreateEnvironmentApplicationLock: &api.CreateEnvironmentApplicationLockRequest{
+							Environment: "production",
+							Application: "myapp",
+							LockId:      "first",
+						},
+					},
+				},
+				{
+					Action: &api.BatchAction_CreateEnvironmentApplicationLock{
+						CreateEnvironmentApplicationLock: &api.CreateEnvironmentApplicationLockRequest{
+							Environment: "production",
+							Application: "myapp",
+							LockId:      "invalid/lock",
+						},
+					},
+				},
+				{
+					Action: &api.BatchAction_Deploy{
+						Deploy: &api.DeployRequest{
+							Environment:  "production",
+							Application:  "myapp",
+							Version:      1,
+							LockBehavior: api.LockBehavior_FAIL,
+						},
+					},
+				},
+				{
+					Action: &api.BatchAction_CreateEnvironmentApplicationLock{
+						CreateEnvironmentApplicationLock: &api.CreateEnvironmentApplicationLockRequest{
+							Environment: "production",
+							Application: "myapp",
+							LockId:      "second",
+						},
+					},
+				},
+			},
+			ExpectedResponse: `
+results:{}
+results:{error:{kind:VALIDATION message:"cannot create environment application lock: invalid lock id: 'invalid/lock'"}}
+results:{error:{kind:LOCKED message:"locked" locked:{environment_locks:{key:"maintenance" value:{message:"database upgrade" lock_id:"maintenance"}}}}}
+results:{}`,
+			ExpectedLocks: []string{"first", "second"},
+		},
+		{
+			Name: "all actions fail",
+			Batch: []*api.BatchAction{
+				{
+					Action: &api.BatchAction_Deploy{
+						Deploy: &api.DeployRequest{
+							Environment:  "production",
+							Application:  "myapp",
+							Version:      2,
+							LockBehavior: api.LockBehavior_IGNORE,
+						},
+					},
+				},
+			},
+			ExpectedResponse: `
+results:{error:{kind:FAILED message:"deployment failed: could not open manifest for app myapp with release 2 on env production 'applications/myapp/releases/2/environments/production/manifests.yaml': file does not exist"}}`,
+			ExpectedLocks: []string{},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			var expectedResponse api.BatchResponse
+			if err := prototext.Unmarshal([]byte(tc.ExpectedResponse), &expectedResponse); err != nil {
+				t.Fatalf("failed to unmarshal the expected response: %v", err)
+			}
+			repo, err := setupRepositoryTest(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tr := range setup {
+				if err := repo.Apply(testutil.MakeTestContext(), tr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			svc := &BatchServer{
+				Repository: repo,
+			}
+			response, err := svc.ProcessBatch(
+				testutil.MakeTestContext(),
+				&api.BatchRequest{
+					Actions: tc.Batch,
+					Mode:    api.BatchMode_INDEPENDENT,
+				},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(&expectedResponse, response, protocmp.Transform(), protocmp.IgnoreFields(&api.Lock{}, "created_at", "created_by")); d != "" {
+				t.Errorf("response mismatch: %s", d)
+			}
+			locks, err := repo.State().GetEnvironmentApplicationLocks("production", "myapp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			lockIds := []string{}
+			for lockId := range locks {
+				lockIds = append(lockIds, lockId)
+			}
+			sort.Strings(lockIds)
+			if d := cmp.Diff(tc.ExpectedLocks, lockIds); d != "" {
+				t.Errorf("locks mismatch: %s", d)
+			}
+		})
+	}
+}
+
+func TestBatchServiceIdempotencyKey(t *testing.T) {
+	lockAction := func(message string) []*api.BatchAction {
+		return []*api.BatchAction{
+			{
+				Action: &api.BatchAction_CreateEnvironmentLock{
+					CreateEnvironmentLock: &api.CreateEnvironmentLockRequest{
+						Environment: "production",
+						LockId:      "maintenance",
+						Message:     message,
+					},
+				},
+			},
+		}
+	}
+	tcs := []struct {
+		Name   string
+		Window time.Duration
+		First  *api.BatchRequest
+		Second *api.BatchRequest
+		// The email of the user who sends the second request, if it's not the user of the first one
+		SecondUser    string
+		ExpectedError string
+		// Whether the second request creates a new commit
+		ExpectApplied bool
+	}{
+		{
+			Name:          "repeated request is not applied again",
+			Window:        time.Hour,
+			First:         &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-123"},
+			Second:        &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-123"},
+			ExpectApplied: false,
+		},
+		{
+			Name:          "repeated independent request is not applied again",
+			Window:        time.Hour,
+			First:         &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-123", Mode: api.BatchMode_INDEPENDENT},
+			Second:        &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-123", Mode: api.BatchMode_INDEPENDENT},
+			ExpectApplied: false,
+		},
+		{
+			Name:          "different keys are applied",
+			Window:        time.Hour,
+			First:         &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-123"},
+			Second:        &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-124"},
+			ExpectApplied: true,
+		},
+		{
+			Name:          "the same key of another user is applied",
+			Window:        time.Hour,
+			First:         &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-123"},
+			Second:        &api.BatchRequest{Actions: lockAction("other"), IdempotencyKey: "ci-123"},
+			SecondUser:    "other@example.com",
+			ExpectApplied: true,
+		},
+		{
+			Name:          "expired keys are applied",
+			Window:        time.Nanosecond,
+			First:         &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-123"},
+			Second:        &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-123"},
+			ExpectApplied: true,
+		},
+		{
+			Name:          "key reused for a different request",
+			Window:        time.Hour,
+			First:         &api.BatchRequest{Actions: lockAction("upgrade"), IdempotencyKey: "ci-123"},
+			Second:        &api.BatchRequest{Actions: lockAction("other"), IdempotencyKey: "ci-123"},
+			ExpectedError: "rpc error: code = InvalidArgument desc = the idempotency key was already used for a different request",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			repo, err := setupRepositoryTest(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.Apply(testutil.MakeTestContext(), &repository.CreateEnvironment{Environment: "production"}); err != nil {
+				t.Fatal(err)
+			}
+			svc := &BatchServer{
+				Repository: repo,
+				Config:     BatchServerConfig{IdempotencyWindow: tc.Window},
+			}
+			first, err := svc.ProcessBatch(testutil.MakeTestContext(), tc.First)
+			if err != nil {
+				t.Fatal(err)
+			}
+			commitBefore := repo.State().Commit.Id().String()
+			secondCtx := testutil.MakeTestContext()
+			if tc.SecondUser != "" {
+				secondCtx = metadata.NewIncomingContext(secondCtx, metadata.New(map[string]string{
+					auth.HeaderUserEmail: auth.Encode64(tc.SecondUser),
+					auth.HeaderUserName:  auth.Encode64("other"),
+				}))
+			}
+			second, err := svc.ProcessBatch(secondCtx, tc.Second)
+			if tc.ExpectedError != "" {
+				if err == nil || err.Error() != tc.ExpectedError {
+					t.Fatalf("expected error %q, got %v", tc.ExpectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.SecondUser == "" {
+				if d := cmp.Diff(first, second, protocmp.Transform()); d != "" {
+					t.Errorf("response mismatch: %s", d)
+				}
+			}
+			applied := repo.State().Commit.Id().String() != commitBefore
+			if applied != tc.ExpectApplied {
+				t.Errorf("expected applied to be %t, got %t", tc.ExpectApplied, applied)
+			}
 		})
 	}
 }
@@ -21,9 +21,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 	grpcErrors "github.com/freiheit-com/kuberpult/pkg/grpc"
@@ -34,14 +36,34 @@ import (
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
 	billy "github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/util"
+	git "github.com/libgit2/git2go/v34"
 	"github.com/onokonem/sillyQueueServer/timeuuid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type GitServer struct {
 	Config          repository.RepositoryConfig
 	OverviewService *OverviewServiceServer
+	// if set, changelogs are computed from the local mirrors of the source repositories
+	SourceMirror *repository.SourceMirror
+
+	// the source commits of all releases, read once per commit of the repository
+	commitReleasesMx sync.Mutex
+	commitReleases   *commitReleasesIndex
+}
+
+type commitRelease struct {
+	Version        uint64
+	SourceCommitId string
+}
+
+type commitReleasesIndex struct {
+	// the commit of the repository the index was read from
+	stateCommit string
+	// the releases of each app with a valid source commit id, sorted by version
+	apps map[string][]commitRelease
 }
 
 func (s *GitServer) GetGitTags(ctx context.Context, in *api.GetGitTagsRequest) (*api.GetGitTagsResponse, error) {
@@ -178,6 +200,418 @@ func (s *GitServer) GetCommitInfo(ctx context.Context, in *api.GetCommitInfoRequ
 	}, nil
 }
 
+func (s *GitServer) FindCommitDeployments(ctx context.Context, in *api.FindCommitDeploymentsRequest) (*api.FindCommitDeploymentsResponse, error) {
+	if !valid.SHA1CommitIDPrefix(in.CommitHash) {
+		return nil, status.Error(codes.InvalidArgument, "not a valid commit_hash")
+	}
+	if len(in.CommitHash) < 7 {
+		return nil, status.Error(codes.InvalidArgument,
+			"commit_hash too short (must be at least 7 characters)")
+	}
+	state := s.OverviewService.Repository.State()
+	commitPrefix := strings.ToLower(in.CommitHash)
+
+	// The commit data tells us which apps were built from the commit. Without it,
+	// for example if the commit data was not written or only a prefix was given,
+	// we have to look at the releases of all apps.
+	var apps []string
+	if len(commitPrefix) == valid.SHA1CommitIDLength {
+		commitApps, err := names(state.Filesystem, state.Filesystem.Join("commits", commitPrefix[:2], commitPrefix[2:], "applications"))
+		if err != nil {
+			return nil, err
+		}
+		apps = commitApps
+	}
+	var index map[string][]commitRelease
+	if len(apps) == 0 {
+		allApps, err := state.GetApplications()
+		if err != nil {
+			return nil, fmt.Errorf("could not read the applications: %w", err)
+		}
+		apps = allApps
+		// reading the releases of all apps is expensive, so it's only done once per commit
+		index, err = s.commitReleasesIndex(state, apps)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	commitID := ""
+	releases := map[string]uint64{}
+	for _, app := range apps {
+		var release *commitRelease
+		var err error
+		if index != nil {
+			release, err = firstCommitRelease(index[app], commitPrefix)
+		} else {
+			release, err = findCommitRelease(state, app, commitPrefix)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if release == nil {
+			continue
+		}
+		if commitID != "" && commitID != release.SourceCommitId {
+			return nil, status.Error(codes.InvalidArgument,
+				"commit_hash is not unique, provide the complete hash (or a longer prefix)")
+		}
+		commitID = release.SourceCommitId
+		releases[app] = release.Version
+	}
+	if commitID == "" {
+		return nil, grpcErrors.NotFoundError(ctx,
+			fmt.Errorf("no release was built from commit %s", in.CommitHash))
+	}
+
+	envConfigs, err := state.GetEnvironmentConfigs()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the environment configs: %w", err)
+	}
+	envs := make([]string, 0, len(envConfigs))
+	for env := range envConfigs {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+
+	result := &api.FindCommitDeploymentsResponse{
+		CommitHash:   commitID,
+		Applications: make([]*api.CommitDeploymentsOfApp, 0, len(releases)),
+	}
+	for _, app := range apps {
+		releaseVersion, ok := releases[app]
+		if !ok {
+			continue
+		}
+		appResult := &api.CommitDeploymentsOfApp{
+			Application:    app,
+			ReleaseVersion: releaseVersion,
+			Environments:   make([]*api.CommitDeploymentInEnvironment, 0, len(envs)),
+		}
+		for _, env := range envs {
+			envResult, err := s.commitDeploymentInEnvironment(ctx, state, env, app, releaseVersion)
+			if err != nil {
+				return nil, err
+			}
+			appResult.Environments = append(appResult.Environments, envResult)
+		}
+		result.Applications = append(result.Applications, appResult)
+	}
+	return result, nil
+}
+
+func (s *GitServer) GetChangelog(ctx context.Context, in *api.GetChangelogRequest) (*api.GetChangelogResponse, error) {
+	if s.SourceMirror == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the source repository mirror is not enabled; set KUBERPULT_SOURCE_REPO_MIRROR_PATH to enable")
+	}
+	state := s.OverviewService.Repository.State()
+	url, err := state.GetApplicationSourceRepoUrl(in.Application)
+	if err != nil {
+		return nil, err
+	}
+	if url == "" {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("application %s has no source repository url", in.Application))
+	}
+	if err := s.SourceMirror.CheckUrl(url); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	version := in.Version
+	if version == 0 {
+		version, err = releaseTrainVersion(state, in.Environment, in.Application)
+		if err != nil {
+			return nil, err
+		}
+	}
+	release, err := state.GetApplicationRelease(in.Application, version)
+	if err != nil {
+		return nil, grpcErrors.NotFoundError(ctx, fmt.Errorf("release %d of application %s was not found: %w", version, in.Application, err))
+	}
+	if !valid.SHA1CommitID(release.SourceCommitId) {
+		return nil, status.Error(codes.FailedPrecondition, fmt.Sprintf("release %d of application %s has no source commit id", version, in.Application))
+	}
+	deployedVersion, err := state.GetEnvironmentApplicationVersion(in.Environment, in.Application)
+	if err != nil {
+		return nil, err
+	}
+	fromCommitId := ""
+	if deployedVersion != nil {
+		deployed, err := state.GetApplicationRelease(in.Application, *deployedVersion)
+		if err != nil {
+			return nil, err
+		}
+		if valid.SHA1CommitID(deployed.SourceCommitId) {
+			fromCommitId = deployed.SourceCommitId
+		}
+	}
+	paths, err := state.GetApplicationSourcePaths(in.Application)
+	if err != nil {
+		return nil, err
+	}
+	changelog, err := s.SourceMirror.Changelog(ctx, url, fromCommitId, release.SourceCommitId, paths)
+	if errors.Is(err, repository.ErrSourceNotMirrored) {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if err != nil {
+		return nil, grpcErrors.InternalError(ctx, err)
+	}
+	result := &api.GetChangelogResponse{
+		DeployedVersion: deployedVersion,
+		Version:         version,
+		FromCommitId:    fromCommitId,
+		ToCommitId:      release.SourceCommitId,
+		Commits:         make([]*api.SourceCommit, 0, len(changelog.Commits)),
+		Truncated:       changelog.Truncated,
+	}
+	for _, commit := range changelog.Commits {
+		result.Commits = append(result.Commits, &api.SourceCommit{
+			CommitId:    commit.Id,
+			AuthorName:  commit.AuthorName,
+			AuthorEmail: commit.AuthorEmail,
+			Message:     commit.Message,
+			AuthoredAt:  timestamppb.New(commit.AuthoredAt),
+		})
+	}
+	return result, nil
+}
+
+// releaseTrainVersion returns the version of the app that a release train would deploy to the environment.
+func releaseTrainVersion(state *repository.State, environment, application string) (uint64, error) {
+	configs, err := state.GetEnvironmentConfigs()
+	if err != nil {
+		return 0, err
+	}
+	envConfig, ok := configs[environment]
+	if !ok {
+		return 0, status.Error(codes.NotFound, fmt.Sprintf("environment %s was not found", environment))
+	}
+	if envConfig.Upstream == nil {
+		return 0, status.Error(codes.FailedPrecondition, fmt.Sprintf("environment %s has no upstream, so the version is required", environment))
+	}
+	if envConfig.Upstream.Latest {
+		versions, err := state.GetApplicationReleases(application)
+		if err != nil {
+			return 0, err
+		}
+		if len(versions) == 0 {
+			return 0, status.Error(codes.NotFound, fmt.Sprintf("application %s has no releases", application))
+		}
+		return versions[len(versions)-1], nil
+	}
+	upstreamVersion, err := state.GetEnvironmentApplicationVersion(envConfig.Upstream.Environment, application)
+	if err != nil {
+		return 0, err
+	}
+	if upstreamVersion == nil {
+		return 0, status.Error(codes.NotFound, fmt.Sprintf("application %s is not deployed in the upstream environment %s", application, envConfig.Upstream.Environment))
+	}
+	return *upstreamVersion, nil
+}
+
+// findCommitRelease returns the first release of the app that was built from a commit with the given prefix, or nil if there is none.
+func findCommitRelease(state *repository.State, app string, commitPrefix string) (*commitRelease, error) {
+	releases, err := readCommitReleases(state, app)
+	if err != nil {
+		return nil, err
+	}
+	return firstCommitRelease(releases, commitPrefix)
+}
+
+// firstCommitRelease fails if releases of the app were built from different commits with the prefix.
+func firstCommitRelease(releases []commitRelease, commitPrefix string) (*commitRelease, error) {
+	var result *commitRelease
+	for i := range releases {
+		if !strings.HasPrefix(releases[i].SourceCommitId, commitPrefix) {
+			continue
+		}
+		if result == nil {
+			result = &releases[i]
+		} else if result.SourceCommitId != releases[i].SourceCommitId {
+			return nil, status.Error(codes.InvalidArgument,
+				"commit_hash is not unique, provide the complete hash (or a longer prefix)")
+		}
+	}
+	return result, nil
+}
+
+// readCommitReleases returns the releases of the app that have a valid source commit id, sorted by version.
+func readCommitReleases(state *repository.State, app string) ([]commitRelease, error) {
+	versions, err := state.GetApplicationReleases(app)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the releases of app %s: %w", app, err)
+	}
+	result := make([]commitRelease, 0, len(versions))
+	for _, version := range versions {
+		release, err := state.GetApplicationRelease(app, version)
+		if err != nil {
+			return nil, fmt.Errorf("could not read release %d of app %s: %w", version, app, err)
+		}
+		if valid.SHA1CommitID(release.SourceCommitId) {
+			result = append(result, commitRelease{
+				Version:        version,
+				SourceCommitId: strings.ToLower(release.SourceCommitId),
+			})
+		}
+	}
+	return result, nil
+}
+
+// commitReleasesIndex returns the releases of all apps. The result is reused as long as the repository has no new commit.
+func (s *GitServer) commitReleasesIndex(state *repository.State, apps []string) (map[string][]commitRelease, error) {
+	stateCommit := ""
+	if state.Commit != nil {
+		stateCommit = state.Commit.Id().String()
+	}
+	s.commitReleasesMx.Lock()
+	defer s.commitReleasesMx.Unlock()
+	if stateCommit != "" && s.commitReleases != nil && s.commitReleases.stateCommit == stateCommit {
+		return s.commitReleases.apps, nil
+	}
+	index := make(map[string][]commitRelease, len(apps))
+	for _, app := range apps {
+		releases, err := readCommitReleases(state, app)
+		if err != nil {
+			return nil, err
+		}
+		index[app] = releases
+	}
+	if stateCommit != "" {
+		s.commitReleases = &commitReleasesIndex{
+			stateCommit: stateCommit,
+			apps:        index,
+		}
+	}
+	return index, nil
+}
+
+func (s *GitServer) commitDeploymentInEnvironment(ctx context.Context, state *repository.State, env, app string, releaseVersion uint64) (*api.CommitDeploymentInEnvironment, error) {
+	result := &api.CommitDeploymentInEnvironment{
+		Environment:       env,
+		Status:            api.CommitDeploymentInEnvironment_NOT_DEPLOYED,
+		DeployedVersion:   nil,
+		DeployedAt:        nil,
+		DeployedBy:        "",
+		DeployedAtUnknown: false,
+	}
+	deployedVersion, err := state.GetEnvironmentApplicationVersion(env, app)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the deployed version of app %s in environment %s: %w", app, env, err)
+	}
+	if deployedVersion == nil {
+		return result, nil
+	}
+	result.DeployedVersion = deployedVersion
+	if *deployedVersion == releaseVersion {
+		result.Status = api.CommitDeploymentInEnvironment_DEPLOYED
+	} else if *deployedVersion > releaseVersion {
+		result.Status = api.CommitDeploymentInEnvironment_NEWER_RELEASE_DEPLOYED
+	}
+	deploymentState := state
+	if result.Status == api.CommitDeploymentInEnvironment_NEWER_RELEASE_DEPLOYED {
+		// the current deployment may be a later one, the commit went live with the first newer release
+		deploymentState, err = s.liveSince(state, env, app, releaseVersion)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the deployment history of app %s in environment %s: %w", app, env, err)
+		}
+		if deploymentState == nil {
+			result.DeployedAtUnknown = true
+			return result, nil
+		}
+	}
+	deployedBy, deployedAt, err := deploymentState.GetDeploymentMetaData(ctx, env, app)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the deployment metadata of app %s in environment %s: %w", app, env, err)
+	}
+	result.DeployedBy = deployedBy
+	if !deployedAt.IsZero() {
+		result.DeployedAt = timestamppb.New(deployedAt)
+	} else if result.Status != api.CommitDeploymentInEnvironment_NOT_DEPLOYED {
+		result.DeployedAtUnknown = true
+	}
+	return result, nil
+}
+
+// maxLiveSinceCommits is the number of commits that liveSince reads before it gives up.
+const maxLiveSinceCommits = 1000
+
+// liveSince returns the state of the oldest commit since which the app is deployed in the environment
+// with a version of at least minVersion, or nil if that is more than maxLiveSinceCommits commits back.
+func (s *GitServer) liveSince(state *repository.State, env, app string, minVersion uint64) (*repository.State, error) {
+	if state.Commit == nil {
+		return state, nil
+	}
+	versionPath := path.Join("environments", env, "applications", app, "version")
+	candidate := state.Commit
+	candidateVersion, err := treeEntryId(candidate, versionPath)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < maxLiveSinceCommits; i++ {
+		if candidate.ParentCount() == 0 {
+			return s.OverviewService.Repository.StateAt(candidate.Id())
+		}
+		parent := candidate.Parent(0)
+		parentVersion, err := treeEntryId(parent, versionPath)
+		if err != nil {
+			return nil, err
+		}
+		// the version symlink only changes when another version is deployed
+		if parentVersion == nil || !parentVersion.Equal(candidateVersion) {
+			live := parentVersion != nil
+			if live {
+				parentState, err := s.OverviewService.Repository.StateAt(parent.Id())
+				if err != nil {
+					return nil, err
+				}
+				version, err := parentState.GetEnvironmentApplicationVersion(env, app)
+				if err != nil {
+					return nil, err
+				}
+				live = version != nil && *version >= minVersion
+			}
+			if !live {
+				return s.OverviewService.Repository.StateAt(candidate.Id())
+			}
+		}
+		candidate = parent
+		candidateVersion = parentVersion
+	}
+	return nil, nil
+}
+
+// treeEntryId returns the id of the file in the tree of the commit, nil if it does not exist.
+func treeEntryId(commit *git.Commit, file string) (*git.Oid, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	defer tree.Free()
+	entry, err := tree.EntryByPath(file)
+	if err != nil {
+		if git.IsErrorCode(err, git.ErrorCodeNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return entry.Id, nil
+}
+
+// names returns the sorted names of the entries of a directory, or nothing if it does not exist.
+func names(fs billy.Filesystem, path string) ([]string, error) {
+	files, err := fs.ReadDir(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read directory %s: %w", path, err)
+	}
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		result = append(result, file.Name())
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
 func (s *GitServer) GetEvents(ctx context.Context, fs billy.Filesystem, commitPath string) ([]*api.Event, error) {
 	var result []*api.Event
 	allEventsPath := fs.Join(commitPath, "events")
@@ -246,9 +680,89 @@ func (s *GitServer) ReadEvent(ctx context.Context, fs billy.Filesystem, eventPat
 		}
 		return result, nil
 	}
+	fields, err := readEventFields(fs, eventPath)
+	if err != nil {
+		return nil, err
+	}
+	switch eventType {
+	case eventmod.DeploymentEventName:
+		version, err := strconv.ParseUint(fields["version"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the version of event %s: %w", eventPath, err)
+		}
+		deployment := &api.DeploymentEvent{
+			Application:        fields["application"],
+			Environment:        fields["environment"],
+			Version:            version,
+			AuthorName:         fields["author_name"],
+			AuthorEmail:        fields["author_email"],
+			Trigger:            api.DeploymentEvent_Trigger(api.DeploymentEvent_Trigger_value[fields["trigger"]]),
+			ReleaseTrainSource: nil,
+		}
+		if deployment.Trigger == api.DeploymentEvent_RELEASE_TRAIN {
+			deployment.ReleaseTrainSource = &api.DeploymentEvent_ReleaseTrainSource{
+				UpstreamEnvironment: fields["release_train_upstream_environment"],
+				Target:              fields["release_train_target"],
+			}
+		}
+		return &api.Event{
+			CreatedAt: uuid.GetTime(&eventId),
+			EventType: &api.Event_DeploymentEvent{
+				DeploymentEvent: deployment,
+			},
+		}, nil
+	case eventmod.LockPreventedDeploymentEventName:
+		version, err := strconv.ParseUint(fields["version"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the version of event %s: %w", eventPath, err)
+		}
+		return &api.Event{
+			CreatedAt: uuid.GetTime(&eventId),
+			EventType: &api.Event_LockPreventedDeploymentEvent{
+				LockPreventedDeploymentEvent: &api.LockPreventedDeploymentEvent{
+					Application: fields["application"],
+					Environment: fields["environment"],
+					Version:     version,
+					LockType:    api.LockPreventedDeploymentEvent_LockType(api.LockPreventedDeploymentEvent_LockType_value[fields["lock_type"]]),
+					LockMessage: fields["lock_message"],
+				},
+			},
+		}, nil
+	case eventmod.ReplacedByEventName:
+		return &api.Event{
+			CreatedAt: uuid.GetTime(&eventId),
+			EventType: &api.Event_ReplacedByEvent{
+				ReplacedByEvent: &api.ReplacedByEvent{
+					Application:        fields["application"],
+					Environment:        fields["environment"],
+					ReplacedByCommitId: fields["replaced_by_commit_id"],
+				},
+			},
+		}, nil
+	}
 	return nil, fmt.Errorf("could not read event, did not recognize event type '%s'", eventType)
 }
 
+// readEventFields reads the files of an event, which contain one field each.
+func readEventFields(fs billy.Filesystem, eventPath string) (map[string]string, error) {
+	entries, err := fs.ReadDir(eventPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read event directory '%s' - %v", eventPath, err)
+	}
+	fields := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := util.ReadFile(fs, fs.Join(eventPath, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read event field in path %s - %v", fs.Join(eventPath, entry.Name()), err)
+		}
+		fields[entry.Name()] = string(content)
+	}
+	return fields, nil
+}
+
 // findCommitID checks if the "commits" directory in the given
 // filesystem contains a commit with the given prefix. Returns the
 // full hash of the commit, if a unique one can be found. Returns a
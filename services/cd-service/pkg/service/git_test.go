@@ -438,6 +438,109 @@ func TestGetCommitInfo(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "deployments, lock-prevented deployments and replacements are recorded",
+			transformers: []rp.Transformer{
+				&rp.CreateEnvironment{
+					Environment: "production",
+				},
+				&rp.CreateApplicationVersion{
+					Application:    "app",
+					Version:        1,
+					SourceCommitId: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+					SourceMessage:  "first message",
+					Manifests: map[string]string{
+						"production": "manifest-1",
+					},
+					WriteCommitData: true,
+				},
+				&rp.DeployApplicationVersion{
+					Application:     "app",
+					Environment:     "production",
+					Version:         1,
+					LockBehaviour:   api.LockBehavior_FAIL,
+					WriteCommitData: true,
+					Trigger:         api.DeploymentEvent_MANUAL,
+				},
+				&rp.CreateApplicationVersion{
+					Application:    "app",
+					Version:        2,
+					SourceCommitId: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+					SourceMessage:  "second message",
+					Manifests: map[string]string{
+						"production": "manifest-2",
+					},
+					WriteCommitData: true,
+				},
+				&rp.CreateEnvironmentLock{
+					Environment: "production",
+					LockId:      "l1",
+					Message:     "maintenance",
+				},
+				&rp.DeployApplicationVersion{
+					Application:     "app",
+					Environment:     "production",
+					Version:         2,
+					LockBehaviour:   api.LockBehavior_RECORD,
+					WriteCommitData: true,
+					Trigger:         api.DeploymentEvent_MANUAL,
+				},
+				&rp.DeleteEnvironmentLock{
+					Environment: "production",
+					LockId:      "l1",
+				},
+				&rp.DeployApplicationVersion{
+					Application:     "app",
+					Environment:     "production",
+					Version:         2,
+					LockBehaviour:   api.LockBehavior_FAIL,
+					WriteCommitData: true,
+					Trigger:         api.DeploymentEvent_MANUAL,
+				},
+			},
+			request: &api.GetCommitInfoRequest{
+				CommitHash: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			},
+			allowReadingCommitData: true,
+			expectedResponse: &api.GetCommitInfoResponse{
+				CommitHash:    "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+				CommitMessage: "first message",
+				TouchedApps:   []string{"app"},
+				Events: []*api.Event{
+					{
+						CreatedAt: timestamppb.New(fixedTime().Add(time.Second * time.Duration(1))),
+						EventType: &api.Event_CreateReleaseEvent{
+							CreateReleaseEvent: &api.CreateReleaseEvent{
+								EnvironmentNames: []string{"production"},
+							},
+						},
+					},
+					{
+						CreatedAt: timestamppb.New(fixedTime().Add(time.Second * time.Duration(2))),
+						EventType: &api.Event_DeploymentEvent{
+							DeploymentEvent: &api.DeploymentEvent{
+								Application: "app",
+								Environment: "production",
+								Version:     1,
+								AuthorName:  "test tester",
+								AuthorEmail: "testmail@example.com",
+								Trigger:     api.DeploymentEvent_MANUAL,
+							},
+						},
+					},
+					{
+						CreatedAt: timestamppb.New(fixedTime().Add(time.Second * time.Duration(7))),
+						EventType: &api.Event_ReplacedByEvent{
+							ReplacedByEvent: &api.ReplacedByEvent{
+								Application:        "app",
+								Environment:        "production",
+								ReplacedByCommitId: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "no commit info returned if feature toggle not set",
 			transformers: []rp.Transformer{
@@ -521,3 +624,424 @@ func TestGetCommitInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestFindCommitDeployments(t *testing.T) {
+	setup := []rp.Transformer{
+		&rp.CreateEnvironment{
+			Environment: "development",
+			Config:      testutil.MakeEnvConfigLatest(nil),
+		},
+		&rp.CreateEnvironment{
+			Environment: "staging",
+			Config:      testutil.MakeEnvConfigUpstream("development", nil),
+		},
+		&rp.CreateEnvironment{
+			Environment: "production",
+			Config:      testutil.MakeEnvConfigUpstream("staging", nil),
+		},
+		&rp.CreateApplicationVersion{
+			Application:    "app1",
+			Version:        1,
+			SourceCommitId: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			Manifests: map[string]string{
+				"development": "manifest",
+				"staging":     "manifest",
+				"production":  "manifest",
+			},
+			WriteCommitData: true,
+		},
+		&rp.CreateApplicationVersion{
+			Application:    "app2",
+			Version:        1,
+			SourceCommitId: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			Manifests: map[string]string{
+				"development": "manifest",
+			},
+		},
+		&rp.DeployApplicationVersion{
+			Application:   "app1",
+			Environment:   "staging",
+			Version:       1,
+			LockBehaviour: api.LockBehavior_FAIL,
+		},
+		&rp.CreateApplicationVersion{
+			Application:    "app1",
+			Version:        2,
+			SourceCommitId: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+			Manifests: map[string]string{
+				"development": "manifest",
+				"staging":     "manifest",
+				"production":  "manifest",
+			},
+		},
+	}
+	deployed := func(env string, status api.CommitDeploymentInEnvironment_Status, version uint64) *api.CommitDeploymentInEnvironment {
+		return &api.CommitDeploymentInEnvironment{
+			Environment:     env,
+			Status:          status,
+			DeployedVersion: &version,
+			DeployedBy:      "test tester",
+		}
+	}
+	notDeployed := func(env string) *api.CommitDeploymentInEnvironment {
+		return &api.CommitDeploymentInEnvironment{
+			Environment: env,
+			Status:      api.CommitDeploymentInEnvironment_NOT_DEPLOYED,
+		}
+	}
+	tcs := []struct {
+		Name             string
+		CommitHash       string
+		ExpectedResponse *api.FindCommitDeploymentsResponse
+		ExpectedError    error
+	}{
+		{
+			// app2 has no commit data, but it is found by its release
+			Name:       "the releases of the commit or newer ones are deployed",
+			CommitHash: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			ExpectedResponse: &api.FindCommitDeploymentsResponse{
+				CommitHash: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+				Applications: []*api.CommitDeploymentsOfApp{
+					{
+						Application:    "app1",
+						ReleaseVersion: 1,
+						Environments: []*api.CommitDeploymentInEnvironment{
+							deployed("development", api.CommitDeploymentInEnvironment_NEWER_RELEASE_DEPLOYED, 2),
+							notDeployed("production"),
+							deployed("staging", api.CommitDeploymentInEnvironment_DEPLOYED, 1),
+						},
+					},
+					{
+						Application:    "app2",
+						ReleaseVersion: 1,
+						Environments: []*api.CommitDeploymentInEnvironment{
+							deployed("development", api.CommitDeploymentInEnvironment_DEPLOYED, 1),
+							notDeployed("production"),
+							notDeployed("staging"),
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:       "a prefix of the commit is enough",
+			CommitHash: "bbbbbbbbb",
+			ExpectedResponse: &api.FindCommitDeploymentsResponse{
+				CommitHash: "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+				Applications: []*api.CommitDeploymentsOfApp{
+					{
+						Application:    "app1",
+						ReleaseVersion: 2,
+						Environments: []*api.CommitDeploymentInEnvironment{
+							deployed("development", api.CommitDeploymentInEnvironment_DEPLOYED, 2),
+							notDeployed("production"),
+							deployed("staging", api.CommitDeploymentInEnvironment_NOT_DEPLOYED, 1),
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:          "no release was built from the commit",
+			CommitHash:    "cccccccccccccccccccccccccccccccccccccccc",
+			ExpectedError: status.Error(codes.NotFound, "error: no release was built from commit cccccccccccccccccccccccccccccccccccccccc"),
+		},
+		{
+			Name:          "the prefix is too short",
+			CommitHash:    "aaaa",
+			ExpectedError: status.Error(codes.InvalidArgument, "commit_hash too short (must be at least 7 characters)"),
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			shutdown := make(chan struct{}, 1)
+			repo, err := setupRepositoryTest(t)
+			if err != nil {
+				t.Fatalf("error setting up repository test: %v", err)
+			}
+			for _, transformer := range setup {
+				if err := repo.Apply(testutil.MakeTestContext(), transformer); err != nil {
+					t.Fatalf("expected no error in transformer but got:\n%v\n", err)
+				}
+			}
+			sv := &GitServer{OverviewService: &OverviewServiceServer{Repository: repo, Shutdown: shutdown}}
+
+			response, err := sv.FindCommitDeployments(testutil.MakeTestContext(), &api.FindCommitDeploymentsRequest{CommitHash: tc.CommitHash})
+			if !errors.Is(err, tc.ExpectedError) {
+				t.Fatalf("expected error %v\nreceived error %v", tc.ExpectedError, err)
+			}
+			if response != nil {
+				// the deployment time is the real time of the test
+				for _, app := range response.Applications {
+					for _, env := range app.Environments {
+						if (env.DeployedAt != nil) != (env.DeployedVersion != nil) {
+							t.Errorf("expected a deployment time exactly for deployed versions, got %v", env)
+						}
+						env.DeployedAt = nil
+					}
+				}
+			}
+			if !proto.Equal(tc.ExpectedResponse, response) {
+				t.Fatalf("expected response:\n%v\nreceived response:\n%v\n", tc.ExpectedResponse, response)
+			}
+		})
+	}
+}
+
+func TestFindCommitDeploymentsAfterNewRelease(t *testing.T) {
+	ctx := testutil.MakeTestContext()
+	repo, err := setupRepositoryTest(t)
+	if err != nil {
+		t.Fatalf("error setting up repository test: %v", err)
+	}
+	release := func(version uint64, commit string) rp.Transformer {
+		return &rp.CreateApplicationVersion{
+			Application:    "app1",
+			Version:        version,
+			SourceCommitId: commit,
+			Manifests:      map[string]string{"development": "manifest"},
+		}
+	}
+	for _, transformer := range []rp.Transformer{
+		&rp.CreateEnvironment{Environment: "development", Config: testutil.MakeEnvConfigLatest(nil)},
+		release(1, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+	} {
+		if err := repo.Apply(ctx, transformer); err != nil {
+			t.Fatalf("expected no error in transformer but got:\n%v\n", err)
+		}
+	}
+	sv := &GitServer{OverviewService: &OverviewServiceServer{Repository: repo, Shutdown: make(chan struct{}, 1)}}
+	find := func(commitHash string) *api.FindCommitDeploymentsResponse {
+		response, err := sv.FindCommitDeployments(ctx, &api.FindCommitDeploymentsRequest{CommitHash: commitHash})
+		if err != nil {
+			t.Fatalf("expected no error for %s, got %v", commitHash, err)
+		}
+		return response
+	}
+
+	// the second request is answered from the releases read by the first one
+	for i := 0; i < 2; i++ {
+		if response := find("aaaaaaa"); response.Applications[0].ReleaseVersion != 1 {
+			t.Errorf("expected release 1, got %v", response)
+		}
+	}
+	if err := repo.Apply(ctx, release(2, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")); err != nil {
+		t.Fatalf("expected no error in transformer but got:\n%v\n", err)
+	}
+	// a new commit of the repository invalidates the releases that were read before
+	if response := find("bbbbbbb"); response.Applications[0].ReleaseVersion != 2 {
+		t.Errorf("expected release 2, got %v", response)
+	}
+}
+
+func TestFindCommitDeploymentsLiveSince(t *testing.T) {
+	repo, err := setupRepositoryTest(t)
+	if err != nil {
+		t.Fatalf("error setting up repository test: %v", err)
+	}
+	apply := func(now int64, transformer rp.Transformer) {
+		ctx := rp.WithTimeNow(testutil.MakeTestContext(), time.Unix(now, 0))
+		if err := repo.Apply(ctx, transformer); err != nil {
+			t.Fatalf("expected no error in transformer but got:\n%v\n", err)
+		}
+	}
+	release := func(version uint64, commit string) rp.Transformer {
+		return &rp.CreateApplicationVersion{
+			Application:    "app1",
+			Version:        version,
+			SourceCommitId: commit,
+			Manifests:      map[string]string{"development": "manifest"},
+		}
+	}
+	deploy := func(version uint64) rp.Transformer {
+		return &rp.DeployApplicationVersion{
+			Application:   "app1",
+			Environment:   "development",
+			Version:       version,
+			LockBehaviour: api.LockBehavior_FAIL,
+		}
+	}
+	sv := &GitServer{OverviewService: &OverviewServiceServer{Repository: repo, Shutdown: make(chan struct{}, 1)}}
+	expectDeployment := func(commitHash string, expectedStatus api.CommitDeploymentInEnvironment_Status, expectedVersion uint64, expectedAt int64) {
+		t.Helper()
+		response, err := sv.FindCommitDeployments(testutil.MakeTestContext(), &api.FindCommitDeploymentsRequest{CommitHash: commitHash})
+		if err != nil {
+			t.Fatalf("expected no error for %s, got %v", commitHash, err)
+		}
+		expected := &api.CommitDeploymentInEnvironment{
+			Environment:     "development",
+			Status:          expectedStatus,
+			DeployedVersion: &expectedVersion,
+			DeployedAt:      timestamppb.New(time.Unix(expectedAt, 0)),
+			DeployedBy:      "test tester",
+		}
+		if actual := response.Applications[0].Environments[0]; !proto.Equal(expected, actual) {
+			t.Errorf("expected deployment of %s:\n%v\nreceived:\n%v\n", commitHash, expected, actual)
+		}
+	}
+
+	apply(0, &rp.CreateEnvironment{Environment: "development", Config: testutil.MakeEnvConfigLatest(nil)})
+	apply(1, release(1, "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
+	apply(2, release(2, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"))
+	apply(3, release(3, "cccccccccccccccccccccccccccccccccccccccc"))
+	// the commits went live with their own releases, not with the release that is deployed now
+	expectDeployment("aaaaaaa", api.CommitDeploymentInEnvironment_NEWER_RELEASE_DEPLOYED, 3, 1)
+	expectDeployment("bbbbbbb", api.CommitDeploymentInEnvironment_NEWER_RELEASE_DEPLOYED, 3, 2)
+	expectDeployment("ccccccc", api.CommitDeploymentInEnvironment_DEPLOYED, 3, 3)
+
+	// a rollback to an older release removes the commit until a newer release is deployed again
+	apply(4, deploy(1))
+	expectDeployment("bbbbbbb", api.CommitDeploymentInEnvironment_NOT_DEPLOYED, 1, 4)
+	apply(5, deploy(3))
+	expectDeployment("bbbbbbb", api.CommitDeploymentInEnvironment_NEWER_RELEASE_DEPLOYED, 3, 5)
+	expectDeployment("aaaaaaa", api.CommitDeploymentInEnvironment_NEWER_RELEASE_DEPLOYED, 3, 5)
+}
+
+func TestFindCommitDeploymentsAmbiguousPrefix(t *testing.T) {
+	ctx := testutil.MakeTestContext()
+	repo, err := setupRepositoryTest(t)
+	if err != nil {
+		t.Fatalf("error setting up repository test: %v", err)
+	}
+	for _, transformer := range []rp.Transformer{
+		&rp.CreateEnvironment{Environment: "development", Config: testutil.MakeEnvConfigLatest(nil)},
+		&rp.CreateApplicationVersion{
+			Application:    "app1",
+			Version:        1,
+			SourceCommitId: "abcdef1aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+			Manifests:      map[string]string{"development": "manifest"},
+		},
+		&rp.CreateApplicationVersion{
+			Application:    "app1",
+			Version:        2,
+			SourceCommitId: "abcdef1bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb",
+			Manifests:      map[string]string{"development": "manifest"},
+		},
+	} {
+		if err := repo.Apply(ctx, transformer); err != nil {
+			t.Fatalf("expected no error in transformer but got:\n%v\n", err)
+		}
+	}
+	sv := &GitServer{OverviewService: &OverviewServiceServer{Repository: repo, Shutdown: make(chan struct{}, 1)}}
+
+	// both releases belong to the same app
+	_, err = sv.FindCommitDeployments(ctx, &api.FindCommitDeploymentsRequest{CommitHash: "abcdef1"})
+	expectedError := status.Error(codes.InvalidArgument, "commit_hash is not unique, provide the complete hash (or a longer prefix)")
+	if !errors.Is(err, expectedError) {
+		t.Fatalf("expected error %v\nreceived error %v", expectedError, err)
+	}
+	if _, err := sv.FindCommitDeployments(ctx, &api.FindCommitDeploymentsRequest{CommitHash: "abcdef1a"}); err != nil {
+		t.Errorf("expected no error for a unique prefix, got %v", err)
+	}
+}
+
+func TestGetChangelogErrors(t *testing.T) {
+	tcs := []struct {
+		Name          string
+		Setup         []rp.Transformer
+		Mirror        bool
+		Request       *api.GetChangelogRequest
+		ExpectedError error
+	}{
+		{
+			Name:          "the mirror is not enabled",
+			Request:       &api.GetChangelogRequest{Application: "app", Environment: "production", Version: 1},
+			ExpectedError: status.Error(codes.FailedPrecondition, "the source repository mirror is not enabled; set KUBERPULT_SOURCE_REPO_MIRROR_PATH to enable"),
+		},
+		{
+			Name: "the app has no source repository",
+			Setup: []rp.Transformer{
+				&rp.CreateApplicationVersion{
+					Application:    "app",
+					SourceCommitId: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+					Manifests: map[string]string{
+						"production": "manifest",
+					},
+				},
+			},
+			Mirror:        true,
+			Request:       &api.GetChangelogRequest{Application: "app", Environment: "production", Version: 1},
+			ExpectedError: status.Error(codes.FailedPrecondition, "application app has no source repository url"),
+		},
+		{
+			Name: "the release train version requires an upstream",
+			Setup: []rp.Transformer{
+				&rp.CreateEnvironment{
+					Environment: "production",
+				},
+				&rp.CreateApplicationVersion{
+					Application:    "app",
+					SourceCommitId: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+					SourceRepoUrl:  "https://github.com/example/source.git",
+					Manifests: map[string]string{
+						"production": "manifest",
+					},
+				},
+			},
+			Mirror:        true,
+			Request:       &api.GetChangelogRequest{Application: "app", Environment: "production"},
+			ExpectedError: status.Error(codes.FailedPrecondition, "environment production has no upstream, so the version is required"),
+		},
+		{
+			Name: "local source repositories are not mirrored",
+			Setup: []rp.Transformer{
+				&rp.CreateApplicationVersion{
+					Application:    "app",
+					SourceCommitId: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+					SourceRepoUrl:  "/source",
+					Manifests: map[string]string{
+						"production": "manifest",
+					},
+				},
+			},
+			Mirror:        true,
+			Request:       &api.GetChangelogRequest{Application: "app", Environment: "production", Version: 1},
+			ExpectedError: status.Error(codes.FailedPrecondition, "the source repository url /source is not a remote repository"),
+		},
+		{
+			Name: "the source repository is not mirrored yet",
+			Setup: []rp.Transformer{
+				&rp.CreateEnvironment{
+					Environment: "production",
+				},
+				&rp.CreateApplicationVersion{
+					Application:    "app",
+					SourceCommitId: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+					SourceRepoUrl:  "https://github.com/example/source.git",
+					Manifests: map[string]string{
+						"production": "manifest",
+					},
+				},
+			},
+			Mirror:        true,
+			Request:       &api.GetChangelogRequest{Application: "app", Environment: "production", Version: 1},
+			ExpectedError: status.Error(codes.Unavailable, "the commits are not mirrored yet, try again later: the source repository https://github.com/example/source.git is not mirrored yet"),
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			repo, err := setupRepositoryTest(t)
+			if err != nil {
+				t.Fatalf("error setting up repository test: %v", err)
+			}
+			for _, transformer := range tc.Setup {
+				if err := repo.Apply(testutil.MakeTestContext(), transformer); err != nil {
+					t.Fatalf("expected no error in transformer but got:\n%v\n", err)
+				}
+			}
+			sv := &GitServer{OverviewService: &OverviewServiceServer{Repository: repo, Shutdown: make(chan struct{}, 1)}}
+			if tc.Mirror {
+				sv.SourceMirror, err = rp.NewSourceMirror(rp.SourceMirrorConfig{Path: t.TempDir(), AllowedUrls: []string{"https://github.com/"}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err = sv.GetChangelog(testutil.MakeTestContext(), tc.Request)
+			if !errors.Is(err, tc.ExpectedError) {
+				t.Fatalf("expected error %v\nreceived error %v", tc.ExpectedError, err)
+			}
+		})
+	}
+}
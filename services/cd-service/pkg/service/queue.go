@@ -0,0 +1,63 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package service
+
+import (
+	"context"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type QueueServiceServer struct {
+	Repository     repository.Repository
+	RBACConfig     auth.RBACConfig
+	MaxQueueLength int
+}
+
+func (q *QueueServiceServer) GetQueueStatus(
+	ctx context.Context,
+	in *api.GetQueueStatusRequest) (*api.GetQueueStatusResponse, error) {
+	if err := q.RBACConfig.CheckAdmin(ctx, "read the queue status"); err != nil {
+		return nil, err
+	}
+	return queueStatusResponse(q.Repository.QueueStatus(), q.MaxQueueLength, time.Now()), nil
+}
+
+func queueStatusResponse(queued []repository.QueuedElement, maxQueueLength int, now time.Time) *api.GetQueueStatusResponse {
+	elements := make([]*api.QueueElement, 0, len(queued))
+	for _, e := range queued {
+		elements = append(elements, &api.QueueElement{
+			UserName:   e.UserName,
+			UserEmail:  e.UserEmail,
+			Actions:    e.Transformers,
+			EnqueuedAt: timestamppb.New(e.EnqueuedAt),
+			Age:        durationpb.New(now.Sub(e.EnqueuedAt)),
+			Processing: e.Processing,
+		})
+	}
+	return &api.GetQueueStatusResponse{
+		Elements:       elements,
+		MaxQueueLength: uint32(maxQueueLength),
+	}
+}
+
+var _ api.QueueServiceServer = (*QueueServiceServer)(nil)
@@ -0,0 +1,160 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository/testutil"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type queueStatusRepository struct {
+	repository.Repository
+	queued []repository.QueuedElement
+}
+
+func (q *queueStatusRepository) QueueStatus() []repository.QueuedElement {
+	return q.queued
+}
+
+func TestGetQueueStatus(t *testing.T) {
+	rbacConfig := auth.RBACConfig{
+		DexEnabled: true,
+		AdminRole:  "admin",
+	}
+	queued := []repository.QueuedElement{
+		{
+			UserName:     "test tester",
+			UserEmail:    "testmail@example.com",
+			Transformers: []string{"CreateApplicationVersion"},
+			EnqueuedAt:   time.Now(),
+			Processing:   true,
+		},
+	}
+	tcs := []struct {
+		Name             string
+		RBACConfig       auth.RBACConfig
+		Context          context.Context
+		ExpectedElements int
+		ExpectedError    string
+	}{
+		{
+			Name:             "rbac disabled",
+			RBACConfig:       auth.RBACConfig{DexEnabled: false},
+			Context:          testutil.MakeTestContext(),
+			ExpectedElements: 1,
+		},
+		{
+			Name:             "admin",
+			RBACConfig:       rbacConfig,
+			Context:          testutil.MakeTestContextDexEnabledUser("admin"),
+			ExpectedElements: 1,
+		},
+		{
+			Name:          "not admin",
+			RBACConfig:    rbacConfig,
+			Context:       testutil.MakeTestContextDexEnabled(),
+			ExpectedError: "rpc error: code = PermissionDenied desc = PermissionDenied: The user 'test tester' with role 'developer' is not allowed to read the queue status",
+		},
+		{
+			Name:          "no admin role configured",
+			RBACConfig:    auth.RBACConfig{DexEnabled: true},
+			Context:       testutil.MakeTestContextDexEnabledUser(""),
+			ExpectedError: "rpc error: code = PermissionDenied desc = PermissionDenied: The user 'test tester' with role '' is not allowed to read the queue status",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			svc := &QueueServiceServer{
+				Repository:     &queueStatusRepository{queued: queued},
+				RBACConfig:     tc.RBACConfig,
+				MaxQueueLength: 10,
+			}
+			response, err := svc.GetQueueStatus(tc.Context, &api.GetQueueStatusRequest{})
+			if tc.ExpectedError != "" {
+				if err == nil {
+					t.Fatalf("expected error %q but got none", tc.ExpectedError)
+				}
+				if diff := cmp.Diff(tc.ExpectedError, err.Error()); diff != "" {
+					t.Errorf("error mismatch (-want, +got):\n%s", diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Elements) != tc.ExpectedElements {
+				t.Errorf("expected %d elements, got %d", tc.ExpectedElements, len(response.Elements))
+			}
+			if response.MaxQueueLength != 10 {
+				t.Errorf("expected max queue length 10, got %d", response.MaxQueueLength)
+			}
+		})
+	}
+}
+
+func TestQueueStatusResponse(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	queued := []repository.QueuedElement{
+		{
+			UserName:     "test tester",
+			UserEmail:    "testmail@example.com",
+			Transformers: []string{"CreateEnvironmentLock", "DeployApplicationVersion"},
+			EnqueuedAt:   now.Add(-90 * time.Second),
+			Processing:   true,
+		},
+		{
+			UserName:     "",
+			UserEmail:    "",
+			Transformers: []string{"CreateApplicationVersion"},
+			EnqueuedAt:   now.Add(-time.Second),
+			Processing:   false,
+		},
+	}
+	expected := &api.GetQueueStatusResponse{
+		Elements: []*api.QueueElement{
+			{
+				UserName:   "test tester",
+				UserEmail:  "testmail@example.com",
+				Actions:    []string{"CreateEnvironmentLock", "DeployApplicationVersion"},
+				EnqueuedAt: timestamppb.New(now.Add(-90 * time.Second)),
+				Age:        durationpb.New(90 * time.Second),
+				Processing: true,
+			},
+			{
+				Actions:    []string{"CreateApplicationVersion"},
+				EnqueuedAt: timestamppb.New(now.Add(-time.Second)),
+				Age:        durationpb.New(time.Second),
+			},
+		},
+		MaxQueueLength: 3,
+	}
+	actual := queueStatusResponse(queued, 3, now)
+	if diff := cmp.Diff(expected, actual, protocmp.Transform()); diff != "" {
+		t.Errorf("response mismatch (-want, +got):\n%s", diff)
+	}
+}
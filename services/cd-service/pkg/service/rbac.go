@@ -0,0 +1,85 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package service
+
+import (
+	"context"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/pkg/grpc"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type RbacServiceServer struct {
+	Repository repository.Repository
+	RBACConfig auth.RBACConfig
+}
+
+func (r *RbacServiceServer) ExplainPermission(
+	ctx context.Context,
+	in *api.ExplainPermissionRequest) (*api.ExplainPermissionResponse, error) {
+	if !r.RBACConfig.DexEnabled {
+		return &api.ExplainPermissionResponse{
+			Allowed:      true,
+			RbacDisabled: true,
+			Role:         in.Role,
+		}, nil
+	}
+	user, err := auth.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	role := ""
+	if user.DexAuthContext != nil {
+		role = user.DexAuthContext.Role
+	}
+	if in.Role != "" && in.Role != role {
+		// Only admins may look at the permissions of other roles.
+		if err := r.RBACConfig.CheckAdmin(ctx, "explain the permissions of the role '"+in.Role+"'"); err != nil {
+			return nil, err
+		}
+		role = in.Role
+	}
+	if role == "" {
+		return nil, status.Error(codes.InvalidArgument, "cannot explain permission: no role given")
+	}
+	if err := auth.ValidateAction(in.Action); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "cannot explain permission: "+err.Error())
+	}
+	if in.Environment == "" {
+		return nil, status.Error(codes.InvalidArgument, "cannot explain permission: no environment given")
+	}
+	application := in.Application
+	if application == "" {
+		application = "*"
+	}
+	explanation, err := r.Repository.State().ExplainPermission(r.RBACConfig, role, in.EnvironmentGroup, in.Environment, application, in.Action)
+	if err != nil {
+		return nil, grpc.PublicError(ctx, err)
+	}
+	return &api.ExplainPermissionResponse{
+		Allowed:   explanation.Allowed,
+		Rule:      explanation.Rule,
+		AdminRole: explanation.AdminRole,
+		Role:      role,
+	}, nil
+}
+
+var _ api.RbacServiceServer = (*RbacServiceServer)(nil)
@@ -0,0 +1,214 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/config"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository/testutil"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestExplainPermission(t *testing.T) {
+	group := "production"
+	rbacConfig := auth.RBACConfig{
+		DexEnabled: true,
+		AdminRole:  "admin",
+		Policy: map[string]*auth.Permission{
+			"developer,DeployRelease,*:*,*,allow":                {Role: "developer"},
+			"developer,DeployRelease,production:prod-de,*,deny":  {Role: "developer", Deny: true},
+			"developer,CreateLock,production:prod-de,app1,allow": {Role: "developer"},
+		},
+	}
+	tcs := []struct {
+		Name             string
+		ctx              context.Context
+		RBACConfig       auth.RBACConfig
+		Request          *api.ExplainPermissionRequest
+		ExpectedResponse *api.ExplainPermissionResponse
+		ExpectedError    string
+	}{
+		{
+			Name:       "allowed by rule",
+			RBACConfig: rbacConfig,
+			Request: &api.ExplainPermissionRequest{
+				Role:        "developer",
+				Action:      auth.PermissionDeployRelease,
+				Environment: "prod-fr",
+				Application: "app1",
+			},
+			ExpectedResponse: &api.ExplainPermissionResponse{
+				Allowed: true,
+				Rule:    "developer,DeployRelease,*:*,*,allow",
+				Role:    "developer",
+			},
+		},
+		{
+			Name:       "denied by rule",
+			RBACConfig: rbacConfig,
+			Request: &api.ExplainPermissionRequest{
+				Role:        "developer",
+				Action:      auth.PermissionDeployRelease,
+				Environment: "prod-de",
+				Application: "app1",
+			},
+			ExpectedResponse: &api.ExplainPermissionResponse{
+				Allowed: false,
+				Rule:    "developer,DeployRelease,production:prod-de,*,deny",
+				Role:    "developer",
+			},
+		},
+		{
+			Name:       "uses the role of the user and the wildcard application",
+			RBACConfig: rbacConfig,
+			Request: &api.ExplainPermissionRequest{
+				Action:      auth.PermissionCreateLock,
+				Environment: "prod-de",
+			},
+			ExpectedResponse: &api.ExplainPermissionResponse{
+				Allowed: false,
+				Role:    "developer",
+			},
+		},
+		{
+			Name:       "allowed as admin",
+			ctx:        testutil.MakeTestContextDexEnabledUser("admin"),
+			RBACConfig: rbacConfig,
+			Request: &api.ExplainPermissionRequest{
+				Role:        "admin",
+				Action:      auth.PermissionCreateLock,
+				Environment: "prod-de",
+			},
+			ExpectedResponse: &api.ExplainPermissionResponse{
+				Allowed:   true,
+				AdminRole: true,
+				Role:      "admin",
+			},
+		},
+		{
+			Name:       "admin explains another role",
+			ctx:        testutil.MakeTestContextDexEnabledUser("admin"),
+			RBACConfig: rbacConfig,
+			Request: &api.ExplainPermissionRequest{
+				Role:        "developer",
+				Action:      auth.PermissionDeployRelease,
+				Environment: "prod-de",
+				Application: "app1",
+			},
+			ExpectedResponse: &api.ExplainPermissionResponse{
+				Allowed: false,
+				Rule:    "developer,DeployRelease,production:prod-de,*,deny",
+				Role:    "developer",
+			},
+		},
+		{
+			Name:       "only admins explain other roles",
+			RBACConfig: rbacConfig,
+			Request: &api.ExplainPermissionRequest{
+				Role:        "admin",
+				Action:      auth.PermissionCreateLock,
+				Environment: "prod-de",
+			},
+			ExpectedError: "rpc error: code = PermissionDenied desc = PermissionDenied: The user 'test tester' with role 'developer' is not allowed to explain the permissions of the role 'admin'",
+		},
+		{
+			Name:       "rbac disabled",
+			RBACConfig: auth.RBACConfig{DexEnabled: false},
+			Request: &api.ExplainPermissionRequest{
+				Role:        "developer",
+				Action:      auth.PermissionCreateLock,
+				Environment: "prod-de",
+			},
+			ExpectedResponse: &api.ExplainPermissionResponse{
+				Allowed:      true,
+				RbacDisabled: true,
+				Role:         "developer",
+			},
+		},
+		{
+			Name:       "invalid action",
+			RBACConfig: rbacConfig,
+			Request: &api.ExplainPermissionRequest{
+				Role:        "developer",
+				Action:      "Dance",
+				Environment: "prod-de",
+			},
+			ExpectedError: "rpc error: code = InvalidArgument desc = cannot explain permission: invalid action Dance",
+		},
+		{
+			Name:       "unknown environment",
+			RBACConfig: rbacConfig,
+			Request: &api.ExplainPermissionRequest{
+				Role:        "developer",
+				Action:      auth.PermissionCreateLock,
+				Environment: "prod-us",
+			},
+			ExpectedError: "rpc error: code = InvalidArgument desc = error: group not found for environment: prod-us",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			repo, err := setupRepositoryTest(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := testutil.MakeTestContextDexEnabled()
+			for _, env := range []string{"prod-de", "prod-fr"} {
+				err := repo.Apply(ctx, &repository.CreateEnvironment{
+					Environment: env,
+					Config: config.EnvironmentConfig{
+						Upstream:         &config.EnvironmentConfigUpstream{Latest: true},
+						EnvironmentGroup: &group,
+					},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.ctx != nil {
+				ctx = tc.ctx
+			}
+			svc := &RbacServiceServer{
+				Repository: repo,
+				RBACConfig: tc.RBACConfig,
+			}
+			response, err := svc.ExplainPermission(ctx, tc.Request)
+			if tc.ExpectedError != "" {
+				if err == nil {
+					t.Fatalf("expected error %q but got none", tc.ExpectedError)
+				}
+				if diff := cmp.Diff(tc.ExpectedError, err.Error()); diff != "" {
+					t.Errorf("error mismatch (-want, +got):\n%s", diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.ExpectedResponse, response, protocmp.Transform()); diff != "" {
+				t.Errorf("response mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ package service
 import (
 	"context"
 	"errors"
+	"strings"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -67,3 +68,150 @@ func (o *VersionServiceServer) GetVersion(
 	}
 	return &res, nil
 }
+
+// GetDeploymentHistory walks the first parents of the branch back to the given time and returns the
+// deployments that changed the version of an app in an environment.
+func (o *VersionServiceServer) GetDeploymentHistory(
+	ctx context.Context,
+	in *api.GetDeploymentHistoryRequest) (*api.GetDeploymentHistoryResponse, error) {
+	if in.Since == nil {
+		return nil, status.Error(codes.InvalidArgument, "since is required")
+	}
+	since := in.Since.AsTime()
+	head := o.Repository.State().Commit
+	if head == nil {
+		return &api.GetDeploymentHistoryResponse{}, nil
+	}
+	var deployments []*api.DeploymentHistoryEntry
+	commit := head
+	for commit != nil && !commit.Committer().When.Before(since) {
+		changed, err := changedVersions(commit)
+		if err != nil {
+			return nil, err
+		}
+		if len(changed) > 0 {
+			commitDeployments, err := o.deploymentsAt(ctx, commit, changed)
+			if err != nil {
+				return nil, err
+			}
+			deployments = append(deployments, commitDeployments...)
+		}
+		var parent *git.Commit
+		if commit.ParentCount() > 0 {
+			parent = commit.Parent(0)
+		}
+		if commit != head {
+			commit.Free()
+		}
+		commit = parent
+	}
+	if commit != nil && commit != head {
+		commit.Free()
+	}
+	// the commits are read newest first
+	for i, j := 0, len(deployments)-1; i < j; i, j = i+1, j-1 {
+		deployments[i], deployments[j] = deployments[j], deployments[i]
+	}
+	return &api.GetDeploymentHistoryResponse{
+		Deployments: deployments,
+	}, nil
+}
+
+type environmentApplication struct {
+	environment string
+	application string
+}
+
+// changedVersions returns the apps whose version in an environment was set by the commit compared to its first parent.
+func changedVersions(commit *git.Commit) ([]environmentApplication, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	defer tree.Free()
+	var parentTree *git.Tree
+	if commit.ParentCount() > 0 {
+		parent := commit.Parent(0)
+		defer parent.Free()
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+		defer parentTree.Free()
+	}
+	opts, err := git.DefaultDiffOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.Pathspec = []string{"environments"}
+	diff, err := commit.Owner().DiffTreeToTree(parentTree, tree, &opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = diff.Free()
+	}()
+	deltas, err := diff.NumDeltas()
+	if err != nil {
+		return nil, err
+	}
+	var result []environmentApplication
+	for i := 0; i < deltas; i++ {
+		delta, err := diff.Delta(i)
+		if err != nil {
+			return nil, err
+		}
+		if delta.Status == git.DeltaDeleted {
+			continue
+		}
+		// environments/<environment>/applications/<application>/version
+		parts := strings.Split(delta.NewFile.Path, "/")
+		if len(parts) == 5 && parts[0] == "environments" && parts[2] == "applications" && parts[4] == "version" {
+			result = append(result, environmentApplication{environment: parts[1], application: parts[3]})
+		}
+	}
+	return result, nil
+}
+
+func (o *VersionServiceServer) deploymentsAt(ctx context.Context, commit *git.Commit, changed []environmentApplication) ([]*api.DeploymentHistoryEntry, error) {
+	state, err := o.Repository.StateAt(commit.Id())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*api.DeploymentHistoryEntry, 0, len(changed))
+	for _, ea := range changed {
+		version, err := state.GetEnvironmentApplicationVersion(ea.environment, ea.application)
+		if err != nil {
+			return nil, err
+		}
+		if version == nil {
+			continue
+		}
+		_, deployedAt, err := state.GetDeploymentMetaData(ctx, ea.environment, ea.application)
+		if err != nil {
+			return nil, err
+		}
+		if deployedAt.IsZero() {
+			// older deployments have no deployment time, but the commit time is close enough
+			deployedAt = commit.Committer().When
+		}
+		team, err := state.GetApplicationTeamOwner(ea.application)
+		if err != nil {
+			return nil, err
+		}
+		entry := &api.DeploymentHistoryEntry{
+			Environment:      ea.environment,
+			Application:      ea.application,
+			Team:             team,
+			Version:          *version,
+			DeployedAt:       timestamppb.New(deployedAt),
+			ReleaseCreatedAt: nil,
+		}
+		// the release may have been cleaned up since
+		if release, err := state.GetApplicationRelease(ea.application, *version); err == nil && !release.CreatedAt.IsZero() {
+			entry.ReleaseCreatedAt = timestamppb.New(release.CreatedAt)
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
@@ -25,6 +25,9 @@ import (
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/config"
 	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/repository"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestVersion(t *testing.T) {
@@ -166,3 +169,126 @@ func TestVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestDeploymentHistory(t *testing.T) {
+	setup := []repository.Transformer{
+		&repository.CreateEnvironment{
+			Environment: "development",
+			Config: config.EnvironmentConfig{
+				Upstream: &config.EnvironmentConfigUpstream{
+					Latest: true,
+				},
+			},
+		},
+		&repository.CreateEnvironment{
+			Environment: "staging",
+			Config: config.EnvironmentConfig{
+				Upstream: &config.EnvironmentConfigUpstream{
+					Environment: "development",
+				},
+			},
+		},
+		&repository.CreateApplicationVersion{
+			Application: "test",
+			Team:        "team-a",
+			Version:     1,
+			Manifests: map[string]string{
+				"development": "dev",
+				"staging":     "staging",
+			},
+		},
+		&repository.CreateApplicationVersion{
+			Application: "test",
+			Team:        "team-a",
+			Version:     2,
+			Manifests: map[string]string{
+				"development": "dev",
+				"staging":     "staging",
+			},
+		},
+		&repository.DeployApplicationVersion{
+			Environment: "staging",
+			Application: "test",
+			Version:     2,
+		},
+		&repository.DeployApplicationVersion{
+			Environment: "development",
+			Application: "test",
+			Version:     1,
+		},
+	}
+	tcs := []struct {
+		Name     string
+		Since    time.Time
+		Expected []*api.DeploymentHistoryEntry
+	}{
+		{
+			Name:  "all deployments",
+			Since: time.Unix(0, 0),
+			Expected: []*api.DeploymentHistoryEntry{
+				{
+					Environment:      "development",
+					Application:      "test",
+					Team:             "team-a",
+					Version:          1,
+					DeployedAt:       timestamppb.New(time.Unix(2, 0)),
+					ReleaseCreatedAt: timestamppb.New(time.Unix(2, 0)),
+				},
+				{
+					Environment:      "development",
+					Application:      "test",
+					Team:             "team-a",
+					Version:          2,
+					DeployedAt:       timestamppb.New(time.Unix(3, 0)),
+					ReleaseCreatedAt: timestamppb.New(time.Unix(3, 0)),
+				},
+				{
+					Environment:      "staging",
+					Application:      "test",
+					Team:             "team-a",
+					Version:          2,
+					DeployedAt:       timestamppb.New(time.Unix(4, 0)),
+					ReleaseCreatedAt: timestamppb.New(time.Unix(3, 0)),
+				},
+				{
+					Environment:      "development",
+					Application:      "test",
+					Team:             "team-a",
+					Version:          1,
+					DeployedAt:       timestamppb.New(time.Unix(5, 0)),
+					ReleaseCreatedAt: timestamppb.New(time.Unix(2, 0)),
+				},
+			},
+		},
+		{
+			Name:     "no commits since",
+			Since:    time.Now().Add(time.Hour),
+			Expected: nil,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			repo, err := setupRepositoryTest(t)
+			if err != nil {
+				t.Fatalf("error setting up repository test: %v", err)
+			}
+			sv := &VersionServiceServer{Repository: repo}
+			for i, transformer := range setup {
+				ctx := repository.WithTimeNow(testutil.MakeTestContext(), time.Unix(int64(i), 0))
+				if err := repo.Apply(ctx, transformer); err != nil {
+					t.Fatal(err)
+				}
+			}
+			res, err := sv.GetDeploymentHistory(context.Background(), &api.GetDeploymentHistoryRequest{
+				Since: timestamppb.New(tc.Since),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tc.Expected, res.Deployments, protocmp.Transform()); d != "" {
+				t.Errorf("deployment history mismatch (-want, +got):\n%s", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/freiheit-com/kuberpult/pkg/logger"
+	"github.com/freiheit-com/kuberpult/pkg/setup"
+	"github.com/freiheit-com/kuberpult/pkg/uuid"
+	"github.com/freiheit-com/kuberpult/services/cd-service/pkg/notify"
+	"go.uber.org/zap"
+)
+
+const (
+	deliveryTimeout    = 30 * time.Second
+	maxRetryInterval   = 5 * time.Minute
+	queueFileExtension = ".json"
+	// the directory of the events whose commit is being pushed
+	stagedDir = "staged"
+)
+
+// Queue stores events on disk until they are delivered, so that enqueuing never waits for a receiver
+// and pending events survive a restart.
+// Each webhook has its own directory and receives its events in order.
+//
+// The events of a commit are staged before the commit is pushed and released into the queue after the push.
+// If the cd-service stops in between, Recover releases or discards them depending on whether the commit was pushed.
+type Queue struct {
+	dir       string
+	webhooks  []Config
+	retention time.Duration
+	client    *http.Client
+	notify    notify.Notify
+	// newBackOff is replaced in tests
+	newBackOff func() backoff.BackOff
+
+	mx           sync.Mutex
+	lastSequence int64
+}
+
+// NewQueue creates a queue in dir. Events older than retention are dropped if they could not be delivered.
+func NewQueue(dir string, webhooks []Config, retention time.Duration) (*Queue, error) {
+	for i := range webhooks {
+		if err := webhooks[i].validate(); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(webhookDir(dir, &webhooks[i]), 0777); err != nil {
+			return nil, fmt.Errorf("creating webhook queue: %w", err)
+		}
+	}
+	return &Queue{
+		dir:       dir,
+		webhooks:  webhooks,
+		retention: retention,
+		client:    &http.Client{Timeout: deliveryTimeout},
+		notify:    notify.Notify{},
+		newBackOff: func() backoff.BackOff {
+			eb := backoff.NewExponentialBackOff()
+			eb.MaxInterval = maxRetryInterval
+			eb.MaxElapsedTime = 0
+			return eb
+		},
+		mx:           sync.Mutex{},
+		lastSequence: 0,
+	}, nil
+}
+
+// webhookDir is derived from the url and the filters, so that changing the secret keeps pending events.
+func webhookDir(dir string, config *Config) string {
+	withoutSecret := *config
+	withoutSecret.Secret = ""
+	content, _ := json.Marshal(withoutSecret)
+	sum := sha256.Sum256(content)
+	return filepath.Join(dir, hex.EncodeToString(sum[:8]))
+}
+
+// Stage stores the events of a commit before it is pushed. The events get their ids here,
+// so that they keep them if they are released twice.
+func (q *Queue) Stage(commitId string, events []Event) error {
+	if q == nil || len(events) == 0 {
+		return nil
+	}
+	gen := uuid.RealUUIDGenerator{}
+	for i := range events {
+		if events[i].Id == "" {
+			events[i].Id = gen.Generate()
+		}
+	}
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(q.dir, stagedDir), 0777); err != nil {
+		return fmt.Errorf("staging webhook events: %w", err)
+	}
+	if err := writeFileAtomically(q.stagedFile(commitId), body); err != nil {
+		return fmt.Errorf("staging webhook events: %w", err)
+	}
+	return nil
+}
+
+// Release enqueues the staged events of a pushed commit.
+func (q *Queue) Release(commitId string) error {
+	if q == nil {
+		return nil
+	}
+	path := q.stagedFile(commitId)
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// the commit had no events
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var events []Event
+	if err := json.Unmarshal(body, &events); err != nil {
+		return fmt.Errorf("reading staged webhook events of %s: %w", commitId, err)
+	}
+	if err := q.Enqueue(events); err != nil {
+		return err
+	}
+	return remove(path)
+}
+
+// Discard drops the staged events of a commit that was not pushed.
+func (q *Queue) Discard(commitId string) error {
+	if q == nil {
+		return nil
+	}
+	return remove(q.stagedFile(commitId))
+}
+
+// Recover releases the staged events of all commits that were pushed and discards the others.
+// It must be called before new events are staged.
+func (q *Queue) Recover(pushed func(commitId string) (bool, error)) error {
+	if q == nil {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Join(q.dir, stagedDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	type staged struct {
+		commitId string
+		modTime  time.Time
+	}
+	var commits []staged
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), queueFileExtension) {
+			continue
+		}
+		commitId := strings.TrimSuffix(entry.Name(), queueFileExtension)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		commits = append(commits, staged{commitId: commitId, modTime: info.ModTime()})
+	}
+	// the events are released in the order of the pushes
+	sort.Slice(commits, func(i, j int) bool {
+		return commits[i].modTime.Before(commits[j].modTime)
+	})
+	for _, commit := range commits {
+		isPushed, err := pushed(commit.commitId)
+		if err != nil {
+			return err
+		}
+		if isPushed {
+			err = q.Release(commit.commitId)
+		} else {
+			err = q.Discard(commit.commitId)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Queue) stagedFile(commitId string) string {
+	return filepath.Join(q.dir, stagedDir, commitId+queueFileExtension)
+}
+
+// Enqueue stores the events for all webhooks with matching filters.
+func (q *Queue) Enqueue(events []Event) error {
+	if q == nil || len(events) == 0 {
+		return nil
+	}
+	gen := uuid.RealUUIDGenerator{}
+	for i := range events {
+		event := &events[i]
+		if event.Id == "" {
+			event.Id = gen.Generate()
+		}
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%020d-%s%s", q.nextSequence(), event.Id, queueFileExtension)
+		for j := range q.webhooks {
+			if !q.webhooks[j].Matches(event) {
+				continue
+			}
+			if err := writeFileAtomically(filepath.Join(webhookDir(q.dir, &q.webhooks[j]), name), body); err != nil {
+				return fmt.Errorf("enqueuing webhook: %w", err)
+			}
+		}
+	}
+	q.notify.Notify()
+	return nil
+}
+
+// nextSequence returns increasing numbers that sort the queue files in the order of the events.
+func (q *Queue) nextSequence() int64 {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	sequence := time.Now().UnixNano()
+	if sequence <= q.lastSequence {
+		sequence = q.lastSequence + 1
+	}
+	q.lastSequence = sequence
+	return sequence
+}
+
+func writeFileAtomically(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Run delivers the queued events until the context is done.
+func (q *Queue) Run(ctx context.Context, reporter *setup.HealthReporter) error {
+	reporter.ReportReady("delivering webhooks")
+	var wg sync.WaitGroup
+	for i := range q.webhooks {
+		wg.Add(1)
+		go func(config *Config) {
+			defer wg.Done()
+			q.deliverAll(ctx, config)
+		}(&q.webhooks[i])
+	}
+	wg.Wait()
+	return nil
+}
+
+func (q *Queue) deliverAll(ctx context.Context, config *Config) {
+	// Subscribe returns a channel that is ready immediately, so events from before a restart are delivered right away.
+	ch, unsubscribe := q.notify.Subscribe()
+	defer unsubscribe()
+	dir := webhookDir(q.dir, config)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
+		if err := q.deliverPending(ctx, config, dir); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// The remaining events are delivered with the next notification.
+			logger.FromContext(ctx).Error("webhook.queue", zap.String("webhook.url", config.URL), zap.Error(err))
+		}
+	}
+}
+
+// deliverPending delivers the events in the directory, including events that are enqueued in the meantime.
+func (q *Queue) deliverPending(ctx context.Context, config *Config, dir string) error {
+	for {
+		names, err := pendingFiles(dir)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return nil
+		}
+		for _, name := range names {
+			if err := q.deliverFile(ctx, config, filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func pendingFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), queueFileExtension) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// deliverFile retries the delivery of one event until it succeeds, is rejected or expires, and then removes it from the queue.
+func (q *Queue) deliverFile(ctx context.Context, config *Config, path string) error {
+	log := logger.FromContext(ctx).With(zap.String("webhook.url", config.URL), zap.String("webhook.file", filepath.Base(path)))
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Error("webhook.queue.invalid", zap.Error(err))
+		return remove(path)
+	}
+	err = backoff.RetryNotify(func() error {
+		if q.retention > 0 && time.Since(event.Time) > q.retention {
+			return backoff.Permanent(fmt.Errorf("event expired after %s", q.retention))
+		}
+		err := send(ctx, q.client, config, &event, body)
+		var permanent *errPermanent
+		if errors.As(err, &permanent) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, backoff.WithContext(q.newBackOff(), ctx), func(err error, next time.Duration) {
+		log.Warn("webhook.retry", zap.Error(err), zap.Duration("next", next))
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		log.Error("webhook.dropped", zap.String("webhook.id", event.Id), zap.Error(err))
+	}
+	return remove(path)
+}
+
+func remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing delivered webhook: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/freiheit-com/kuberpult/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type EventType string
+
+const (
+	EventReleaseCreated       EventType = "release.created"
+	EventDeployed             EventType = "deployment.deployed"
+	EventDeploymentQueued     EventType = "deployment.queued"
+	EventLockCreated          EventType = "lock.created"
+	EventLockDeleted          EventType = "lock.deleted"
+	EventReleaseTrainFinished EventType = "release_train.finished"
+	EventEnvironmentCreated   EventType = "environment.created"
+)
+
+var eventTypes = []EventType{
+	EventReleaseCreated,
+	EventDeployed,
+	EventDeploymentQueued,
+	EventLockCreated,
+	EventLockDeleted,
+	EventReleaseTrainFinished,
+	EventEnvironmentCreated,
+}
+
+// Event is the body of a webhook request.
+// Fields are only added to this struct, never renamed or removed, so that receivers can rely on the schema.
+type Event struct {
+	// Id is unique per event and stays the same when the delivery is retried.
+	Id   string    `json:"id"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Author is the email of the user that caused the event.
+	Author string `json:"author,omitempty"`
+	// Environment is set for events that concern exactly one environment.
+	Environment string `json:"environment,omitempty"`
+	// Environments is set for releases and release trains, which concern several environments.
+	Environments []string `json:"environments,omitempty"`
+	Application  string   `json:"application,omitempty"`
+	Team         string   `json:"team,omitempty"`
+	Version      uint64   `json:"version,omitempty"`
+	// SourceCommitId is the commit in the source repository of a release.
+	SourceCommitId string `json:"sourceCommitId,omitempty"`
+	LockId         string `json:"lockId,omitempty"`
+	Message        string `json:"message,omitempty"`
+	// Target is the environment or environment group of a release train.
+	Target string `json:"target,omitempty"`
+}
+
+func (e *Event) environments() []string {
+	if e.Environment != "" {
+		return []string{e.Environment}
+	}
+	return e.Environments
+}
+
+// Config describes one receiver of webhooks.
+// Empty filters match all events.
+type Config struct {
+	URL string `json:"url"`
+	// Secret is the key of the HMAC-SHA256 signature in the header X-Hub-Signature-256.
+	Secret       string      `json:"secret"`
+	Environments []string    `json:"environments,omitempty"`
+	Teams        []string    `json:"teams,omitempty"`
+	Events       []EventType `json:"events,omitempty"`
+}
+
+// ReadConfig reads a json list of webhook configs.
+func ReadConfig(path string) ([]Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading webhook config %q: %w", path, err)
+	}
+	var configs []Config
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, fmt.Errorf("parsing webhook config %q: %w", path, err)
+	}
+	for _, c := range configs {
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+func (c *Config) validate() error {
+	if c.URL == "" {
+		return errors.New("webhook config without url")
+	}
+	for _, eventType := range c.Events {
+		if !containsEventType(eventTypes, eventType) {
+			return fmt.Errorf("webhook %q: unknown event type %q", c.URL, eventType)
+		}
+	}
+	return nil
+}
+
+// Matches returns whether the event passes the filters of the webhook.
+// Events without environment or team, e.g. environment locks, never match an environment or team filter.
+func (c *Config) Matches(event *Event) bool {
+	if len(c.Events) > 0 && !containsEventType(c.Events, event.Type) {
+		return false
+	}
+	if len(c.Environments) > 0 && !containsAny(c.Environments, event.environments()) {
+		return false
+	}
+	if len(c.Teams) > 0 && !containsAny(c.Teams, []string{event.Team}) {
+		return false
+	}
+	return true
+}
+
+func containsEventType(eventTypes []EventType, eventType EventType) bool {
+	for _, t := range eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(allowed []string, values []string) bool {
+	for _, a := range allowed {
+		for _, v := range values {
+			if a == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errPermanent marks deliveries that are not retried, because the receiver rejected the request.
+type errPermanent struct {
+	inner error
+}
+
+func (e *errPermanent) Error() string {
+	return e.inner.Error()
+}
+
+func (e *errPermanent) Unwrap() error {
+	return e.inner
+}
+
+func send(ctx context.Context, client *http.Client, config *Config, event *Event, body []byte) error {
+	ctx, span := tracing.StartSpan(ctx, "webhook.notify",
+		attribute.String("webhook.url", config.URL),
+		attribute.String("webhook.id", event.Id),
+		attribute.String("webhook.type", string(event.Type)),
+	)
+	defer span.End()
+	h := hmac.New(sha256.New, []byte(config.Secret))
+	h.Write(body)
+	sha := "sha256=" + hex.EncodeToString(h.Sum(nil))
+	r, err := http.NewRequestWithContext(ctx, "POST", config.URL, bytes.NewReader(body))
+	if err != nil {
+		return &errPermanent{fmt.Errorf("creating http request: %w", err)}
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Hub-Signature-256", sha)
+	r.Header.Set("X-Kuberpult-Event", string(event.Type))
+	r.Header.Set("X-Kuberpult-Delivery", event.Id)
+	r.Header.Set("User-Agent", "kuberpult")
+	s, err := client.Do(r)
+	if err != nil {
+		tracing.EndSpan(span, err)
+		return err
+	}
+	span.SetAttributes(attribute.String("http.status_code", s.Status))
+	defer s.Body.Close()
+	content, _ := io.ReadAll(s.Body)
+	if s.StatusCode > 299 {
+		err := fmt.Errorf("http status (%d): %s", s.StatusCode, content)
+		// Client errors are retried only if the receiver asks for it.
+		if s.StatusCode >= 400 && s.StatusCode < 500 && s.StatusCode != http.StatusRequestTimeout && s.StatusCode != http.StatusTooManyRequests {
+			return &errPermanent{err}
+		}
+		return err
+	}
+	return nil
+}
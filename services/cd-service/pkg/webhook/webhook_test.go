@@ -0,0 +1,422 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	backoff "github.com/cenkalti/backoff/v4"
+	"github.com/freiheit-com/kuberpult/pkg/setup"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConfigMatches(t *testing.T) {
+	tcs := []struct {
+		Name     string
+		Config   Config
+		Event    Event
+		Expected bool
+	}{
+		{
+			Name:     "without filters",
+			Config:   Config{URL: "http://example.com"},
+			Event:    Event{Type: EventLockCreated, Environment: "production"},
+			Expected: true,
+		},
+		{
+			Name:     "filtered by event type",
+			Config:   Config{URL: "http://example.com", Events: []EventType{EventDeployed}},
+			Event:    Event{Type: EventLockCreated, Environment: "production"},
+			Expected: false,
+		},
+		{
+			Name:     "filtered by environment",
+			Config:   Config{URL: "http://example.com", Environments: []string{"production"}},
+			Event:    Event{Type: EventDeployed, Environment: "production"},
+			Expected: true,
+		},
+		{
+			Name:     "filtered by one of several environments",
+			Config:   Config{URL: "http://example.com", Environments: []string{"production"}},
+			Event:    Event{Type: EventReleaseCreated, Environments: []string{"development", "production"}},
+			Expected: true,
+		},
+		{
+			Name:     "filtered by other environment",
+			Config:   Config{URL: "http://example.com", Environments: []string{"production"}},
+			Event:    Event{Type: EventDeployed, Environment: "development"},
+			Expected: false,
+		},
+		{
+			Name:     "filtered by team",
+			Config:   Config{URL: "http://example.com", Teams: []string{"team1"}},
+			Event:    Event{Type: EventDeployed, Environment: "production", Team: "team1"},
+			Expected: true,
+		},
+		{
+			Name:     "events without team do not match a team filter",
+			Config:   Config{URL: "http://example.com", Teams: []string{"team1"}},
+			Event:    Event{Type: EventLockCreated, Environment: "production"},
+			Expected: false,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			if actual := tc.Config.Matches(&tc.Event); actual != tc.Expected {
+				t.Errorf("expected %t, got %t", tc.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	tcs := []struct {
+		Name          string
+		Content       string
+		Expected      []Config
+		ExpectedError string
+	}{
+		{
+			Name:    "reads filters",
+			Content: `[{"url":"http://example.com","secret":"s3cr3t","environments":["production"],"teams":["team1"],"events":["deployment.deployed"]}]`,
+			Expected: []Config{
+				{
+					URL:          "http://example.com",
+					Secret:       "s3cr3t",
+					Environments: []string{"production"},
+					Teams:        []string{"team1"},
+					Events:       []EventType{EventDeployed},
+				},
+			},
+		},
+		{
+			Name:          "rejects unknown event types",
+			Content:       `[{"url":"http://example.com","events":["deployed"]}]`,
+			ExpectedError: `webhook "http://example.com": unknown event type "deployed"`,
+		},
+		{
+			Name:          "rejects webhooks without url",
+			Content:       `[{"secret":"s3cr3t"}]`,
+			ExpectedError: "webhook config without url",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "webhooks.json")
+			if err := os.WriteFile(path, []byte(tc.Content), 0666); err != nil {
+				t.Fatal(err)
+			}
+			actual, err := ReadConfig(path)
+			if tc.ExpectedError != "" {
+				if err == nil || err.Error() != tc.ExpectedError {
+					t.Fatalf("expected error %q, got %v", tc.ExpectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := cmp.Diff(tc.Expected, actual); d != "" {
+				t.Errorf("config mismatch: %s", d)
+			}
+		})
+	}
+}
+
+type receivedRequest struct {
+	Event     string
+	Signature bool
+	Body      Event
+}
+
+type receiver struct {
+	mx       sync.Mutex
+	secret   string
+	statuses []int
+	received []receivedRequest
+	done     chan struct{}
+	expected int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	h := hmac.New(sha256.New, []byte(r.secret))
+	h.Write(body)
+	var event Event
+	json.Unmarshal(body, &event)
+	r.received = append(r.received, receivedRequest{
+		Event:     req.Header.Get("X-Kuberpult-Event"),
+		Signature: req.Header.Get("X-Hub-Signature-256") == "sha256="+hex.EncodeToString(h.Sum(nil)),
+		Body:      event,
+	})
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status = r.statuses[0]
+		r.statuses = r.statuses[1:]
+	}
+	w.WriteHeader(status)
+	if len(r.received) == r.expected {
+		close(r.done)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	tcs := []struct {
+		Name             string
+		Statuses         []int
+		Webhook          Config
+		Events           []Event
+		ExpectedRequests []receivedRequest
+	}{
+		{
+			Name:    "delivers matching events in order",
+			Webhook: Config{Secret: "s3cr3t", Environments: []string{"production"}},
+			Events: []Event{
+				{Id: "1", Type: EventLockCreated, Time: now, Environment: "production", LockId: "l1"},
+				{Id: "2", Type: EventLockCreated, Time: now, Environment: "development", LockId: "l2"},
+				{Id: "3", Type: EventLockDeleted, Time: now, Environment: "production", LockId: "l1"},
+			},
+			ExpectedRequests: []receivedRequest{
+				{
+					Event:     "lock.created",
+					Signature: true,
+					Body:      Event{Id: "1", Type: EventLockCreated, Time: now, Environment: "production", LockId: "l1"},
+				},
+				{
+					Event:     "lock.deleted",
+					Signature: true,
+					Body:      Event{Id: "3", Type: EventLockDeleted, Time: now, Environment: "production", LockId: "l1"},
+				},
+			},
+		},
+		{
+			Name:     "retries server errors",
+			Statuses: []int{http.StatusInternalServerError, http.StatusServiceUnavailable},
+			Webhook:  Config{Secret: "s3cr3t"},
+			Events: []Event{
+				{Id: "1", Type: EventDeployed, Time: now, Environment: "production", Application: "app1", Version: 3},
+			},
+			ExpectedRequests: []receivedRequest{
+				{
+					Event:     "deployment.deployed",
+					Signature: true,
+					Body:      Event{Id: "1", Type: EventDeployed, Time: now, Environment: "production", Application: "app1", Version: 3},
+				},
+				{
+					Event:     "deployment.deployed",
+					Signature: true,
+					Body:      Event{Id: "1", Type: EventDeployed, Time: now, Environment: "production", Application: "app1", Version: 3},
+				},
+				{
+					Event:     "deployment.deployed",
+					Signature: true,
+					Body:      Event{Id: "1", Type: EventDeployed, Time: now, Environment: "production", Application: "app1", Version: 3},
+				},
+			},
+		},
+		{
+			Name:     "drops events that the receiver rejects",
+			Statuses: []int{http.StatusBadRequest},
+			Webhook:  Config{Secret: "s3cr3t"},
+			Events: []Event{
+				{Id: "1", Type: EventEnvironmentCreated, Time: now, Environment: "production"},
+				{Id: "2", Type: EventEnvironmentCreated, Time: now, Environment: "staging"},
+			},
+			ExpectedRequests: []receivedRequest{
+				{
+					Event:     "environment.created",
+					Signature: true,
+					Body:      Event{Id: "1", Type: EventEnvironmentCreated, Time: now, Environment: "production"},
+				},
+				{
+					Event:     "environment.created",
+					Signature: true,
+					Body:      Event{Id: "2", Type: EventEnvironmentCreated, Time: now, Environment: "staging"},
+				},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			rcv := &receiver{
+				secret:   tc.Webhook.Secret,
+				statuses: tc.Statuses,
+				done:     make(chan struct{}),
+				expected: len(tc.ExpectedRequests),
+			}
+			srv := httptest.NewServer(rcv)
+			defer srv.Close()
+			tc.Webhook.URL = srv.URL
+			dir := t.TempDir()
+			// The events are enqueued before the delivery starts, like events that were left from before a restart.
+			before, err := NewQueue(dir, []Config{tc.Webhook}, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := before.Enqueue(tc.Events); err != nil {
+				t.Fatal(err)
+			}
+			queue, err := NewQueue(dir, []Config{tc.Webhook}, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			queue.newBackOff = func() backoff.BackOff {
+				return backoff.NewConstantBackOff(time.Millisecond)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			hs := &setup.HealthServer{}
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- queue.Run(ctx, hs.Reporter("webhooks"))
+			}()
+			select {
+			case <-rcv.done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the webhooks")
+			}
+			waitForEmptyQueue(t, webhookDir(dir, &tc.Webhook))
+			cancel()
+			if err := <-errCh; err != nil {
+				t.Fatal(err)
+			}
+			rcv.mx.Lock()
+			defer rcv.mx.Unlock()
+			if d := cmp.Diff(tc.ExpectedRequests, rcv.received); d != "" {
+				t.Errorf("requests mismatch: %s", d)
+			}
+		})
+	}
+}
+
+// waitForEmptyQueue waits until the delivered events are removed from the queue
+func waitForEmptyQueue(t *testing.T, dir string) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		pending, err := pendingFiles(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) == 0 {
+			return
+		}
+	}
+	t.Fatal("timed out waiting for the queue to be empty")
+}
+
+func TestQueueDropsExpiredEvents(t *testing.T) {
+	rcv := &receiver{
+		done:     make(chan struct{}),
+		expected: 1,
+	}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	dir := t.TempDir()
+	queue, err := NewQueue(dir, []Config{{URL: srv.URL}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = queue.Enqueue([]Event{
+		{Id: "1", Type: EventDeployed, Time: time.Now().Add(-2 * time.Hour)},
+		{Id: "2", Type: EventDeployed, Time: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hs := &setup.HealthServer{}
+	go queue.Run(ctx, hs.Reporter("webhooks"))
+	select {
+	case <-rcv.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the webhook")
+	}
+	rcv.mx.Lock()
+	defer rcv.mx.Unlock()
+	if rcv.received[0].Body.Id != "2" {
+		t.Errorf("expected only the second event to be delivered, got %v", rcv.received)
+	}
+}
+
+func TestQueueRecover(t *testing.T) {
+	dir := t.TempDir()
+	webhooks := []Config{{URL: "https://example.com/webhook"}}
+	queue, err := NewQueue(dir, webhooks, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Stage("pushed", []Event{{Type: EventLockCreated, LockId: "l1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Stage("not-pushed", []Event{{Type: EventLockCreated, LockId: "l2"}}); err != nil {
+		t.Fatal(err)
+	}
+	// the cd-service stopped before the events were released
+	restarted, err := NewQueue(dir, webhooks, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restarted.Recover(func(commitId string) (bool, error) {
+		return commitId == "pushed", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := os.ReadDir(filepath.Join(dir, stagedDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staged) != 0 {
+		t.Errorf("expected no staged events, got %d", len(staged))
+	}
+	names, err := pendingFiles(webhookDir(dir, &webhooks[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("expected one queued event, got %v", names)
+	}
+	content, err := os.ReadFile(filepath.Join(webhookDir(dir, &webhooks[0]), names[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := json.Unmarshal(content, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.LockId != "l1" || event.Id == "" {
+		t.Errorf("expected the event of the pushed commit with an id, got %v", event)
+	}
+}
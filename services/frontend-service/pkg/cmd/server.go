@@ -20,6 +20,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -45,6 +46,7 @@ import (
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"github.com/kelseyhightower/envconfig"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"google.golang.org/api/idtoken"
 	"google.golang.org/grpc"
@@ -52,8 +54,6 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
-	grpctrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/google.golang.org/grpc"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
 var c config.ServerConfig
@@ -106,6 +106,18 @@ func runServer(ctx context.Context) error {
 			return err
 		}
 	}
+	var roleResolver *auth.RoleResolver = nil
+	if c.RbacEnabled && !c.DexEnabled {
+		// Without Dex, the roles are read from the IAP or Azure token and from the static role mapping.
+		roleResolver = &auth.RoleResolver{Claim: c.RbacRoleClaim}
+		if c.RbacRoleMappingPath != "" {
+			roleResolver.Mapping, err = auth.ReadRoleMapping(c.RbacRoleMappingPath)
+			if err != nil {
+				logger.FromContext(ctx).Error("rbac.roleMapping.read", zap.Error(err))
+				return err
+			}
+		}
+	}
 	logger.FromContext(ctx).Info("config.gke_project_number: " + c.GKEProjectNumber + "\n")
 	logger.FromContext(ctx).Info("config.gke_backend_service_id: " + c.GKEBackendServiceID + "\n")
 
@@ -134,25 +146,18 @@ func runServer(ctx context.Context) error {
 		grpc.WithTransportCredentials(cred),
 	}
 
+	var grpcServerOpts []grpc.ServerOption
+
 	if c.EnableTracing {
-		tracer.Start()
-		defer tracer.Stop()
-
-		grpcStreamInterceptors = append(grpcStreamInterceptors,
-			grpctrace.StreamServerInterceptor(grpctrace.WithServiceName(tracing.ServiceName("kuberpult-frontend-service"))),
-		)
-		grpcUnaryInterceptors = append(grpcUnaryInterceptors,
-			grpctrace.UnaryServerInterceptor(grpctrace.WithServiceName(tracing.ServiceName("kuberpult-frontend-service"))),
-		)
-
-		grpcClientOpts = append(grpcClientOpts,
-			grpc.WithStreamInterceptor(
-				grpctrace.StreamClientInterceptor(grpctrace.WithServiceName(tracing.ServiceName("kuberpult-frontend-service"))),
-			),
-			grpc.WithUnaryInterceptor(
-				grpctrace.UnaryClientInterceptor(grpctrace.WithServiceName(tracing.ServiceName("kuberpult-frontend-service"))),
-			),
-		)
+		shutdownTracing, err := tracing.Init(ctx, c.TracingExporter, tracing.ServiceName("kuberpult-frontend-service"))
+		if err != nil {
+			logger.FromContext(ctx).Fatal("tracing.init.error", zap.Error(err))
+		}
+		defer shutdownTracing(context.Background()) //nolint:errcheck
+
+		grpcServerOpts = append(grpcServerOpts, tracing.ServerOption())
+		// The trace context is propagated to the cd-service and the rollout-service.
+		grpcClientOpts = append(grpcClientOpts, tracing.DialOption())
 	}
 
 	var defaultUser = auth.User{
@@ -197,10 +202,10 @@ func runServer(ctx context.Context) error {
 		return err
 	}
 
-	gsrv := grpc.NewServer(
+	gsrv := grpc.NewServer(append(grpcServerOpts,
 		grpc.ChainStreamInterceptor(grpcStreamInterceptors...),
 		grpc.ChainUnaryInterceptor(grpcUnaryInterceptors...),
-	)
+	)...)
 	cdCon, err := grpc.Dial(c.CdServer, grpcClientOpts...)
 	if err != nil {
 		logger.FromContext(ctx).Fatal("grpc.dial.error", zap.Error(err), zap.String("addr", c.CdServer))
@@ -223,11 +228,17 @@ func runServer(ctx context.Context) error {
 		BatchClient:          batchClient,
 		RolloutServiceClient: rolloutClient,
 		GitClient:            api.NewGitServiceClient(cdCon),
+		RbacClient:           api.NewRbacServiceClient(cdCon),
+		AuditClient:          api.NewAuditServiceClient(cdCon),
+		QueueClient:          api.NewQueueServiceClient(cdCon),
 	}
 	api.RegisterOverviewServiceServer(gsrv, gproxy)
 	api.RegisterBatchServiceServer(gsrv, gproxy)
 	api.RegisterRolloutServiceServer(gsrv, gproxy)
 	api.RegisterGitServiceServer(gsrv, gproxy)
+	api.RegisterRbacServiceServer(gsrv, gproxy)
+	api.RegisterAuditServiceServer(gsrv, gproxy)
+	api.RegisterQueueServiceServer(gsrv, gproxy)
 
 	frontendConfigService := &service.FrontendConfigServiceServer{
 		Config: config.FrontendConfig{
@@ -252,11 +263,14 @@ func runServer(ctx context.Context) error {
 
 	grpcWebServer := grpcweb.WrapServer(gsrv)
 	httpHandler := handler.Server{
-		BatchClient:   batchClient,
-		RolloutClient: rolloutClient,
-		Config:        c,
-		KeyRing:       pgpKeyRing,
-		AzureAuth:     c.AzureEnableAuth,
+		BatchClient:    batchClient,
+		RolloutClient:  rolloutClient,
+		AuditClient:    gproxy.AuditClient,
+		OverviewClient: gproxy.OverviewClient,
+		GitClient:      gproxy.GitClient,
+		Config:         c,
+		KeyRing:        pgpKeyRing,
+		AzureAuth:      c.AzureEnableAuth,
 	}
 	mux := http.NewServeMux()
 	mux.Handle("/environments/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -266,6 +280,27 @@ func runServer(ctx context.Context) error {
 		}
 		httpHandler.Handle(w, req)
 	}))
+	mux.Handle("/environments", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer readAllAndClose(req.Body, 1024)
+		if c.DexEnabled {
+			interceptors.DexLoginInterceptor(w, req, httpHandler, c.DexClientId, c.DexClientSecret)
+		}
+		httpHandler.Handle(w, req)
+	}))
+	mux.Handle("/applications/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer readAllAndClose(req.Body, 1024)
+		if c.DexEnabled {
+			interceptors.DexLoginInterceptor(w, req, httpHandler, c.DexClientId, c.DexClientSecret)
+		}
+		httpHandler.Handle(w, req)
+	}))
+	mux.Handle("/product-summary", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer readAllAndClose(req.Body, 1024)
+		if c.DexEnabled {
+			interceptors.DexLoginInterceptor(w, req, httpHandler, c.DexClientId, c.DexClientSecret)
+		}
+		httpHandler.Handle(w, req)
+	}))
 	mux.Handle("/environment-groups/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		defer readAllAndClose(req.Body, 1024)
 		if c.DexEnabled {
@@ -281,6 +316,14 @@ func runServer(ctx context.Context) error {
 		httpHandler.Handle(w, req)
 	}))
 
+	mux.Handle("/api/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer readAllAndClose(req.Body, 1024)
+		if c.DexEnabled {
+			interceptors.DexLoginInterceptor(w, req, httpHandler, c.DexClientId, c.DexClientSecret)
+		}
+		httpHandler.Handle(w, req)
+	}))
+
 	mux.Handle("/", http.FileServer(http.Dir("build")))
 	// Split HTTP REST from gRPC Web requests, as suggested in the documentation:
 	// https://pkg.go.dev/github.com/improbable-eng/grpc-web@v0.15.0/go/grpcweb
@@ -348,9 +391,11 @@ func runServer(ctx context.Context) error {
 		}
 	})
 	authHandler := &Auth{
-		HttpServer:  splitGrpcHandler,
-		DefaultUser: defaultUser,
-		KeyRing:     pgpKeyRing,
+		HttpServer:   splitGrpcHandler,
+		DefaultUser:  defaultUser,
+		KeyRing:      pgpKeyRing,
+		RoleResolver: roleResolver,
+		Jwks:         jwks,
 	}
 	corsHandler := &setup.CORSMiddleware{
 		PolicyFor: func(r *http.Request) *setup.CORSPolicy {
@@ -382,28 +427,33 @@ type Auth struct {
 	DefaultUser auth.User
 	// KeyRing is as of now required because we do not have technical users yet. So we protect public endpoints by requiring a signature
 	KeyRing openpgp.KeyRing
+	// RoleResolver determines the RBAC role of the user. Only set if RBAC is enabled without Dex.
+	RoleResolver *auth.RoleResolver
+	// Jwks is used to read the role claims of azure tokens.
+	Jwks *keyfunc.JWKS
 }
 
-func getRequestAuthorFromGoogleIAP(ctx context.Context, r *http.Request) *auth.User {
+// getRequestAuthorFromGoogleIAP returns the user and the validated claims of the iap token.
+func getRequestAuthorFromGoogleIAP(ctx context.Context, r *http.Request) (*auth.User, map[string]interface{}) {
 	iapJWT := r.Header.Get("X-Goog-IAP-JWT-Assertion")
 
 	if iapJWT == "" {
 		// not using iap (local), default user
 		logger.FromContext(ctx).Info("iap.jwt header was not found or doesn't exist")
-		return nil
+		return nil, nil
 	}
 
 	if c.GKEProjectNumber == "" || c.GKEBackendServiceID == "" {
 		// environment variables not set up correctly
 		logger.FromContext(ctx).Info("iap.jke environment variables are not set up correctly")
-		return nil
+		return nil, nil
 	}
 
 	aud := fmt.Sprintf("/projects/%s/global/backendServices/%s", c.GKEProjectNumber, c.GKEBackendServiceID)
 	payload, err := idtoken.Validate(ctx, iapJWT, aud)
 	if err != nil {
 		logger.FromContext(ctx).Warn("iap.idtoken.validate", zap.Error(err))
-		return nil
+		return nil, nil
 	}
 
 	// here, we can use People api later to get the full name
@@ -412,40 +462,67 @@ func getRequestAuthorFromGoogleIAP(ctx context.Context, r *http.Request) *auth.U
 	u := &auth.User{
 		Email: payload.Claims["email"].(string),
 	}
-	return u
+	return u, payload.Claims
 }
 
 func getRequestAuthorFromAzure(ctx context.Context, r *http.Request) (*auth.User, error) {
 	return auth.ReadUserFromHttpHeader(ctx, r)
 }
 
+// getClaimsFromAzure returns the validated claims of the azure token, or nil if the request has no valid token.
+func getClaimsFromAzure(ctx context.Context, r *http.Request, jwks *keyfunc.JWKS) map[string]interface{} {
+	token := r.Header.Get("authorization")
+	if token == "" {
+		return nil
+	}
+	claims, err := auth.ValidateToken(token, jwks, c.AzureClientId, c.AzureTenantId)
+	if err != nil {
+		logger.FromContext(ctx).Warn("azure.token.validate", zap.Error(err))
+		return nil
+	}
+	return claims
+}
+
 func (p *Auth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logger.Wrap(r.Context(), func(ctx context.Context) error {
-		span, ctx := tracer.StartSpanFromContext(ctx, "ServeHTTP")
-		defer span.Finish()
+		ctx, span := tracing.StartSpan(ctx, "ServeHTTP")
+		defer span.End()
 		var user *auth.User = nil
 		var err error = nil
 		var source = ""
+		var claims map[string]interface{} = nil
 		if c.AzureEnableAuth {
 			user, err = getRequestAuthorFromAzure(ctx, r)
 			if err != nil {
 				return err
 			}
+			if p.RoleResolver != nil {
+				claims = getClaimsFromAzure(ctx, r, p.Jwks)
+			}
 			source = "azure"
 		} else {
-			user = getRequestAuthorFromGoogleIAP(ctx, r)
+			user, claims = getRequestAuthorFromGoogleIAP(ctx, r)
 			source = "iap"
 		}
 		if user != nil {
-			span.SetTag("current-user-name", user.Name)
-			span.SetTag("current-user-email", user.Email)
-			span.SetTag("current-user-source", source)
+			span.SetAttributes(
+				attribute.String("current-user-name", user.Name),
+				attribute.String("current-user-email", user.Email),
+				attribute.String("current-user-source", source),
+			)
 		}
 		combinedUser := auth.GetUserOrDefault(user, p.DefaultUser)
 
 		auth.WriteUserToHttpHeader(r, combinedUser)
 		ctx = auth.WriteUserToContext(ctx, combinedUser)
 		ctx = auth.WriteUserToGrpcContext(ctx, combinedUser)
+		if p.RoleResolver != nil {
+			// The role is always overwritten, so that it cannot be set by the client.
+			role := p.RoleResolver.Resolve(claims)
+			span.SetAttributes(attribute.String("current-user-role", role))
+			auth.WriteUserRoleToHttpHeader(r, role)
+			ctx = auth.WriteUserRoleToGrpcContext(ctx, role)
+		}
 		p.HttpServer.ServeHTTP(w, r.WithContext(ctx))
 		return nil
 	})
@@ -459,20 +536,65 @@ type GrpcProxy struct {
 	BatchClient          api.BatchServiceClient
 	RolloutServiceClient api.RolloutServiceClient
 	GitClient            api.GitServiceClient
+	RbacClient           api.RbacServiceClient
+	AuditClient          api.AuditServiceClient
+	QueueClient          api.QueueServiceClient
 }
 
 func (p *GrpcProxy) ProcessBatch(
 	ctx context.Context,
 	in *api.BatchRequest) (*api.BatchResponse, error) {
+	if err := checkBatchActions(ctx, in); err != nil {
+		return nil, err
+	}
+	return p.BatchClient.ProcessBatch(ctx, in)
+}
+
+func (p *GrpcProxy) SubmitBatch(
+	ctx context.Context,
+	in *api.BatchRequest) (*api.SubmitBatchResponse, error) {
+	if err := checkBatchActions(ctx, in); err != nil {
+		return nil, err
+	}
+	return p.BatchClient.SubmitBatch(ctx, in)
+}
+
+func checkBatchActions(ctx context.Context, in *api.BatchRequest) error {
 	for i := range in.Actions {
 		batchAction := in.GetActions()[i]
 		switch batchAction.Action.(type) {
 		case *api.BatchAction_CreateRelease:
-			return nil, grpcerrors.PublicError(ctx, fmt.Errorf("action create-release is only supported via http in the frontend-service"))
+			return grpcerrors.PublicError(ctx, fmt.Errorf("action create-release is only supported via http in the frontend-service"))
 		}
 	}
+	return nil
+}
 
-	return p.BatchClient.ProcessBatch(ctx, in)
+func (p *GrpcProxy) GetBatchJob(
+	ctx context.Context,
+	in *api.GetBatchJobRequest) (*api.BatchJob, error) {
+	return p.BatchClient.GetBatchJob(ctx, in)
+}
+
+func (p *GrpcProxy) WatchBatchJob(
+	in *api.GetBatchJobRequest,
+	stream api.BatchService_WatchBatchJobServer) error {
+	resp, err := p.BatchClient.WatchBatchJob(stream.Context(), in)
+	if err != nil {
+		return err
+	}
+	for {
+		item, err := resp.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := stream.Send(item); err != nil {
+			return err
+		}
+	}
 }
 
 func (p *GrpcProxy) GetOverview(
@@ -499,6 +621,36 @@ func (p *GrpcProxy) GetCommitInfo(
 	return p.GitClient.GetCommitInfo(ctx, in)
 }
 
+func (p *GrpcProxy) FindCommitDeployments(
+	ctx context.Context,
+	in *api.FindCommitDeploymentsRequest) (*api.FindCommitDeploymentsResponse, error) {
+	return p.GitClient.FindCommitDeployments(ctx, in)
+}
+
+func (p *GrpcProxy) GetChangelog(
+	ctx context.Context,
+	in *api.GetChangelogRequest) (*api.GetChangelogResponse, error) {
+	return p.GitClient.GetChangelog(ctx, in)
+}
+
+func (p *GrpcProxy) ExplainPermission(
+	ctx context.Context,
+	in *api.ExplainPermissionRequest) (*api.ExplainPermissionResponse, error) {
+	return p.RbacClient.ExplainPermission(ctx, in)
+}
+
+func (p *GrpcProxy) QueryAuditLog(
+	ctx context.Context,
+	in *api.QueryAuditLogRequest) (*api.QueryAuditLogResponse, error) {
+	return p.AuditClient.QueryAuditLog(ctx, in)
+}
+
+func (p *GrpcProxy) GetQueueStatus(
+	ctx context.Context,
+	in *api.GetQueueStatusRequest) (*api.GetQueueStatusResponse, error) {
+	return p.QueueClient.GetQueueStatus(ctx, in)
+}
+
 func (p *GrpcProxy) StreamOverview(
 	in *api.GetOverviewRequest,
 	stream api.OverviewService_StreamOverviewServer) error {
@@ -543,3 +695,10 @@ func (p *GrpcProxy) GetStatus(ctx context.Context, in *api.GetStatusRequest) (*a
 	}
 	return p.RolloutServiceClient.GetStatus(ctx, in)
 }
+
+func (p *GrpcProxy) GetDoraMetrics(ctx context.Context, in *api.GetDoraMetricsRequest) (*api.GetDoraMetricsResponse, error) {
+	if p.RolloutServiceClient == nil {
+		return nil, status.Error(codes.Unimplemented, "rollout service not configured")
+	}
+	return p.RolloutServiceClient.GetDoraMetrics(ctx, in)
+}
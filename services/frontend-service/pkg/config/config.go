@@ -26,6 +26,7 @@ type ServerConfig struct {
 	GKEProjectNumber    string        `default:"" split_words:"true"`
 	GKEBackendServiceID string        `default:"" split_words:"true"`
 	EnableTracing       bool          `default:"false" split_words:"true"`
+	TracingExporter     string        `default:"datadog" split_words:"true"`
 	ArgocdBaseUrl       string        `default:"" split_words:"true"`
 	PgpKeyRingPath      string        `split_words:"true"`
 	AzureEnableAuth     bool          `default:"false" split_words:"true"`
@@ -38,6 +39,9 @@ type ServerConfig struct {
 	DexClientSecret     string        `default:"" split_words:"true"`
 	DexBaseURL          string        `default:"" split_words:"true"`
 	DexScopes           string        `default:"" split_words:"true"`
+	RbacEnabled         bool          `default:"false" split_words:"true"`
+	RbacRoleClaim       string        `default:"groups" split_words:"true"`
+	RbacRoleMappingPath string        `default:"" split_words:"true"`
 	Version             string        `default:""`
 	SourceRepoUrl       string        `default:"" split_words:"true"`
 	ManifestRepoUrl     string        `default:"" split_words:"true"`
@@ -18,57 +18,19 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
-	xpath "github.com/freiheit-com/kuberpult/pkg/path"
 )
 
-func (s Server) handleApplications(w http.ResponseWriter, req *http.Request, environment, tail string) {
-	application, tail := xpath.Shift(tail)
-	if application == "" {
-		http.Error(w, "missing application ID", http.StatusNotFound)
-		return
-	}
-
-	function, tail := xpath.Shift(tail)
-	switch function {
-	case "locks":
-		s.handleApplicationLocks(w, req, environment, application, tail)
-	default:
-		http.Error(w, fmt.Sprintf("unknown function '%s'", function), http.StatusNotFound)
-	}
-}
-
-func (s Server) handleApplicationLocks(w http.ResponseWriter, req *http.Request, environment, application, tail string) {
-	lockID, tail := xpath.Shift(tail)
-	if lockID == "" {
-		http.Error(w, "missing lock ID", http.StatusNotFound)
-		return
-	}
-	if tail != "/" {
-		http.Error(w, fmt.Sprintf("locks does not accept additional path arguments after the lock ID, got: %s", tail), http.StatusNotFound)
-		return
-	}
-
-	switch req.Method {
-	case http.MethodPut:
-		s.handlePutApplicationLock(w, req, environment, application, lockID)
-	case http.MethodDelete:
-		s.handleDeleteApplicationLock(w, req, environment, application, lockID)
-	default:
-		http.Error(w, fmt.Sprintf("unsupported method '%s'", req.Method), http.StatusMethodNotAllowed)
-	}
-}
-
 func (s Server) handlePutApplicationLock(w http.ResponseWriter, req *http.Request, environment, application, lockID string) {
-	contentType := req.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		http.Error(w, fmt.Sprintf("body must be application/json, got: '%s'", contentType), http.StatusUnsupportedMediaType)
-		return
-	}
-
 	var body putLockRequest
 	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -111,3 +73,91 @@ func (s Server) handleDeleteApplicationLock(w http.ResponseWriter, req *http.Req
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func (s Server) handleApplicationDeploy(w http.ResponseWriter, req *http.Request, environment, application string) {
+	var body putDeployRequest
+	invalidMessage := "Please provide the version in body"
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		decodeError := err.Error()
+		if errors.Is(err, io.EOF) {
+			decodeError = invalidMessage
+		}
+		http.Error(w, decodeError, http.StatusBadRequest)
+		return
+	}
+	if body.Version == 0 {
+		http.Error(w, invalidMessage, http.StatusBadRequest)
+		return
+	}
+	lockBehavior, err := parseLockBehavior(body.LockBehavior)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if s.AzureAuth {
+		signature := body.Signature
+		if len(signature) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Missing signature in request body"))
+			return
+		}
+
+		signedData := deploySignedData(environment, application, body.Version, lockBehavior, body.ExpectedCurrentVersion)
+		if _, err := openpgp.CheckArmoredDetachedSignature(s.KeyRing, strings.NewReader(signedData), strings.NewReader(signature), nil); err != nil {
+			if err != pgperrors.ErrUnknownIssuer {
+				w.WriteHeader(500)
+				fmt.Fprintf(w, "Internal: Invalid Signature: %s", err)
+				return
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, "Invalid signature")
+			return
+		}
+	}
+
+	_, err = s.BatchClient.ProcessBatch(req.Context(), &api.BatchRequest{
+		Actions: []*api.BatchAction{
+			{Action: &api.BatchAction_Deploy{
+				Deploy: &api.DeployRequest{
+					Environment:            environment,
+					Application:            application,
+					Version:                body.Version,
+					LockBehavior:           lockBehavior,
+					ExpectedCurrentVersion: body.ExpectedCurrentVersion,
+				},
+			}},
+		},
+		ExpectedGitRevision: body.ExpectedGitRevision,
+	})
+	if err != nil {
+		handleGRPCError(req.Context(), w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deploySignedData also covers the lock behavior and the expected version,
+// so that a signature cannot be replayed with "ignore" to deploy past locks.
+func deploySignedData(environment, application string, version uint64, lockBehavior api.LockBehavior, expectedCurrentVersion *uint64) string {
+	signedData := environment + application + strconv.FormatUint(version, 10) + strings.ToLower(lockBehavior.String())
+	if expectedCurrentVersion != nil {
+		signedData += strconv.FormatUint(*expectedCurrentVersion, 10)
+	}
+	return signedData
+}
+
+// parseLockBehavior defaults to recording the deployment as a queued version if the application is locked.
+func parseLockBehavior(lockBehavior string) (api.LockBehavior, error) {
+	switch lockBehavior {
+	case "", "record":
+		return api.LockBehavior_RECORD, nil
+	case "fail":
+		return api.LockBehavior_FAIL, nil
+	case "ignore":
+		return api.LockBehavior_IGNORE, nil
+	default:
+		return api.LockBehavior_FAIL, fmt.Errorf("invalid lockBehavior '%s', must be one of 'record', 'fail' or 'ignore'", lockBehavior)
+	}
+}
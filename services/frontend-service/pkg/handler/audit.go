@@ -0,0 +1,121 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type auditLogEntry struct {
+	Id          string    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	UserName    string    `json:"userName"`
+	UserEmail   string    `json:"userEmail"`
+	Role        string    `json:"role"`
+	Action      string    `json:"action"`
+	Parameters  string    `json:"parameters"`
+	Environment string    `json:"environment"`
+	Application string    `json:"application"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error"`
+	CommitId    string    `json:"commitId"`
+}
+
+// handleAuditLog exports the audit log as json, e.g. for compliance reviews.
+// Supported query parameters are user, environment, application, from, to (both RFC3339) and limit.
+func (s Server) handleAuditLog(w http.ResponseWriter, req *http.Request) {
+	if s.AuditClient == nil {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+		return
+	}
+	ctx := req.Context()
+	query := req.URL.Query()
+	request := &api.QueryAuditLogRequest{
+		User:        query.Get("user"),
+		Environment: query.Get("environment"),
+		Application: query.Get("application"),
+	}
+	for name, target := range map[string]**timestamppb.Timestamp{"from": &request.From, "to": &request.To} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s: '%s' is not in RFC3339 format", name, value), http.StatusBadRequest)
+				return
+			}
+			*target = timestamppb.New(t)
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid limit: '%s'", value), http.StatusBadRequest)
+			return
+		}
+		request.Limit = uint32(limit)
+	}
+	response, err := s.AuditClient.QueryAuditLog(ctx, request)
+	if err != nil {
+		handleGRPCError(ctx, w, err)
+		return
+	}
+	entries := make([]auditLogEntry, 0, len(response.Entries))
+	for _, entry := range response.Entries {
+		entries = append(entries, auditLogEntry{
+			Id:          entry.Id,
+			Timestamp:   entry.Timestamp.AsTime(),
+			UserName:    entry.UserName,
+			UserEmail:   entry.UserEmail,
+			Role:        entry.Role,
+			Action:      entry.Action,
+			Parameters:  entry.Parameters,
+			Environment: entry.Environment,
+			Application: entry.Application,
+			Outcome:     auditLogOutcomeName(entry.Outcome),
+			Error:       entry.Error,
+			CommitId:    entry.CommitId,
+		})
+	}
+	jsonResponse, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Internal error: %s", err), http.StatusInternalServerError)
+		logger.FromContext(ctx).Error("audit.json", zap.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="kuberpult-audit-log.json"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func auditLogOutcomeName(outcome api.AuditLogOutcome) string {
+	switch outcome {
+	case api.AuditLogOutcome_AUDIT_LOG_OUTCOME_SUCCESS:
+		return "success"
+	case api.AuditLogOutcome_AUDIT_LOG_OUTCOME_FAILURE:
+		return "failure"
+	}
+	return "unknown"
+}
@@ -0,0 +1,91 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+)
+
+type changelogResponse struct {
+	Application     string                    `json:"application"`
+	Environment     string                    `json:"environment"`
+	DeployedVersion *uint64                   `json:"deployedVersion,omitempty"`
+	Version         uint64                    `json:"version"`
+	FromCommitId    string                    `json:"fromCommitId,omitempty"`
+	ToCommitId      string                    `json:"toCommitId"`
+	Commits         []changelogCommitResponse `json:"commits"`
+	Truncated       bool                      `json:"truncated"`
+}
+
+type changelogCommitResponse struct {
+	CommitId    string    `json:"commitId"`
+	AuthorName  string    `json:"authorName"`
+	AuthorEmail string    `json:"authorEmail"`
+	Message     string    `json:"message"`
+	AuthoredAt  time.Time `json:"authoredAt"`
+}
+
+// handleGetChangelog returns the commits of the source repository between the deployed release of an application and another release.
+// The query parameter version selects the other release, by default it is the release that a release train would deploy.
+func (s Server) handleGetChangelog(w http.ResponseWriter, req *http.Request, environment, application string) {
+	if s.GitClient == nil {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+		return
+	}
+	var version uint64
+	if value := req.URL.Query().Get("version"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || parsed == 0 {
+			http.Error(w, "Invalid version: '"+value+"' must be a positive number", http.StatusBadRequest)
+			return
+		}
+		version = parsed
+	}
+	response, err := s.GitClient.GetChangelog(req.Context(), &api.GetChangelogRequest{
+		Application: application,
+		Environment: environment,
+		Version:     version,
+	})
+	if err != nil {
+		handleGRPCError(req.Context(), w, err)
+		return
+	}
+	result := changelogResponse{
+		Application:     application,
+		Environment:     environment,
+		DeployedVersion: response.DeployedVersion,
+		Version:         response.Version,
+		FromCommitId:    response.FromCommitId,
+		ToCommitId:      response.ToCommitId,
+		Commits:         make([]changelogCommitResponse, 0, len(response.Commits)),
+		Truncated:       response.Truncated,
+	}
+	for _, commit := range response.Commits {
+		result.Commits = append(result.Commits, changelogCommitResponse{
+			CommitId:    commit.CommitId,
+			AuthorName:  commit.AuthorName,
+			AuthorEmail: commit.AuthorEmail,
+			Message:     commit.Message,
+			AuthoredAt:  commit.AuthoredAt.AsTime(),
+		})
+	}
+	writeJSONWithETag(w, req, result)
+}
@@ -31,12 +31,7 @@ const (
 	MAXIMUM_MULTIPART_SIZE = 12 * 1024 * 1024 // = 12Mi
 )
 
-func (s Server) handleCreateEnvironment(w http.ResponseWriter, req *http.Request, environment, tail string) {
-
-	if tail != "/" {
-		http.Error(w, fmt.Sprintf("Create Environment does not accept additional path arguments, got: '%s'", tail), http.StatusNotFound)
-		return
-	}
+func (s Server) handleCreateEnvironment(w http.ResponseWriter, req *http.Request, environment string) {
 	if err := req.ParseMultipartForm(MAXIMUM_MULTIPART_SIZE); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, "Invalid body: %s", err)
@@ -18,8 +18,11 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"time"
 
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 	"github.com/freiheit-com/kuberpult/pkg/logger"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -27,16 +30,110 @@ import (
 
 func handleGRPCError(ctx context.Context, w http.ResponseWriter, err error) {
 	s, _ := status.FromError(err)
+	for _, detail := range s.Details() {
+		switch detail := detail.(type) {
+		case *api.LockedError:
+			writeLockedError(ctx, w, detail)
+			return
+		case *api.ConcurrentModificationError:
+			writeConflictError(ctx, w, s.Message(), detail)
+			return
+		}
+	}
 	switch s.Code() {
 	case codes.InvalidArgument:
 		http.Error(w, s.Message(), http.StatusBadRequest)
+	case codes.PermissionDenied:
+		http.Error(w, s.Message(), http.StatusForbidden)
+	case codes.Aborted:
+		http.Error(w, s.Message(), http.StatusConflict)
+	case codes.NotFound:
+		http.Error(w, s.Message(), http.StatusNotFound)
 	case codes.FailedPrecondition:
 		// This is a bit of a shortcut.
 		// We probably do not want to return NotFound for any failed precondition.
 		// For now, this is only used when deleting locks that are non-existent.
 		http.Error(w, s.Message(), http.StatusNotFound)
+	case codes.Unavailable:
+		http.Error(w, s.Message(), http.StatusServiceUnavailable)
 	default:
 		logger.FromContext(ctx).Error(s.Message())
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
+
+type lockResponse struct {
+	Message   string    `json:"message"`
+	LockId    string    `json:"lockId"`
+	CreatedAt time.Time `json:"createdAt"`
+	CreatedBy actor     `json:"createdBy"`
+}
+
+type actor struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type lockedErrorResponse struct {
+	EnvironmentLocks            map[string]lockResponse `json:"environmentLocks"`
+	EnvironmentApplicationLocks map[string]lockResponse `json:"environmentApplicationLocks"`
+}
+
+// writeLockedError responds with 409 and the locks that prevented the change.
+func writeLockedError(ctx context.Context, w http.ResponseWriter, lockedError *api.LockedError) {
+	response := lockedErrorResponse{
+		EnvironmentLocks:            map[string]lockResponse{},
+		EnvironmentApplicationLocks: map[string]lockResponse{},
+	}
+	for lockId, lock := range lockedError.EnvironmentLocks {
+		response.EnvironmentLocks[lockId] = lockFromApi(lock)
+	}
+	for lockId, lock := range lockedError.EnvironmentApplicationLocks {
+		response.EnvironmentApplicationLocks[lockId] = lockFromApi(lock)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+	}
+}
+
+type conflictErrorResponse struct {
+	Message             string `json:"message"`
+	Environment         string `json:"environment,omitempty"`
+	Application         string `json:"application,omitempty"`
+	ExpectedVersion     uint64 `json:"expectedVersion,omitempty"`
+	CurrentVersion      uint64 `json:"currentVersion,omitempty"`
+	ExpectedGitRevision string `json:"expectedGitRevision,omitempty"`
+	CurrentGitRevision  string `json:"currentGitRevision,omitempty"`
+}
+
+// writeConflictError responds with 409 and what changed since the client read the state.
+func writeConflictError(ctx context.Context, w http.ResponseWriter, message string, conflict *api.ConcurrentModificationError) {
+	response := conflictErrorResponse{
+		Message:             message,
+		Environment:         conflict.Environment,
+		Application:         conflict.Application,
+		ExpectedVersion:     conflict.ExpectedVersion,
+		CurrentVersion:      conflict.CurrentVersion,
+		ExpectedGitRevision: conflict.ExpectedGitRevision,
+		CurrentGitRevision:  conflict.CurrentGitRevision,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.FromContext(ctx).Error(err.Error())
+	}
+}
+
+func lockFromApi(lock *api.Lock) lockResponse {
+	return lockResponse{
+		Message:   lock.Message,
+		LockId:    lock.LockId,
+		CreatedAt: lock.CreatedAt.AsTime(),
+		CreatedBy: actor{
+			Name:  lock.CreatedBy.GetName(),
+			Email: lock.CreatedBy.GetEmail(),
+		},
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/grpc/codes"
@@ -53,6 +54,38 @@ func Test_handleGRPCError(t *testing.T) {
 			},
 			expectedBody: "test message\n",
 		},
+		{
+			name: "permission denied",
+			err:  status.Error(codes.PermissionDenied, "not allowed"),
+			expectedResp: &http.Response{
+				StatusCode: http.StatusForbidden,
+			},
+			expectedBody: "not allowed\n",
+		},
+		{
+			name: "concurrent modification",
+			err: func() error {
+				st, _ := status.New(codes.Aborted, "conflict").WithDetails(&api.ConcurrentModificationError{
+					Environment:     "production",
+					Application:     "app1",
+					ExpectedVersion: 2,
+					CurrentVersion:  3,
+				})
+				return st.Err()
+			}(),
+			expectedResp: &http.Response{
+				StatusCode: http.StatusConflict,
+			},
+			expectedBody: `{"message":"conflict","environment":"production","application":"app1","expectedVersion":2,"currentVersion":3}` + "\n",
+		},
+		{
+			name: "aborted",
+			err:  status.Error(codes.Aborted, "a request with the same idempotency key is in progress"),
+			expectedResp: &http.Response{
+				StatusCode: http.StatusConflict,
+			},
+			expectedBody: "a request with the same idempotency key is in progress\n",
+		},
 		{
 			name: "unknown gRPC status error",
 			err:  status.Error(codes.Canceled, "test message"),
@@ -17,34 +17,19 @@ Copyright 2023 freiheit.com*/
 package handler
 
 import (
-	"fmt"
-	"net/http"
-
 	"github.com/ProtonMail/go-crypto/openpgp"
 
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
-	xpath "github.com/freiheit-com/kuberpult/pkg/path"
 	"github.com/freiheit-com/kuberpult/services/frontend-service/pkg/config"
 )
 
 type Server struct {
-	BatchClient   api.BatchServiceClient
-	RolloutClient api.RolloutServiceClient
-	Config        config.ServerConfig
-	KeyRing       openpgp.KeyRing
-	AzureAuth     bool
-}
-
-func (s Server) Handle(w http.ResponseWriter, req *http.Request) {
-	group, tail := xpath.Shift(req.URL.Path)
-	switch group {
-	case "environments":
-		s.HandleEnvironments(w, req, tail)
-	case "environment-groups":
-		s.HandleEnvironmentGroups(w, req, tail)
-	case "release":
-		s.HandleRelease(w, req, tail)
-	default:
-		http.Error(w, fmt.Sprintf("unknown endpoint '%s'", group), http.StatusNotFound)
-	}
+	BatchClient    api.BatchServiceClient
+	RolloutClient  api.RolloutServiceClient
+	AuditClient    api.AuditServiceClient
+	OverviewClient api.OverviewServiceClient
+	GitClient      api.GitServiceClient
+	Config         config.ServerConfig
+	KeyRing        openpgp.KeyRing
+	AzureAuth      bool
 }
@@ -17,14 +17,20 @@ Copyright 2023 freiheit.com*/
 package handler
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -32,11 +38,15 @@ import (
 	"github.com/ProtonMail/go-crypto/openpgp"
 
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/release"
 	"github.com/freiheit-com/kuberpult/services/frontend-service/pkg/config"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestServer_Handle(t *testing.T) {
@@ -101,7 +111,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "unknown endpoint ''\n",
+			expectedBody: "unknown endpoint '/'\n",
 		},
 		{
 			name: "env but missing env",
@@ -112,9 +122,9 @@ func TestServer_Handle(t *testing.T) {
 				},
 			},
 			expectedResp: &http.Response{
-				StatusCode: http.StatusNotFound,
+				StatusCode: http.StatusMethodNotAllowed,
 			},
-			expectedBody: "missing environment ID\n",
+			expectedBody: "unsupported method 'PUT'\n",
 		},
 		{
 			name: "release train",
@@ -160,7 +170,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusMethodNotAllowed,
 			},
-			expectedBody: "releasetrain only accepts method PUT, got: 'GET'\n",
+			expectedBody: "unsupported method 'GET'\n",
 		},
 		{
 			name: "release train but additional path params",
@@ -173,7 +183,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "releasetrain does not accept additional path arguments, got: '/junk'\n",
+			expectedBody: "unknown endpoint '/environments/development/releasetrain/junk'\n",
 		},
 		{
 			name:             "release train - Azure enabled",
@@ -348,15 +358,15 @@ func TestServer_Handle(t *testing.T) {
 		{
 			name: "create environment but wrong method",
 			req: &http.Request{
-				Method: http.MethodGet,
+				Method: http.MethodPatch,
 				URL: &url.URL{
 					Path: "/environments/stg/",
 				},
 			},
 			expectedResp: &http.Response{
-				StatusCode: http.StatusNotFound,
+				StatusCode: http.StatusMethodNotAllowed,
 			},
-			expectedBody: "unknown function ''\n",
+			expectedBody: "unsupported method 'PATCH'\n",
 		},
 		{
 			name: "create environment but additional path params",
@@ -369,7 +379,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "unknown function 'my-awesome-path'\n",
+			expectedBody: "unknown endpoint '/environments/stg/my-awesome-path'\n",
 		},
 		{
 			name:             "create environment - Azure enabled",
@@ -380,6 +390,9 @@ func TestServer_Handle(t *testing.T) {
 				URL: &url.URL{
 					Path: "/environments/stg/",
 				},
+				Header: http.Header{
+					"Content-Type": []string{"multipart/form-data"},
+				},
 				MultipartForm: &multipart.Form{
 					Value: map[string][]string{
 						"config":    []string{exampleConfig},
@@ -419,6 +432,9 @@ func TestServer_Handle(t *testing.T) {
 				URL: &url.URL{
 					Path: "/environments/stg/",
 				},
+				Header: http.Header{
+					"Content-Type": []string{"multipart/form-data"},
+				},
 				MultipartForm: &multipart.Form{
 					Value: map[string][]string{
 						"config": []string{exampleConfig},
@@ -439,6 +455,9 @@ func TestServer_Handle(t *testing.T) {
 				URL: &url.URL{
 					Path: "/environments/stg/",
 				},
+				Header: http.Header{
+					"Content-Type": []string{"multipart/form-data"},
+				},
 				MultipartForm: &multipart.Form{
 					Value: map[string][]string{
 						"config":    []string{exampleConfig},
@@ -459,6 +478,9 @@ func TestServer_Handle(t *testing.T) {
 				URL: &url.URL{
 					Path: "/environments/stg/",
 				},
+				Header: http.Header{
+					"Content-Type": []string{"multipart/form-data"},
+				},
 				MultipartForm: &multipart.Form{
 					Value: map[string][]string{
 						"config":    []string{exampleConfig},
@@ -501,6 +523,23 @@ func TestServer_Handle(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "lock env with a body that is too large",
+			req: &http.Request{
+				Method: http.MethodPut,
+				URL: &url.URL{
+					Path: "/environments/development/locks/test",
+				},
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+				},
+				Body: io.NopCloser(strings.NewReader(`{"message":"` + strings.Repeat("a", MAXIMUM_REQUEST_SIZE) + `"}`)),
+			},
+			expectedResp: &http.Response{
+				StatusCode: http.StatusRequestEntityTooLarge,
+			},
+			expectedBody: "body must not be larger than 1048576 bytes\n",
+		},
 		{
 			name:             "lock env - Azure Enabled",
 			AzureAuthEnabled: true,
@@ -563,7 +602,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "missing ID for env lock\n",
+			expectedBody: "unknown endpoint '/environments/development/locks'\n",
 		},
 		{
 			name: "lock env but additional path params",
@@ -576,7 +615,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "env locks does not accept additional path arguments after the lock ID, got: '/junk'\n",
+			expectedBody: "unknown endpoint '/environments/development/locks/test/junk'\n",
 		},
 		{
 			name: "lock env but wrong content type",
@@ -606,7 +645,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusBadRequest,
 			},
-			expectedBody: "Please provide lock message in body\n",
+			expectedBody: "missing required field 'message'\n",
 		},
 		{
 			name: "lock env but no message",
@@ -623,7 +662,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusBadRequest,
 			},
-			expectedBody: "Please provide lock message in body\n",
+			expectedBody: "missing required field 'message'\n",
 		},
 		{
 			name: "lock env but empty message",
@@ -678,7 +717,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "missing ID for env lock\n",
+			expectedBody: "unknown endpoint '/environments/development/locks'\n",
 		},
 		{
 			name: "unlock env but additional path params",
@@ -691,7 +730,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "env locks does not accept additional path arguments after the lock ID, got: '/junk'\n",
+			expectedBody: "unknown endpoint '/environments/development/locks/test/junk'\n",
 		},
 		{
 			name: "lock env but wrong method",
@@ -717,7 +756,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "missing application ID\n",
+			expectedBody: "unknown endpoint '/environments/development/applications'\n",
 		},
 		{
 			name: "lock app",
@@ -761,7 +800,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "missing lock ID\n",
+			expectedBody: "unknown endpoint '/environments/development/applications/service/locks'\n",
 		},
 		{
 			name: "lock app but additional path params",
@@ -774,7 +813,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "locks does not accept additional path arguments after the lock ID, got: /junk\n",
+			expectedBody: "unknown endpoint '/environments/development/applications/service/locks/test/junk'\n",
 		},
 		{
 			name: "lock app but wrong content type",
@@ -826,7 +865,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "missing lock ID\n",
+			expectedBody: "unknown endpoint '/environments/development/applications/service/locks'\n",
 		},
 		{
 			name: "unlock app but additional path params",
@@ -839,7 +878,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "locks does not accept additional path arguments after the lock ID, got: /junk\n",
+			expectedBody: "unknown endpoint '/environments/development/applications/service/locks/test/junk'\n",
 		},
 		{
 			name: "lock app but wrong method",
@@ -893,7 +932,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "missing ID for env group lock\n",
+			expectedBody: "unknown endpoint '/environment-groups/development/locks'\n",
 		},
 		{
 			name: "unlock env group but additional path params",
@@ -906,7 +945,7 @@ func TestServer_Handle(t *testing.T) {
 			expectedResp: &http.Response{
 				StatusCode: http.StatusNotFound,
 			},
-			expectedBody: "group locks does not accept additional path arguments after the lock ID, got: '/garbage'\n",
+			expectedBody: "unknown endpoint '/environment-groups/development/locks/test/garbage'\n",
 		},
 		{
 			name: "lock env group but wrong method",
@@ -1128,11 +1167,1037 @@ func TestServer_Rollout(t *testing.T) {
 }
 
 type mockBatchClient struct {
+	// the methods that are not implemented here panic
+	api.BatchServiceClient
 	batchRequest  *api.BatchRequest
 	batchResponse *api.BatchResponse
+	batchError    error
 }
 
 func (m *mockBatchClient) ProcessBatch(_ context.Context, in *api.BatchRequest, _ ...grpc.CallOption) (*api.BatchResponse, error) {
 	m.batchRequest = in
-	return m.batchResponse, nil
+	return m.batchResponse, m.batchError
+}
+
+type mockAuditClient struct {
+	request  *api.QueryAuditLogRequest
+	response *api.QueryAuditLogResponse
+}
+
+func (m *mockAuditClient) QueryAuditLog(_ context.Context, in *api.QueryAuditLogRequest, _ ...grpc.CallOption) (*api.QueryAuditLogResponse, error) {
+	m.request = in
+	return m.response, nil
+}
+
+func TestServer_AuditLog(t *testing.T) {
+	tests := []struct {
+		name                 string
+		req                  *http.Request
+		auditResponse        *api.QueryAuditLogResponse
+		expectedResp         *http.Response
+		expectedBody         string
+		expectedAuditRequest *api.QueryAuditLogRequest
+	}{
+		{
+			name: "exports the audit log",
+			req: &http.Request{
+				Method: http.MethodGet,
+				URL: &url.URL{
+					Path:     "/api/audit-log",
+					RawQuery: "user=alice&environment=production&application=app1&from=2024-01-01T00:00:00Z&limit=10",
+				},
+			},
+			auditResponse: &api.QueryAuditLogResponse{
+				Entries: []*api.AuditLogEntry{
+					{
+						Id:          "1",
+						Timestamp:   timestamppb.New(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+						UserName:    "alice",
+						UserEmail:   "alice@example.com",
+						Action:      "deploy",
+						Parameters:  `{"version":"1"}`,
+						Environment: "production",
+						Application: "app1",
+						Outcome:     api.AuditLogOutcome_AUDIT_LOG_OUTCOME_FAILURE,
+						Error:       "locked",
+					},
+				},
+			},
+			expectedResp: &http.Response{
+				StatusCode: http.StatusOK,
+			},
+			expectedBody: `[{"id":"1","timestamp":"2024-01-02T03:04:05Z","userName":"alice","userEmail":"alice@example.com","role":"","action":"deploy","parameters":"{\"version\":\"1\"}","environment":"production","application":"app1","outcome":"failure","error":"locked","commitId":""}]`,
+			expectedAuditRequest: &api.QueryAuditLogRequest{
+				User:        "alice",
+				Environment: "production",
+				Application: "app1",
+				From:        timestamppb.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+				Limit:       10,
+			},
+		},
+		{
+			name: "rejects invalid times",
+			req: &http.Request{
+				Method: http.MethodGet,
+				URL: &url.URL{
+					Path:     "/api/audit-log",
+					RawQuery: "to=yesterday",
+				},
+			},
+			expectedResp: &http.Response{
+				StatusCode: http.StatusBadRequest,
+			},
+			expectedBody: "Invalid to: 'yesterday' is not in RFC3339 format\n",
+		},
+		{
+			name: "rejects other methods",
+			req: &http.Request{
+				Method: http.MethodPost,
+				URL: &url.URL{
+					Path: "/api/audit-log",
+				},
+			},
+			expectedResp: &http.Response{
+				StatusCode: http.StatusMethodNotAllowed,
+			},
+			expectedBody: "unsupported method 'POST'\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			auditClient := &mockAuditClient{response: tt.auditResponse}
+			s := Server{
+				AuditClient: auditClient,
+			}
+
+			w := httptest.NewRecorder()
+			s.Handle(w, tt.req)
+			resp := w.Result()
+
+			if d := cmp.Diff(tt.expectedResp, resp, cmpopts.IgnoreFields(http.Response{}, "Status", "Proto", "ProtoMajor", "ProtoMinor", "Header", "Body", "ContentLength")); d != "" {
+				t.Errorf("response mismatch: %s", d)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("error reading response body: %s", err)
+			}
+			if d := cmp.Diff(tt.expectedBody, string(body)); d != "" {
+				t.Errorf("response body mismatch:\ngot:  %s\nwant: %s\ndiff: \n%s", string(body), tt.expectedBody, d)
+			}
+			if d := cmp.Diff(tt.expectedAuditRequest, auditClient.request, protocmp.Transform()); d != "" {
+				t.Errorf("query audit log request mismatch: %s", d)
+			}
+		})
+	}
+}
+
+func TestServer_Deploy(t *testing.T) {
+	exampleKey, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exampleKeyRing := openpgp.EntityList{exampleKey}
+	signatureBuffer := bytes.Buffer{}
+	err = openpgp.ArmoredDetachSign(&signatureBuffer, exampleKey, bytes.NewReader([]byte("development"+"app1"+"3"+"fail")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exampleSignature := signatureBuffer.String()
+	signedBody, err := json.Marshal(putDeployRequest{Version: 3, LockBehavior: "fail", Signature: exampleSignature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSignatureBuffer := bytes.Buffer{}
+	err = openpgp.ArmoredDetachSign(&recordSignatureBuffer, exampleKey, bytes.NewReader([]byte("development"+"app1"+"3"+"record")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayedBody, err := json.Marshal(putDeployRequest{Version: 3, LockBehavior: "ignore", Signature: recordSignatureBuffer.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedError, err := status.New(codes.FailedPrecondition, "locked").WithDetails(&api.LockedError{
+		EnvironmentLocks: map[string]*api.Lock{
+			"maintenance": {
+				Message:   "database upgrade",
+				LockId:    "maintenance",
+				CreatedAt: timestamppb.New(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+				CreatedBy: &api.Actor{Name: "alice", Email: "alice@example.com"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                 string
+		req                  *http.Request
+		KeyRing              openpgp.KeyRing
+		AzureAuthEnabled     bool
+		batchError           error
+		expectedResp         *http.Response
+		expectedBody         string
+		expectedBatchRequest *api.BatchRequest
+	}{
+		{
+			name: "deploys a version",
+			req: &http.Request{
+				Method: http.MethodPut,
+				URL: &url.URL{
+					Path: "/environments/development/applications/app1/deploy",
+				},
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+				},
+				Body: io.NopCloser(strings.NewReader(`{"version":3,"lockBehavior":"ignore"}`)),
+			},
+			expectedResp: &http.Response{
+				StatusCode: http.StatusOK,
+			},
+			expectedBatchRequest: &api.BatchRequest{
+				Actions: []*api.BatchAction{
+					{
+						Action: &api.BatchAction_Deploy{
+							Deploy: &api.DeployRequest{
+								Environment:  "development",
+								Application:  "app1",
+								Version:      3,
+								LockBehavior: api.LockBehavior_IGNORE,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "deploys a version with a valid signature",
+			req: &http.Request{
+				Method: http.MethodPut,
+				URL: &url.URL{
+					Path: "/environments/development/applications/app1/deploy",
+				},
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+				},
+				Body: io.NopCloser(bytes.NewReader(signedBody)),
+			},
+			KeyRing:          exampleKeyRing,
+			AzureAuthEnabled: true,
+			expectedResp: &http.Response{
+				StatusCode: http.StatusOK,
+			},
+			expectedBatchRequest: &api.BatchRequest{
+				Actions: []*api.BatchAction{
+					{
+						Action: &api.BatchAction_Deploy{
+							Deploy: &api.DeployRequest{
+								Environment:  "development",
+								Application:  "app1",
+								Version:      3,
+								LockBehavior: api.LockBehavior_FAIL,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "rejects a signature for another lock behavior",
+			req: &http.Request{
+				Method: http.MethodPut,
+				URL: &url.URL{
+					Path: "/environments/development/applications/app1/deploy",
+				},
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+				},
+				Body: io.NopCloser(bytes.NewReader(replayedBody)),
+			},
+			KeyRing:          exampleKeyRing,
+			AzureAuthEnabled: true,
+			expectedResp: &http.Response{
+				StatusCode: http.StatusInternalServerError,
+			},
+			expectedBody: "Internal: Invalid Signature: openpgp: invalid signature: RSA verification failure",
+		},
+		{
+			name: "rejects a missing signature",
+			req: &http.Request{
+				Method: http.MethodPut,
+				URL: &url.URL{
+					Path: "/environments/development/applications/app1/deploy",
+				},
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+				},
+				Body: io.NopCloser(strings.NewReader(`{"version":3}`)),
+			},
+			KeyRing:          exampleKeyRing,
+			AzureAuthEnabled: true,
+			expectedResp: &http.Response{
+				StatusCode: http.StatusBadRequest,
+			},
+			expectedBody: "Missing signature in request body",
+		},
+		{
+			name: "rejects an invalid lock behavior",
+			req: &http.Request{
+				Method: http.MethodPut,
+				URL: &url.URL{
+					Path: "/environments/development/applications/app1/deploy",
+				},
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+				},
+				Body: io.NopCloser(strings.NewReader(`{"version":3,"lockBehavior":"wait"}`)),
+			},
+			expectedResp: &http.Response{
+				StatusCode: http.StatusBadRequest,
+			},
+			expectedBody: "invalid lockBehavior 'wait', must be one of 'record', 'fail' or 'ignore'\n",
+		},
+		{
+			name: "rejects a missing version",
+			req: &http.Request{
+				Method: http.MethodPut,
+				URL: &url.URL{
+					Path: "/environments/development/applications/app1/deploy",
+				},
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+				},
+				Body: io.NopCloser(strings.NewReader(`{}`)),
+			},
+			expectedResp: &http.Response{
+				StatusCode: http.StatusBadRequest,
+			},
+			expectedBody: "missing required field 'version'\n",
+		},
+		{
+			name: "returns the locks that prevented the deployment",
+			req: &http.Request{
+				Method: http.MethodPut,
+				URL: &url.URL{
+					Path: "/environments/development/applications/app1/deploy",
+				},
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+				},
+				Body: io.NopCloser(strings.NewReader(`{"version":3,"lockBehavior":"fail"}`)),
+			},
+			batchError: lockedError.Err(),
+			expectedResp: &http.Response{
+				StatusCode: http.StatusConflict,
+			},
+			expectedBody: `{"environmentLocks":{"maintenance":{"message":"database upgrade","lockId":"maintenance","createdAt":"2024-01-02T03:04:05Z","createdBy":{"name":"alice","email":"alice@example.com"}}},"environmentApplicationLocks":{}}` + "\n",
+			expectedBatchRequest: &api.BatchRequest{
+				Actions: []*api.BatchAction{
+					{
+						Action: &api.BatchAction_Deploy{
+							Deploy: &api.DeployRequest{
+								Environment:  "development",
+								Application:  "app1",
+								Version:      3,
+								LockBehavior: api.LockBehavior_FAIL,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "rejects other methods",
+			req: &http.Request{
+				Method: http.MethodPost,
+				URL: &url.URL{
+					Path: "/environments/development/applications/app1/deploy",
+				},
+			},
+			expectedResp: &http.Response{
+				StatusCode: http.StatusMethodNotAllowed,
+			},
+			expectedBody: "unsupported method 'POST'\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			batchClient := &mockBatchClient{batchError: tt.batchError}
+			s := Server{
+				BatchClient: batchClient,
+				KeyRing:     tt.KeyRing,
+				AzureAuth:   tt.AzureAuthEnabled,
+			}
+
+			w := httptest.NewRecorder()
+			s.Handle(w, tt.req)
+			resp := w.Result()
+
+			if d := cmp.Diff(tt.expectedResp, resp, cmpopts.IgnoreFields(http.Response{}, "Status", "Proto", "ProtoMajor", "ProtoMinor", "Header", "Body", "ContentLength")); d != "" {
+				t.Errorf("response mismatch: %s", d)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("error reading response body: %s", err)
+			}
+			if d := cmp.Diff(tt.expectedBody, string(body)); d != "" {
+				t.Errorf("response body mismatch:\ngot:  %s\nwant: %s\ndiff: \n%s", string(body), tt.expectedBody, d)
+			}
+			if d := cmp.Diff(tt.expectedBatchRequest, batchClient.batchRequest, protocmp.Transform()); d != "" {
+				t.Errorf("create batch request mismatch: %s", d)
+			}
+		})
+	}
+}
+
+type mockOverviewClient struct {
+	api.OverviewServiceClient
+	response *api.GetOverviewResponse
+}
+
+func (m *mockOverviewClient) GetOverview(_ context.Context, _ *api.GetOverviewRequest, _ ...grpc.CallOption) (*api.GetOverviewResponse, error) {
+	return m.response, nil
+}
+
+type mockGitClient struct {
+	api.GitServiceClient
+	request           *api.GetProductSummaryRequest
+	response          *api.GetProductSummaryResponse
+	changelogRequest  *api.GetChangelogRequest
+	changelogResponse *api.GetChangelogResponse
+}
+
+func (m *mockGitClient) GetProductSummary(_ context.Context, in *api.GetProductSummaryRequest, _ ...grpc.CallOption) (*api.GetProductSummaryResponse, error) {
+	m.request = in
+	return m.response, nil
+}
+
+func (m *mockGitClient) GetChangelog(_ context.Context, in *api.GetChangelogRequest, _ ...grpc.CallOption) (*api.GetChangelogResponse, error) {
+	m.changelogRequest = in
+	return m.changelogResponse, nil
+}
+
+func TestServer_ReadApi(t *testing.T) {
+	overview := &api.GetOverviewResponse{
+		Applications: map[string]*api.Application{
+			"app1": {
+				Name: "app1",
+				Team: "team1",
+				Releases: []*api.Release{
+					{Version: 1, SourceCommitId: "aaa", SourceAuthor: "alice", SourceMessage: "first", CreatedAt: timestamppb.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))},
+					{Version: 3, SourceCommitId: "ccc", SourceAuthor: "alice", SourceMessage: "third", CreatedAt: timestamppb.New(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))},
+					{Version: 2, SourceCommitId: "bbb", SourceAuthor: "bob", SourceMessage: "second", CreatedAt: timestamppb.New(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))},
+				},
+			},
+		},
+		EnvironmentGroups: []*api.EnvironmentGroup{
+			{
+				EnvironmentGroupName: "dev",
+				Environments: []*api.Environment{
+					{
+						Name:     "development",
+						Priority: api.Priority_UPSTREAM,
+						Config: &api.EnvironmentConfig{
+							Upstream: &api.EnvironmentConfig_Upstream{Latest: &[]bool{true}[0]},
+						},
+						Locks: map[string]*api.Lock{
+							"maintenance": {
+								Message:   "database upgrade",
+								LockId:    "maintenance",
+								CreatedAt: timestamppb.New(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+								CreatedBy: &api.Actor{Name: "alice", Email: "alice@example.com"},
+							},
+						},
+						Applications: map[string]*api.Environment_Application{
+							"app1": {
+								Name:          "app1",
+								Version:       2,
+								QueuedVersion: 3,
+								DeploymentMetaData: &api.Environment_Application_DeploymentMetaData{
+									DeployAuthor: "bob",
+									DeployTime:   "1704164645",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	productSummary := &api.GetProductSummaryResponse{
+		ProductSummary: []*api.ProductSummary{
+			{App: "app1", Version: "2", CommitId: "bbb", DisplayVersion: "v2", Environment: "development", Team: "team1"},
+		},
+	}
+	changelog := &api.GetChangelogResponse{
+		DeployedVersion: &[]uint64{2}[0],
+		Version:         3,
+		FromCommitId:    "bbb",
+		ToCommitId:      "ccc",
+		Commits: []*api.SourceCommit{
+			{CommitId: "ccc", AuthorName: "alice", AuthorEmail: "alice@example.com", Message: "third", AuthoredAt: timestamppb.New(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))},
+		},
+	}
+	const environmentsBody = `[{"name":"development","environmentGroup":"dev","priority":"UPSTREAM","distanceToUpstream":0,"upstream":{"latest":true},"locks":{"maintenance":{"message":"database upgrade","lockId":"maintenance","createdAt":"2024-01-02T03:04:05Z","createdBy":{"name":"alice","email":"alice@example.com"}}}}]`
+
+	tests := []struct {
+		name                   string
+		path                   string
+		query                  string
+		ifNoneMatch            string
+		expectedStatus         int
+		expectedBody           string
+		expectedProductSummary *api.GetProductSummaryRequest
+		expectedChangelog      *api.GetChangelogRequest
+	}{
+		{
+			name:           "lists environments",
+			path:           "/environments",
+			expectedStatus: http.StatusOK,
+			expectedBody:   environmentsBody,
+		},
+		{
+			name:           "returns 304 if the client has the current version",
+			path:           "/environments",
+			ifNoneMatch:    `"0123", ` + etagOf(environmentsBody),
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:           "returns an environment with its applications",
+			path:           "/environments/development",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"name":"development","environmentGroup":"dev","priority":"UPSTREAM","distanceToUpstream":0,"upstream":{"latest":true},"locks":{"maintenance":{"message":"database upgrade","lockId":"maintenance","createdAt":"2024-01-02T03:04:05Z","createdBy":{"name":"alice","email":"alice@example.com"}}},"applications":{"app1":{"name":"app1","team":"team1","version":2,"queuedVersion":3,"undeployVersion":false,"locks":{},"deployAuthor":"bob","deployTime":"2024-01-02T03:04:05Z"}}}`,
+		},
+		{
+			name:           "returns 404 for unknown environments",
+			path:           "/environments/staging",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "environment 'staging' not found\n",
+		},
+		{
+			name:           "returns an application in an environment",
+			path:           "/environments/development/applications/app1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"name":"app1","team":"team1","version":2,"queuedVersion":3,"undeployVersion":false,"locks":{},"deployAuthor":"bob","deployTime":"2024-01-02T03:04:05Z"}`,
+		},
+		{
+			name:           "returns 404 for unknown applications",
+			path:           "/environments/development/applications/app2",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "application 'app2' not found in environment 'development'\n",
+		},
+		{
+			name:           "returns the newest releases first",
+			path:           "/applications/app1/releases",
+			query:          "pageSize=2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"application":"app1","releases":[{"version":3,"sourceCommitId":"ccc","sourceAuthor":"alice","sourceMessage":"third","createdAt":"2024-01-03T00:00:00Z","undeployVersion":false},{"version":2,"sourceCommitId":"bbb","sourceAuthor":"bob","sourceMessage":"second","createdAt":"2024-01-02T00:00:00Z","undeployVersion":false}],"page":1,"pageSize":2,"totalReleases":3}`,
+		},
+		{
+			name:           "returns later pages of releases",
+			path:           "/applications/app1/releases",
+			query:          "pageSize=2&page=2",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"application":"app1","releases":[{"version":1,"sourceCommitId":"aaa","sourceAuthor":"alice","sourceMessage":"first","createdAt":"2024-01-01T00:00:00Z","undeployVersion":false}],"page":2,"pageSize":2,"totalReleases":3}`,
+		},
+		{
+			name:           "rejects invalid pages",
+			path:           "/applications/app1/releases",
+			query:          "page=0",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid page: '0' must be a positive number\n",
+		},
+		{
+			name:           "returns the product summary",
+			path:           "/product-summary",
+			query:          "commit=1234&env=development",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"app":"app1","version":"2","commitId":"bbb","displayVersion":"v2","environment":"development","team":"team1"}]`,
+			expectedProductSummary: &api.GetProductSummaryRequest{
+				CommitHash:  "1234",
+				Environment: &[]string{"development"}[0],
+			},
+		},
+		{
+			name:           "returns the changelog of a release",
+			path:           "/environments/development/applications/app1/changelog",
+			query:          "version=3",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"application":"app1","environment":"development","deployedVersion":2,"version":3,"fromCommitId":"bbb","toCommitId":"ccc","commits":[{"commitId":"ccc","authorName":"alice","authorEmail":"alice@example.com","message":"third","authoredAt":"2024-01-03T00:00:00Z"}],"truncated":false}`,
+			expectedChangelog: &api.GetChangelogRequest{
+				Application: "app1",
+				Environment: "development",
+				Version:     3,
+			},
+		},
+		{
+			name:           "rejects invalid versions for the changelog",
+			path:           "/environments/development/applications/app1/changelog",
+			query:          "version=latest",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid version: 'latest' must be a positive number\n",
+		},
+		{
+			name:           "requires either an environment or a group for the product summary",
+			path:           "/product-summary",
+			query:          "commit=1234",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Exactly one of the env and group query parameters is required\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			gitClient := &mockGitClient{response: productSummary, changelogResponse: changelog}
+			s := Server{
+				OverviewClient: &mockOverviewClient{response: overview},
+				GitClient:      gitClient,
+			}
+			req := &http.Request{
+				Method: http.MethodGet,
+				URL: &url.URL{
+					Path:     tt.path,
+					RawQuery: tt.query,
+				},
+				Header: http.Header{},
+			}
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+
+			w := httptest.NewRecorder()
+			s.Handle(w, req)
+			resp := w.Result()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("error reading response body: %s", err)
+			}
+			if d := cmp.Diff(tt.expectedBody, string(body)); d != "" {
+				t.Errorf("response body mismatch:\ngot:  %s\nwant: %s\ndiff: \n%s", string(body), tt.expectedBody, d)
+			}
+			if tt.expectedStatus == http.StatusOK && resp.Header.Get("ETag") != etagOf(tt.expectedBody) {
+				t.Errorf("expected etag %s but got %s", etagOf(tt.expectedBody), resp.Header.Get("ETag"))
+			}
+			if d := cmp.Diff(tt.expectedProductSummary, gitClient.request, protocmp.Transform()); d != "" {
+				t.Errorf("product summary request mismatch: %s", d)
+			}
+			if d := cmp.Diff(tt.expectedChangelog, gitClient.changelogRequest, protocmp.Transform()); d != "" {
+				t.Errorf("changelog request mismatch: %s", d)
+			}
+		})
+	}
+}
+
+func etagOf(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func TestServer_OpenApi(t *testing.T) {
+	s := Server{
+		Config: config.ServerConfig{Version: "1.2.3"},
+	}
+	w := httptest.NewRecorder()
+	s.Handle(w, &http.Request{
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Path: "/api/openapi.json",
+		},
+	})
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d but got %d", http.StatusOK, resp.StatusCode)
+	}
+	var document openApiDocument
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		t.Fatalf("error decoding the openapi document: %s", err)
+	}
+	if document.Info.Version != "1.2.3" {
+		t.Errorf("expected version 1.2.3 but got %s", document.Info.Version)
+	}
+	operationIds := map[string]bool{}
+	for _, r := range allRoutes() {
+		operation, ok := document.Paths[r.Path][strings.ToLower(r.Method)]
+		if !ok {
+			t.Errorf("route %s %s is missing in the openapi document", r.Method, r.Path)
+			continue
+		}
+		if operationIds[operation.OperationId] {
+			t.Errorf("duplicate operation id %s", operation.OperationId)
+		}
+		operationIds[operation.OperationId] = true
+	}
+	lockEnvironment := document.Paths["/environments/{environment}/locks/{lockId}"]["put"]
+	expectedParameters := []openApiParameter{
+		{Name: "environment", In: "path", Required: true, Schema: openApiSchema{Type: "string"}},
+		{Name: "lockId", In: "path", Required: true, Schema: openApiSchema{Type: "string"}},
+	}
+	if d := cmp.Diff(expectedParameters, lockEnvironment.Parameters); d != "" {
+		t.Errorf("parameters mismatch: %s", d)
+	}
+	if d := cmp.Diff([]string{"message"}, lockEnvironment.RequestBody.Content["application/json"].Schema.Required); d != "" {
+		t.Errorf("required fields mismatch: %s", d)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tcs := []struct {
+		Name           string
+		Pattern        string
+		Path           string
+		ExpectedParams map[string]string
+	}{
+		{
+			Name:           "static path",
+			Pattern:        "/environments",
+			Path:           "/environments/",
+			ExpectedParams: map[string]string{},
+		},
+		{
+			Name:           "path parameters",
+			Pattern:        "/environments/{environment}/locks/{lockId}",
+			Path:           "/environments/development/locks/my-lock",
+			ExpectedParams: map[string]string{"environment": "development", "lockId": "my-lock"},
+		},
+		{
+			Name:           "additional segments",
+			Pattern:        "/environments/{environment}/locks/{lockId}",
+			Path:           "/environments/development/locks/my-lock/junk",
+			ExpectedParams: nil,
+		},
+		{
+			Name:           "missing segments",
+			Pattern:        "/environments/{environment}/locks/{lockId}",
+			Path:           "/environments/development/locks",
+			ExpectedParams: nil,
+		},
+		{
+			Name:           "different static segment",
+			Pattern:        "/environments/{environment}/locks/{lockId}",
+			Path:           "/environments/development/releasetrain/my-lock",
+			ExpectedParams: nil,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			params, _ := matchPath(tc.Pattern, tc.Path)
+			if d := cmp.Diff(tc.ExpectedParams, params); d != "" {
+				t.Errorf("params mismatch: %s", d)
+			}
+		})
+	}
+}
+
+func gzipBase64(t *testing.T, content string) string {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServer_CreateRelease(t *testing.T) {
+	exampleKey, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exampleKeyRing := openpgp.EntityList{exampleKey}
+	otherKey, err := openpgp.NewEntity("Other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(content string) string {
+		signature := bytes.Buffer{}
+		if err := openpgp.ArmoredDetachSign(&signature, exampleKey, strings.NewReader(content), nil); err != nil {
+			t.Fatal(err)
+		}
+		return signature.String()
+	}
+	hash, err := release.Hash(release.Release{
+		Application: "app1",
+		Manifests:   map[string]string{"development": "dev manifest", "production": "prod manifest"},
+		Team:        "sre",
+		Version:     12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonBody := func(body postReleaseRequest) string {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	successResponse := &api.BatchResponse{Results: []*api.BatchResult{
+		{Result: &api.BatchResult_CreateReleaseResponse{CreateReleaseResponse: &api.CreateReleaseResponse{
+			Response: &api.CreateReleaseResponse_Success{Success: &api.CreateReleaseResponseSuccess{}},
+		}}},
+	}}
+	expectedBatchRequest := &api.BatchRequest{Actions: []*api.BatchAction{
+		{Action: &api.BatchAction_CreateRelease{CreateRelease: &api.CreateReleaseRequest{
+			Application: "app1",
+			Manifests:   map[string]string{"development": "dev manifest", "production": "prod manifest"},
+			Team:        "sre",
+			Version:     12,
+		}}},
+	}}
+	expectedSourceBatchRequest := &api.BatchRequest{Actions: []*api.BatchAction{
+		{Action: &api.BatchAction_CreateRelease{CreateRelease: &api.CreateReleaseRequest{
+			Application:   "app1",
+			Manifests:     map[string]string{"development": "dev manifest"},
+			SourceRepoUrl: "https://github.com/example/source.git",
+			SourcePaths:   []string{"services/app1", "pkg"},
+		}}},
+	}}
+
+	tests := []struct {
+		name                 string
+		contentType          string
+		query                string
+		body                 string
+		KeyRing              openpgp.KeyRing
+		expectedStatus       int
+		expectedBody         string
+		expectedBatchRequest *api.BatchRequest
+	}{
+		{
+			name:        "json with plain manifests",
+			contentType: "application/json",
+			body: jsonBody(postReleaseRequest{
+				Application: "app1",
+				Team:        "sre",
+				Version:     12,
+				Manifests:   map[string]string{"development": "dev manifest", "production": "prod manifest"},
+			}),
+			expectedStatus:       http.StatusCreated,
+			expectedBody:         "{\"Success\":{}}\n",
+			expectedBatchRequest: expectedBatchRequest,
+		},
+		{
+			name:        "json with compressed manifests and signature",
+			contentType: "application/json; charset=utf-8",
+			body: jsonBody(postReleaseRequest{
+				Application:      "app1",
+				Team:             "sre",
+				Version:          12,
+				Manifests:        map[string]string{"development": gzipBase64(t, "dev manifest"), "production": gzipBase64(t, "prod manifest")},
+				ManifestEncoding: "gzip+base64",
+				Signature:        sign(hash),
+			}),
+			KeyRing:              exampleKeyRing,
+			expectedStatus:       http.StatusCreated,
+			expectedBody:         "{\"Success\":{}}\n",
+			expectedBatchRequest: expectedBatchRequest,
+		},
+		{
+			name:        "json with source repository",
+			contentType: "application/json",
+			body: jsonBody(postReleaseRequest{
+				Application:   "app1",
+				Manifests:     map[string]string{"development": "dev manifest"},
+				SourceRepoUrl: "https://github.com/example/source.git",
+				SourcePaths:   []string{"services/app1", "pkg"},
+			}),
+			expectedStatus:       http.StatusCreated,
+			expectedBody:         "{\"Success\":{}}\n",
+			expectedBatchRequest: expectedSourceBatchRequest,
+		},
+		{
+			name:        "json signed by an unknown key",
+			contentType: "application/json",
+			body: jsonBody(postReleaseRequest{
+				Application: "app1",
+				Team:        "sre",
+				Version:     12,
+				Manifests:   map[string]string{"development": "dev manifest", "production": "prod manifest"},
+				Signature:   sign(hash),
+			}),
+			KeyRing:        openpgp.EntityList{otherKey},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "signature not found for release\n",
+		},
+		{
+			name:        "json without signature",
+			contentType: "application/json",
+			body: jsonBody(postReleaseRequest{
+				Application: "app1",
+				Manifests:   map[string]string{"development": "dev manifest"},
+			}),
+			KeyRing:        exampleKeyRing,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "signature not found for release\n",
+		},
+		{
+			name:        "json with unknown encoding",
+			contentType: "application/json",
+			body: jsonBody(postReleaseRequest{
+				Application:      "app1",
+				Manifests:        map[string]string{"development": "dev manifest"},
+				ManifestEncoding: "zip",
+			}),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid manifestEncoding 'zip', must be 'gzip+base64' or empty\n",
+		},
+		{
+			name:           "json without manifests",
+			contentType:    "application/json",
+			body:           `{"application":"app1"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "missing required field 'manifests'\n",
+		},
+		{
+			name:           "json with empty manifests",
+			contentType:    "application/json",
+			body:           `{"application":"app1","version":12,"manifests":{}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "No manifest files provided\n",
+		},
+		{
+			name:        "json with invalid compressed manifest",
+			contentType: "application/json",
+			body: jsonBody(postReleaseRequest{
+				Application:      "app1",
+				Manifests:        map[string]string{"development": "not base64!"},
+				ManifestEncoding: "gzip+base64",
+			}),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid manifest for development: illegal base64 data at input byte 3\n",
+		},
+		{
+			name:        "archive with signatures",
+			contentType: "application/gzip",
+			query:       "application=app1&team=sre&version=12",
+			body: string(tarGz(t, map[string]string{
+				"development/manifests.yaml": "dev manifest",
+				"development/signature.asc":  sign("dev manifest"),
+				"production/manifests.yaml":  "prod manifest",
+				"production/signature.asc":   sign("prod manifest"),
+			})),
+			KeyRing:              exampleKeyRing,
+			expectedStatus:       http.StatusCreated,
+			expectedBody:         "{\"Success\":{}}\n",
+			expectedBatchRequest: expectedBatchRequest,
+		},
+		{
+			name:        "archive with source repository",
+			contentType: "application/gzip",
+			query:       "application=app1&source_repo_url=https://github.com/example/source.git&source_paths=services/app1&source_paths=pkg",
+			body: string(tarGz(t, map[string]string{
+				"development/manifests.yaml": "dev manifest",
+			})),
+			expectedStatus:       http.StatusCreated,
+			expectedBody:         "{\"Success\":{}}\n",
+			expectedBatchRequest: expectedSourceBatchRequest,
+		},
+		{
+			name:        "archive without signature",
+			contentType: "application/gzip",
+			query:       "application=app1",
+			body: string(tarGz(t, map[string]string{
+				"development/manifests.yaml": "dev manifest",
+			})),
+			KeyRing:        exampleKeyRing,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "signature not found for development\n",
+		},
+		{
+			name:        "archive with other files",
+			contentType: "application/gzip",
+			query:       "application=app1",
+			body: string(tarGz(t, map[string]string{
+				"development/values.yaml": "values",
+			})),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid archive entry 'development/values.yaml'. Must be <environment>/manifests.yaml or <environment>/signature.asc\n",
+		},
+		{
+			name:           "archive without application",
+			contentType:    "application/gzip",
+			body:           string(tarGz(t, map[string]string{"development/manifests.yaml": "dev manifest"})),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid application name: '' - must not be empty\n",
+		},
+		{
+			name:           "archive that is not gzipped",
+			contentType:    "application/gzip",
+			query:          "application=app1",
+			body:           "manifests",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Invalid archive: unexpected EOF\n",
+		},
+		{
+			name:           "unsupported content type",
+			contentType:    "text/plain",
+			body:           "manifests",
+			expectedStatus: http.StatusUnsupportedMediaType,
+			expectedBody:   "body must be multipart/form-data, application/json or application/gzip, got: 'text/plain'\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			batchClient := &mockBatchClient{batchResponse: successResponse}
+			s := Server{
+				BatchClient: batchClient,
+				KeyRing:     tt.KeyRing,
+			}
+			req := httptest.NewRequest(http.MethodPost, "/release?"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			s.Handle(w, req)
+			resp := w.Result()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d but got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("error reading response body: %s", err)
+			}
+			if d := cmp.Diff(tt.expectedBody, string(body)); d != "" {
+				t.Errorf("response body mismatch: %s", d)
+			}
+			if d := cmp.Diff(tt.expectedBatchRequest, batchClient.batchRequest, protocmp.Transform()); d != "" {
+				t.Errorf("create batch request mismatch: %s", d)
+			}
+		})
+	}
 }
@@ -29,56 +29,9 @@ import (
 	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 	"github.com/freiheit-com/kuberpult/pkg/logger"
-	xpath "github.com/freiheit-com/kuberpult/pkg/path"
 )
 
-func (s Server) handleEnvironmentGroupLocks(w http.ResponseWriter, req *http.Request, environmentGroup, tail string) {
-	lockID, tail := xpath.Shift(tail)
-	if lockID == "" {
-		http.Error(w, "missing ID for env group lock", http.StatusNotFound)
-		return
-	}
-	if tail != "/" {
-		http.Error(w, fmt.Sprintf("group locks does not accept additional path arguments after the lock ID, got: '%s'", tail), http.StatusNotFound)
-		return
-	}
-
-	switch req.Method {
-	case http.MethodPut:
-		s.handlePutEnvironmentGroupLock(w, req, environmentGroup, lockID)
-	case http.MethodDelete:
-		s.handleDeleteEnvironmentGroupLock(w, req, environmentGroup, lockID)
-	default:
-		http.Error(w, fmt.Sprintf("unsupported method '%s'", req.Method), http.StatusMethodNotAllowed)
-	}
-}
-
-func (s Server) handleEnvironmentLocks(w http.ResponseWriter, req *http.Request, environment, tail string) {
-	lockID, tail := xpath.Shift(tail)
-	if lockID == "" {
-		http.Error(w, "missing ID for env lock", http.StatusNotFound)
-		return
-	}
-	if tail != "/" {
-		http.Error(w, fmt.Sprintf("env locks does not accept additional path arguments after the lock ID, got: '%s'", tail), http.StatusNotFound)
-		return
-	}
-
-	switch req.Method {
-	case http.MethodPut:
-		s.handlePutEnvironmentLock(w, req, environment, lockID)
-	case http.MethodDelete:
-		s.handleDeleteEnvironmentLock(w, req, environment, lockID)
-	default:
-		http.Error(w, fmt.Sprintf("unsupported method '%s'", req.Method), http.StatusMethodNotAllowed)
-	}
-}
-
 func (s Server) handlePutEnvironmentLock(w http.ResponseWriter, req *http.Request, environment, lockID string) {
-	if s.checkContentType(w, req) {
-		return
-	}
-
 	var body putLockRequest
 	invalidMessage := "Please provide lock message in body"
 	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
@@ -182,10 +135,6 @@ func (s Server) handleDeleteEnvironmentLock(w http.ResponseWriter, req *http.Req
 }
 
 func (s Server) handlePutEnvironmentGroupLock(w http.ResponseWriter, req *http.Request, environmentGroup, lockID string) {
-	if s.checkContentType(w, req) {
-		return
-	}
-
 	var body putLockRequest
 	invalidMessage := "Please provide lock message in body"
 	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
@@ -0,0 +1,184 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/freiheit-com/kuberpult/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type openApiDocument struct {
+	OpenApi string                                 `json:"openapi"`
+	Info    openApiInfo                            `json:"info"`
+	Paths   map[string]map[string]openApiOperation `json:"paths"`
+}
+
+type openApiInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openApiOperation struct {
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description,omitempty"`
+	OperationId string                     `json:"operationId"`
+	Parameters  []openApiParameter         `json:"parameters,omitempty"`
+	RequestBody *openApiRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openApiResponse `json:"responses"`
+}
+
+type openApiParameter struct {
+	Name        string        `json:"name"`
+	In          string        `json:"in"`
+	Required    bool          `json:"required"`
+	Description string        `json:"description,omitempty"`
+	Schema      openApiSchema `json:"schema"`
+}
+
+type openApiRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openApiMediaType `json:"content"`
+}
+
+type openApiMediaType struct {
+	Schema openApiSchema `json:"schema"`
+}
+
+type openApiSchema struct {
+	Type        string                   `json:"type"`
+	Format      string                   `json:"format,omitempty"`
+	Description string                   `json:"description,omitempty"`
+	Properties  map[string]openApiSchema `json:"properties,omitempty"`
+	Required    []string                 `json:"required,omitempty"`
+	Items       *openApiSchema           `json:"items,omitempty"`
+}
+
+type openApiResponse struct {
+	Description string `json:"description"`
+}
+
+func (s Server) handleOpenApi(w http.ResponseWriter, req *http.Request) {
+	jsonResponse, err := json.Marshal(openApi(allRoutes(), s.Config.Version))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Internal error: %s", err), http.StatusInternalServerError)
+		logger.FromContext(req.Context()).Error("openapi.json", zap.Error(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// openApi generates an OpenAPI 3 document from the route table.
+func openApi(routes []route, version string) openApiDocument {
+	document := openApiDocument{
+		OpenApi: "3.0.3",
+		Info: openApiInfo{
+			Title:   "kuberpult",
+			Version: version,
+		},
+		Paths: map[string]map[string]openApiOperation{},
+	}
+	for _, r := range routes {
+		if document.Paths[r.Path] == nil {
+			document.Paths[r.Path] = map[string]openApiOperation{}
+		}
+		operation := openApiOperation{
+			Summary:     r.Summary,
+			Description: r.Description,
+			OperationId: r.OperationId,
+			Parameters:  nil,
+			RequestBody: nil,
+			Responses:   map[string]openApiResponse{},
+		}
+		for _, segment := range strings.Split(r.Path, "/") {
+			if name, ok := pathParameterName(segment); ok {
+				operation.Parameters = append(operation.Parameters, openApiParameter{
+					Name:        name,
+					In:          "path",
+					Required:    true,
+					Description: "",
+					Schema:      openApiSchema{Type: "string"},
+				})
+			}
+		}
+		for _, field := range r.Query {
+			operation.Parameters = append(operation.Parameters, openApiParameter{
+				Name:        field.Name,
+				In:          "query",
+				Required:    field.Required,
+				Description: field.Description,
+				Schema:      fieldSchema(field),
+			})
+		}
+		if r.ContentType != "" {
+			schema := bodySchema(routeBody{ContentType: r.ContentType, Fields: r.Fields, Description: ""})
+			operation.RequestBody = &openApiRequestBody{
+				Required: len(schema.Required) > 0,
+				Content:  map[string]openApiMediaType{r.ContentType: {Schema: schema}},
+			}
+			for _, body := range r.AlternativeBodies {
+				operation.RequestBody.Content[body.ContentType] = openApiMediaType{Schema: bodySchema(body)}
+			}
+		} else if r.RawBody != "" {
+			contentType := r.RawBodyContentType
+			if contentType == "" {
+				contentType = "text/plain"
+			}
+			operation.RequestBody = &openApiRequestBody{
+				Required: false,
+				Content:  map[string]openApiMediaType{contentType: {Schema: openApiSchema{Type: "string", Description: r.RawBody}}},
+			}
+		}
+		for code, description := range r.Responses {
+			operation.Responses[strconv.Itoa(code)] = openApiResponse{Description: description}
+		}
+		document.Paths[r.Path][strings.ToLower(r.Method)] = operation
+	}
+	return document
+}
+
+func bodySchema(body routeBody) openApiSchema {
+	if body.ContentType != contentTypeJson && body.ContentType != contentTypeMultipart {
+		return openApiSchema{Type: "string", Format: "binary", Description: body.Description}
+	}
+	schema := openApiSchema{Type: "object", Properties: map[string]openApiSchema{}}
+	for _, field := range body.Fields {
+		schema.Properties[field.Name] = fieldSchema(field)
+		if field.Required {
+			schema.Required = append(schema.Required, field.Name)
+		}
+	}
+	return schema
+}
+
+func fieldSchema(field routeField) openApiSchema {
+	if field.Type == "file" {
+		return openApiSchema{Type: "string", Format: "binary", Description: field.Description}
+	}
+	if field.Type == "array" {
+		// arrays are only used for strings so far
+		return openApiSchema{Type: "array", Description: field.Description, Items: &openApiSchema{Type: "string"}}
+	}
+	return openApiSchema{Type: field.Type, Description: field.Description}
+}
@@ -0,0 +1,266 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+)
+
+const (
+	defaultReleasesPageSize = 20
+	maximumReleasesPageSize = 100
+)
+
+type upstreamResponse struct {
+	Environment string `json:"environment,omitempty"`
+	Latest      bool   `json:"latest,omitempty"`
+}
+
+type environmentResponse struct {
+	Name               string                                    `json:"name"`
+	EnvironmentGroup   string                                    `json:"environmentGroup"`
+	Priority           string                                    `json:"priority"`
+	DistanceToUpstream uint32                                    `json:"distanceToUpstream"`
+	Upstream           *upstreamResponse                         `json:"upstream,omitempty"`
+	Locks              map[string]lockResponse                   `json:"locks"`
+	Applications       map[string]environmentApplicationResponse `json:"applications,omitempty"`
+}
+
+type environmentApplicationResponse struct {
+	Name            string                  `json:"name"`
+	Team            string                  `json:"team,omitempty"`
+	Version         uint64                  `json:"version"`
+	QueuedVersion   uint64                  `json:"queuedVersion"`
+	UndeployVersion bool                    `json:"undeployVersion"`
+	Locks           map[string]lockResponse `json:"locks"`
+	DeployAuthor    string                  `json:"deployAuthor,omitempty"`
+	DeployTime      *time.Time              `json:"deployTime,omitempty"`
+}
+
+type releaseResponse struct {
+	Version         uint64    `json:"version"`
+	DisplayVersion  string    `json:"displayVersion,omitempty"`
+	SourceCommitId  string    `json:"sourceCommitId"`
+	SourceAuthor    string    `json:"sourceAuthor"`
+	SourceMessage   string    `json:"sourceMessage"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UndeployVersion bool      `json:"undeployVersion"`
+	PrNumber        string    `json:"prNumber,omitempty"`
+}
+
+type releasesResponse struct {
+	Application   string            `json:"application"`
+	Releases      []releaseResponse `json:"releases"`
+	Page          int               `json:"page"`
+	PageSize      int               `json:"pageSize"`
+	TotalReleases int               `json:"totalReleases"`
+}
+
+// getOverview returns false and writes the error response if the overview is not available.
+func (s Server) getOverview(w http.ResponseWriter, req *http.Request) (*api.GetOverviewResponse, bool) {
+	if s.OverviewClient == nil {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+		return nil, false
+	}
+	overview, err := s.OverviewClient.GetOverview(req.Context(), &api.GetOverviewRequest{})
+	if err != nil {
+		handleGRPCError(req.Context(), w, err)
+		return nil, false
+	}
+	return overview, true
+}
+
+func (s Server) handleGetEnvironments(w http.ResponseWriter, req *http.Request) {
+	overview, ok := s.getOverview(w, req)
+	if !ok {
+		return
+	}
+	environments := []environmentResponse{}
+	for _, group := range overview.EnvironmentGroups {
+		for _, env := range group.Environments {
+			environments = append(environments, environmentFromApi(group, env, nil))
+		}
+	}
+	writeJSONWithETag(w, req, environments)
+}
+
+func (s Server) handleGetEnvironment(w http.ResponseWriter, req *http.Request, environment string) {
+	overview, ok := s.getOverview(w, req)
+	if !ok {
+		return
+	}
+	group, env := findEnvironment(overview, environment)
+	if env == nil {
+		http.Error(w, fmt.Sprintf("environment '%s' not found", environment), http.StatusNotFound)
+		return
+	}
+	writeJSONWithETag(w, req, environmentFromApi(group, env, overview.Applications))
+}
+
+func (s Server) handleGetEnvironmentApplication(w http.ResponseWriter, req *http.Request, environment, application string) {
+	overview, ok := s.getOverview(w, req)
+	if !ok {
+		return
+	}
+	_, env := findEnvironment(overview, environment)
+	if env == nil {
+		http.Error(w, fmt.Sprintf("environment '%s' not found", environment), http.StatusNotFound)
+		return
+	}
+	app, ok := env.Applications[application]
+	if !ok {
+		http.Error(w, fmt.Sprintf("application '%s' not found in environment '%s'", application, environment), http.StatusNotFound)
+		return
+	}
+	writeJSONWithETag(w, req, environmentApplicationFromApi(app, overview.Applications[application]))
+}
+
+// handleGetApplicationReleases returns the releases of an application, newest first.
+// Supported query parameters are page (starting at 1) and pageSize.
+func (s Server) handleGetApplicationReleases(w http.ResponseWriter, req *http.Request, application string) {
+	page, err := parsePositiveQueryParameter(req, "page", 1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pageSize, err := parsePositiveQueryParameter(req, "pageSize", defaultReleasesPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if pageSize > maximumReleasesPageSize {
+		pageSize = maximumReleasesPageSize
+	}
+	overview, ok := s.getOverview(w, req)
+	if !ok {
+		return
+	}
+	app, ok := overview.Applications[application]
+	if !ok {
+		http.Error(w, fmt.Sprintf("application '%s' not found", application), http.StatusNotFound)
+		return
+	}
+	releases := make([]*api.Release, len(app.Releases))
+	copy(releases, app.Releases)
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Version > releases[j].Version
+	})
+	response := releasesResponse{
+		Application:   application,
+		Releases:      []releaseResponse{},
+		Page:          page,
+		PageSize:      pageSize,
+		TotalReleases: len(releases),
+	}
+	for i := (page - 1) * pageSize; i < len(releases) && i < page*pageSize; i++ {
+		response.Releases = append(response.Releases, releaseFromApi(releases[i]))
+	}
+	writeJSONWithETag(w, req, response)
+}
+
+func parsePositiveQueryParameter(req *http.Request, name string, defaultValue int) (int, error) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil || result < 1 {
+		return 0, fmt.Errorf("Invalid %s: '%s' must be a positive number", name, value)
+	}
+	return result, nil
+}
+
+func findEnvironment(overview *api.GetOverviewResponse, environment string) (*api.EnvironmentGroup, *api.Environment) {
+	for _, group := range overview.EnvironmentGroups {
+		for _, env := range group.Environments {
+			if env.Name == environment {
+				return group, env
+			}
+		}
+	}
+	return nil, nil
+}
+
+// environmentFromApi only includes the applications if they are passed.
+func environmentFromApi(group *api.EnvironmentGroup, env *api.Environment, applications map[string]*api.Application) environmentResponse {
+	response := environmentResponse{
+		Name:               env.Name,
+		EnvironmentGroup:   group.EnvironmentGroupName,
+		Priority:           env.Priority.String(),
+		DistanceToUpstream: env.DistanceToUpstream,
+		Upstream:           nil,
+		Locks:              map[string]lockResponse{},
+		Applications:       nil,
+	}
+	if upstream := env.Config.GetUpstream(); upstream != nil {
+		response.Upstream = &upstreamResponse{
+			Environment: upstream.GetEnvironment(),
+			Latest:      upstream.GetLatest(),
+		}
+	}
+	for lockId, lock := range env.Locks {
+		response.Locks[lockId] = lockFromApi(lock)
+	}
+	if applications != nil {
+		response.Applications = map[string]environmentApplicationResponse{}
+		for name, app := range env.Applications {
+			response.Applications[name] = environmentApplicationFromApi(app, applications[name])
+		}
+	}
+	return response
+}
+
+func environmentApplicationFromApi(app *api.Environment_Application, details *api.Application) environmentApplicationResponse {
+	response := environmentApplicationResponse{
+		Name:            app.Name,
+		Team:            details.GetTeam(),
+		Version:         app.Version,
+		QueuedVersion:   app.QueuedVersion,
+		UndeployVersion: app.UndeployVersion,
+		Locks:           map[string]lockResponse{},
+		DeployAuthor:    app.DeploymentMetaData.GetDeployAuthor(),
+		DeployTime:      nil,
+	}
+	for lockId, lock := range app.Locks {
+		response.Locks[lockId] = lockFromApi(lock)
+	}
+	// the deploy time is sent as unix timestamp in seconds, see api.proto
+	if seconds, err := strconv.ParseInt(app.DeploymentMetaData.GetDeployTime(), 10, 64); err == nil {
+		deployTime := time.Unix(seconds, 0).UTC()
+		response.DeployTime = &deployTime
+	}
+	return response
+}
+
+func releaseFromApi(release *api.Release) releaseResponse {
+	return releaseResponse{
+		Version:         release.Version,
+		DisplayVersion:  release.DisplayVersion,
+		SourceCommitId:  release.SourceCommitId,
+		SourceAuthor:    release.SourceAuthor,
+		SourceMessage:   release.SourceMessage,
+		CreatedAt:       release.CreatedAt.AsTime(),
+		UndeployVersion: release.UndeployVersion,
+		PrNumber:        release.PrNumber,
+	}
+}
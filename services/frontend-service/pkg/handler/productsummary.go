@@ -0,0 +1,76 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package handler
+
+import (
+	"net/http"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+)
+
+type productSummaryResponse struct {
+	App            string `json:"app"`
+	Version        string `json:"version"`
+	CommitId       string `json:"commitId"`
+	DisplayVersion string `json:"displayVersion"`
+	Environment    string `json:"environment"`
+	Team           string `json:"team"`
+}
+
+// handleProductSummary returns the versions of all apps in an environment or environment group at a commit of the manifest repository.
+// Supported query parameters are commit, and either env or group.
+func (s Server) handleProductSummary(w http.ResponseWriter, req *http.Request) {
+	if s.GitClient == nil {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+		return
+	}
+	query := req.URL.Query()
+	commit := query.Get("commit")
+	environment := query.Get("env")
+	environmentGroup := query.Get("group")
+	if (environment == "") == (environmentGroup == "") {
+		http.Error(w, "Exactly one of the env and group query parameters is required", http.StatusBadRequest)
+		return
+	}
+	request := &api.GetProductSummaryRequest{
+		CommitHash:       commit,
+		Environment:      nil,
+		EnvironmentGroup: nil,
+	}
+	if environment != "" {
+		request.Environment = &environment
+	} else {
+		request.EnvironmentGroup = &environmentGroup
+	}
+	response, err := s.GitClient.GetProductSummary(req.Context(), request)
+	if err != nil {
+		handleGRPCError(req.Context(), w, err)
+		return
+	}
+	summary := make([]productSummaryResponse, 0, len(response.ProductSummary))
+	for _, product := range response.ProductSummary {
+		summary = append(summary, productSummaryResponse{
+			App:            product.App,
+			Version:        product.Version,
+			CommitId:       product.CommitId,
+			DisplayVersion: product.DisplayVersion,
+			Environment:    product.Environment,
+			Team:           product.Team,
+		})
+	}
+	writeJSONWithETag(w, req, summary)
+}
@@ -17,12 +17,18 @@ Copyright 2023 freiheit.com*/
 package handler
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -31,12 +37,24 @@ import (
 	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 	"github.com/freiheit-com/kuberpult/pkg/logger"
+	"github.com/freiheit-com/kuberpult/pkg/release"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+const (
+	// Limits json and archive releases, which are not restricted by MAXIMUM_MULTIPART_SIZE.
+	MAXIMUM_RELEASE_SIZE = 64 * 1024 * 1024 // = 64Mi
+	manifestEncodingGzip = "gzip+base64"
+)
+
+// errReleaseTooLarge is returned if the decompressed content of a release exceeds MAXIMUM_RELEASE_SIZE.
+var errReleaseTooLarge = fmt.Errorf("body must not be larger than %d bytes", MAXIMUM_RELEASE_SIZE)
+
 var (
 	manifestFieldRx = regexp.MustCompile(`\Amanifests\[([^]]+)\]\z`)
+	// matches the files of release archives, e.g. "development/manifests.yaml"
+	archiveEntryRx = regexp.MustCompile(`\A([^/]+)/(manifests\.yaml|signature\.asc)\z`)
 	// matches hex strings with 7 - 40 chars
 	commitIdRx = regexp.MustCompile(`\A[0-9a-f]{7,40}\z`)
 	// parses anything that looks like "name <mail@host.com>"
@@ -76,13 +94,21 @@ func writeReleaseResponse(w http.ResponseWriter, r *http.Request, jsonBlob []byt
 	w.Write([]byte("\n"))
 }
 
-func (s Server) HandleRelease(w http.ResponseWriter, r *http.Request, tail string) {
-	ctx := r.Context()
-	if tail != "/" {
-		http.Error(w, fmt.Sprintf("Release does not accept additional path arguments, got: %s", tail), http.StatusNotFound)
-		return
+// HandleRelease creates a release from a multipart form, a json body or a tar.gz archive.
+func (s Server) HandleRelease(w http.ResponseWriter, r *http.Request) {
+	// the route table ensures that the content type is supported
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case contentTypeJson:
+		s.handleJsonRelease(w, r)
+	case contentTypeGzip:
+		s.handleArchiveRelease(w, r)
+	default:
+		s.handleMultipartRelease(w, r)
 	}
+}
 
+func (s Server) handleMultipartRelease(w http.ResponseWriter, r *http.Request) {
 	tf := api.CreateReleaseRequest{
 		Manifests: map[string]string{},
 	}
@@ -92,16 +118,11 @@ func (s Server) HandleRelease(w http.ResponseWriter, r *http.Request, tail strin
 		return
 	}
 	form := r.MultipartForm
-	if len(form.Value["application"]) != 1 {
-		if len(form.Value["application"]) > 1 {
-			w.WriteHeader(400)
-			fmt.Fprintf(w, "Please provide single application name")
-			return
-		} else {
-			w.WriteHeader(400)
-			fmt.Fprintf(w, "Must provide application name")
-			return
-		}
+	// the route table ensures that an application name is provided
+	if len(form.Value["application"]) > 1 {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, "Please provide single application name")
+		return
 	}
 	application := form.Value["application"][0]
 	if application == "" {
@@ -195,6 +216,15 @@ func (s Server) HandleRelease(w http.ResponseWriter, r *http.Request, tail strin
 			tf.SourceMessage = sourceMessage[0]
 		}
 	}
+
+	if sourceRepoUrl, ok := form.Value["source_repo_url"]; ok {
+		if len(sourceRepoUrl) == 1 {
+			tf.SourceRepoUrl = sourceRepoUrl[0]
+		}
+	}
+
+	tf.SourcePaths = form.Value["source_paths"]
+
 	if version, ok := form.Value["version"]; ok {
 		if len(version) == 1 {
 			val, err := strconv.ParseUint(version[0], 10, 64)
@@ -221,10 +251,16 @@ func (s Server) HandleRelease(w http.ResponseWriter, r *http.Request, tail strin
 
 	}
 
+	s.createRelease(w, r, &tf)
+}
+
+// createRelease sends the release to the cd-service and responds with the result.
+func (s Server) createRelease(w http.ResponseWriter, r *http.Request, tf *api.CreateReleaseRequest) {
+	ctx := r.Context()
 	response, err := s.BatchClient.ProcessBatch(ctx, &api.BatchRequest{Actions: []*api.BatchAction{
 		{
 			Action: &api.BatchAction_CreateRelease{
-				CreateRelease: &tf,
+				CreateRelease: tf,
 			},
 		}},
 	})
@@ -234,6 +270,10 @@ func (s Server) HandleRelease(w http.ResponseWriter, r *http.Request, tail strin
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if ok && s.Code() == codes.PermissionDenied {
+			http.Error(w, s.Message(), http.StatusForbidden)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -292,3 +332,220 @@ func (s Server) HandleRelease(w http.ResponseWriter, r *http.Request, tail strin
 		}
 	}
 }
+
+func (s Server) handleJsonRelease(w http.ResponseWriter, r *http.Request) {
+	var body postReleaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if body.ManifestEncoding != "" && body.ManifestEncoding != manifestEncodingGzip {
+		http.Error(w, fmt.Sprintf("invalid manifestEncoding '%s', must be '%s' or empty", body.ManifestEncoding, manifestEncodingGzip), http.StatusBadRequest)
+		return
+	}
+	tf := api.CreateReleaseRequest{
+		Application:   body.Application,
+		Manifests:     map[string]string{},
+		Version:       body.Version,
+		SourceRepoUrl: body.SourceRepoUrl,
+		SourcePaths:   body.SourcePaths,
+	}
+	remaining := int64(MAXIMUM_RELEASE_SIZE)
+	for environmentName, manifest := range body.Manifests {
+		content, err := decodeManifest(manifest, body.ManifestEncoding, remaining)
+		if errors.Is(err, errReleaseTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid manifest for %s: %s", environmentName, err), http.StatusBadRequest)
+			return
+		}
+		remaining -= int64(len(content))
+		tf.Manifests[environmentName] = content
+	}
+	if len(tf.Manifests) == 0 {
+		http.Error(w, "No manifest files provided", http.StatusBadRequest)
+		return
+	}
+	if s.KeyRing != nil {
+		// the signature covers the request as sent, before optional fields are dropped
+		hash, err := release.Hash(release.Release{
+			Application:    body.Application,
+			DisplayVersion: body.DisplayVersion,
+			Manifests:      tf.Manifests,
+			SourceAuthor:   body.SourceAuthor,
+			SourceCommitId: body.SourceCommitId,
+			SourceMessage:  body.SourceMessage,
+			SourcePaths:    body.SourcePaths,
+			SourceRepoUrl:  body.SourceRepoUrl,
+			Team:           body.Team,
+			Version:        body.Version,
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Internal: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if err := s.checkReleaseSignature([]byte(hash), []byte(body.Signature)); err != nil {
+			http.Error(w, fmt.Sprintf("%s for release", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := setReleaseFields(&tf, body.Team, body.SourceCommitId, body.SourceAuthor, body.SourceMessage, body.DisplayVersion); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.createRelease(w, r, &tf)
+}
+
+// decodeManifest returns the plain manifest. It fails if the manifest is longer than the limit.
+func decodeManifest(manifest, encoding string, limit int64) (string, error) {
+	if encoding != manifestEncodingGzip {
+		if int64(len(manifest)) > limit {
+			return "", errReleaseTooLarge
+		}
+		return manifest, nil
+	}
+	compressed, err := base64.StdEncoding.DecodeString(manifest)
+	if err != nil {
+		return "", err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	content, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(content)) > limit {
+		return "", errReleaseTooLarge
+	}
+	return string(content), nil
+}
+
+// handleArchiveRelease reads a tar.gz archive with the files <environment>/manifests.yaml and <environment>/signature.asc.
+// The archive is decompressed while it is received, the other fields of the release are query parameters.
+func (s Server) handleArchiveRelease(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tf := api.CreateReleaseRequest{
+		Application:   query.Get("application"),
+		Manifests:     map[string]string{},
+		SourceRepoUrl: query.Get("source_repo_url"),
+		SourcePaths:   query["source_paths"],
+	}
+	if version := query.Get("version"); version != "" {
+		val, err := strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid version: %s", err), http.StatusBadRequest)
+			return
+		}
+		tf.Version = val
+	}
+	if err := setReleaseFields(&tf, query.Get("team"), query.Get("source_commit_id"), query.Get("source_author"), query.Get("source_message"), query.Get("display_version")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reader, err := gzip.NewReader(http.MaxBytesReader(w, r.Body, MAXIMUM_RELEASE_SIZE))
+	if err != nil {
+		writeArchiveError(w, err)
+		return
+	}
+	archive := tar.NewReader(reader)
+	signatures := map[string][]byte{}
+	remaining := int64(MAXIMUM_RELEASE_SIZE)
+	for {
+		hdr, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeArchiveError(w, err)
+			return
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		match := archiveEntryRx.FindStringSubmatch(path.Clean(hdr.Name))
+		if match == nil {
+			http.Error(w, fmt.Sprintf("Invalid archive entry '%s'. Must be <environment>/manifests.yaml or <environment>/signature.asc", hdr.Name), http.StatusBadRequest)
+			return
+		}
+		content, err := io.ReadAll(io.LimitReader(archive, remaining+1))
+		if err != nil {
+			writeArchiveError(w, err)
+			return
+		}
+		remaining -= int64(len(content))
+		if remaining < 0 {
+			http.Error(w, errReleaseTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		environmentName := match[1]
+		if match[2] == "signature.asc" {
+			signatures[environmentName] = content
+			continue
+		}
+		if _, ok := tf.Manifests[environmentName]; ok {
+			http.Error(w, fmt.Sprintf("multiple manifests submitted for %q", environmentName), http.StatusBadRequest)
+			return
+		}
+		tf.Manifests[environmentName] = string(content)
+	}
+	if len(tf.Manifests) == 0 {
+		http.Error(w, "No manifest files provided", http.StatusBadRequest)
+		return
+	}
+	if s.KeyRing != nil {
+		for environmentName, manifest := range tf.Manifests {
+			if err := s.checkReleaseSignature([]byte(manifest), signatures[environmentName]); err != nil {
+				http.Error(w, fmt.Sprintf("%s for %s", err, environmentName), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	s.createRelease(w, r, &tf)
+}
+
+func writeArchiveError(w http.ResponseWriter, err error) {
+	if writeBodyTooLarge(w, err) {
+		return
+	}
+	http.Error(w, fmt.Sprintf("Invalid archive: %s", err), http.StatusBadRequest)
+}
+
+// setReleaseFields validates and sets the optional fields of json and archive releases.
+// Like for multipart releases, invalid commit ids and authors are ignored.
+func setReleaseFields(tf *api.CreateReleaseRequest, team, sourceCommitId, sourceAuthor, sourceMessage, displayVersion string) error {
+	if tf.Application == "" {
+		return fmt.Errorf("Invalid application name: '%s' - must not be empty", tf.Application)
+	}
+	if len(displayVersion) > 15 {
+		return fmt.Errorf("DisplayVersion given should be <= 15 characters")
+	}
+	tf.Team = team
+	if isCommitId(sourceCommitId) {
+		tf.SourceCommitId = sourceCommitId
+	}
+	if isAuthor(sourceAuthor) {
+		tf.SourceAuthor = sourceAuthor
+	}
+	tf.SourceMessage = sourceMessage
+	tf.DisplayVersion = displayVersion
+	return nil
+}
+
+// checkReleaseSignature returns an error if the signature is missing or not valid for the content.
+func (s Server) checkReleaseSignature(content, signature []byte) error {
+	if len(signature) == 0 {
+		return errors.New("signature not found")
+	}
+	if _, err := openpgp.CheckArmoredDetachedSignature(s.KeyRing, bytes.NewReader(content), bytes.NewReader(signature), nil); err != nil {
+		if err == pgperrors.ErrUnknownIssuer {
+			return errors.New("signature not found")
+		}
+		return fmt.Errorf("Invalid Signature: %s", err)
+	}
+	return nil
+}
@@ -29,15 +29,7 @@ import (
 	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
 )
 
-func (s Server) handleReleaseTrain(w http.ResponseWriter, req *http.Request, target, tail string) {
-	if req.Method != http.MethodPut {
-		http.Error(w, fmt.Sprintf("releasetrain only accepts method PUT, got: '%s'", req.Method), http.StatusMethodNotAllowed)
-		return
-	}
-	if tail != "/" {
-		http.Error(w, fmt.Sprintf("releasetrain does not accept additional path arguments, got: '%s'", tail), http.StatusNotFound)
-		return
-	}
+func (s Server) handleReleaseTrain(w http.ResponseWriter, req *http.Request, target string) {
 	queryParams := req.URL.Query()
 	teamParam := queryParams.Get("team")
 
@@ -20,3 +20,30 @@ type putLockRequest struct {
 	Message   string `json:"message"`
 	Signature string `json:"signature,omitempty"`
 }
+
+type putDeployRequest struct {
+	Version                uint64  `json:"version"`
+	LockBehavior           string  `json:"lockBehavior"`
+	Signature              string  `json:"signature,omitempty"`
+	ExpectedCurrentVersion *uint64 `json:"expectedCurrentVersion,omitempty"`
+	ExpectedGitRevision    string  `json:"expectedGitRevision,omitempty"`
+}
+
+type postReleaseRequest struct {
+	Application    string `json:"application"`
+	Team           string `json:"team,omitempty"`
+	SourceCommitId string `json:"sourceCommitId,omitempty"`
+	SourceAuthor   string `json:"sourceAuthor,omitempty"`
+	SourceMessage  string `json:"sourceMessage,omitempty"`
+	Version        uint64 `json:"version,omitempty"`
+	DisplayVersion string `json:"displayVersion,omitempty"`
+	SourceRepoUrl  string `json:"sourceRepoUrl,omitempty"`
+	// The paths in the source repository that belong to the application.
+	SourcePaths []string `json:"sourcePaths,omitempty"`
+	// The manifests by environment, encoded with ManifestEncoding.
+	Manifests map[string]string `json:"manifests"`
+	// Either empty for plain manifests or "gzip+base64".
+	ManifestEncoding string `json:"manifestEncoding,omitempty"`
+	// Armored detached signature of the hash of the release, see pkg/release.
+	Signature string `json:"signature,omitempty"`
+}
@@ -36,9 +36,6 @@ func (s Server) handleEnvironmentGroupRolloutStatus(w http.ResponseWriter, req *
 		return
 	}
 	ctx := req.Context()
-	if s.checkContentType(w, req) {
-		return
-	}
 
 	var reqBody struct {
 		Signature    string `json:"signature"`
@@ -0,0 +1,595 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	contentTypeJson      = "application/json"
+	contentTypeMultipart = "multipart/form-data"
+	contentTypeGzip      = "application/gzip"
+)
+
+const (
+	// Limits the bodies of all routes except /release.
+	MAXIMUM_REQUEST_SIZE = 1024 * 1024 // = 1Mi
+)
+
+var (
+	routeTableOnce sync.Once
+	routeTable     []route
+)
+
+// A field of a request body or a query parameter.
+type routeField struct {
+	Name        string
+	Type        string // "string", "integer", "boolean", "object" or "file"
+	Required    bool
+	Description string
+}
+
+// A request body with another content type than the main body of a route.
+type routeBody struct {
+	ContentType string
+	Fields      []routeField
+	// Description of a body without fields, e.g. an archive.
+	Description string
+}
+
+// A route describes one endpoint of the rest api. The route table is used to dispatch and validate requests and to generate the OpenAPI document.
+type route struct {
+	Method string
+	// Path parameters are written in braces, e.g. "/environments/{environment}".
+	Path string
+	// OperationId identifies the endpoint in the OpenAPI document, e.g. for generated clients.
+	OperationId string
+	Summary     string
+	Description string
+	Query       []routeField
+	// ContentType is the required content type of the body. If empty, the body is not validated.
+	ContentType string
+	Fields      []routeField
+	// AlternativeBodies are accepted in addition to the body with ContentType.
+	AlternativeBodies []routeBody
+	// RawBody describes a body that is passed as plain text, e.g. a signature.
+	RawBody string
+	// RawBodyContentType is the content type of the raw body. If empty, it is text/plain.
+	RawBodyContentType string
+	// MaxBodySize limits the body of the request. If 0, it is MAXIMUM_REQUEST_SIZE.
+	MaxBodySize int64
+	Responses   map[int]string
+	Handler     func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string)
+}
+
+// allRoutes returns the route table. It never changes, so it is only built once.
+func allRoutes() []route {
+	routeTableOnce.Do(func() {
+		routeTable = routes()
+	})
+	return routeTable
+}
+
+func (s Server) Handle(w http.ResponseWriter, req *http.Request) {
+	allowedMethods := []string{}
+	for _, r := range allRoutes() {
+		params, ok := matchPath(r.Path, req.URL.Path)
+		if !ok {
+			continue
+		}
+		if r.Method != req.Method {
+			allowedMethods = append(allowedMethods, r.Method)
+			continue
+		}
+		if req.Body != nil {
+			req.Body = http.MaxBytesReader(w, req.Body, r.maxBodySize())
+		}
+		if !r.validateRequest(w, req) {
+			return
+		}
+		r.Handler(s, w, req, params)
+		return
+	}
+	if len(allowedMethods) > 0 {
+		w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
+		http.Error(w, fmt.Sprintf("unsupported method '%s'", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	http.Error(w, fmt.Sprintf("unknown endpoint '%s'", req.URL.Path), http.StatusNotFound)
+}
+
+// matchPath returns the path parameters if the request path has the shape of the pattern.
+func matchPath(pattern, requestPath string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path.Clean("/"+requestPath), "/"), "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, segment := range patternSegments {
+		if name, ok := pathParameterName(segment); ok {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			params[name] = pathSegments[i]
+		} else if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func pathParameterName(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+func (r route) maxBodySize() int64 {
+	if r.MaxBodySize == 0 {
+		return MAXIMUM_REQUEST_SIZE
+	}
+	return r.MaxBodySize
+}
+
+// validateRequest checks the query parameters, the content type and the required fields of the body.
+// It writes the error response and returns false if the request is invalid.
+func (r route) validateRequest(w http.ResponseWriter, req *http.Request) bool {
+	query := req.URL.Query()
+	for _, parameter := range r.Query {
+		if parameter.Required && query.Get(parameter.Name) == "" {
+			http.Error(w, fmt.Sprintf("missing required query parameter '%s'", parameter.Name), http.StatusBadRequest)
+			return false
+		}
+	}
+	if r.ContentType == "" {
+		return true
+	}
+	contentType := req.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	body, ok := r.body(mediaType)
+	if err != nil || !ok {
+		http.Error(w, fmt.Sprintf("body must be %s, got: '%s'", r.contentTypes(), contentType), http.StatusUnsupportedMediaType)
+		return false
+	}
+	var present func(name string) bool
+	switch body.ContentType {
+	case contentTypeJson:
+		fields, err := readJsonFields(req)
+		if writeBodyTooLarge(w, err) {
+			return false
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		present = func(name string) bool {
+			value, ok := fields[name]
+			return ok && string(value) != "null"
+		}
+	case contentTypeMultipart:
+		if err := req.ParseMultipartForm(MAXIMUM_MULTIPART_SIZE); err != nil {
+			if !writeBodyTooLarge(w, err) {
+				http.Error(w, fmt.Sprintf("Invalid body: %s", err), http.StatusBadRequest)
+			}
+			return false
+		}
+		present = func(name string) bool {
+			return len(req.MultipartForm.Value[name]) > 0 || len(req.MultipartForm.File[name]) > 0
+		}
+	default:
+		// other bodies are streamed by the handler
+		return true
+	}
+	for _, field := range body.Fields {
+		if field.Required && !present(field.Name) {
+			http.Error(w, fmt.Sprintf("missing required field '%s'", field.Name), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// body returns the body of the route with the media type.
+func (r route) body(mediaType string) (routeBody, bool) {
+	if mediaType == r.ContentType {
+		return routeBody{ContentType: r.ContentType, Fields: r.Fields, Description: ""}, true
+	}
+	for _, body := range r.AlternativeBodies {
+		if mediaType == body.ContentType {
+			return body, true
+		}
+	}
+	return routeBody{}, false
+}
+
+// contentTypes lists the accepted content types for error messages, e.g. "application/json or application/gzip".
+func (r route) contentTypes() string {
+	contentTypes := []string{r.ContentType}
+	for _, body := range r.AlternativeBodies {
+		contentTypes = append(contentTypes, body.ContentType)
+	}
+	if len(contentTypes) == 1 {
+		return contentTypes[0]
+	}
+	return strings.Join(contentTypes[:len(contentTypes)-1], ", ") + " or " + contentTypes[len(contentTypes)-1]
+}
+
+// writeBodyTooLarge writes the error response and returns true if the body exceeds the limit of the route.
+func writeBodyTooLarge(w http.ResponseWriter, err error) bool {
+	var maxBytesError *http.MaxBytesError
+	if !errors.As(err, &maxBytesError) {
+		return false
+	}
+	http.Error(w, fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit), http.StatusRequestEntityTooLarge)
+	return true
+}
+
+// readJsonFields reads the top level fields of a json body. The body can be read again by the handler.
+// The size of the body is limited by the route, see Handle.
+func readJsonFields(req *http.Request) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if req.Body == nil {
+		return fields, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read request body %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err := json.Unmarshal(body, &fields); err != nil {
+		if errors.Is(err, io.EOF) || len(bytes.TrimSpace(body)) == 0 {
+			return fields, nil
+		}
+		return nil, fmt.Errorf("Invalid body: %s", err)
+	}
+	return fields, nil
+}
+
+func routes() []route {
+	signatureField := routeField{
+		Name:        "signature",
+		Type:        "string",
+		Required:    false,
+		Description: "Armored pgp signature. Required if azure auth is enabled.",
+	}
+	return []route{
+		{
+			Method:      http.MethodPost,
+			Path:        "/release",
+			OperationId: "createRelease",
+			Summary:     "Create a release",
+			Description: "Creates a new release of an application with one manifest per environment. " +
+				"The manifests can be sent as multipart form, as json, or as tar.gz archive for large releases.",
+			Query: []routeField{
+				{Name: "application", Type: "string", Description: "Name of the application. Required for archives."},
+				{Name: "team", Type: "string", Description: "Team that owns the application. Only for archives."},
+				{Name: "source_commit_id", Type: "string", Description: "Commit hash in the source repository. Only for archives."},
+				{Name: "source_author", Type: "string", Description: "Author of the commit in the source repository. Only for archives."},
+				{Name: "source_message", Type: "string", Description: "Message of the commit in the source repository. Only for archives."},
+				{Name: "source_repo_url", Type: "string", Description: "Url of the source repository, used for the changelog. Only for archives."},
+				{Name: "source_paths", Type: "string", Description: "Path in the source repository that belongs to the application, used to filter the changelog. Can be repeated. Only for archives."},
+				{Name: "version", Type: "integer", Description: "Version of the release. Only for archives."},
+				{Name: "display_version", Type: "string", Description: "Version shown in the ui. Only for archives."},
+			},
+			ContentType: contentTypeMultipart,
+			Fields: []routeField{
+				{Name: "application", Type: "string", Required: true, Description: "Name of the application."},
+				{Name: "manifests[{environment}]", Type: "file", Description: "The manifest for an environment. At least one is required."},
+				{Name: "signatures[{environment}]", Type: "file", Description: "Armored pgp signature of the manifest for an environment. Required if a key ring is configured."},
+				{Name: "team", Type: "string", Description: "Team that owns the application."},
+				{Name: "source_commit_id", Type: "string", Description: "Commit hash in the source repository."},
+				{Name: "source_author", Type: "string", Description: "Author of the commit in the source repository."},
+				{Name: "source_message", Type: "string", Description: "Message of the commit in the source repository."},
+				{Name: "source_repo_url", Type: "string", Description: "Url of the source repository, used for the changelog."},
+				{Name: "source_paths", Type: "string", Description: "Path in the source repository that belongs to the application, used to filter the changelog. Can be repeated."},
+				{Name: "version", Type: "integer", Description: "Version of the release. Defaults to the last version + 1."},
+				{Name: "display_version", Type: "string", Description: "Version shown in the ui, at most 15 characters."},
+			},
+			AlternativeBodies: []routeBody{
+				{
+					ContentType: contentTypeJson,
+					Fields: []routeField{
+						{Name: "application", Type: "string", Required: true, Description: "Name of the application."},
+						{Name: "manifests", Type: "object", Required: true, Description: "The manifests by environment."},
+						{Name: "manifestEncoding", Type: "string", Description: "Empty for plain manifests, or 'gzip+base64' for base64 encoded gzipped manifests."},
+						{Name: "signature", Type: "string", Description: "Armored pgp signature of the hex encoded sha256 of the canonical json of the release, see pkg/release. Required if a key ring is configured."},
+						{Name: "team", Type: "string", Description: "Team that owns the application."},
+						{Name: "sourceCommitId", Type: "string", Description: "Commit hash in the source repository."},
+						{Name: "sourceAuthor", Type: "string", Description: "Author of the commit in the source repository."},
+						{Name: "sourceMessage", Type: "string", Description: "Message of the commit in the source repository."},
+						{Name: "sourceRepoUrl", Type: "string", Description: "Url of the source repository, used for the changelog."},
+						{Name: "sourcePaths", Type: "array", Description: "Paths in the source repository that belong to the application, used to filter the changelog."},
+						{Name: "version", Type: "integer", Description: "Version of the release. Defaults to the last version + 1."},
+						{Name: "displayVersion", Type: "string", Description: "Version shown in the ui, at most 15 characters."},
+					},
+					Description: "",
+				},
+				{
+					ContentType: contentTypeGzip,
+					Fields:      nil,
+					Description: "A tar.gz archive with the files <environment>/manifests.yaml and <environment>/signature.asc. The signatures are required if a key ring is configured.",
+				},
+			},
+			Responses: map[int]string{
+				http.StatusCreated:               "The release was created.",
+				http.StatusOK:                    "The same release already exists.",
+				http.StatusBadRequest:            "The request is invalid.",
+				http.StatusConflict:              "A different release with this version already exists.",
+				http.StatusRequestEntityTooLarge: "The release is larger than 64Mi.",
+				http.StatusUnsupportedMediaType:  "The body is not multipart/form-data, application/json or application/gzip.",
+			},
+			MaxBodySize: MAXIMUM_RELEASE_SIZE,
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.HandleRelease(w, req)
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/environments",
+			OperationId: "listEnvironments",
+			Summary:     "List environments",
+			Responses:   map[int]string{http.StatusOK: "The environments with their locks.", http.StatusNotModified: "The environments did not change."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleGetEnvironments(w, req)
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/environments/{environment}",
+			OperationId: "getEnvironment",
+			Summary:     "Get an environment with its applications",
+			Responses:   map[int]string{http.StatusOK: "The environment.", http.StatusNotModified: "The environment did not change.", http.StatusNotFound: "The environment does not exist."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleGetEnvironment(w, req, params["environment"])
+			},
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/environments/{environment}",
+			OperationId: "createEnvironment",
+			Summary:     "Create an environment",
+			ContentType: contentTypeMultipart,
+			Fields: []routeField{
+				{Name: "config", Type: "string", Required: true, Description: "The environment config as json."},
+				{Name: "signature", Type: "string", Description: "Armored pgp signature of the config. Required if azure auth is enabled."},
+			},
+			Responses: map[int]string{http.StatusOK: "The environment was created.", http.StatusBadRequest: "The request is invalid.", http.StatusUnauthorized: "The signature is invalid."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleCreateEnvironment(w, req, params["environment"])
+			},
+		},
+		{
+			Method:      http.MethodPut,
+			Path:        "/environments/{environment}/locks/{lockId}",
+			OperationId: "lockEnvironment",
+			Summary:     "Lock an environment",
+			ContentType: contentTypeJson,
+			Fields: []routeField{
+				{Name: "message", Type: "string", Required: true, Description: "Why the environment is locked."},
+				signatureField,
+			},
+			Responses: map[int]string{http.StatusOK: "The lock was created.", http.StatusBadRequest: "The request is invalid.", http.StatusUnauthorized: "The signature is invalid."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handlePutEnvironmentLock(w, req, params["environment"], params["lockId"])
+			},
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/environments/{environment}/locks/{lockId}",
+			OperationId: "unlockEnvironment",
+			Summary:     "Unlock an environment",
+			RawBody:     "Armored pgp signature of the environment and the lock id. Required if azure auth is enabled.",
+			Responses:   map[int]string{http.StatusOK: "The lock was deleted.", http.StatusBadRequest: "The request is invalid.", http.StatusUnauthorized: "The signature is invalid."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleDeleteEnvironmentLock(w, req, params["environment"], params["lockId"])
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/environments/{environment}/applications/{application}",
+			OperationId: "getEnvironmentApplication",
+			Summary:     "Get the deployment of an application in an environment",
+			Responses:   map[int]string{http.StatusOK: "The deployed version, the queued version and the locks.", http.StatusNotModified: "The deployment did not change.", http.StatusNotFound: "The environment or application does not exist."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleGetEnvironmentApplication(w, req, params["environment"], params["application"])
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/environments/{environment}/applications/{application}/changelog",
+			OperationId: "getChangelog",
+			Summary:     "Get the commits of the source repository between the deployed release and another release",
+			Description: "Requires the source repository mirror of the cd-service. Only commits that change the source paths of the application are returned.",
+			Query: []routeField{
+				{Name: "version", Type: "integer", Description: "The other release. Defaults to the release that a release train would deploy."},
+			},
+			Responses: map[int]string{http.StatusOK: "The commits, newest first.", http.StatusNotModified: "The changelog did not change.", http.StatusBadRequest: "The request is invalid.", http.StatusNotFound: "The release does not exist.", http.StatusServiceUnavailable: "The commits are not mirrored yet, try again later."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleGetChangelog(w, req, params["environment"], params["application"])
+			},
+		},
+		{
+			Method:      http.MethodPut,
+			Path:        "/environments/{environment}/applications/{application}/locks/{lockId}",
+			OperationId: "lockApplication",
+			Summary:     "Lock an application in an environment",
+			ContentType: contentTypeJson,
+			Fields: []routeField{
+				{Name: "message", Type: "string", Description: "Why the application is locked."},
+			},
+			Responses: map[int]string{http.StatusOK: "The lock was created.", http.StatusBadRequest: "The request is invalid."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handlePutApplicationLock(w, req, params["environment"], params["application"], params["lockId"])
+			},
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/environments/{environment}/applications/{application}/locks/{lockId}",
+			OperationId: "unlockApplication",
+			Summary:     "Unlock an application in an environment",
+			Responses:   map[int]string{http.StatusOK: "The lock was deleted."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleDeleteApplicationLock(w, req, params["environment"], params["application"], params["lockId"])
+			},
+		},
+		{
+			Method:      http.MethodPut,
+			Path:        "/environments/{environment}/applications/{application}/deploy",
+			OperationId: "deployApplication",
+			Summary:     "Deploy a version of an application",
+			ContentType: contentTypeJson,
+			Fields: []routeField{
+				{Name: "version", Type: "integer", Required: true, Description: "The release to deploy."},
+				{Name: "lockBehavior", Type: "string", Description: "One of 'record' (default), 'fail' or 'ignore'."},
+				{Name: "signature", Type: "string", Description: "Armored pgp signature of the environment, application, version, lock behavior (record, fail or ignore) and, if given, the expected current version. Required if azure auth is enabled."},
+				{Name: "expectedCurrentVersion", Type: "integer", Description: "Only deploy if this version is currently deployed, 0 if no version is deployed."},
+				{Name: "expectedGitRevision", Type: "string", Description: "Only deploy if the manifest repository is at this commit."},
+			},
+			Responses: map[int]string{http.StatusOK: "The version was deployed or queued.", http.StatusBadRequest: "The request is invalid.", http.StatusUnauthorized: "The signature is invalid.", http.StatusConflict: "The deployment is blocked by locks, or the environment changed since the expected version or revision."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleApplicationDeploy(w, req, params["environment"], params["application"])
+			},
+		},
+		{
+			Method:      http.MethodPut,
+			Path:        "/environments/{environment}/releasetrain",
+			OperationId: "releaseTrain",
+			Summary:     "Run a release train to an environment or environment group",
+			Query: []routeField{
+				{Name: "team", Type: "string", Description: "Only deploy the applications of this team."},
+			},
+			RawBody:   "Armored pgp signature of the target. Required if azure auth is enabled.",
+			Responses: map[int]string{http.StatusOK: "The result of the release train.", http.StatusBadRequest: "The request is invalid.", http.StatusUnauthorized: "The signature is invalid."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleReleaseTrain(w, req, params["environment"])
+			},
+		},
+		{
+			Method:      http.MethodPut,
+			Path:        "/environment-groups/{environmentGroup}/locks/{lockId}",
+			OperationId: "lockEnvironmentGroup",
+			Summary:     "Lock all environments of an environment group",
+			ContentType: contentTypeJson,
+			Fields: []routeField{
+				{Name: "message", Type: "string", Required: true, Description: "Why the environment group is locked."},
+				signatureField,
+			},
+			Responses: map[int]string{http.StatusCreated: "The locks were created.", http.StatusBadRequest: "The request is invalid.", http.StatusUnauthorized: "The signature is invalid."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handlePutEnvironmentGroupLock(w, req, params["environmentGroup"], params["lockId"])
+			},
+		},
+		{
+			Method:      http.MethodDelete,
+			Path:        "/environment-groups/{environmentGroup}/locks/{lockId}",
+			OperationId: "unlockEnvironmentGroup",
+			Summary:     "Unlock all environments of an environment group",
+			// the handler checks for application/json, although the body is the plain signature
+			RawBody:            "Armored pgp signature of the environment group and the lock id. Required if azure auth is enabled.",
+			RawBodyContentType: contentTypeJson,
+			Responses:          map[int]string{http.StatusOK: "The locks were deleted.", http.StatusBadRequest: "The request is invalid.", http.StatusUnauthorized: "The signature is invalid."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleDeleteEnvironmentGroupLock(w, req, params["environmentGroup"], params["lockId"])
+			},
+		},
+		{
+			Method:      http.MethodPost,
+			Path:        "/environment-groups/{environmentGroup}/rollout-status",
+			OperationId: "getRolloutStatus",
+			Summary:     "Get the rollout status of an environment group",
+			ContentType: contentTypeJson,
+			Fields: []routeField{
+				{Name: "team", Type: "string", Description: "Only include the applications of this team."},
+				{Name: "waitDuration", Type: "string", Description: "How long to wait for a successful rollout, e.g. '5m'."},
+				{Name: "signature", Type: "string", Description: "Armored pgp signature of the environment group. Required if azure auth is enabled."},
+			},
+			Responses: map[int]string{http.StatusOK: "The rollout status.", http.StatusBadRequest: "The request is invalid.", http.StatusUnauthorized: "The signature is invalid."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleEnvironmentGroupRolloutStatus(w, req, params["environmentGroup"])
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/applications/{application}/releases",
+			OperationId: "listReleases",
+			Summary:     "List the releases of an application, newest first",
+			Query: []routeField{
+				{Name: "page", Type: "integer", Description: "The page, starting at 1."},
+				{Name: "pageSize", Type: "integer", Description: "The number of releases per page, at most 100."},
+			},
+			Responses: map[int]string{http.StatusOK: "A page of releases.", http.StatusNotModified: "The releases did not change.", http.StatusNotFound: "The application does not exist."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleGetApplicationReleases(w, req, params["application"])
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/product-summary",
+			OperationId: "getProductSummary",
+			Summary:     "Get the versions of all applications at a commit of the manifest repository",
+			Query: []routeField{
+				{Name: "commit", Type: "string", Required: true, Description: "Commit hash of the manifest repository."},
+				{Name: "env", Type: "string", Description: "The environment. Either env or group is required."},
+				{Name: "group", Type: "string", Description: "The environment group. Either env or group is required."},
+			},
+			Responses: map[int]string{http.StatusOK: "The product summary.", http.StatusNotModified: "The product summary did not change.", http.StatusBadRequest: "The request is invalid."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleProductSummary(w, req)
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/api/audit-log",
+			OperationId: "exportAuditLog",
+			Summary:     "Export the audit log",
+			Query: []routeField{
+				{Name: "user", Type: "string", Description: "Email or name of the user."},
+				{Name: "environment", Type: "string"},
+				{Name: "application", Type: "string"},
+				{Name: "from", Type: "string", Description: "RFC3339 timestamp."},
+				{Name: "to", Type: "string", Description: "RFC3339 timestamp."},
+				{Name: "limit", Type: "integer"},
+			},
+			Responses: map[int]string{http.StatusOK: "The audit log entries, newest first.", http.StatusBadRequest: "The request is invalid.", http.StatusForbidden: "Only the admin role may read the audit log."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleAuditLog(w, req)
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Path:        "/api/openapi.json",
+			OperationId: "getOpenApi",
+			Summary:     "Get the OpenAPI document of this api",
+			Responses:   map[int]string{http.StatusOK: "The OpenAPI document."},
+			Handler: func(s Server, w http.ResponseWriter, req *http.Request, params map[string]string) {
+				s.handleOpenApi(w, req)
+			},
+		},
+	}
+}
@@ -30,11 +30,31 @@ type BatchServiceWithDefaultTimeout struct {
 }
 
 func (b *BatchServiceWithDefaultTimeout) ProcessBatch(ctx context.Context, req *api.BatchRequest, options ...grpc.CallOption) (*api.BatchResponse, error) {
-	var cancel context.CancelFunc
-	_, hasDeadline := ctx.Deadline()
-	if !hasDeadline {
-		ctx, cancel = context.WithTimeout(ctx, b.DefaultTimeout)
-		defer cancel()
-	}
+	ctx, cancel := b.withDefaultTimeout(ctx)
+	defer cancel()
 	return b.Inner.ProcessBatch(ctx, req, options...)
 }
+
+func (b *BatchServiceWithDefaultTimeout) SubmitBatch(ctx context.Context, req *api.BatchRequest, options ...grpc.CallOption) (*api.SubmitBatchResponse, error) {
+	ctx, cancel := b.withDefaultTimeout(ctx)
+	defer cancel()
+	return b.Inner.SubmitBatch(ctx, req, options...)
+}
+
+func (b *BatchServiceWithDefaultTimeout) GetBatchJob(ctx context.Context, req *api.GetBatchJobRequest, options ...grpc.CallOption) (*api.BatchJob, error) {
+	ctx, cancel := b.withDefaultTimeout(ctx)
+	defer cancel()
+	return b.Inner.GetBatchJob(ctx, req, options...)
+}
+
+// WatchBatchJob has no default timeout, because the stream lasts as long as the job.
+func (b *BatchServiceWithDefaultTimeout) WatchBatchJob(ctx context.Context, req *api.GetBatchJobRequest, options ...grpc.CallOption) (api.BatchService_WatchBatchJobClient, error) {
+	return b.Inner.WatchBatchJob(ctx, req, options...)
+}
+
+func (b *BatchServiceWithDefaultTimeout) withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, hasDeadline := ctx.Deadline(); hasDeadline {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, b.DefaultTimeout)
+}
@@ -16,7 +16,11 @@ Copyright 2023 freiheit.com*/
 import { render, screen } from '@testing-library/react';
 import { CommitInfo } from './CommitInfo';
 import { MemoryRouter } from 'react-router-dom';
-import { GetCommitInfoResponse } from '../../../api/api';
+import {
+    GetCommitInfoResponse,
+    DeploymentEvent_Trigger,
+    LockPreventedDeploymentEvent_LockType,
+} from '../../../api/api';
 
 test('CommitInfo component does not render commit info when the response is undefined', () => {
     const { container } = render(
@@ -64,3 +68,65 @@ Commit message body line 2`,
     expect(screen.getAllByRole('row', { name: /received data about this commit for the first time/ })).toHaveLength(1);
     expect(screen.getAllByRole('row', { name: /dev, staging/ })).toHaveLength(1);
 });
+
+test('CommitInfo component renders deployment events', () => {
+    const commitInfo: GetCommitInfoResponse = {
+        commitHash: 'potato',
+        commitMessage: 'tomato',
+        touchedApps: ['google'],
+        events: [
+            {
+                createdAt: new Date('2024-02-09T09:46:00Z'),
+                eventType: {
+                    $case: 'deploymentEvent',
+                    deploymentEvent: {
+                        application: 'google',
+                        environment: 'staging',
+                        version: 2,
+                        authorName: 'test tester',
+                        authorEmail: 'testmail@example.com',
+                        trigger: DeploymentEvent_Trigger.RELEASE_TRAIN,
+                        releaseTrainSource: { upstreamEnvironment: 'dev', target: 'staging' },
+                    },
+                },
+            },
+            {
+                createdAt: new Date('2024-02-09T09:47:00Z'),
+                eventType: {
+                    $case: 'lockPreventedDeploymentEvent',
+                    lockPreventedDeploymentEvent: {
+                        application: 'google',
+                        environment: 'production',
+                        version: 2,
+                        lockType: LockPreventedDeploymentEvent_LockType.ENVIRONMENT,
+                        lockMessage: 'maintenance',
+                    },
+                },
+            },
+            {
+                createdAt: new Date('2024-02-09T09:48:00Z'),
+                eventType: {
+                    $case: 'replacedByEvent',
+                    replacedByEvent: {
+                        application: 'google',
+                        environment: 'staging',
+                        replacedByCommitId: 'cucumber',
+                    },
+                },
+            },
+        ],
+    };
+    render(
+        <MemoryRouter>
+            <CommitInfo commitInfo={commitInfo} />
+        </MemoryRouter>
+    );
+
+    expect(
+        screen.getAllByRole('row', { name: /Version 2 of google was deployed by a release train from dev by test tester/ })
+    ).toHaveLength(1);
+    expect(
+        screen.getAllByRole('row', { name: /was queued, because the environment is locked: maintenance/ })
+    ).toHaveLength(1);
+    expect(screen.getAllByRole('row', { name: /replaced by the release of commit cucumber/ })).toHaveLength(1);
+});
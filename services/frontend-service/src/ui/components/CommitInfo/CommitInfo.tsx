@@ -16,7 +16,13 @@ Copyright 2023 freiheit.com*/
 
 import { TopAppBar } from '../TopAppBar/TopAppBar';
 import React from 'react';
-import { GetCommitInfoResponse, Event } from '../../../api/api';
+import {
+    GetCommitInfoResponse,
+    Event,
+    DeploymentEvent,
+    DeploymentEvent_Trigger,
+    LockPreventedDeploymentEvent_LockType,
+} from '../../../api/api';
 
 type CommitInfoProps = {
     commitInfo: GetCommitInfoResponse | undefined;
@@ -104,5 +110,36 @@ const eventDescription = (event: Event): [string, string[]] => {
                 'Kuberpult received data about this commit for the first time',
                 tp.createReleaseEvent.environmentNames,
             ];
+        case 'deploymentEvent':
+            return [
+                `Version ${tp.deploymentEvent.version} of ${tp.deploymentEvent.application} was deployed ${deploymentTrigger(
+                    tp.deploymentEvent
+                )} by ${tp.deploymentEvent.authorName}`,
+                [tp.deploymentEvent.environment],
+            ];
+        case 'lockPreventedDeploymentEvent': {
+            const ev = tp.lockPreventedDeploymentEvent;
+            const locked = ev.lockType === LockPreventedDeploymentEvent_LockType.ENVIRONMENT ? 'environment' : 'application';
+            return [
+                `Version ${ev.version} of ${ev.application} was queued, because the ${locked} is locked: ${ev.lockMessage}`,
+                [ev.environment],
+            ];
+        }
+        case 'replacedByEvent':
+            return [
+                `${tp.replacedByEvent.application} was replaced by the release of commit ${tp.replacedByEvent.replacedByCommitId}`,
+                [tp.replacedByEvent.environment],
+            ];
+    }
+};
+
+const deploymentTrigger = (event: DeploymentEvent): string => {
+    switch (event.trigger) {
+        case DeploymentEvent_Trigger.RELEASE_TRAIN:
+            return `by a release train from ${event.releaseTrainSource?.upstreamEnvironment ?? ''}`;
+        case DeploymentEvent_Trigger.NEW_RELEASE:
+            return 'right after the release was created';
+        default:
+            return 'manually';
     }
 };
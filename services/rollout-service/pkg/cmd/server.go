@@ -22,6 +22,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/argoproj/argo-cd/v2/pkg/apiclient"
 	argoio "github.com/argoproj/argo-cd/v2/util/io"
@@ -30,6 +31,7 @@ import (
 	pkgmetrics "github.com/freiheit-com/kuberpult/pkg/metrics"
 	"github.com/freiheit-com/kuberpult/pkg/setup"
 	"github.com/freiheit-com/kuberpult/pkg/tracing"
+	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/dora"
 	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/metrics"
 	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/notifier"
 	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/revolution"
@@ -43,15 +45,14 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/protobuf/types/known/emptypb"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
-
-	grpctrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/google.golang.org/grpc"
 )
 
 type Config struct {
 	CdServer       string `default:"kuberpult-cd-service:8443"`
 	CdServerSecure bool   `default:"false" split_words:"true"`
 	EnableTracing  bool   `default:"false" split_words:"true"`
+	// TracingExporter is either datadog or otlp
+	TracingExporter string `default:"datadog" split_words:"true"`
 
 	ArgocdServer             string `split_words:"true"`
 	ArgocdInsecure           bool   `default:"false" split_words:"true"`
@@ -69,8 +70,19 @@ type Config struct {
 
 	ManifestRepoUrl string `default:"" split_words:"true"`
 	Branch          string `default:"" split_words:"true"`
+
+	DoraRetention time.Duration `default:"720h" split_words:"true"`
+}
+
+// rolloutServer serves the rollout status from the broadcast and the DORA
+// metrics computed from it.
+type rolloutServer struct {
+	*service.Broadcast
+	*dora.Dora
 }
 
+var _ api.RolloutServiceServer = (*rolloutServer)(nil)
+
 func (config *Config) ClientConfig() (apiclient.ClientOptions, error) {
 	var opts apiclient.ClientOptions
 	opts.ConfigPath = ""
@@ -131,14 +143,7 @@ func getGrpcClients(ctx context.Context, config Config) (api.OverviewServiceClie
 		grpc.WithTransportCredentials(cred),
 	}
 	if config.EnableTracing {
-		grpcClientOpts = append(grpcClientOpts,
-			grpc.WithStreamInterceptor(
-				grpctrace.StreamClientInterceptor(grpctrace.WithServiceName(tracing.ServiceName("kuberpult-rollout-service"))),
-			),
-			grpc.WithUnaryInterceptor(
-				grpctrace.UnaryClientInterceptor(grpctrace.WithServiceName(tracing.ServiceName("kuberpult-rollout-service"))),
-			),
-		)
+		grpcClientOpts = append(grpcClientOpts, tracing.DialOption())
 	}
 
 	con, err := grpc.Dial(config.CdServer, grpcClientOpts...)
@@ -158,15 +163,18 @@ func runServer(ctx context.Context, config Config) error {
 		grpc_zap.UnaryServerInterceptor(grpcServerLogger),
 	}
 
+	grpcServerOpts := []grpc.ServerOption{
+		grpc.ChainStreamInterceptor(grpcStreamInterceptors...),
+		grpc.ChainUnaryInterceptor(grpcUnaryInterceptors...),
+	}
+
 	if config.EnableTracing {
-		tracer.Start()
-		defer tracer.Stop()
-		grpcStreamInterceptors = append(grpcStreamInterceptors,
-			grpctrace.StreamServerInterceptor(grpctrace.WithServiceName("rollout-service")),
-		)
-		grpcUnaryInterceptors = append(grpcUnaryInterceptors,
-			grpctrace.UnaryServerInterceptor(grpctrace.WithServiceName("rollout-service")),
-		)
+		shutdownTracing, err := tracing.Init(ctx, config.TracingExporter, tracing.ServiceName("kuberpult-rollout-service"))
+		if err != nil {
+			return err
+		}
+		defer shutdownTracing(context.Background()) //nolint:errcheck
+		grpcServerOpts = append(grpcServerOpts, tracing.ServerOption())
 	}
 
 	opts, err := config.ClientConfig()
@@ -260,6 +268,20 @@ func runServer(ctx context.Context, config Config) error {
 		})
 	}
 
+	if config.DoraRetention <= 0 {
+		return fmt.Errorf("KUBERPULT_DORA_RETENTION must be positive, got %s", config.DoraRetention)
+	}
+	doraMetrics := dora.New(dora.Config{
+		Retention: config.DoraRetention,
+		History:   versionGrpc,
+	})
+	backgroundTasks = append(backgroundTasks, setup.BackgroundTaskConfig{
+		Name: "compute dora metrics",
+		Run: func(ctx context.Context, health *setup.HealthReporter) error {
+			return doraMetrics.Subscribe(ctx, broadcast, pkgmetrics.FromContext(ctx), health)
+		},
+	})
+
 	backgroundTasks = append(backgroundTasks, setup.BackgroundTaskConfig{
 		Name: "create metrics",
 		Run: func(ctx context.Context, health *setup.HealthReporter) error {
@@ -268,6 +290,13 @@ func runServer(ctx context.Context, config Config) error {
 		},
 	})
 
+	backgroundTasks = append(backgroundTasks, setup.BackgroundTaskConfig{
+		Name: "export version info",
+		Run: func(ctx context.Context, health *setup.HealthReporter) error {
+			return metrics.VersionInfo(ctx, overviewGrpc, pkgmetrics.FromContext(ctx), health)
+		},
+	})
+
 	setup.Run(ctx, setup.ServerConfig{
 		HTTP: []setup.HTTPConfig{
 			{
@@ -277,12 +306,9 @@ func runServer(ctx context.Context, config Config) error {
 		Background: backgroundTasks,
 		GRPC: &setup.GRPCConfig{
 			Port: "8443",
-			Opts: []grpc.ServerOption{
-				grpc.ChainStreamInterceptor(grpcStreamInterceptors...),
-				grpc.ChainUnaryInterceptor(grpcUnaryInterceptors...),
-			},
+			Opts: grpcServerOpts,
 			Register: func(srv *grpc.Server) {
-				api.RegisterRolloutServiceServer(srv, broadcast)
+				api.RegisterRolloutServiceServer(srv, &rolloutServer{broadcast, doraMetrics})
 				reflection.Register(srv)
 			},
 		},
@@ -0,0 +1,397 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package dora
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/pkg/setup"
+	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/service"
+	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/versions"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Config struct {
+	// Deployments are kept in memory for this long. Metrics can't be
+	// requested for longer windows.
+	Retention time.Duration
+	// History reads the deployments of the retention window from the
+	// manifest repository when the metrics are computed for the first time.
+	History HistoryClient
+}
+
+// HistoryClient is implemented by api.VersionServiceClient.
+type HistoryClient interface {
+	GetDeploymentHistory(ctx context.Context, in *api.GetDeploymentHistoryRequest, opts ...grpc.CallOption) (*api.GetDeploymentHistoryResponse, error)
+}
+
+func New(config Config) *Dora {
+	return &Dora{
+		retention:   config.Retention,
+		history:     config.History,
+		clock:       time.Now,
+		ready:       func() {},
+		deployments: map[service.Key][]*deployment{},
+	}
+}
+
+// Dora computes the four DORA metrics from the deployments seen in the
+// broadcast. After a restart, the deployments and rollbacks are read again
+// from the history of the manifest repository. Rollout failures are only
+// known while the service runs, so a deployment that is already unhealthy
+// after a restart counts as failed, but not for the time to restore.
+type Dora struct {
+	mx            sync.Mutex
+	retention     time.Duration
+	clock         func() time.Time
+	history       HistoryClient
+	historyLoaded bool
+	deployments   map[service.Key][]*deployment
+	// The ready function is needed to sync tests
+	ready func()
+}
+
+type deployment struct {
+	team             string
+	environment      string
+	version          uint64
+	deployedAt       time.Time
+	releaseCreatedAt time.Time
+	failedAt         time.Time
+	restoredAt       time.Time
+	// Deployments read from the history may have failed before the restart,
+	// so the time of their failure is unknown until their status was seen.
+	fromHistory        bool
+	statusSeen         bool
+	failureTimeUnknown bool
+}
+
+func (d *deployment) failed() bool {
+	return !d.failedAt.IsZero()
+}
+
+func (d *Dora) Subscribe(ctx context.Context, b *service.Broadcast, meterProvider metric.MeterProvider, health *setup.HealthReporter) error {
+	reg, err := d.registerMetrics(meterProvider)
+	if err != nil {
+		return err
+	}
+	defer reg.Unregister() //nolint:errcheck
+	return health.Retry(ctx, func() error {
+		if !d.historyLoaded {
+			if err := d.loadHistory(ctx); err != nil {
+				return fmt.Errorf("dora.history: %w", err)
+			}
+			d.historyLoaded = true
+		}
+		health.ReportReady("computing")
+		return d.subscribeOnce(ctx, b)
+	})
+}
+
+// loadHistory adds the deployments of the retention window from the manifest repository.
+func (d *Dora) loadHistory(ctx context.Context) error {
+	if d.history == nil {
+		return nil
+	}
+	ctx = auth.WriteUserToGrpcContext(ctx, versions.RolloutServiceUser)
+	resp, err := d.history.GetDeploymentHistory(ctx, &api.GetDeploymentHistoryRequest{
+		Since: timestamppb.New(d.clock().Add(-d.retention)),
+	})
+	if err != nil {
+		return err
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	for _, entry := range resp.Deployments {
+		dep := &deployment{
+			team:        entry.Team,
+			environment: entry.Environment,
+			version:     entry.Version,
+			deployedAt:  entry.DeployedAt.AsTime(),
+			fromHistory: true,
+		}
+		if entry.ReleaseCreatedAt != nil {
+			dep.releaseCreatedAt = entry.ReleaseCreatedAt.AsTime()
+		}
+		d.deploy(service.Key{Application: entry.Application, Environment: entry.Environment}, dep)
+	}
+	now := d.clock()
+	for key := range d.deployments {
+		d.expire(key, now)
+	}
+	return nil
+}
+
+func (d *Dora) subscribeOnce(ctx context.Context, b *service.Broadcast) error {
+	event, ch, unsub := b.Start()
+	defer unsub()
+	d.mx.Lock()
+	for _, ev := range event {
+		d.process(ev)
+	}
+	d.mx.Unlock()
+	d.ready()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			d.mx.Lock()
+			d.process(ev)
+			d.mx.Unlock()
+			d.ready()
+		}
+	}
+}
+
+func (d *Dora) process(ev *service.BroadcastEvent) {
+	now := d.clock()
+	var current *deployment
+	kv := ev.KuberpultVersion
+	if kv != nil && kv.Version != 0 && !kv.DeployedAt.IsZero() {
+		current = d.deploy(ev.Key, &deployment{
+			team:             ev.Team,
+			environment:      ev.Environment,
+			version:          kv.Version,
+			deployedAt:       kv.DeployedAt,
+			releaseCreatedAt: kv.ReleaseCreatedAt,
+		})
+	} else if deps := d.deployments[ev.Key]; len(deps) > 0 {
+		current = deps[len(deps)-1]
+	}
+	if current == nil {
+		return
+	}
+	if ev.ArgocdVersion != nil && ev.ArgocdVersion.Version == current.version {
+		switch ev.RolloutStatus {
+		case api.RolloutStatus_ROLLOUT_STATUS_ERROR, api.RolloutStatus_ROLLOUT_STATUS_UNHEALTHY:
+			if !current.failed() {
+				if current.fromHistory && !current.statusSeen {
+					current.failedAt = current.deployedAt
+					current.failureTimeUnknown = true
+				} else {
+					current.failedAt = now
+				}
+			}
+		case api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL:
+			for _, dep := range d.deployments[ev.Key] {
+				if dep.failed() && dep.restoredAt.IsZero() {
+					dep.restoredAt = now
+				}
+			}
+		}
+		if ev.RolloutStatus != api.RolloutStatus_ROLLOUT_STATUS_UNKNOWN {
+			current.statusSeen = true
+		}
+	}
+	d.expire(ev.Key, now)
+}
+
+// deploy adds the deployment unless its version is already deployed and returns the current deployment.
+func (d *Dora) deploy(key service.Key, dep *deployment) *deployment {
+	deps := d.deployments[key]
+	if len(deps) > 0 {
+		current := deps[len(deps)-1]
+		if current.version == dep.version {
+			return current
+		}
+		if dep.version < current.version && !current.failed() {
+			// Going back to an older version is a rollback, so the
+			// replaced deployment failed and the rollback restored it.
+			current.failedAt = current.deployedAt
+			current.restoredAt = dep.deployedAt
+		}
+	}
+	d.deployments[key] = append(deps, dep)
+	return dep
+}
+
+// expire removes the deployments before the retention window. The current deployment is always kept to detect rollbacks.
+func (d *Dora) expire(key service.Key, now time.Time) {
+	deps := d.deployments[key]
+	cutoff := now.Add(-d.retention)
+	i := 0
+	for i < len(deps)-1 && deps[i].deployedAt.Before(cutoff) {
+		i++
+	}
+	d.deployments[key] = deps[i:]
+}
+
+type group struct {
+	team        string
+	environment string
+}
+
+type accumulator struct {
+	deployments  uint64
+	failures     uint64
+	leadTimes    []float64
+	restoreTimes []float64
+}
+
+func (d *Dora) compute(team, environment string, start, end time.Time) map[group]*accumulator {
+	result := map[group]*accumulator{}
+	for _, deps := range d.deployments {
+		for _, dep := range deps {
+			if dep.deployedAt.Before(start) || dep.deployedAt.After(end) {
+				continue
+			}
+			if (team != "" && dep.team != team) || (environment != "" && dep.environment != environment) {
+				continue
+			}
+			g := group{team: dep.team, environment: dep.environment}
+			acc := result[g]
+			if acc == nil {
+				acc = &accumulator{}
+				result[g] = acc
+			}
+			acc.deployments++
+			if !dep.releaseCreatedAt.IsZero() && !dep.releaseCreatedAt.After(dep.deployedAt) {
+				acc.leadTimes = append(acc.leadTimes, dep.deployedAt.Sub(dep.releaseCreatedAt).Seconds())
+			}
+			if dep.failed() {
+				acc.failures++
+				if !dep.restoredAt.IsZero() && !dep.failureTimeUnknown {
+					acc.restoreTimes = append(acc.restoreTimes, dep.restoredAt.Sub(dep.failedAt).Seconds())
+				}
+			}
+		}
+	}
+	return result
+}
+
+func (a *accumulator) metrics(g group, window time.Duration) *api.DoraMetrics {
+	// an empty window would result in an infinite frequency
+	deploymentsPerDay := 0.0
+	if window > 0 {
+		deploymentsPerDay = float64(a.deployments) / window.Hours() * 24
+	}
+	return &api.DoraMetrics{
+		Team:                 g.team,
+		Environment:          g.environment,
+		DeploymentCount:      a.deployments,
+		DeploymentsPerDay:    deploymentsPerDay,
+		LeadTimeSeconds:      median(a.leadTimes),
+		ChangeFailureRate:    float64(a.failures) / float64(a.deployments),
+		TimeToRestoreSeconds: median(a.restoreTimes),
+	}
+}
+
+func median(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	m := values[len(values)/2]
+	if len(values)%2 == 0 {
+		m = (values[len(values)/2-1] + m) / 2
+	}
+	return &m
+}
+
+// GetDoraMetrics implements api.RolloutServiceServer
+func (d *Dora) GetDoraMetrics(ctx context.Context, in *api.GetDoraMetricsRequest) (*api.GetDoraMetricsResponse, error) {
+	window := d.retention
+	if in.WindowSeconds != 0 {
+		window = time.Duration(in.WindowSeconds) * time.Second
+		if window > d.retention {
+			return nil, status.Errorf(codes.InvalidArgument, "window must not be longer than the retention of %s", d.retention)
+		}
+	}
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	end := d.clock()
+	start := end.Add(-window)
+	groups := d.compute(in.Team, in.Environment, start, end)
+	result := make([]*api.DoraMetrics, 0, len(groups))
+	for g, acc := range groups {
+		result = append(result, acc.metrics(g, window))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Team != result[j].Team {
+			return result[i].Team < result[j].Team
+		}
+		return result[i].Environment < result[j].Environment
+	})
+	return &api.GetDoraMetricsResponse{
+		WindowStart: timestamppb.New(start),
+		WindowEnd:   timestamppb.New(end),
+		Metrics:     result,
+	}, nil
+}
+
+func (d *Dora) registerMetrics(meterProvider metric.MeterProvider) (metric.Registration, error) {
+	meter := meterProvider.Meter("kuberpult")
+	frequency, err := meter.Float64ObservableGauge("dora_deployment_frequency")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	leadTime, err := meter.Float64ObservableGauge("dora_lead_time_seconds")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	failureRate, err := meter.Float64ObservableGauge("dora_change_failure_rate")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	timeToRestore, err := meter.Float64ObservableGauge("dora_time_to_restore_seconds")
+	if err != nil {
+		return nil, fmt.Errorf("registering meter: %w", err)
+	}
+	reg, err := meter.RegisterCallback(
+		func(_ context.Context, o metric.Observer) error {
+			d.mx.Lock()
+			defer d.mx.Unlock()
+			end := d.clock()
+			for g, acc := range d.compute("", "", end.Add(-d.retention), end) {
+				m := acc.metrics(g, d.retention)
+				attrs := metric.WithAttributes(
+					attribute.String("kuberpult_team", m.Team),
+					attribute.String("kuberpult_environment", m.Environment),
+				)
+				o.ObserveFloat64(frequency, m.DeploymentsPerDay, attrs)
+				o.ObserveFloat64(failureRate, m.ChangeFailureRate, attrs)
+				if m.LeadTimeSeconds != nil {
+					o.ObserveFloat64(leadTime, *m.LeadTimeSeconds, attrs)
+				}
+				if m.TimeToRestoreSeconds != nil {
+					o.ObserveFloat64(timeToRestore, *m.TimeToRestoreSeconds, attrs)
+				}
+			}
+			return nil
+		},
+		frequency, leadTime, failureRate, timeToRestore,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("registering callback: %w", err)
+	}
+	return reg, nil
+}
@@ -0,0 +1,471 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package dora
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	pkgmetrics "github.com/freiheit-com/kuberpult/pkg/metrics"
+	"github.com/freiheit-com/kuberpult/pkg/setup"
+	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/service"
+	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/versions"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type step struct {
+	Now   int64
+	Event *service.BroadcastEvent
+}
+
+func kuberpult(version uint64, createdAt, deployedAt int64) *versions.VersionInfo {
+	return &versions.VersionInfo{
+		Version:          version,
+		DeployedAt:       time.Unix(deployedAt, 0).UTC(),
+		ReleaseCreatedAt: time.Unix(createdAt, 0).UTC(),
+	}
+}
+
+func event(version *versions.VersionInfo, argocd uint64, status api.RolloutStatus) *service.BroadcastEvent {
+	return &service.BroadcastEvent{
+		Key:              service.Key{Application: "foo", Environment: "production"},
+		EnvironmentGroup: "production",
+		Team:             "sre",
+		KuberpultVersion: version,
+		ArgocdVersion:    &versions.VersionInfo{Version: argocd},
+		RolloutStatus:    status,
+	}
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func TestGetDoraMetrics(t *testing.T) {
+	tcs := []struct {
+		Name             string
+		Steps            []step
+		Request          *api.GetDoraMetricsRequest
+		ExpectedResponse *api.GetDoraMetricsResponse
+		ExpectedError    error
+	}{
+		{
+			Name:    "returns nothing without deployments",
+			Request: &api.GetDoraMetricsRequest{},
+			ExpectedResponse: &api.GetDoraMetricsResponse{
+				WindowStart: timestamppb.New(time.Unix(0, 0)),
+				WindowEnd:   timestamppb.New(time.Unix(86400, 0)),
+				Metrics:     []*api.DoraMetrics{},
+			},
+		},
+		{
+			Name: "computes frequency and lead time",
+			Steps: []step{
+				{
+					Now:   1000,
+					Event: event(kuberpult(1, 400, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+				{
+					Now:   2000,
+					Event: event(kuberpult(2, 1800, 2000), 2, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+			},
+			Request: &api.GetDoraMetricsRequest{},
+			ExpectedResponse: &api.GetDoraMetricsResponse{
+				WindowStart: timestamppb.New(time.Unix(0, 0)),
+				WindowEnd:   timestamppb.New(time.Unix(86400, 0)),
+				Metrics: []*api.DoraMetrics{
+					{
+						Team:              "sre",
+						Environment:       "production",
+						DeploymentCount:   2,
+						DeploymentsPerDay: 2,
+						LeadTimeSeconds:   ptr(400),
+					},
+				},
+			},
+		},
+		{
+			Name: "a rollback fails and restores the previous deployment",
+			Steps: []step{
+				{
+					Now:   1000,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+				{
+					Now:   2000,
+					Event: event(kuberpult(2, 2000, 2000), 2, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+				{
+					Now:   2600,
+					Event: event(kuberpult(1, 1000, 2600), 1, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+			},
+			Request: &api.GetDoraMetricsRequest{},
+			ExpectedResponse: &api.GetDoraMetricsResponse{
+				WindowStart: timestamppb.New(time.Unix(0, 0)),
+				WindowEnd:   timestamppb.New(time.Unix(86400, 0)),
+				Metrics: []*api.DoraMetrics{
+					{
+						Team:                 "sre",
+						Environment:          "production",
+						DeploymentCount:      3,
+						DeploymentsPerDay:    3,
+						LeadTimeSeconds:      ptr(0),
+						ChangeFailureRate:    1.0 / 3,
+						TimeToRestoreSeconds: ptr(600),
+					},
+				},
+			},
+		},
+		{
+			Name: "an unhealthy rollout fails until it is successful again",
+			Steps: []step{
+				{
+					Now:   1000,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_PROGRESSING),
+				},
+				{
+					Now:   1100,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_UNHEALTHY),
+				},
+				{
+					Now:   1200,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_ERROR),
+				},
+				{
+					Now:   2000,
+					Event: event(kuberpult(2, 1500, 2000), 1, api.RolloutStatus_ROLLOUT_STATUS_ERROR),
+				},
+				{
+					Now:   2300,
+					Event: event(kuberpult(2, 1500, 2000), 2, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+			},
+			Request: &api.GetDoraMetricsRequest{},
+			ExpectedResponse: &api.GetDoraMetricsResponse{
+				WindowStart: timestamppb.New(time.Unix(0, 0)),
+				WindowEnd:   timestamppb.New(time.Unix(86400, 0)),
+				Metrics: []*api.DoraMetrics{
+					{
+						Team:                 "sre",
+						Environment:          "production",
+						DeploymentCount:      2,
+						DeploymentsPerDay:    2,
+						LeadTimeSeconds:      ptr(250),
+						ChangeFailureRate:    0.5,
+						TimeToRestoreSeconds: ptr(1200),
+					},
+				},
+			},
+		},
+		{
+			Name: "filters by team and window",
+			Steps: []step{
+				{
+					Now:   1000,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+				{
+					Now:   80000,
+					Event: event(kuberpult(2, 80000, 80000), 2, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+			},
+			Request: &api.GetDoraMetricsRequest{
+				Team:          "sre",
+				WindowSeconds: 43200,
+			},
+			ExpectedResponse: &api.GetDoraMetricsResponse{
+				WindowStart: timestamppb.New(time.Unix(43200, 0)),
+				WindowEnd:   timestamppb.New(time.Unix(86400, 0)),
+				Metrics: []*api.DoraMetrics{
+					{
+						Team:              "sre",
+						Environment:       "production",
+						DeploymentCount:   1,
+						DeploymentsPerDay: 2,
+						LeadTimeSeconds:   ptr(0),
+					},
+				},
+			},
+		},
+		{
+			Name: "filters out other teams",
+			Steps: []step{
+				{
+					Now:   1000,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+			},
+			Request: &api.GetDoraMetricsRequest{
+				Team: "other",
+			},
+			ExpectedResponse: &api.GetDoraMetricsResponse{
+				WindowStart: timestamppb.New(time.Unix(0, 0)),
+				WindowEnd:   timestamppb.New(time.Unix(86400, 0)),
+				Metrics:     []*api.DoraMetrics{},
+			},
+		},
+		{
+			Name: "rejects windows longer than the retention",
+			Request: &api.GetDoraMetricsRequest{
+				WindowSeconds: 86401,
+			},
+			ExpectedError: status.Error(codes.InvalidArgument, "window must not be longer than the retention of 24h0m0s"),
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			var now int64
+			d := New(Config{Retention: 24 * time.Hour})
+			d.clock = func() time.Time { return time.Unix(now, 0).UTC() }
+			for _, s := range tc.Steps {
+				now = s.Now
+				d.process(s.Event)
+			}
+			now = 86400
+			resp, err := d.GetDoraMetrics(context.Background(), tc.Request)
+			if diff := cmp.Diff(tc.ExpectedError, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("error mismatch (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.ExpectedResponse, resp, protocmp.Transform()); diff != "" {
+				t.Errorf("response mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+type mockHistoryClient struct {
+	request  *api.GetDeploymentHistoryRequest
+	response *api.GetDeploymentHistoryResponse
+}
+
+func (m *mockHistoryClient) GetDeploymentHistory(ctx context.Context, in *api.GetDeploymentHistoryRequest, opts ...grpc.CallOption) (*api.GetDeploymentHistoryResponse, error) {
+	m.request = in
+	return m.response, nil
+}
+
+func historyEntry(version uint64, createdAt, deployedAt int64) *api.DeploymentHistoryEntry {
+	return &api.DeploymentHistoryEntry{
+		Environment:      "production",
+		Application:      "foo",
+		Team:             "sre",
+		Version:          version,
+		DeployedAt:       timestamppb.New(time.Unix(deployedAt, 0)),
+		ReleaseCreatedAt: timestamppb.New(time.Unix(createdAt, 0)),
+	}
+}
+
+func TestDoraHistory(t *testing.T) {
+	tcs := []struct {
+		Name             string
+		History          []*api.DeploymentHistoryEntry
+		Steps            []step
+		ExpectedResponse *api.GetDoraMetricsResponse
+	}{
+		{
+			Name: "rebuilds deployments and rollbacks from the history",
+			History: []*api.DeploymentHistoryEntry{
+				historyEntry(1, 400, 1000),
+				historyEntry(2, 1800, 2000),
+				historyEntry(1, 400, 2600),
+			},
+			Steps: []step{
+				{
+					Now:   3000,
+					Event: event(kuberpult(1, 400, 2600), 1, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+			},
+			ExpectedResponse: &api.GetDoraMetricsResponse{
+				WindowStart: timestamppb.New(time.Unix(0, 0)),
+				WindowEnd:   timestamppb.New(time.Unix(86400, 0)),
+				Metrics: []*api.DoraMetrics{
+					{
+						Team:                 "sre",
+						Environment:          "production",
+						DeploymentCount:      3,
+						DeploymentsPerDay:    3,
+						LeadTimeSeconds:      ptr(600),
+						ChangeFailureRate:    1.0 / 3,
+						TimeToRestoreSeconds: ptr(600),
+					},
+				},
+			},
+		},
+		{
+			Name: "a deployment that is unhealthy after the restart failed at an unknown time",
+			History: []*api.DeploymentHistoryEntry{
+				historyEntry(1, 1000, 1000),
+			},
+			Steps: []step{
+				{
+					Now:   5000,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_UNHEALTHY),
+				},
+				{
+					Now:   5600,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+			},
+			ExpectedResponse: &api.GetDoraMetricsResponse{
+				WindowStart: timestamppb.New(time.Unix(0, 0)),
+				WindowEnd:   timestamppb.New(time.Unix(86400, 0)),
+				Metrics: []*api.DoraMetrics{
+					{
+						Team:              "sre",
+						Environment:       "production",
+						DeploymentCount:   1,
+						DeploymentsPerDay: 1,
+						LeadTimeSeconds:   ptr(0),
+						ChangeFailureRate: 1,
+					},
+				},
+			},
+		},
+		{
+			Name: "a deployment from the history that becomes unhealthy after the restart fails then",
+			History: []*api.DeploymentHistoryEntry{
+				historyEntry(1, 1000, 1000),
+			},
+			Steps: []step{
+				{
+					Now:   5000,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+				{
+					Now:   6000,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_UNHEALTHY),
+				},
+				{
+					Now:   6600,
+					Event: event(kuberpult(1, 1000, 1000), 1, api.RolloutStatus_ROLLOUT_STATUS_SUCCESFUL),
+				},
+			},
+			ExpectedResponse: &api.GetDoraMetricsResponse{
+				WindowStart: timestamppb.New(time.Unix(0, 0)),
+				WindowEnd:   timestamppb.New(time.Unix(86400, 0)),
+				Metrics: []*api.DoraMetrics{
+					{
+						Team:                 "sre",
+						Environment:          "production",
+						DeploymentCount:      1,
+						DeploymentsPerDay:    1,
+						LeadTimeSeconds:      ptr(0),
+						ChangeFailureRate:    1,
+						TimeToRestoreSeconds: ptr(600),
+					},
+				},
+			},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			now := int64(4000)
+			history := &mockHistoryClient{
+				response: &api.GetDeploymentHistoryResponse{Deployments: tc.History},
+			}
+			d := New(Config{Retention: 24 * time.Hour, History: history})
+			d.clock = func() time.Time { return time.Unix(now, 0).UTC() }
+			if err := d.loadHistory(context.Background()); err != nil {
+				t.Fatalf("expected no error loading the history but got %q", err)
+			}
+			if diff := cmp.Diff(timestamppb.New(time.Unix(4000-86400, 0)), history.request.Since, protocmp.Transform()); diff != "" {
+				t.Errorf("history request mismatch (-want, +got):\n%s", diff)
+			}
+			for _, s := range tc.Steps {
+				now = s.Now
+				d.process(s.Event)
+			}
+			now = 86400
+			resp, err := d.GetDoraMetrics(context.Background(), &api.GetDoraMetricsRequest{})
+			if err != nil {
+				t.Fatalf("expected no error but got %q", err)
+			}
+			if diff := cmp.Diff(tc.ExpectedResponse, resp, protocmp.Transform()); diff != "" {
+				t.Errorf("response mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMetricsWithoutWindow(t *testing.T) {
+	acc := &accumulator{deployments: 1}
+	metrics := acc.metrics(group{team: "sre", environment: "production"}, 0)
+	if metrics.DeploymentsPerDay != 0 {
+		t.Errorf("expected no deployment frequency without a window, got %f", metrics.DeploymentsPerDay)
+	}
+}
+
+func TestDoraOtelMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mpv, handler, _ := pkgmetrics.Init()
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	bc := service.New()
+	readyCh := make(chan struct{}, 1)
+	d := New(Config{Retention: 24 * time.Hour})
+	d.clock = func() time.Time { return time.Unix(86400, 0).UTC() }
+	d.ready = func() { readyCh <- struct{}{} }
+	errCh := make(chan error, 1)
+	hs := &setup.HealthServer{}
+	go func() {
+		errCh <- d.Subscribe(ctx, bc, mpv, hs.Reporter("dora"))
+	}()
+	<-readyCh
+	bc.ProcessKuberpultEvent(ctx, versions.KuberpultEvent{
+		Application:      "foo",
+		Environment:      "production",
+		EnvironmentGroup: "production",
+		Team:             "sre",
+		Version:          kuberpult(1, 1000, 1600),
+	})
+	<-readyCh
+	resp, err := srv.Client().Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("error getting metrics: %q", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading body: %q", err)
+	}
+	for _, expected := range []string{
+		`dora_deployment_frequency{kuberpult_environment="production",kuberpult_team="sre"} 1`,
+		`dora_lead_time_seconds{kuberpult_environment="production",kuberpult_team="sre"} 600`,
+		`dora_change_failure_rate{kuberpult_environment="production",kuberpult_team="sre"} 0`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", expected, body)
+		}
+	}
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("expected no error but got %q", err)
+	}
+}
@@ -0,0 +1,129 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	"github.com/freiheit-com/kuberpult/pkg/auth"
+	"github.com/freiheit-com/kuberpult/pkg/setup"
+	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/versions"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VersionInfo exports the deployed and queued version of every application
+// from the latest overview, so that dashboards can show what runs where
+// without calling the api.
+func VersionInfo(ctx context.Context, overviewClient api.OverviewServiceClient, meterProvider metric.MeterProvider, hr *setup.HealthReporter) error {
+	meter := meterProvider.Meter("kuberpult")
+	version, err := meter.Int64ObservableGauge("kuberpult_application_version")
+	if err != nil {
+		return fmt.Errorf("registering meter: %w", err)
+	}
+	queuedVersion, err := meter.Int64ObservableGauge("kuberpult_application_queued_version")
+	if err != nil {
+		return fmt.Errorf("registering meter: %w", err)
+	}
+	var overviewMx sync.Mutex
+	var overview *api.GetOverviewResponse
+	reg, err := meter.RegisterCallback(
+		func(_ context.Context, o metric.Observer) error {
+			overviewMx.Lock()
+			defer overviewMx.Unlock()
+			observeVersions(o, overview, version, queuedVersion)
+			return nil
+		},
+		version, queuedVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("registering callback: %w", err)
+	}
+	defer reg.Unregister() //nolint:errcheck
+
+	ctx = auth.WriteUserToGrpcContext(ctx, versions.RolloutServiceUser)
+	return hr.Retry(ctx, func() error {
+		client, err := overviewClient.StreamOverview(ctx, &api.GetOverviewRequest{})
+		if err != nil {
+			return fmt.Errorf("overview.connect: %w", err)
+		}
+		hr.ReportReady("consuming")
+		for {
+			ov, err := client.Recv()
+			if err != nil {
+				if status.Code(err) == codes.Canceled {
+					return nil
+				}
+				return fmt.Errorf("overview.recv: %w", err)
+			}
+			overviewMx.Lock()
+			overview = ov
+			overviewMx.Unlock()
+		}
+	})
+}
+
+func observeVersions(o metric.Observer, overview *api.GetOverviewResponse, version, queuedVersion metric.Int64Observable) {
+	if overview == nil {
+		return
+	}
+	for _, group := range overview.EnvironmentGroups {
+		for _, env := range group.Environments {
+			for _, app := range env.Applications {
+				tm := team(overview, app.Name)
+				if app.Version != 0 {
+					o.ObserveInt64(version, int64(app.Version), metric.WithAttributes(
+						attribute.String("environment", env.Name),
+						attribute.String("application", app.Name),
+						attribute.String("team", tm),
+						attribute.String("display_version", displayVersion(overview, app.Name, app.Version)),
+					))
+				}
+				if app.QueuedVersion != 0 {
+					o.ObserveInt64(queuedVersion, int64(app.QueuedVersion), metric.WithAttributes(
+						attribute.String("environment", env.Name),
+						attribute.String("application", app.Name),
+						attribute.String("team", tm),
+					))
+				}
+			}
+		}
+	}
+}
+
+func team(overview *api.GetOverviewResponse, app string) string {
+	if a := overview.Applications[app]; a != nil {
+		return a.Team
+	}
+	return ""
+}
+
+func displayVersion(overview *api.GetOverviewResponse, app string, version uint64) string {
+	if a := overview.Applications[app]; a != nil {
+		for _, rel := range a.Releases {
+			if rel.Version == version {
+				return rel.DisplayVersion
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+/*This file is part of kuberpult.
+
+Kuberpult is free software: you can redistribute it and/or modify
+it under the terms of the Expat(MIT) License as published by
+the Free Software Foundation.
+
+Kuberpult is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+MIT License for more details.
+
+You should have received a copy of the MIT License
+along with kuberpult. If not, see <https://directory.fsf.org/wiki/License:Expat>.
+
+Copyright 2023 freiheit.com*/
+
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/freiheit-com/kuberpult/pkg/api/v1"
+	pkgmetrics "github.com/freiheit-com/kuberpult/pkg/metrics"
+	"github.com/freiheit-com/kuberpult/pkg/setup"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type mockOverviewClient struct {
+	grpc.ClientStream
+	ctx       context.Context
+	overviews chan *api.GetOverviewResponse
+	recv      chan struct{}
+}
+
+func (m *mockOverviewClient) GetOverview(ctx context.Context, in *api.GetOverviewRequest, opts ...grpc.CallOption) (*api.GetOverviewResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "no")
+}
+
+func (m *mockOverviewClient) StreamOverview(ctx context.Context, in *api.GetOverviewRequest, opts ...grpc.CallOption) (api.OverviewService_StreamOverviewClient, error) {
+	m.ctx = ctx
+	return m, nil
+}
+
+func (m *mockOverviewClient) Recv() (*api.GetOverviewResponse, error) {
+	m.recv <- struct{}{}
+	select {
+	case ov := <-m.overviews:
+		return ov, nil
+	case <-m.ctx.Done():
+		return nil, status.Error(codes.Canceled, "context cancelled")
+	}
+}
+
+func TestVersionInfo(t *testing.T) {
+	tcs := []struct {
+		Name         string
+		Overview     *api.GetOverviewResponse
+		ExpectedBody string
+	}{
+		{
+			Name:         "writes nothing for an empty overview",
+			Overview:     &api.GetOverviewResponse{},
+			ExpectedBody: ``,
+		},
+		{
+			Name: "writes deployed and queued versions",
+			Overview: &api.GetOverviewResponse{
+				Applications: map[string]*api.Application{
+					"foo": {
+						Name: "foo",
+						Team: "sre",
+						Releases: []*api.Release{
+							{Version: 1, DisplayVersion: "v1.0.0"},
+							{Version: 2, DisplayVersion: "v1.1.0"},
+						},
+					},
+				},
+				EnvironmentGroups: []*api.EnvironmentGroup{
+					{
+						EnvironmentGroupName: "production",
+						Environments: []*api.Environment{
+							{
+								Name: "production",
+								Applications: map[string]*api.Environment_Application{
+									"foo": {Name: "foo", Version: 1, QueuedVersion: 2},
+								},
+							},
+							{
+								Name: "staging",
+								Applications: map[string]*api.Environment_Application{
+									"foo": {Name: "foo", Version: 2},
+								},
+							},
+						},
+					},
+				},
+			},
+			ExpectedBody: `# HELP kuberpult_application_queued_version 
+# TYPE kuberpult_application_queued_version gauge
+kuberpult_application_queued_version{application="foo",environment="production",team="sre"} 2
+# HELP kuberpult_application_version 
+# TYPE kuberpult_application_version gauge
+kuberpult_application_version{application="foo",display_version="v1.0.0",environment="production",team="sre"} 1
+kuberpult_application_version{application="foo",display_version="v1.1.0",environment="staging",team="sre"} 2
+`,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			mpv, handler, _ := pkgmetrics.Init()
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+			moc := &mockOverviewClient{
+				overviews: make(chan *api.GetOverviewResponse),
+				recv:      make(chan struct{}),
+			}
+			hs := &setup.HealthServer{}
+			errCh := make(chan error)
+			go func() {
+				errCh <- VersionInfo(ctx, moc, mpv, hs.Reporter("version-info"))
+			}()
+			<-moc.recv
+			moc.overviews <- tc.Overview
+			// The next receive only starts after the overview was stored.
+			<-moc.recv
+			resp, err := srv.Client().Get(srv.URL + "/metrics")
+			if err != nil {
+				t.Fatalf("error getting metrics: %q", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("error reading body: %q", err)
+			}
+			if d := cmp.Diff(tc.ExpectedBody, string(body)); d != "" {
+				t.Errorf("wrong metrics received, diff: %s", d)
+			}
+			cancel()
+			if err := <-errCh; err != nil {
+				t.Errorf("expected no error but got %q", err)
+			}
+		})
+	}
+}
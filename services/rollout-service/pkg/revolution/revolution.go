@@ -27,10 +27,11 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/freiheit-com/kuberpult/pkg/tracing"
 	"github.com/freiheit-com/kuberpult/services/rollout-service/pkg/service"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
 type Config struct {
@@ -134,12 +135,13 @@ func (s *Subscriber) notify(ctx context.Context, ev *service.BroadcastEvent) fun
 		ServiceName: ev.Application,
 	}
 	return func() error {
-		span, _ := tracer.StartSpanFromContext(ctx, "revolution.notify")
-		defer span.Finish()
-		span.SetTag("revolution.url", s.url)
-		span.SetTag("revolution.id", event.Id)
-		span.SetTag("environment", ev.Environment)
-		span.SetTag("application", ev.Application)
+		_, span := tracing.StartSpan(ctx, "revolution.notify",
+			attribute.String("revolution.url", s.url),
+			attribute.String("revolution.id", event.Id),
+			attribute.String("environment", ev.Environment),
+			attribute.String("application", ev.Application),
+		)
+		defer span.End()
 		body, err := json.Marshal(event)
 		h := hmac.New(sha256.New, s.token)
 		h.Write([]byte(body))
@@ -153,10 +155,10 @@ func (s *Subscriber) notify(ctx context.Context, ev *service.BroadcastEvent) fun
 		r.Header.Set("User-Agent", "kuberpult")
 		s, err := http.DefaultClient.Do(r)
 		if err != nil {
-			span.Finish(tracer.WithError(err))
+			tracing.EndSpan(span, err)
 			return nil
 		}
-		span.SetTag("http.status_code", s.Status)
+		span.SetAttributes(attribute.String("http.status_code", s.Status))
 		defer s.Body.Close()
 		content, _ := io.ReadAll(s.Body)
 		if s.StatusCode > 299 {
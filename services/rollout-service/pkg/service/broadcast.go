@@ -397,4 +397,3 @@ func mostRelevantStatus(a, b api.RolloutStatus) api.RolloutStatus {
 }
 
 var _ ArgoEventProcessor = (*Broadcast)(nil)
-var _ api.RolloutServiceServer = (*Broadcast)(nil)
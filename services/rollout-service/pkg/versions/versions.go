@@ -61,6 +61,8 @@ type VersionInfo struct {
 	Version        uint64
 	SourceCommitId string
 	DeployedAt     time.Time
+	// Only known when the version was read from the overview.
+	ReleaseCreatedAt time.Time
 }
 
 func (v *VersionInfo) Equal(w *VersionInfo) bool {
@@ -114,9 +116,10 @@ func (v *versionClient) tryGetVersion(ctx context.Context, revision, environment
 					return &ZeroVersion, nil
 				}
 				return &VersionInfo{
-					Version:        app.Version,
-					SourceCommitId: sourceCommitId(overview, app),
-					DeployedAt:     deployedAt(app),
+					Version:          app.Version,
+					SourceCommitId:   sourceCommitId(overview, app),
+					DeployedAt:       deployedAt(app),
+					ReleaseCreatedAt: releaseCreatedAt(overview, app),
 				}, nil
 			}
 		}
@@ -160,6 +163,19 @@ func sourceCommitId(overview *api.GetOverviewResponse, app *api.Environment_Appl
 	return ""
 }
 
+func releaseCreatedAt(overview *api.GetOverviewResponse, app *api.Environment_Application) time.Time {
+	a := overview.Applications[app.Name]
+	if a == nil {
+		return time.Time{}
+	}
+	for _, rel := range a.Releases {
+		if rel.Version == app.Version && rel.CreatedAt != nil {
+			return rel.CreatedAt.AsTime()
+		}
+	}
+	return time.Time{}
+}
+
 type KuberpultEvent struct {
 	Environment      string
 	Application      string
@@ -214,6 +230,7 @@ func (v *versionClient) ConsumeEvents(ctx context.Context, processor VersionEven
 					for _, app := range env.Applications {
 						dt := deployedAt(app)
 						sc := sourceCommitId(overview, app)
+						ca := releaseCreatedAt(overview, app)
 						tm := team(overview, app.Name)
 
 						l.Info("version.process", zap.String("application", app.Name), zap.String("environment", env.Name), zap.Uint64("version", app.Version), zap.Time("deployedAt", dt))
@@ -232,9 +249,10 @@ func (v *versionClient) ConsumeEvents(ctx context.Context, processor VersionEven
 							Team:             tm,
 							IsProduction:     env.Priority == api.Priority_PROD,
 							Version: &VersionInfo{
-								Version:        app.Version,
-								SourceCommitId: sc,
-								DeployedAt:     dt,
+								Version:          app.Version,
+								SourceCommitId:   sc,
+								DeployedAt:       dt,
+								ReleaseCreatedAt: ca,
 							},
 						})
 
@@ -133,6 +133,10 @@ func (m *mockVersionClient) GetVersion(ctx context.Context, in *api.GetVersionRe
 	return res.response, res.err
 }
 
+func (m *mockVersionClient) GetDeploymentHistory(ctx context.Context, in *api.GetDeploymentHistoryRequest, opts ...grpc.CallOption) (*api.GetDeploymentHistoryResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "no")
+}
+
 type mockVersionEventProcessor struct {
 	events []KuberpultEvent
 }
@@ -150,6 +154,7 @@ func TestVersionClientStream(t *testing.T) {
 					{
 						Version:        1,
 						SourceCommitId: "00001",
+						CreatedAt:      timestamppb.New(time.Unix(123456700, 0).UTC()),
 					},
 				},
 				Team: "footeam",
@@ -290,9 +295,10 @@ func TestVersionClientStream(t *testing.T) {
 							EnvironmentGroup: "staging-group",
 							Team:             "footeam",
 							Version: &VersionInfo{
-								Version:        1,
-								SourceCommitId: "00001",
-								DeployedAt:     time.Unix(123456789, 0).UTC(),
+								Version:          1,
+								SourceCommitId:   "00001",
+								DeployedAt:       time.Unix(123456789, 0).UTC(),
+								ReleaseCreatedAt: time.Unix(123456700, 0).UTC(),
 							},
 						},
 					},
@@ -359,9 +365,10 @@ func TestVersionClientStream(t *testing.T) {
 							EnvironmentGroup: "staging-group",
 							Team:             "footeam",
 							Version: &VersionInfo{
-								Version:        1,
-								SourceCommitId: "00001",
-								DeployedAt:     time.Unix(123456789, 0).UTC(),
+								Version:          1,
+								SourceCommitId:   "00001",
+								DeployedAt:       time.Unix(123456789, 0).UTC(),
+								ReleaseCreatedAt: time.Unix(123456700, 0).UTC(),
 							},
 						},
 					},
@@ -391,9 +398,10 @@ func TestVersionClientStream(t *testing.T) {
 							EnvironmentGroup: "staging-group",
 							Team:             "footeam",
 							Version: &VersionInfo{
-								Version:        1,
-								SourceCommitId: "00001",
-								DeployedAt:     time.Unix(123456789, 0).UTC(),
+								Version:          1,
+								SourceCommitId:   "00001",
+								DeployedAt:       time.Unix(123456789, 0).UTC(),
+								ReleaseCreatedAt: time.Unix(123456700, 0).UTC(),
 							},
 						},
 					},
@@ -432,9 +440,10 @@ func TestVersionClientStream(t *testing.T) {
 							EnvironmentGroup: "staging-group",
 							Team:             "footeam",
 							Version: &VersionInfo{
-								Version:        1,
-								SourceCommitId: "00001",
-								DeployedAt:     time.Unix(123456789, 0).UTC(),
+								Version:          1,
+								SourceCommitId:   "00001",
+								DeployedAt:       time.Unix(123456789, 0).UTC(),
+								ReleaseCreatedAt: time.Unix(123456700, 0).UTC(),
 							},
 						},
 					},
@@ -478,9 +487,10 @@ func TestVersionClientStream(t *testing.T) {
 							EnvironmentGroup: "staging-group",
 							Team:             "footeam",
 							Version: &VersionInfo{
-								Version:        1,
-								SourceCommitId: "00001",
-								DeployedAt:     time.Unix(123456789, 0).UTC(),
+								Version:          1,
+								SourceCommitId:   "00001",
+								DeployedAt:       time.Unix(123456789, 0).UTC(),
+								ReleaseCreatedAt: time.Unix(123456700, 0).UTC(),
 							},
 						},
 					},